@@ -0,0 +1,413 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 统计的默认天数和最大天数
+const (
+	defaultAnalyticsDays = 30
+	maxAnalyticsDays     = 366
+)
+
+// 统计时间范围，按当地日期计算，end 为结束日期次日零点
+type analyticsRange struct {
+	start time.Time
+	end   time.Time
+	days  int
+	loc   *time.Location
+}
+
+// 解析 start_date、end_date（含）及 tz 参数，默认最近 30 天。失败时已写入响应
+func parseAnalyticsRange(c *gin.Context) (analyticsRange, bool) {
+	tz, _ := c.Get("time_zone")
+	loc := loadLocation(fmt.Sprint(tz))
+	if name := c.Query("tz"); name != "" {
+		if !validTimeZone(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时区无效"})
+			return analyticsRange{}, false
+		}
+		loc = loadLocation(name)
+	}
+	today := time.Now().In(loc).Format("2006-01-02")
+	endDate := c.DefaultQuery("end_date", today)
+	_, end, ok := localDayRange(endDate, loc)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "日期格式错误"})
+		return analyticsRange{}, false
+	}
+	startDate := c.Query("start_date")
+	if startDate == "" {
+		last := end.In(loc)
+		startDate = time.Date(last.Year(), last.Month(), last.Day()-defaultAnalyticsDays, 0, 0, 0, 0, loc).Format("2006-01-02")
+	}
+	start, _, ok := localDayRange(startDate, loc)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "日期格式错误"})
+		return analyticsRange{}, false
+	}
+	// 按日期计算天数，不受夏令时影响
+	first, _ := time.Parse("2006-01-02", startDate)
+	last, _ := time.Parse("2006-01-02", endDate)
+	days := int(last.Sub(first).Hours()/24) + 1
+	if days <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "结束日期不能早于开始日期"})
+		return analyticsRange{}, false
+	}
+	if days > maxAnalyticsDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("统计范围不能超过 %d 天", maxAnalyticsDays)})
+		return analyticsRange{}, false
+	}
+	return analyticsRange{start: start, end: end, days: days, loc: loc}, true
+}
+
+// 按 site、building、floor、room_id 参数筛选参与统计的会议室
+func analyticsRooms(c *gin.Context) ([]Room, error) {
+	query := db.Model(&Room{}).Order("id")
+	for _, field := range []string{"site", "building", "floor"} {
+		if v := c.Query(field); v != "" {
+			query = query.Where(field+" = ?", v)
+		}
+	}
+	if v := c.Query("room_id"); v != "" {
+		query = query.Where("id = ?", v)
+	}
+	var rooms []Room
+	err := query.Find(&rooms).Error
+	return rooms, err
+}
+
+// 会议室每天的开放分钟数，不限开放时间时为全天
+func dailyOpenMinutes(room Room) int {
+	open, ok1 := parseClock(room.OpenTime)
+	closing, ok2 := parseClock(room.CloseTime)
+	if room.OpenTime == "" || room.CloseTime == "" || !ok1 || !ok2 || closing <= open {
+		return 24 * 60
+	}
+	return closing - open
+}
+
+// 保留一位小数
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+// 百分比，分母为 0 时为空
+func percent(part, total float64) *float64 {
+	if total <= 0 {
+		return nil
+	}
+	v := round1(part / total * 100)
+	return &v
+}
+
+// 每个会议室的预订汇总，由 SQL 聚合得到
+type roomUsageRow struct {
+	RoomID         uint
+	Bookings       int64   // 含取消
+	Cancelled      int64   // 已取消
+	Meetings       int64   // 未取消
+	BookedMinutes  float64 // 统计范围内的占用分钟数
+	MeetingMinutes float64 // 会议完整时长之和
+	Attendees      int64   // 预订人与参与人之和
+	Ended          int64   // 已结束的会议
+	NoShows        int64   // 已结束但未签到的会议
+}
+
+// RoomUtilization 会议室使用情况，百分比保留一位小数
+type RoomUtilization struct {
+	RoomID            uint     `json:"room_id"`
+	Name              string   `json:"name"`
+	Site              string   `json:"site"`
+	Building          string   `json:"building"`
+	Floor             string   `json:"floor"`
+	Capacity          int      `json:"capacity"`
+	Bookings          int64    `json:"bookings"`            // 时间范围内的预订数，含取消
+	Cancelled         int64    `json:"cancelled"`           // 取消数
+	BookedHours       float64  `json:"booked_hours"`        // 占用时长
+	AvailableHours    float64  `json:"available_hours"`     // 开放时长
+	Utilization       *float64 `json:"utilization"`         // 占用时长 / 开放时长（%）
+	AvgMeetingMinutes *float64 `json:"avg_meeting_minutes"` // 平均会议时长
+	AvgCapacityRatio  *float64 `json:"avg_capacity_ratio"`  // 平均参会人数 / 容量（%）
+	CancellationRate  *float64 `json:"cancellation_rate"`   // 取消率（%）
+	NoShowRate        *float64 `json:"no_show_rate"`        // 已结束会议中未签到的比例（%），未配置门口平板的会议室为空
+}
+
+func newRoomUtilization(room Room, row roomUsageRow, availableMinutes float64, checkIn bool) RoomUtilization {
+	u := RoomUtilization{
+		RoomID:           room.ID,
+		Name:             room.Name,
+		Site:             room.Site,
+		Building:         room.Building,
+		Floor:            room.Floor,
+		Capacity:         room.Capacity,
+		Bookings:         row.Bookings,
+		Cancelled:        row.Cancelled,
+		BookedHours:      round1(row.BookedMinutes / 60),
+		AvailableHours:   round1(availableMinutes / 60),
+		Utilization:      percent(row.BookedMinutes, availableMinutes),
+		CancellationRate: percent(float64(row.Cancelled), float64(row.Bookings)),
+	}
+	if row.Meetings > 0 {
+		avg := round1(row.MeetingMinutes / float64(row.Meetings))
+		u.AvgMeetingMinutes = &avg
+		if room.Capacity > 0 {
+			u.AvgCapacityRatio = percent(float64(row.Attendees), float64(row.Meetings*int64(room.Capacity)))
+		}
+	}
+	if checkIn {
+		u.NoShowRate = percent(float64(row.NoShows), float64(row.Ended))
+	}
+	return u
+}
+
+// 按会议室汇总统计范围内的预订，占用时长只计算与统计范围重叠的部分
+func roomUsageSQL(d sqlDialect) string {
+	return fmt.Sprintf(`SELECT b.room_id AS room_id,
+	COUNT(*) AS bookings,
+	SUM(CASE WHEN b.status = @cancelled THEN 1 ELSE 0 END) AS cancelled,
+	SUM(CASE WHEN b.status <> @cancelled THEN 1 ELSE 0 END) AS meetings,
+	SUM(CASE WHEN b.status <> @cancelled THEN %s - %s ELSE 0 END) AS booked_minutes,
+	SUM(CASE WHEN b.status <> @cancelled THEN %s ELSE 0 END) AS meeting_minutes,
+	SUM(CASE WHEN b.status <> @cancelled THEN 1 + (SELECT COUNT(*) FROM booking_participants p WHERE p.booking_id = b.id) ELSE 0 END) AS attendees,
+	SUM(CASE WHEN b.status <> @cancelled AND b.end_time <= @now THEN 1 ELSE 0 END) AS ended,
+	SUM(CASE WHEN b.status <> @cancelled AND b.end_time <= @now AND b.checked_in_at IS NULL THEN 1 ELSE 0 END) AS no_shows
+FROM bookings b
+WHERE b.room_id IN @rooms AND b.end_time > @start AND b.start_time < @end
+GROUP BY b.room_id`,
+		d.least(d.minutesBetween("@start", "b.end_time"), d.minutesBetween("@start", "@end")),
+		d.greatest(d.minutesBetween("@start", "b.start_time"), "0"),
+		d.minutesBetween("b.start_time", "b.end_time"))
+}
+
+// @Summary 会议室使用率统计
+// @Description 管理员按日期范围和位置统计各会议室的开放时间使用率、平均会议时长、平均参会人数与容量之比、取消率和未签到率，并给出汇总。
+// @Description 未签到率只统计配置了门口平板的会议室
+// @Tags 统计
+// @Produce json
+// @Param start_date query string false "开始日期(YYYY-MM-DD)，默认 30 天前"
+// @Param end_date query string false "结束日期(YYYY-MM-DD，含)，默认今天"
+// @Param tz query string false "解释日期所用的时区，默认用户时区"
+// @Param site query string false "园区"
+// @Param building query string false "楼宇"
+// @Param floor query string false "楼层"
+// @Param room_id query int false "会议室ID"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/analytics/rooms [get]
+func roomUtilizationHandler(c *gin.Context) {
+	r, ok := parseAnalyticsRange(c)
+	if !ok {
+		return
+	}
+	rooms, err := analyticsRooms(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "统计失败"})
+		return
+	}
+	result := gin.H{
+		"start_date": r.start.In(r.loc).Format("2006-01-02"),
+		"end_date":   r.end.In(r.loc).AddDate(0, 0, -1).Format("2006-01-02"),
+		"time_zone":  r.loc.String(),
+		"rooms":      []RoomUtilization{},
+	}
+	if len(rooms) == 0 {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+	roomIDs := make([]uint, len(rooms))
+	for i, room := range rooms {
+		roomIDs[i] = room.ID
+	}
+
+	var rows []roomUsageRow
+	err = db.Raw(roomUsageSQL(dialectOf(db)),
+		sql.Named("cancelled", BookingStatusCancelled),
+		sql.Named("start", r.start),
+		sql.Named("end", r.end),
+		sql.Named("now", time.Now().UTC()),
+		sql.Named("rooms", roomIDs),
+	).Scan(&rows).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "统计失败"})
+		return
+	}
+	usage := make(map[uint]roomUsageRow, len(rows))
+	for _, row := range rows {
+		usage[row.RoomID] = row
+	}
+	// 配置了门口平板的会议室才能签到
+	var kioskRoomIDs []uint
+	db.Model(&KioskDevice{}).Distinct().Where("room_id IN ?", roomIDs).Pluck("room_id", &kioskRoomIDs)
+	checkIn := make(map[uint]bool, len(kioskRoomIDs))
+	for _, id := range kioskRoomIDs {
+		checkIn[id] = true
+	}
+
+	var total, totalCheckIn roomUsageRow
+	var totalAvailable float64
+	var totalCapacity int64
+	list := make([]RoomUtilization, 0, len(rooms))
+	for _, room := range rooms {
+		row := usage[room.ID]
+		available := float64(dailyOpenMinutes(room) * r.days)
+		list = append(list, newRoomUtilization(room, row, available, checkIn[room.ID]))
+		total.Bookings += row.Bookings
+		total.Cancelled += row.Cancelled
+		total.Meetings += row.Meetings
+		total.BookedMinutes += row.BookedMinutes
+		total.MeetingMinutes += row.MeetingMinutes
+		totalAvailable += available
+		if room.Capacity > 0 {
+			total.Attendees += row.Attendees
+			totalCapacity += row.Meetings * int64(room.Capacity)
+		}
+		if checkIn[room.ID] {
+			totalCheckIn.Ended += row.Ended
+			totalCheckIn.NoShows += row.NoShows
+		}
+	}
+	summary := gin.H{
+		"rooms":               len(rooms),
+		"bookings":            total.Bookings,
+		"cancelled":           total.Cancelled,
+		"booked_hours":        round1(total.BookedMinutes / 60),
+		"available_hours":     round1(totalAvailable / 60),
+		"utilization":         percent(total.BookedMinutes, totalAvailable),
+		"cancellation_rate":   percent(float64(total.Cancelled), float64(total.Bookings)),
+		"avg_capacity_ratio":  percent(float64(total.Attendees), float64(totalCapacity)),
+		"no_show_rate":        percent(float64(totalCheckIn.NoShows), float64(totalCheckIn.Ended)),
+		"avg_meeting_minutes": nil,
+	}
+	if total.Meetings > 0 {
+		summary["avg_meeting_minutes"] = round1(total.MeetingMinutes / float64(total.Meetings))
+	}
+	result["rooms"] = list
+	result["summary"] = summary
+	c.JSON(http.StatusOK, result)
+}
+
+// 每个时区每小时的占用分钟数
+type hourUsageRow struct {
+	TimeZone string
+	N        int // 距统计开始的小时数
+	Minutes  float64
+}
+
+// HeatmapCell 热力图单元，按会议室当地时间的星期和小时汇总
+type HeatmapCell struct {
+	Weekday     int      `json:"weekday"` // 0 为星期日
+	Hour        int      `json:"hour"`
+	BookedHours float64  `json:"booked_hours"`
+	Occupancy   *float64 `json:"occupancy"` // 占用时长 / 该时段会议室总时长（%）
+}
+
+// 将预订按整点拆分后按时区和小时汇总，在数据库中完成。时间以距统计开始的分钟数表示
+func hourUsageSQL(d sqlDialect) string {
+	// 片段开始时间所在的小时序号，加上极小值避免浮点误差落入上一小时
+	bucket := d.floor("s / 60 + 1e-9")
+	next := "(" + bucket + " + 1) * 60"
+	return fmt.Sprintf(`WITH RECURSIVE pieces(tz, s, e) AS (
+	SELECT rooms.time_zone, %[1]s, %[2]s
+	FROM bookings b JOIN rooms ON rooms.id = b.room_id
+	WHERE b.room_id IN @rooms AND b.status <> @cancelled AND b.end_time > @start AND b.start_time < @end
+	UNION ALL
+	SELECT tz, %[3]s, e FROM pieces
+	WHERE %[4]s < e
+)
+SELECT tz AS time_zone, %[5]s AS n, SUM(%[6]s - s) AS minutes
+FROM pieces GROUP BY tz, n`,
+		d.float(d.greatest(d.minutesBetween("@start", "b.start_time"), "0")),
+		d.float(d.least(d.minutesBetween("@start", "b.end_time"), d.minutesBetween("@start", "@end"))),
+		d.float(next), next, bucket, d.least("e", next))
+}
+
+// @Summary 会议室高峰时段热力图
+// @Description 管理员按星期和小时（会议室当地时间）统计占用时长及占用率，用于查看高峰时段。筛选参数与使用率统计相同
+// @Tags 统计
+// @Produce json
+// @Param start_date query string false "开始日期(YYYY-MM-DD)，默认 30 天前"
+// @Param end_date query string false "结束日期(YYYY-MM-DD，含)，默认今天"
+// @Param tz query string false "解释日期所用的时区，默认用户时区"
+// @Param site query string false "园区"
+// @Param building query string false "楼宇"
+// @Param floor query string false "楼层"
+// @Param room_id query int false "会议室ID"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/analytics/heatmap [get]
+func roomHeatmapHandler(c *gin.Context) {
+	r, ok := parseAnalyticsRange(c)
+	if !ok {
+		return
+	}
+	rooms, err := analyticsRooms(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "统计失败"})
+		return
+	}
+	var booked, capacity [7][24]float64
+	if len(rooms) > 0 {
+		roomIDs := make([]uint, len(rooms))
+		roomsByZone := make(map[string]int)
+		for i, room := range rooms {
+			roomIDs[i] = room.ID
+			roomsByZone[room.TimeZone]++
+		}
+		var rows []hourUsageRow
+		err = db.Raw(hourUsageSQL(dialectOf(db)),
+			sql.Named("cancelled", BookingStatusCancelled),
+			sql.Named("start", r.start),
+			sql.Named("end", r.end),
+			sql.Named("rooms", roomIDs),
+		).Scan(&rows).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "统计失败"})
+			return
+		}
+		for _, row := range rows {
+			local := r.start.Add(time.Duration(row.N) * time.Hour).In(loadLocation(row.TimeZone))
+			booked[local.Weekday()][local.Hour()] += row.Minutes
+		}
+		// 各时段的会议室总时长，按各时区分别计算
+		hours := int(r.end.Sub(r.start) / time.Hour)
+		for zone, count := range roomsByZone {
+			loc := loadLocation(zone)
+			for n := 0; n < hours; n++ {
+				local := r.start.Add(time.Duration(n) * time.Hour).In(loc)
+				capacity[local.Weekday()][local.Hour()] += float64(count * 60)
+			}
+		}
+	}
+	cells := make([]HeatmapCell, 0, 7*24)
+	var peak *HeatmapCell
+	for weekday := 0; weekday < 7; weekday++ {
+		for hour := 0; hour < 24; hour++ {
+			cell := HeatmapCell{
+				Weekday:     weekday,
+				Hour:        hour,
+				BookedHours: round1(booked[weekday][hour] / 60),
+				Occupancy:   percent(booked[weekday][hour], capacity[weekday][hour]),
+			}
+			cells = append(cells, cell)
+			if cell.BookedHours > 0 && cell.Occupancy != nil && (peak == nil || *cell.Occupancy > *peak.Occupancy) {
+				p := cell
+				peak = &p
+			}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"start_date": r.start.In(r.loc).Format("2006-01-02"),
+		"end_date":   r.end.In(r.loc).AddDate(0, 0, -1).Format("2006-01-02"),
+		"time_zone":  r.loc.String(),
+		"rooms":      len(rooms),
+		"cells":      cells,
+		"peak":       peak,
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 统计范围 2030-05-06 全天（UTC）内的预订：
+// b1 01:00-02:30 有一名参与人且已签到；b2 从前一天 23:30 开始，跨越统计开始；
+// b3 已取消；b4 在统计范围之外
+func createAnalyticsFixtures(t *testing.T, tx *gorm.DB) (Room, Room, time.Time, time.Time) {
+	t.Helper()
+	user := User{Username: "alice", Role: "user"}
+	guest := User{Username: "bob", Role: "user"}
+	room := Room{Name: "A101", Capacity: 6, TimeZone: "Asia/Shanghai"}
+	empty := Room{Name: "A102", Capacity: 4, TimeZone: "UTC"}
+	for _, v := range []interface{}{&user, &guest, &room, &empty} {
+		if err := tx.Create(v).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Date(2030, 5, 6, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	checkedIn := at(65)
+	bookings := []Booking{
+		{StartTime: at(60), EndTime: at(150), Status: BookingStatusActive, CheckedInAt: &checkedIn},
+		{StartTime: at(-30), EndTime: at(30), Status: BookingStatusActive},
+		{StartTime: at(300), EndTime: at(360), Status: BookingStatusCancelled},
+		{StartTime: at(-120), EndTime: at(-60), Status: BookingStatusActive},
+	}
+	for i := range bookings {
+		bookings[i].RoomID = room.ID
+		bookings[i].UserID = user.ID
+		if err := tx.Create(&bookings[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Create(&BookingParticipant{BookingID: bookings[0].ID, UserID: guest.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return room, empty, start, start.Add(24 * time.Hour)
+}
+
+func TestRoomUsageSQL(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, tx *gorm.DB) {
+		room, empty, start, end := createAnalyticsFixtures(t, tx)
+		var rows []roomUsageRow
+		err := tx.Raw(roomUsageSQL(dialectOf(tx)),
+			sql.Named("cancelled", BookingStatusCancelled),
+			sql.Named("start", start),
+			sql.Named("end", end),
+			sql.Named("now", start.Add(12*time.Hour)),
+			sql.Named("rooms", []uint{room.ID, empty.ID}),
+		).Scan(&rows).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 1 {
+			t.Fatalf("返回 %d 行，应只有有预订的会议室：%+v", len(rows), rows)
+		}
+		got := rows[0]
+		want := roomUsageRow{RoomID: room.ID, Bookings: 3, Cancelled: 1, Meetings: 2, BookedMinutes: 120, MeetingMinutes: 150, Attendees: 3, Ended: 2, NoShows: 1}
+		if math.Abs(got.BookedMinutes-want.BookedMinutes) > 1e-6 || math.Abs(got.MeetingMinutes-want.MeetingMinutes) > 1e-6 {
+			t.Errorf("分钟数 %+v，应为 %+v", got, want)
+		}
+		got.BookedMinutes, got.MeetingMinutes = want.BookedMinutes, want.MeetingMinutes
+		if got != want {
+			t.Errorf("%+v，应为 %+v", got, want)
+		}
+	})
+}
+
+func TestHourUsageSQL(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, tx *gorm.DB) {
+		room, empty, start, end := createAnalyticsFixtures(t, tx)
+		var rows []hourUsageRow
+		err := tx.Raw(hourUsageSQL(dialectOf(tx)),
+			sql.Named("cancelled", BookingStatusCancelled),
+			sql.Named("start", start),
+			sql.Named("end", end),
+			sql.Named("rooms", []uint{room.ID, empty.ID}),
+		).Scan(&rows).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		// b2 落在第 0 小时 30 分钟，b1 拆分为第 1 小时 60 分钟和第 2 小时 30 分钟
+		want := map[int]float64{0: 30, 1: 60, 2: 30}
+		if len(rows) != len(want) {
+			t.Fatalf("返回 %+v，应为 %v", rows, want)
+		}
+		for _, row := range rows {
+			if row.TimeZone != room.TimeZone || math.Abs(row.Minutes-want[row.N]) > 1e-6 {
+				t.Errorf("第 %d 小时 %+v，应为 %s %v 分钟", row.N, row, room.TimeZone, want[row.N])
+			}
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 审计日志默认保留天数
+const defaultAuditRetentionDays = 180
+
+// 审计日志记录的 User-Agent 最大长度
+const maxAuditUserAgent = 512
+
+// AuditLog 管理操作和预订操作的审计日志，只追加不修改，超过保留期后由后台任务清理
+type AuditLog struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	ActorID    uint   `gorm:"index" json:"actor_id"` // 操作人，门口平板和系统操作为 0
+	ActorName  string `json:"actor_name"`            // 操作时的用户名，门口平板为 kiosk:设备名称
+	Action     string `gorm:"index" json:"action"`   // 如 room.update、user.role
+	TargetType string `gorm:"index:idx_audit_target" json:"target_type"`
+	TargetID   uint   `gorm:"index:idx_audit_target" json:"target_id"`
+	// 变更的字段，格式为 {"字段": {"from": 旧值, "to": 新值}}
+	Changes   string    `gorm:"type:text" json:"changes,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// 字段变更
+type auditChange struct {
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// 不记录到变更中的字段：修改时间和仅用于返回的当地时间
+var auditIgnoredFields = map[string]bool{
+	"updated_at":       true,
+	"start_time_local": true,
+	"end_time_local":   true,
+}
+
+// 比较操作前后的对象，返回变更的字段。before 为 nil 表示新建，after 为 nil 表示删除
+func auditDiff(before, after interface{}) map[string]auditChange {
+	from, to := auditFields(before), auditFields(after)
+	changes := make(map[string]auditChange)
+	for k, v := range to {
+		if old, ok := from[k]; !ok || !reflect.DeepEqual(old, v) {
+			changes[k] = auditChange{From: from[k], To: v}
+		}
+	}
+	for k, v := range from {
+		if _, ok := to[k]; !ok {
+			changes[k] = auditChange{From: v}
+		}
+	}
+	return changes
+}
+
+// 以 JSON 字段名展开对象，不输出的字段（如密码、令牌）不会出现在审计日志中
+func auditFields(v interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return fields
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(body, &fields)
+	for k := range auditIgnoredFields {
+		delete(fields, k)
+	}
+	return fields
+}
+
+// 请求的操作人：登录用户或门口平板
+func auditActor(c *gin.Context) (uint, string) {
+	if c == nil {
+		return 0, "system"
+	}
+	if device, ok := c.Get("kiosk_device"); ok {
+		if d, ok := device.(KioskDevice); ok {
+			return 0, "kiosk:" + d.Name
+		}
+	}
+	userID, _ := currentUserID(c)
+	username, _ := c.Get("username")
+	if username == nil {
+		return userID, ""
+	}
+	return userID, fmt.Sprint(username)
+}
+
+// 注册和单点登录的请求未登录，以新用户作为操作人
+func auditAs(c *gin.Context, user User) {
+	c.Set("user_id", user.ID)
+	c.Set("username", user.Username)
+}
+
+// 写入审计日志，应在操作所在的事务中调用。before、after 为操作前后的对象，用于计算变更
+func recordAudit(tx *gorm.DB, c *gin.Context, action, targetType string, targetID uint, before, after interface{}) {
+	entry := AuditLog{Action: action, TargetType: targetType, TargetID: targetID}
+	entry.ActorID, entry.ActorName = auditActor(c)
+	if c != nil {
+		entry.IP = c.ClientIP()
+		entry.UserAgent = c.Request.UserAgent()
+		if len(entry.UserAgent) > maxAuditUserAgent {
+			entry.UserAgent = entry.UserAgent[:maxAuditUserAgent]
+		}
+	}
+	if changes := auditDiff(before, after); len(changes) > 0 {
+		if body, err := json.Marshal(changes); err == nil {
+			entry.Changes = string(body)
+		}
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		log.Printf("写入审计日志失败: %v", err)
+	}
+}
+
+// 定期清理超过保留期的审计日志，保留天数为 0 时不清理
+func runAuditPruner(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		settings, err := loadSystemSettings()
+		if err != nil || settings.AuditRetentionDays <= 0 {
+			continue
+		}
+		cutoff := time.Now().UTC().AddDate(0, 0, -settings.AuditRetentionDays)
+		if err := db.Where("created_at < ?", cutoff).Delete(&AuditLog{}).Error; err != nil {
+			log.Printf("清理审计日志失败: %v", err)
+		}
+	}
+}
+
+// @Summary (Admin) 查询审计日志
+// @Description 管理员分页查询审计日志，按时间倒序
+// @Tags 管理员
+// @Produce json
+// @Param actor_id query int false "操作人ID"
+// @Param action query string false "操作，如 room.update"
+// @Param target_type query string false "对象类型，如 room、user、booking"
+// @Param target_id query int false "对象ID"
+// @Param start_time query string false "开始时间(ISO8601)"
+// @Param end_time query string false "结束时间(ISO8601)"
+// @Param tz query string false "解释不带时区的时间参数所用的时区"
+// @Param page query int false "页码，默认1"
+// @Param page_size query int false "每页数量，默认20，最大100"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/audit-logs [get]
+func listAuditLogsHandler(c *gin.Context) {
+	loc, ok := requestLocation(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "时区无效"})
+		return
+	}
+	query := db.Model(&AuditLog{})
+	for _, field := range []string{"actor_id", "target_id"} {
+		if v := c.Query(field); v != "" {
+			id, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": field + " 无效"})
+				return
+			}
+			query = query.Where(field+" = ?", id)
+		}
+	}
+	for _, field := range []string{"action", "target_type"} {
+		if v := c.Query(field); v != "" {
+			query = query.Where(field+" = ?", v)
+		}
+	}
+	if v := c.Query("start_time"); v != "" {
+		t, ok := parseTimeParam(v, loc)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时间格式错误"})
+			return
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if v := c.Query("end_time"); v != "" {
+		t, ok := parseTimeParam(v, loc)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时间格式错误"})
+			return
+		}
+		query = query.Where("created_at < ?", t)
+	}
+	page, pageSize := parsePage(c)
+	var total int64
+	query.Count(&total)
+	var logs []AuditLog
+	query.Order("id DESC").Scopes(paginate(page, pageSize)).Find(&logs)
+	if logs == nil {
+		logs = []AuditLog{}
+	}
+	c.JSON(http.StatusOK, gin.H{"logs": logs, "total": total, "page": page, "page_size": pageSize})
+}
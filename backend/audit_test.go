@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAuditDiff(t *testing.T) {
+	before := User{ID: 1, Username: "alice", Password: "old", Role: "user"}
+	after := before
+	after.Password, after.Role = "new", "admin"
+	changes := auditDiff(before, after)
+	if len(changes) != 1 || changes["role"].From != "user" || changes["role"].To != "admin" {
+		t.Errorf("修改：%+v", changes)
+	}
+
+	// 不输出的字段（密码、令牌）和修改时间不记录
+	device := KioskDevice{ID: 1, Name: "门口", RoomID: 2, Token: "secret"}
+	changes = auditDiff(nil, device)
+	if _, ok := changes["token"]; ok || changes["name"].To != "门口" || changes["name"].From != nil {
+		t.Errorf("新建：%+v", changes)
+	}
+	booking := Booking{ID: 1, Reason: "周会", UpdatedAt: time.Now()}
+	changes = auditDiff(booking, nil)
+	if _, ok := changes["updated_at"]; ok || changes["reason"].From != "周会" || changes["reason"].To != nil {
+		t.Errorf("删除：%+v", changes)
+	}
+}
+
+// 修改和审计日志在同一事务中写入，操作失败时不留下审计日志
+func TestAuditWrittenWithChange(t *testing.T) {
+	tx := setupTestDB(t)
+	admin := User{Username: "admin", Role: "admin"}
+	alice := User{Username: "alice", Password: "secret1", Role: "user"}
+	room := Room{Name: "A101"}
+	mustCreate(t, &admin, &alice, &room)
+
+	lastAudit := func(action string) (AuditLog, map[string]auditChange) {
+		t.Helper()
+		var entry AuditLog
+		if err := tx.Where("action = ?", action).Order("id DESC").First(&entry).Error; err != nil {
+			t.Fatalf("没有 %s 的审计日志: %v", action, err)
+		}
+		changes := make(map[string]auditChange)
+		if entry.Changes != "" {
+			json.Unmarshal([]byte(entry.Changes), &changes)
+		}
+		return entry, changes
+	}
+
+	code, resp := callHandler(t, adminChangeUserRoleHandler, admin, http.MethodPut, "/api/admin/user/role", nil, ChangeUserRoleRequest{UserID: alice.ID, Role: "admin"})
+	if code != http.StatusOK {
+		t.Fatalf("修改角色返回 %d：%v", code, resp)
+	}
+	entry, changes := lastAudit("user.role")
+	if entry.ActorID != admin.ID || entry.ActorName != "admin" || entry.TargetType != "user" || entry.TargetID != alice.ID || entry.IP == "" {
+		t.Errorf("审计日志 %+v", entry)
+	}
+	if len(changes) != 1 || changes["role"].From != "user" || changes["role"].To != "admin" {
+		t.Errorf("变更 %s", entry.Changes)
+	}
+
+	code, resp = callHandler(t, changePasswordHandler, alice, http.MethodPut, "/api/user/password", nil, ChangePasswordRequest{OldPassword: "secret1", NewPassword: "secret2"})
+	if code != http.StatusOK {
+		t.Fatalf("修改密码返回 %d：%v", code, resp)
+	}
+	if entry, _ := lastAudit("user.password"); entry.ActorID != alice.ID || entry.Changes != "" {
+		t.Errorf("修改密码的审计日志 %+v", entry)
+	}
+
+	code, resp = callHandler(t, createKioskDeviceHandler, admin, http.MethodPost, "/api/admin/kiosk-devices", nil, SaveKioskDeviceRequest{Name: "门口", RoomID: room.ID})
+	if code != http.StatusOK {
+		t.Fatalf("注册设备返回 %d：%v", code, resp)
+	}
+	device := resp["device"].(map[string]interface{})
+	deviceID := uint(device["id"].(float64))
+	if entry, changes := lastAudit("kiosk_device.create"); entry.TargetID != deviceID || changes["name"].To != "门口" {
+		t.Errorf("注册设备的审计日志 %+v", entry)
+	}
+	code, _ = callHandler(t, deleteKioskDeviceHandler, admin, http.MethodDelete, "/api/admin/kiosk-devices", idParam(deviceID), nil)
+	if code != http.StatusOK {
+		t.Fatalf("删除设备返回 %d", code)
+	}
+	if entry, changes := lastAudit("kiosk_device.delete"); entry.TargetID != deviceID || changes["name"].From != "门口" {
+		t.Errorf("删除设备的审计日志 %+v", entry)
+	}
+
+	// 删除失败时返回错误，不记录审计日志（SQLite 触发器模拟删除失败）
+	quota := BookingQuota{Scope: QuotaScopeUser, UserID: alice.ID, MaxActiveBookings: 1}
+	mustCreate(t, &quota)
+	if err := tx.Exec("CREATE TRIGGER fail_quota_delete BEFORE DELETE ON booking_quota BEGIN SELECT RAISE(ABORT, 'fail'); END").Error; err != nil {
+		t.Fatal(err)
+	}
+	code, _ = callHandler(t, deleteQuotaHandler, admin, http.MethodDelete, "/api/admin/quotas", idParam(quota.ID), nil)
+	if code != http.StatusInternalServerError {
+		t.Errorf("删除配额返回 %d，应为 500", code)
+	}
+	var count int64
+	tx.Model(&AuditLog{}).Where("action = ?", "quota.delete").Count(&count)
+	if count != 0 {
+		t.Errorf("删除失败后有 %d 条审计日志", count)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 备份文件名中的时间格式
+const backupTimeLayout = "20060102-150405"
+
+// 备份文件名前缀，只有此前缀的备份按保留数清理；恢复前自动备份的文件以 before-restore- 开头
+const (
+	backupPrefix        = "meeting_room-"
+	restoreBackupPrefix = "before-restore-"
+)
+
+// 恢复时等待其他连接释放数据库的最长时间
+const restoreBusyTimeout = 30 * time.Second
+
+// 备份和恢复依次执行
+var backupMu sync.Mutex
+
+// 备份文件损坏、不是本系统的数据库或版本比当前程序新
+var errInvalidBackup = errors.New("备份文件无效")
+
+// BackupFile 备份目录中的备份文件
+type BackupFile struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 当前数据库为 SQLite 时返回数据库文件路径
+func sqliteFilePath() (string, error) {
+	if appConfig.Database.Driver != DBDriverSQLite {
+		return "", errors.New("仅 SQLite 数据库支持备份和恢复，PostgreSQL 和 MySQL 请使用 pg_dump、mysqldump 等工具")
+	}
+	path := strings.TrimPrefix(appConfig.Database.DSN, "file:")
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	return path, nil
+}
+
+// 将数据库备份到 dest。VACUUM INTO 在一个读事务中写出完整一致的快照，服务运行时也可执行
+func backupDatabase(dest string) error {
+	if _, err := sqliteFilePath(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("文件 %s 已存在", dest)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	return db.Exec("VACUUM INTO ?", dest).Error
+}
+
+// 在备份目录中创建备份，并按保留数删除较早的备份
+func createBackup() (BackupFile, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	dest := newBackupPath(backupPrefix)
+	if err := backupDatabase(dest); err != nil {
+		return BackupFile{}, err
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		return BackupFile{}, err
+	}
+	if err := pruneBackups(appConfig.Backup.Keep); err != nil {
+		log.Printf("清理旧备份失败: %v", err)
+	}
+	return BackupFile{Name: filepath.Base(dest), Size: info.Size(), CreatedAt: info.ModTime()}, nil
+}
+
+// 备份目录中以 prefix 和当前时间命名的新文件路径，同一秒内多次备份时加序号
+func newBackupPath(prefix string) string {
+	name := prefix + time.Now().Format(backupTimeLayout)
+	path := filepath.Join(appConfig.Backup.Dir, name+".db")
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return path
+		}
+		path = filepath.Join(appConfig.Backup.Dir, fmt.Sprintf("%s-%d.db", name, i))
+	}
+}
+
+// 备份目录中的备份文件，按时间倒序
+func listBackups() ([]BackupFile, error) {
+	entries, err := os.ReadDir(appConfig.Backup.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []BackupFile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := []BackupFile{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".db" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, BackupFile{Name: entry.Name(), Size: info.Size(), CreatedAt: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].CreatedAt.After(files[j].CreatedAt)
+	})
+	return files, nil
+}
+
+// 只保留最近的 keep 个备份，keep 为 0 时不删除
+func pruneBackups(keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	files, err := listBackups()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name, backupPrefix) {
+			continue
+		}
+		if keep > 0 {
+			keep--
+			continue
+		}
+		if err := os.Remove(filepath.Join(appConfig.Backup.Dir, f.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 备份目录中指定名称的备份文件路径，名称无效或文件不存在时返回 false
+func backupFilePath(name string) (string, bool) {
+	if name != filepath.Base(name) || filepath.Ext(name) != ".db" {
+		return "", false
+	}
+	path := filepath.Join(appConfig.Backup.Dir, name)
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return "", false
+	}
+	return path, true
+}
+
+// 定时备份
+func runBackupScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := createBackup(); err != nil {
+			log.Printf("定时备份失败: %v", err)
+		}
+	}
+}
+
+// 只读打开备份文件并检查：完整性检查通过、是本系统的数据库，且迁移版本不比当前程序新
+func openBackup(path string) (*gorm.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	src, err := gorm.Open(sqlite.Open("file:"+path+"?mode=ro"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidBackup, err)
+	}
+	if err := checkBackup(src); err != nil {
+		closeDatabase(src)
+		return nil, fmt.Errorf("%w: %v", errInvalidBackup, err)
+	}
+	return src, nil
+}
+
+func checkBackup(src *gorm.DB) error {
+	var result string
+	if err := src.Raw("PRAGMA integrity_check").Row().Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("完整性检查未通过: %s", result)
+	}
+	if !src.Migrator().HasTable(&User{}) || !src.Migrator().HasTable(&SchemaMigration{}) {
+		return errors.New("不是会议室预订系统的数据库")
+	}
+	applied, err := readSchemaMigrations(src)
+	if err != nil {
+		return err
+	}
+	migrations, err := loadMigrations(DBDriverSQLite)
+	if err != nil {
+		return err
+	}
+	return checkSchemaVersion(migrations, applied)
+}
+
+func closeDatabase(tx *gorm.DB) {
+	if sqlDB, err := tx.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
+
+// 用备份文件替换当前数据库，返回替换前自动备份的文件路径。
+// 备份文件通过检查后先备份当前数据库，再用 SQLite 备份 API 在一个写事务中把备份的内容复制到当前数据库，
+// 服务运行时也可执行，其他连接在复制完成后即读到恢复的数据；最后执行迁移，使旧版本的备份升级到当前版本
+func restoreDatabase(src string) (string, error) {
+	if _, err := sqliteFilePath(); err != nil {
+		return "", err
+	}
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	backup, err := openBackup(src)
+	if err != nil {
+		return "", err
+	}
+	defer closeDatabase(backup)
+
+	previous := newBackupPath(restoreBackupPrefix)
+	if err := backupDatabase(previous); err != nil {
+		return "", fmt.Errorf("备份当前数据库失败: %w", err)
+	}
+	if err := copyDatabase(db, backup); err != nil {
+		return previous, fmt.Errorf("替换数据库失败: %w", err)
+	}
+	if _, err := migrateUp(db); err != nil {
+		return previous, fmt.Errorf("数据库迁移失败: %w", err)
+	}
+	return previous, nil
+}
+
+// 通过 SQLite 备份 API 将 src 的内容整体复制到 dst，dst 正被其他连接使用时等待后重试
+func copyDatabase(dst, src *gorm.DB) error {
+	ctx := context.Background()
+	dstDB, err := dst.DB()
+	if err != nil {
+		return err
+	}
+	srcDB, err := src.DB()
+	if err != nil {
+		return err
+	}
+	dstConn, err := dstDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+	srcConn, err := srcDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return dstConn.Raw(func(d interface{}) error {
+		return srcConn.Raw(func(s interface{}) error {
+			to, ok1 := d.(*sqlite3.SQLiteConn)
+			from, ok2 := s.(*sqlite3.SQLiteConn)
+			if !ok1 || !ok2 {
+				return errors.New("仅支持 SQLite 数据库")
+			}
+			backup, err := to.Backup("main", from, "main")
+			if err != nil {
+				return err
+			}
+			deadline := time.Now().Add(restoreBusyTimeout)
+			for {
+				done, err := backup.Step(-1)
+				if err != nil || done {
+					if closeErr := backup.Close(); err == nil {
+						err = closeErr
+					}
+					return err
+				}
+				if time.Now().After(deadline) {
+					backup.Close()
+					return errors.New("数据库繁忙，请稍后重试")
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+		})
+	})
+}
+
+// @Summary (Admin) 备份列表
+// @Description 返回备份目录中的 SQLite 备份文件（按时间倒序）及定时备份设置
+// @Tags 管理员
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/backups [get]
+func listBackupsHandler(c *gin.Context) {
+	if _, err := sqliteFilePath(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	files, err := listBackups()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取备份目录失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"backups": files, "interval": appConfig.Backup.Interval, "keep": appConfig.Backup.Keep})
+}
+
+// @Summary (Admin) 创建备份
+// @Description 在线生成 SQLite 数据库的一致快照，保存到备份目录，并按保留数删除较早的备份；服务无需停止
+// @Tags 管理员
+// @Produce json
+// @Success 201 {object} BackupFile
+// @Security Bearer
+// @Router /api/admin/backups [post]
+func createBackupHandler(c *gin.Context) {
+	if _, err := sqliteFilePath(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	file, err := createBackup()
+	if err != nil {
+		log.Printf("备份失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "备份失败"})
+		return
+	}
+	recordAudit(db, c, "backup.create", "backup", 0, nil, file)
+	c.JSON(http.StatusCreated, file)
+}
+
+// @Summary (Admin) 下载备份
+// @Tags 管理员
+// @Produce octet-stream
+// @Param name path string true "备份文件名"
+// @Success 200 {file} file "备份文件"
+// @Security Bearer
+// @Router /api/admin/backups/{name} [get]
+func downloadBackupHandler(c *gin.Context) {
+	path, ok := backupFilePath(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "备份不存在"})
+		return
+	}
+	c.FileAttachment(path, c.Param("name"))
+}
+
+// @Summary (Admin) 从备份恢复
+// @Description 检查备份文件的完整性和迁移版本（不能比当前程序新）后替换当前数据库，服务无需停止。恢复前自动备份当前数据库（before-restore- 开头），旧版本的备份恢复后自动迁移到当前版本
+// @Tags 管理员
+// @Produce json
+// @Param name path string true "备份文件名"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/backups/{name}/restore [post]
+func restoreBackupHandler(c *gin.Context) {
+	if _, err := sqliteFilePath(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := c.Param("name")
+	path, ok := backupFilePath(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "备份不存在"})
+		return
+	}
+	previous, err := restoreDatabase(path)
+	if err != nil {
+		if errors.Is(err, errInvalidBackup) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("恢复备份 %s 失败: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复失败"})
+		return
+	}
+	recordAudit(db, c, "backup.restore", "backup", 0, nil, gin.H{"name": name, "previous": filepath.Base(previous)})
+	c.JSON(http.StatusOK, gin.H{"message": "恢复成功", "previous": filepath.Base(previous)})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 使用测试数据库的 SQLite 文件及临时备份目录，返回备份目录
+func setupBackupTest(t *testing.T) (*gorm.DB, string) {
+	t.Helper()
+	tx := setupTestDB(t)
+	var seq int
+	var name, file string
+	if err := tx.Raw("PRAGMA database_list").Row().Scan(&seq, &name, &file); err != nil {
+		t.Fatal(err)
+	}
+	old := appConfig
+	appConfig.Database = dbConfig{Driver: DBDriverSQLite, DSN: file}
+	appConfig.Backup = BackupConfig{Dir: t.TempDir(), Keep: 2}
+	t.Cleanup(func() { appConfig = old })
+	return tx, appConfig.Backup.Dir
+}
+
+// 备份目录中以 prefix 开头的文件数
+func countBackups(t *testing.T, prefix string) int {
+	t.Helper()
+	files, err := listBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, f := range files {
+		if strings.HasPrefix(f.Name, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func restoreBackup(t *testing.T, admin User, name string) (int, map[string]interface{}) {
+	t.Helper()
+	return callHandler(t, restoreBackupHandler, admin, http.MethodPost, "/api/admin/backups/"+name+"/restore",
+		gin.Params{{Key: "name", Value: name}}, nil)
+}
+
+// 恢复前自动备份当前数据库，恢复后全局连接读到备份中的数据
+func TestBackupRestore(t *testing.T) {
+	tx, dir := setupBackupTest(t)
+	admin := User{Username: "admin", Role: "admin"}
+	mustCreate(t, &admin)
+	backup, err := createBackup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(backup.Name, backupPrefix) || backup.Size == 0 {
+		t.Fatalf("备份文件 %+v", backup)
+	}
+	mustCreate(t, &User{Username: "bob", Role: "user"})
+
+	code, resp := restoreBackup(t, admin, backup.Name)
+	previous, _ := resp["previous"].(string)
+	if code != http.StatusOK || !strings.HasPrefix(previous, restoreBackupPrefix) {
+		t.Fatalf("恢复返回 %d：%v", code, resp)
+	}
+	var usernames []string
+	tx.Model(&User{}).Order("id").Pluck("username", &usernames)
+	if strings.Join(usernames, ",") != "admin" {
+		t.Errorf("恢复后的用户 %v", usernames)
+	}
+	// 恢复前的数据保存在自动备份中
+	saved, err := openBackup(filepath.Join(dir, previous))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDatabase(saved)
+	var count int64
+	saved.Model(&User{}).Where("username = ?", "bob").Count(&count)
+	if count != 1 {
+		t.Error("自动备份中没有恢复前的数据")
+	}
+	var audits int64
+	tx.Model(&AuditLog{}).Where("action = ?", "backup.restore").Count(&audits)
+	if audits != 1 {
+		t.Errorf("审计日志 %d 条", audits)
+	}
+}
+
+// 损坏、不是本系统或版本比当前程序新的备份不能恢复，当前数据库保持不变
+func TestRestoreRejectsInvalidBackup(t *testing.T) {
+	tx, dir := setupBackupTest(t)
+	admin := User{Username: "admin", Role: "admin"}
+	mustCreate(t, &admin)
+
+	if err := os.WriteFile(filepath.Join(dir, "broken.db"), []byte("SQLite format 3\x00 not really"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other, err := gorm.Open(sqlite.Open(filepath.Join(dir, "other.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Exec("CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT)")
+	closeDatabase(other)
+	if err := backupDatabase(filepath.Join(dir, "newer.db")); err != nil {
+		t.Fatal(err)
+	}
+	newer, err := gorm.Open(sqlite.Open(filepath.Join(dir, "newer.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer.Create(&SchemaMigration{Version: 9999, Name: "future", AppliedAt: time.Now()})
+	closeDatabase(newer)
+
+	cases := []struct {
+		name, file string
+		want       int
+		contains   string
+	}{
+		{"文件损坏", "broken.db", http.StatusBadRequest, errInvalidBackup.Error()},
+		{"不是本系统的数据库", "other.db", http.StatusBadRequest, "不是会议室预订系统的数据库"},
+		{"版本比当前程序新", "newer.db", http.StatusBadRequest, "比当前程序新"},
+		{"不存在", "missing.db", http.StatusNotFound, "备份不存在"},
+		{"路径不在备份目录中", "../test.db", http.StatusNotFound, "备份不存在"},
+		{"扩展名不是 .db", "notes.txt", http.StatusNotFound, "备份不存在"},
+	}
+	for _, tc := range cases {
+		code, resp := restoreBackup(t, admin, tc.file)
+		if msg, _ := resp["error"].(string); code != tc.want || !strings.Contains(msg, tc.contains) {
+			t.Errorf("%s：返回 %d：%v", tc.name, code, resp)
+		}
+	}
+	var count int64
+	tx.Model(&User{}).Count(&count)
+	if count != 1 || countBackups(t, restoreBackupPrefix) != 0 {
+		t.Errorf("恢复失败后数据库被修改：%d 个用户，%d 个自动备份", count, countBackups(t, restoreBackupPrefix))
+	}
+}
+
+// 只按保留数清理定时和手动备份，不删除恢复前的自动备份
+func TestPruneBackups(t *testing.T) {
+	_, dir := setupBackupTest(t)
+	if err := backupDatabase(filepath.Join(dir, restoreBackupPrefix+"20300101-000000.db")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := createBackup(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countBackups(t, backupPrefix); n != 2 {
+		t.Errorf("保留了 %d 个备份", n)
+	}
+	if n := countBackups(t, restoreBackupPrefix); n != 1 {
+		t.Errorf("恢复前的自动备份剩余 %d 个", n)
+	}
+}
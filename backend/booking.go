@@ -0,0 +1,436 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// bookingError 预订校验失败，Status 为对应的 HTTP 状态码，Error() 为面向用户的提示
+type bookingError struct {
+	Status  int
+	Message string
+	Start   *time.Time // 冲突时间段的开始时间
+}
+
+func (e *bookingError) Error() string { return e.Message }
+
+// NewBooking 新建预订的内容，单次和周期预订共用
+type NewBooking struct {
+	Room           Room
+	UserID         uint
+	Slots          []timeSlot
+	Reason         string
+	Reasons        []string // 每个时间段的事由，为空时均使用 Reason
+	Visibility     string
+	ParticipantIDs []uint
+	Recurrence     *RecurrenceRule // 非空时创建周期预订
+	SeriesCount    int             // 周期规则展开的总次数，含被排除的日期；为0时等于 len(Slots)
+	SeriesFirst    *timeSlot       // 周期规则的第一次，首次被排除时与 Slots[0] 不同
+	RecurrenceIDs  []time.Time     // 每个时间段在周期中的原始开始时间，为空时与 Slots 相同
+}
+
+// 按 bookRoomHandler 的规则校验预订时间段：开放时间、已有预订、他人临时占用及配额。
+// 修改已有预订时 excludeID 为其ID，冲突和配额检查不计入自身，新建时为 0
+func checkBookingSlots(tx *gorm.DB, room Room, userID, excludeID uint, slots []timeSlot) error {
+	if err := checkSlotAvailability(tx, room, userID, excludeID, slots); err != nil {
+		return err
+	}
+	// 检查预订配额
+	if err := checkBookingQuota(tx, userID, room.ID, excludeID, slots); err != nil {
+		var qe *quotaError
+		if errors.As(err, &qe) {
+			return &bookingError{Status: http.StatusForbidden, Message: qe.Error()}
+		}
+		return err
+	}
+	return nil
+}
+
+// 校验时间段在开放时间内，且未被预订或被他人临时占用。
+// 应与写入预订在同一事务中调用，校验前锁定会议室，直到事务结束；excludeID 同 checkBookingSlots
+func checkSlotAvailability(tx *gorm.DB, room Room, userID, excludeID uint, slots []timeSlot) error {
+	if err := lockRoom(tx, room.ID); err != nil {
+		return err
+	}
+	for _, slot := range slots {
+		slot := slot
+		// 检查开放时间
+		if err := checkBusinessHours(room, slot.Start, slot.End); err != nil {
+			return &bookingError{Status: http.StatusBadRequest, Message: err.Error()}
+		}
+		// 检查时间冲突
+		if hasBookingConflict(tx, room.ID, slot.Start, slot.End, excludeID) {
+			return &bookingError{Status: http.StatusConflict, Message: "该时间段已被预订", Start: &slot.Start}
+		}
+		// 检查是否被他人临时占用
+		if hasHoldConflict(tx, room.ID, slot.Start, slot.End, userID) {
+			return &bookingError{Status: http.StatusConflict, Message: "该时间段已被他人临时占用", Start: &slot.Start}
+		}
+	}
+	return nil
+}
+
+// 在事务中创建预订，周期预订同时创建 BookingSeries，调用前需已通过 checkBookingSlots
+func createBookings(tx *gorm.DB, nb NewBooking) ([]Booking, *BookingSeries, error) {
+	bookings := make([]Booking, 0, len(nb.Slots))
+	for i, slot := range nb.Slots {
+		reason := nb.Reason
+		if len(nb.Reasons) > 0 {
+			reason = nb.Reasons[i]
+		}
+		bookings = append(bookings, Booking{
+			RoomID:         nb.Room.ID,
+			UserID:         nb.UserID,
+			StartTime:      slot.Start,
+			EndTime:        slot.End,
+			Reason:         reason,
+			Visibility:     nb.Visibility,
+			ParticipantIDs: nb.ParticipantIDs,
+		})
+	}
+	var series *BookingSeries
+	if nb.Recurrence != nil {
+		count := nb.SeriesCount
+		if count == 0 {
+			count = len(nb.Slots)
+		}
+		series = &BookingSeries{
+			RoomID:    nb.Room.ID,
+			UserID:    nb.UserID,
+			Freq:      nb.Recurrence.Freq,
+			Interval:  nb.Recurrence.Interval,
+			Count:     count,
+			Until:     nb.Recurrence.Until,
+			TimeZone:  nb.Room.location().String(),
+			StartTime: nb.Slots[0].Start,
+			EndTime:   nb.Slots[0].End,
+		}
+		if nb.SeriesFirst != nil {
+			series.StartTime = nb.SeriesFirst.Start
+			series.EndTime = nb.SeriesFirst.End
+		}
+		if series.Interval <= 0 {
+			series.Interval = 1
+		}
+		if err := tx.Create(series).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	for i := range bookings {
+		if series != nil {
+			recurrenceID := nb.Slots[i].Start
+			if len(nb.RecurrenceIDs) > 0 {
+				recurrenceID = nb.RecurrenceIDs[i]
+			}
+			bookings[i].SeriesID = &series.ID
+			bookings[i].RecurrenceID = &recurrenceID
+		}
+		if err := tx.Create(&bookings[i]).Error; err != nil {
+			return nil, nil, err
+		}
+		if err := saveParticipants(tx, bookings[i].ID, nb.ParticipantIDs); err != nil {
+			return nil, nil, err
+		}
+		publishEvent(tx, EventBookingCreated, bookingEventData(bookings[i], nb.Room))
+	}
+	syncReminders(tx, bookings...)
+	return bookings, series, nil
+}
+
+// 提前结束进行中的预订，结束时间取整到分钟，刚开始的会议直接结束于当前时间。之后的时间段随即释放
+func endBookingNow(tx *gorm.DB, b *Booking, now time.Time) error {
+	end := now.Truncate(time.Minute)
+	if !end.After(b.StartTime) {
+		end = now
+	}
+	b.EndTime = end
+	b.Sequence++
+	if err := tx.Model(b).Updates(map[string]interface{}{"end_time": b.EndTime, "sequence": b.Sequence}).Error; err != nil {
+		return err
+	}
+	publishBookingEvent(tx, EventBookingUpdated, *b)
+	return nil
+}
+
+// 预订已被取消，包括并发请求先一步取消的情况
+var errBookingCancelled = &bookingError{Status: http.StatusBadRequest, Message: "该预订已取消"}
+
+// 取消预订，记录取消人、时间和原因。以状态为条件更新，同一预订被并发取消时只有一个请求成功，
+// 其余返回 errBookingCancelled，不会重复发布事件和发送通知
+func cancelBooking(tx *gorm.DB, b *Booking, userID uint, reason string, now time.Time) error {
+	result := tx.Model(&Booking{}).Where("id = ? AND status = ?", b.ID, BookingStatusActive).Updates(map[string]interface{}{
+		"status":        BookingStatusCancelled,
+		"cancelled_at":  now,
+		"cancelled_by":  userID,
+		"cancel_reason": reason,
+		"sequence":      gorm.Expr("sequence + 1"),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errBookingCancelled
+	}
+	return tx.First(b, b.ID).Error
+}
+
+// 写入预订校验失败的响应，非 bookingError 时返回 500 和 fallback 提示
+func writeBookingError(c *gin.Context, err error, fallback string) {
+	var be *bookingError
+	if !errors.As(err, &be) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+		return
+	}
+	if be.Start != nil {
+		c.JSON(be.Status, gin.H{"error": be.Message, "start_time": *be.Start})
+		return
+	}
+	c.JSON(be.Status, gin.H{"error": be.Message})
+}
+
+// 单次延长的最大分钟数
+const maxExtendMinutes = 4 * 60
+
+// 延长预订请求体
+type ExtendBookingRequest struct {
+	Minutes int `json:"minutes" binding:"required"` // 延长的分钟数，最多240
+}
+
+// 读取当前用户可修改的预订：本人或管理员，且未取消
+func bookingForChange(c *gin.Context) (Booking, uint, bool) {
+	var booking Booking
+	if err := db.First(&booking, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "预订不存在"})
+		return booking, 0, false
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return booking, 0, false
+	}
+	role, _ := c.Get("role")
+	if booking.UserID != userID && role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限修改该预订"})
+		return booking, 0, false
+	}
+	if booking.Status == BookingStatusCancelled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "该预订已取消"})
+		return booking, 0, false
+	}
+	return booking, userID, true
+}
+
+// @Summary 提前结束预订
+// @Description 将进行中预订的结束时间改为当前时间（取整到分钟），释放剩余时间段
+// @Tags 预订
+// @Produce json
+// @Param id path int true "预订ID"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/bookings/{id}/end [post]
+func endBookingHandler(c *gin.Context) {
+	booking, _, ok := bookingForChange(c)
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	if booking.StartTime.After(now) || !booking.EndTime.After(now) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "只能提前结束进行中的预订"})
+		return
+	}
+	before := booking
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := endBookingNow(tx, &booking, now); err != nil {
+			return err
+		}
+		recordAudit(tx, c, "booking.end", "booking", booking.ID, before, booking)
+		return nil
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "结束会议失败"})
+		return
+	}
+	var room Room
+	db.First(&room, booking.RoomID)
+	booking.localize(room.location())
+	c.JSON(http.StatusOK, gin.H{"message": "会议已结束", "booking": booking})
+}
+
+// @Summary 延长预订
+// @Description 将未结束预订的结束时间推后指定分钟数，延长部分须在开放时间内且未被占用，并计入配额
+// @Tags 预订
+// @Accept json
+// @Produce json
+// @Param id path int true "预订ID"
+// @Param data body ExtendBookingRequest true "延长参数"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/bookings/{id}/extend [post]
+func extendBookingHandler(c *gin.Context) {
+	var req ExtendBookingRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Minutes <= 0 || req.Minutes > maxExtendMinutes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "延长时间须为 1-240 分钟"})
+		return
+	}
+	booking, userID, ok := bookingForChange(c)
+	if !ok {
+		return
+	}
+	if !booking.EndTime.After(time.Now().UTC()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "已结束的预订无法延长"})
+		return
+	}
+	var room Room
+	if err := db.First(&room, booking.RoomID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会议室不存在"})
+		return
+	}
+	before := booking
+	oldEnd := booking.EndTime
+	slot := timeSlot{Start: booking.StartTime, End: oldEnd.Add(time.Duration(req.Minutes) * time.Minute)}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 按延长后的时间段校验，冲突和配额检查不计入自身
+		check := checkBookingSlots
+		if booking.UserID == 0 {
+			// 门口平板的即时预订没有预订人，不计配额
+			check = checkSlotAvailability
+		}
+		if err := check(tx, room, booking.UserID, booking.ID, []timeSlot{slot}); err != nil {
+			return err
+		}
+		booking.EndTime = slot.End
+		booking.Sequence++
+		if err := tx.Save(&booking).Error; err != nil {
+			return err
+		}
+		publishEvent(tx, EventBookingUpdated, bookingEventData(booking, room))
+		recordAudit(tx, c, "booking.extend", "booking", booking.ID, before, booking)
+		notifyBookings(tx, NotifyChange, []Booking{booking}, userID)
+		return nil
+	})
+	var be *bookingError
+	if errors.As(err, &be) && be.Status == http.StatusConflict {
+		// 告知最多可以延长到何时
+		resp := gin.H{"error": "延长的时间段已被占用"}
+		if next, ok := nextBooking(db, room.ID, oldEnd.Add(-time.Second)); ok && next.StartTime.Before(slot.End) {
+			resp["available_until"] = next.StartTime.UTC()
+		}
+		c.JSON(http.StatusConflict, resp)
+		return
+	}
+	if err != nil {
+		writeBookingError(c, err, "延长失败")
+		return
+	}
+	booking.localize(room.location())
+	c.JSON(http.StatusOK, gin.H{"message": "延长成功", "booking": booking})
+}
+
+// 预订列表和导出共用的筛选条件
+type bookingFilter struct {
+	roomID           uint
+	userID           uint
+	status           string // 为空时按 includeCancelled 决定是否包含已取消的预订
+	includeCancelled bool
+	startTime        *time.Time
+	endTime          *time.Time
+	keyword          string // 匹配预订事由
+}
+
+// 解析筛选参数：room_id、user_id、status、include_cancelled、与 date 或 start_time、end_time 重叠的时间段及关键字 q。
+// 不带时区的时间按 loc 解释。参数无效时返回面向用户的提示
+func parseBookingFilter(c *gin.Context, loc *time.Location) (bookingFilter, string) {
+	f := bookingFilter{
+		status:           c.Query("status"),
+		includeCancelled: c.Query("include_cancelled") == "true",
+		keyword:          strings.TrimSpace(c.Query("q")),
+	}
+	for field, dest := range map[string]*uint{"room_id": &f.roomID, "user_id": &f.userID} {
+		if v := c.Query(field); v != "" {
+			id, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return f, field + " 无效"
+			}
+			*dest = uint(id)
+		}
+	}
+	switch f.status {
+	case "", BookingStatusActive, BookingStatusCancelled:
+	default:
+		return f, "状态无效"
+	}
+	if date := c.Query("date"); date != "" {
+		dayStart, dayEnd, ok := localDayRange(date, loc)
+		if !ok {
+			return f, "日期格式错误"
+		}
+		f.startTime, f.endTime = &dayStart, &dayEnd
+	}
+	if v := c.Query("start_time"); v != "" {
+		t, ok := parseTimeParam(v, loc)
+		if !ok {
+			return f, "时间格式错误"
+		}
+		f.startTime = &t
+	}
+	if v := c.Query("end_time"); v != "" {
+		t, ok := parseTimeParam(v, loc)
+		if !ok {
+			return f, "时间格式错误"
+		}
+		f.endTime = &t
+	}
+	return f, ""
+}
+
+// 列名带表名前缀，可用于联表查询
+func (f bookingFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.roomID != 0 {
+		query = query.Where("bookings.room_id = ?", f.roomID)
+	}
+	if f.userID != 0 {
+		query = query.Where("bookings.user_id = ?", f.userID)
+	}
+	switch {
+	case f.status != "":
+		query = query.Where("bookings.status = ?", f.status)
+	case !f.includeCancelled:
+		query = query.Where("bookings.status <> ?", BookingStatusCancelled)
+	}
+	if f.startTime != nil {
+		query = query.Where("bookings.end_time > ?", *f.startTime)
+	}
+	if f.endTime != nil {
+		query = query.Where("bookings.start_time < ?", *f.endTime)
+	}
+	if f.keyword != "" {
+		query = query.Where(likeCondition("bookings.reason"), likePattern(f.keyword))
+	}
+	return query
+}
+
+// 按预订人或事由筛选时，非管理员只能匹配到自己可以查看详情的预订，避免通过筛选结果推断私密预订的内容
+func (f bookingFilter) restrictPrivate(query *gorm.DB, viewerID uint, role interface{}) *gorm.DB {
+	if role == "admin" || (f.userID == 0 && f.keyword == "") {
+		return query
+	}
+	attending := db.Model(&BookingParticipant{}).Select("booking_id").Where("user_id = ?", viewerID)
+	return query.Where("(bookings.visibility <> ? OR bookings.user_id = ? OR bookings.id IN (?))", VisibilityPrivate, viewerID, attending)
+}
+
+// 请求的时区：tz 参数，未指定时使用用户偏好时区
+func requestLocation(c *gin.Context) (*time.Location, bool) {
+	if name := c.Query("tz"); name != "" {
+		if !validTimeZone(name) {
+			return nil, false
+		}
+		return loadLocation(name), true
+	}
+	tz, _ := c.Get("time_zone")
+	return loadLocation(fmt.Sprint(tz)), true
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 创建测试用的用户和会议室
+func createBookingFixtures(t *testing.T, tx *gorm.DB) (User, Room) {
+	t.Helper()
+	user := User{Username: "alice", Role: "user"}
+	room := Room{Name: "A101", Capacity: 6, TimeZone: "UTC"}
+	for _, v := range []interface{}{&user, &room} {
+		if err := tx.Create(v).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user, room
+}
+
+// 同一时间段的并发预订，锁定会议室后只有一个成功，其余返回冲突
+func TestConcurrentBookingConflict(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, tx *gorm.DB) {
+		user, room := createBookingFixtures(t, tx)
+		if tx.Dialector.Name() == DBDriverSQLite {
+			// SQLite 不支持行锁，写事务依次执行
+			sqlDB, err := tx.DB()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sqlDB.SetMaxOpenConns(1)
+		}
+		start := time.Date(2030, 5, 6, 9, 0, 0, 0, time.UTC)
+		const workers = 8
+		var wg sync.WaitGroup
+		errs := make([]error, workers)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				// 各自的时间段互相重叠
+				slot := timeSlot{Start: start.Add(time.Duration(i) * time.Minute), End: start.Add(time.Hour)}
+				errs[i] = tx.Transaction(func(tx *gorm.DB) error {
+					if err := checkSlotAvailability(tx, room, user.ID, 0, []timeSlot{slot}); err != nil {
+						return err
+					}
+					// 扩大检查与写入之间的间隔
+					time.Sleep(20 * time.Millisecond)
+					_, _, err := createBookings(tx, NewBooking{Room: room, UserID: user.ID, Slots: []timeSlot{slot}})
+					return err
+				})
+			}(i)
+		}
+		wg.Wait()
+
+		succeeded := 0
+		for i, err := range errs {
+			var be *bookingError
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.As(err, &be) && be.Status == http.StatusConflict:
+			default:
+				t.Errorf("第 %d 个预订返回 %v，应成功或冲突", i, err)
+			}
+		}
+		var count int64
+		tx.Model(&Booking{}).Where("room_id = ?", room.ID).Count(&count)
+		if succeeded != 1 || count != 1 {
+			t.Errorf("成功 %d 个，写入 %d 个预订，均应为 1", succeeded, count)
+		}
+	})
+}
+
+// 修改已有预订时，冲突和配额检查不计入自身
+func TestBookingChecksExcludeBooking(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, tx *gorm.DB) {
+		user, room := createBookingFixtures(t, tx)
+		start := time.Now().UTC().Truncate(time.Hour).Add(48 * time.Hour)
+		existing := Booking{RoomID: room.ID, UserID: user.ID, StartTime: start, EndTime: start.Add(time.Hour), Status: BookingStatusActive}
+		if err := tx.Create(&existing).Error; err != nil {
+			t.Fatal(err)
+		}
+		quota := BookingQuota{Scope: QuotaScopeUser, UserID: user.ID, HoursPerWeek: 1.5, MaxActiveBookings: 1}
+		if err := tx.Create(&quota).Error; err != nil {
+			t.Fatal(err)
+		}
+		// 延长 30 分钟
+		extended := timeSlot{Start: start, End: start.Add(90 * time.Minute)}
+
+		if !hasBookingConflict(tx, room.ID, extended.Start, extended.End, 0) {
+			t.Error("未排除已有预订时应冲突")
+		}
+		if hasBookingConflict(tx, room.ID, extended.Start, extended.End, existing.ID) {
+			t.Error("排除自身后不应冲突")
+		}
+		if err := checkBookingSlots(tx, room, user.ID, existing.ID, []timeSlot{extended}); err != nil {
+			t.Errorf("排除自身后校验失败: %v", err)
+		}
+
+		var be *bookingError
+		err := checkBookingSlots(tx, room, user.ID, 0, []timeSlot{extended})
+		if !errors.As(err, &be) || be.Status != http.StatusConflict {
+			t.Errorf("未排除自身时返回 %v，应为冲突", err)
+		}
+
+		// 配额：已有 1 小时，延长后共 1.5 小时，不超过每周 1.5 小时和 1 个未完成预订
+		if err := checkBookingQuota(tx, user.ID, room.ID, existing.ID, []timeSlot{extended}); err != nil {
+			t.Errorf("排除自身后配额检查失败: %v", err)
+		}
+		var qe *quotaError
+		if err := checkBookingQuota(tx, user.ID, room.ID, 0, []timeSlot{extended}); !errors.As(err, &qe) {
+			t.Errorf("未排除自身时配额检查返回 %v，应超出配额", err)
+		}
+		longer := timeSlot{Start: start, End: start.Add(2 * time.Hour)}
+		if err := checkBookingQuota(tx, user.ID, room.ID, existing.ID, []timeSlot{longer}); !errors.As(err, &qe) {
+			t.Errorf("延长到 2 小时返回 %v，应超出每周时长配额", err)
+		}
+
+		// 校验不修改已有预订的状态
+		var reloaded Booking
+		tx.First(&reloaded, existing.ID)
+		if reloaded.Status != BookingStatusActive {
+			t.Errorf("已有预订的状态变为 %q", reloaded.Status)
+		}
+	})
+}
+
+// 两个请求读到同一个未取消的预订后先后取消，只有第一个成功
+func TestCancelBookingOnce(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, tx *gorm.DB) {
+		user, room := createBookingFixtures(t, tx)
+		start := time.Now().UTC().Truncate(time.Hour).Add(48 * time.Hour)
+		booking := Booking{RoomID: room.ID, UserID: user.ID, StartTime: start, EndTime: start.Add(time.Hour), Status: BookingStatusActive}
+		if err := tx.Create(&booking).Error; err != nil {
+			t.Fatal(err)
+		}
+		first, stale := booking, booking
+		if err := cancelBooking(tx, &first, user.ID, "改期", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if first.Status != BookingStatusCancelled || first.Sequence != 1 || first.CancelReason != "改期" || first.CancelledBy == nil || *first.CancelledBy != user.ID {
+			t.Errorf("取消后的预订 %+v", first)
+		}
+		if err := cancelBooking(tx, &stale, user.ID, "重复", time.Now()); err != errBookingCancelled {
+			t.Errorf("重复取消返回 %v，应为 errBookingCancelled", err)
+		}
+		var reloaded Booking
+		tx.First(&reloaded, booking.ID)
+		if reloaded.Sequence != 1 || reloaded.CancelReason != "改期" {
+			t.Errorf("重复取消修改了预订 %+v", reloaded)
+		}
+	})
+}
+
+func TestCancelBookingHandler(t *testing.T) {
+	tx := setupTestDB(t)
+	user, room := createBookingFixtures(t, tx)
+	start := time.Now().UTC().Truncate(time.Hour).Add(48 * time.Hour)
+	booking := Booking{RoomID: room.ID, UserID: user.ID, StartTime: start, EndTime: start.Add(time.Hour), Status: BookingStatusActive}
+	mustCreate(t, &booking)
+
+	cancel := func() (int, map[string]interface{}) {
+		return callHandler(t, cancelBookingHandler, user, http.MethodDelete, "/api/bookings", idParam(booking.ID), CancelBookingRequest{Reason: "改期"})
+	}
+	if code, resp := cancel(); code != http.StatusOK {
+		t.Fatalf("取消返回 %d：%v", code, resp)
+	}
+	if code, resp := cancel(); code != http.StatusBadRequest || resp["error"] != "该预订已取消" {
+		t.Errorf("重复取消返回 %d：%v", code, resp)
+	}
+	var events, audits int64
+	tx.Model(&ChangeEvent{}).Where("event = ?", EventBookingCancelled).Count(&events)
+	tx.Model(&AuditLog{}).Where("action = ?", "booking.cancel").Count(&audits)
+	if events != 1 || audits != 1 {
+		t.Errorf("取消事件 %d 个，审计日志 %d 条，均应为 1", events, audits)
+	}
+}
@@ -0,0 +1,1524 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CalDAV 路径前缀及请求体大小上限
+const (
+	caldavPrefix  = "/caldav"
+	maxCalDAVBody = 1 << 20
+)
+
+// XML 命名空间
+const (
+	nsDAV    = "DAV:"
+	nsCalDAV = "urn:ietf:params:xml:ns:caldav"
+	nsCS     = "http://calendarserver.org/ns/"
+)
+
+// CalDAV 支持的请求方法，OPTIONS 单独注册且无需鉴权
+var caldavMethods = []string{"PROPFIND", "REPORT", "GET", "HEAD", "PUT", "DELETE"}
+
+// 只读的请求方法。日历订阅令牌出现在分享给第三方日历服务的订阅地址中，只能用于这些方法
+var caldavReadMethods = map[string]bool{"PROPFIND": true, "REPORT": true, "GET": true, "HEAD": true}
+
+// AppPassword CalDAV 应用专用密码，日历客户端用它代替登录密码读写预订，可随时撤销
+type AppPassword struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index" json:"-"`
+	Name       string     `json:"name"`
+	Token      string     `gorm:"uniqueIndex;size:191" json:"-"` // 仅在创建时返回
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// 创建应用专用密码请求体
+type CreateAppPasswordRequest struct {
+	Name string `json:"name" binding:"required"` // 用途，如使用的设备或客户端
+}
+
+// CalendarObject 通过 CalDAV 创建的日历对象，记录客户端使用的资源名和 UID。
+// 应用内创建的预订使用默认资源名 booking-<id>.ics 或 series-<id>.ics
+type CalendarObject struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	RoomID    uint   `gorm:"uniqueIndex:idx_calendar_object" json:"room_id"`
+	Name      string `gorm:"uniqueIndex:idx_calendar_object;size:191" json:"name"`
+	UID       string `gorm:"index" json:"uid"`
+	BookingID *uint  `gorm:"index" json:"booking_id,omitempty"`
+	SeriesID  *uint  `gorm:"index" json:"series_id,omitempty"`
+}
+
+// davObject 一个日历对象资源：单次预订或整个周期预订
+type davObject struct {
+	Name     string
+	SeriesID *uint
+	Bookings []Booking // 周期预订包含所有日期（含已取消）
+}
+
+// 预订人，周期预订以第一次为准
+func (o davObject) ownerID() uint {
+	return o.Bookings[0].UserID
+}
+
+// 根据预订内容计算 ETag，DTSTAMP 等每次生成都会变化的内容不参与计算
+func (o davObject) etag() string {
+	h := sha1.New()
+	for _, b := range o.Bookings {
+		fmt.Fprintf(h, "%d|%s|%d|%d|%s|%s|%d|%d\n", b.ID, b.Status, b.StartTime.Unix(), b.EndTime.Unix(),
+			b.Reason, b.Visibility, b.Sequence, b.UpdatedAt.UnixNano())
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// 是否有未取消的预订与 [start, end) 重叠
+func (o davObject) overlaps(start, end time.Time) bool {
+	for _, b := range o.Bookings {
+		if b.Status != BookingStatusCancelled && b.EndTime.After(start) && b.StartTime.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// davNode 请求体中的 XML 元素
+type davNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []davNode  `xml:",any"`
+}
+
+// 返回指定名称的直接子元素
+func (n *davNode) child(space, local string) *davNode {
+	for i := range n.Children {
+		if n.Children[i].XMLName.Space == space && n.Children[i].XMLName.Local == local {
+			return &n.Children[i]
+		}
+	}
+	return nil
+}
+
+// 深度优先查找指定名称的元素
+func (n *davNode) find(space, local string) *davNode {
+	if n.XMLName.Space == space && n.XMLName.Local == local {
+		return n
+	}
+	for i := range n.Children {
+		if found := n.Children[i].find(space, local); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func (n *davNode) attr(local string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// davProp 响应中的一个属性，Value 为已转义的 XML 内容
+type davProp struct {
+	Name  xml.Name
+	Value string
+}
+
+// davResponse multistatus 中的一个 response，Status 非0时表示资源本身的状态
+type davResponse struct {
+	Href    string
+	Props   []davProp
+	Missing []xml.Name
+	Status  int
+}
+
+// davError CalDAV 写操作失败，Condition 为 RFC 4791 定义的前置条件元素
+type davError struct {
+	Status    int
+	Condition xml.Name
+	Message   string
+}
+
+func (e *davError) Error() string { return e.Message }
+
+func xmlText(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func davName(space, local string) xml.Name {
+	return xml.Name{Space: space, Local: local}
+}
+
+// 生成 XML 元素，常用命名空间使用固定前缀
+func davElement(name xml.Name, inner string) string {
+	prefix, decl := "x", fmt.Sprintf(` xmlns:x="%s"`, xmlText(name.Space))
+	switch name.Space {
+	case nsDAV:
+		prefix, decl = "d", ""
+	case nsCalDAV:
+		prefix, decl = "cal", ""
+	case nsCS:
+		prefix, decl = "cs", ""
+	}
+	tag := prefix + ":" + name.Local
+	if inner == "" {
+		return "<" + tag + decl + "/>"
+	}
+	return "<" + tag + decl + ">" + inner + "</" + tag + ">"
+}
+
+func davHref(href string) string {
+	return "<d:href>" + xmlText(href) + "</d:href>"
+}
+
+func principalHref(user User) string {
+	return caldavPrefix + "/principals/" + url.PathEscape(user.Username) + "/"
+}
+
+func calendarHomeHref() string {
+	return caldavPrefix + "/rooms/"
+}
+
+func calendarHref(roomID uint) string {
+	return fmt.Sprintf("%s/rooms/%d/", caldavPrefix, roomID)
+}
+
+func objectHref(roomID uint, name string) string {
+	return calendarHref(roomID) + url.PathEscape(name)
+}
+
+// 写入 207 Multi-Status 响应
+func writeMultistatus(c *gin.Context, responses []davResponse) {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:cal="urn:ietf:params:xml:ns:caldav" xmlns:cs="http://calendarserver.org/ns/">`)
+	for _, r := range responses {
+		b.WriteString("<d:response>")
+		b.WriteString(davHref(r.Href))
+		if r.Status != 0 {
+			fmt.Fprintf(&b, "<d:status>HTTP/1.1 %d %s</d:status>", r.Status, http.StatusText(r.Status))
+		}
+		if len(r.Props) > 0 {
+			b.WriteString("<d:propstat><d:prop>")
+			for _, p := range r.Props {
+				b.WriteString(davElement(p.Name, p.Value))
+			}
+			b.WriteString("</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>")
+		}
+		if len(r.Missing) > 0 {
+			b.WriteString("<d:propstat><d:prop>")
+			for _, name := range r.Missing {
+				b.WriteString(davElement(name, ""))
+			}
+			b.WriteString("</d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>")
+		}
+		b.WriteString("</d:response>")
+	}
+	b.WriteString("</d:multistatus>")
+	c.Data(http.StatusMultiStatus, "application/xml; charset=utf-8", []byte(b.String()))
+}
+
+// 写入错误响应，带前置条件时使用 DAV:error 格式
+func writeDAVError(c *gin.Context, err error) {
+	var de *davError
+	if !errors.As(err, &de) {
+		var be *bookingError
+		if errors.As(err, &be) {
+			c.String(be.Status, be.Message)
+			return
+		}
+		c.String(http.StatusInternalServerError, "服务器内部错误")
+		return
+	}
+	if de.Condition.Local == "" {
+		c.String(de.Status, de.Message)
+		return
+	}
+	body := xml.Header + `<d:error xmlns:d="DAV:" xmlns:cal="urn:ietf:params:xml:ns:caldav">` +
+		davElement(de.Condition, "") + "<d:responsedescription>" + xmlText(de.Message) + "</d:responsedescription></d:error>"
+	c.Data(de.Status, "application/xml; charset=utf-8", []byte(body))
+}
+
+// 读取并解析 XML 请求体，请求体为空时返回 nil
+func readDAVBody(c *gin.Context) (*davNode, error) {
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxCalDAVBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxCalDAVBody {
+		return nil, &davError{Status: http.StatusRequestEntityTooLarge, Message: "请求体过大"}
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return nil, nil
+	}
+	var node davNode
+	if err := xml.Unmarshal(data, &node); err != nil {
+		return nil, &davError{Status: http.StatusBadRequest, Message: "XML 格式错误"}
+	}
+	return &node, nil
+}
+
+// 按请求中的 DAV:prop 选择属性，未指定时返回全部属性
+func selectProps(all []davProp, req *davNode) ([]davProp, []xml.Name) {
+	if req == nil || req.child(nsDAV, "allprop") != nil {
+		return all, nil
+	}
+	if req.child(nsDAV, "propname") != nil {
+		names := make([]davProp, 0, len(all))
+		for _, p := range all {
+			names = append(names, davProp{Name: p.Name})
+		}
+		return names, nil
+	}
+	prop := req.child(nsDAV, "prop")
+	if prop == nil {
+		return all, nil
+	}
+	var found []davProp
+	var missing []xml.Name
+	for _, want := range prop.Children {
+		ok := false
+		for _, p := range all {
+			if p.Name == want.XMLName {
+				found = append(found, p)
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			missing = append(missing, want.XMLName)
+		}
+	}
+	return found, missing
+}
+
+// 请求是否明确要求了某个属性
+func wantsProp(req *davNode, space, local string) bool {
+	if req == nil {
+		return false
+	}
+	prop := req.child(nsDAV, "prop")
+	return prop != nil && prop.child(space, local) != nil
+}
+
+// CalDAV 鉴权中间件：HTTP Basic（用户名 + 登录密码、应用专用密码或只读的日历订阅令牌）或 Bearer JWT
+func CalDAVAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, readOnly, ok := caldavUser(c.GetHeader("Authorization"))
+		if !ok {
+			c.Header("WWW-Authenticate", `Basic realm="Meeting Room CalDAV", charset="UTF-8"`)
+			c.String(http.StatusUnauthorized, "未登录")
+			c.Abort()
+			return
+		}
+		if readOnly && !caldavReadMethods[c.Request.Method] {
+			c.String(http.StatusForbidden, "日历订阅令牌只能读取日历，创建或修改预订请使用应用专用密码")
+			c.Abort()
+			return
+		}
+		c.Set("user_id", user.ID)
+		c.Set("username", user.Username)
+		c.Set("role", user.Role)
+		c.Set("nickname", user.Nickname)
+		c.Set("time_zone", user.TimeZone)
+		c.Set("caldav_user", user)
+		c.Next()
+	}
+}
+
+// 根据 Authorization 头获取用户，readOnly 表示使用日历订阅令牌登录，只能读取
+func caldavUser(header string) (user User, readOnly bool, ok bool) {
+	switch {
+	case strings.HasPrefix(header, "Bearer "):
+		claims, err := parseJWT(strings.TrimPrefix(header, "Bearer "))
+		if err != nil {
+			return user, false, false
+		}
+		return user, false, db.First(&user, claims.UserID).Error == nil
+	case strings.HasPrefix(header, "Basic "):
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, "Basic "))
+		if err != nil {
+			return user, false, false
+		}
+		username, password, found := strings.Cut(string(raw), ":")
+		if !found || password == "" {
+			return user, false, false
+		}
+		if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+			return user, false, false
+		}
+		if user.Password == password {
+			return user, false, true
+		}
+		// 应用专用密码，避免在日历客户端中保存登录密码
+		var app AppPassword
+		if db.Where("user_id = ? AND token = ?", user.ID, password).First(&app).Error == nil {
+			db.Model(&app).UpdateColumn("last_used_at", time.Now().UTC())
+			return user, false, true
+		}
+		var token CalendarToken
+		return user, true, db.Where("user_id = ? AND token = ?", user.ID, password).First(&token).Error == nil
+	}
+	return user, false, false
+}
+
+// @Summary 应用专用密码列表
+// @Description 当前用户的 CalDAV 应用专用密码，不含密码本身
+// @Tags 日历
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/user/app-passwords [get]
+func listAppPasswordsHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	passwords := []AppPassword{}
+	db.Where("user_id = ?", userID).Order("id").Find(&passwords)
+	c.JSON(http.StatusOK, gin.H{"app_passwords": passwords})
+}
+
+// @Summary 创建应用专用密码
+// @Description 创建用于 CalDAV 客户端的应用专用密码，可读写预订，返回的密码只显示一次
+// @Tags 日历
+// @Accept json
+// @Produce json
+// @Param data body CreateAppPasswordRequest true "用途"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/user/app-passwords [post]
+func createAppPasswordHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req CreateAppPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	token, err := randomHex(20)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成密码失败"})
+		return
+	}
+	app := AppPassword{UserID: userID, Name: strings.TrimSpace(req.Name), Token: token}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&app).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "app_password.create", "app_password", app.ID, nil, app)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "创建成功", "app_password": app, "password": token})
+}
+
+// @Summary 撤销应用专用密码
+// @Description 删除应用专用密码，使用它的日历客户端立即无法登录
+// @Tags 日历
+// @Produce json
+// @Param id path int true "应用专用密码ID"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/user/app-passwords/{id} [delete]
+func deleteAppPasswordHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var app AppPassword
+	if err := db.Where("user_id = ?", userID).First(&app, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "应用专用密码不存在"})
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&app).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "app_password.delete", "app_password", app.ID, app, nil)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已撤销"})
+}
+
+// @Summary CalDAV 服务发现
+// @Description 日历客户端通过 /.well-known/caldav 查找 CalDAV 根地址
+// @Tags 日历
+// @Success 301
+// @Router /.well-known/caldav [get]
+func caldavWellKnownHandler(c *gin.Context) {
+	c.Redirect(http.StatusMovedPermanently, caldavPrefix+"/")
+}
+
+// @Summary CalDAV 能力查询
+// @Description 返回支持的 DAV 能力和请求方法
+// @Tags 日历
+// @Success 200
+// @Router /caldav/{path} [options]
+func caldavOptionsHandler(c *gin.Context) {
+	c.Header("DAV", "1, 3, calendar-access")
+	c.Header("Allow", "OPTIONS, "+strings.Join(caldavMethods, ", "))
+	c.Status(http.StatusOK)
+}
+
+// @Summary CalDAV 服务
+// @Description 每个会议室是一个日历集合（/caldav/rooms/{id}/），支持 PROPFIND、REPORT（calendar-query、calendar-multiget、free-busy-query）、GET 及 PUT/DELETE 事件。
+// @Description 写操作与 POST /api/bookings 使用相同的开放时间、冲突、临时占用和配额检查。
+// @Description 使用 HTTP Basic 鉴权，密码可以是登录密码或应用专用密码，也支持 Bearer JWT；日历订阅令牌只能用于 PROPFIND、REPORT、GET 和 HEAD
+// @Tags 日历
+// @Param path path string true "资源路径"
+// @Success 207
+// @Security Bearer
+// @Router /caldav/{path} [propfind]
+func caldavHandler(c *gin.Context) {
+	user := c.MustGet("caldav_user").(User)
+	p := strings.Trim(c.Param("path"), "/")
+	var parts []string
+	if p != "" {
+		parts = strings.Split(p, "/")
+	}
+
+	switch {
+	case len(parts) == 0:
+		caldavCollection(c, user, caldavPrefix+"/", rootProps(user), func() []davResponse {
+			return []davResponse{
+				{Href: principalHref(user), Props: principalProps(user)},
+				{Href: calendarHomeHref(), Props: homeProps(user)},
+			}
+		})
+	case len(parts) == 2 && parts[0] == "principals":
+		if parts[1] != user.Username {
+			c.String(http.StatusNotFound, "资源不存在")
+			return
+		}
+		caldavCollection(c, user, principalHref(user), principalProps(user), nil)
+	case len(parts) == 1 && parts[0] == "rooms":
+		caldavCollection(c, user, calendarHomeHref(), homeProps(user), func() []davResponse {
+			var rooms []Room
+			db.Order("id").Find(&rooms)
+			ctags := roomCTags(db)
+			responses := make([]davResponse, 0, len(rooms))
+			for _, room := range rooms {
+				responses = append(responses, davResponse{Href: calendarHref(room.ID), Props: calendarProps(user, room, ctags[room.ID])})
+			}
+			return responses
+		})
+	case len(parts) == 2 && parts[0] == "rooms":
+		room, ok := caldavRoom(c, parts[1])
+		if !ok {
+			return
+		}
+		caldavCalendar(c, user, room)
+	case len(parts) == 3 && parts[0] == "rooms":
+		room, ok := caldavRoom(c, parts[1])
+		if !ok {
+			return
+		}
+		caldavObject(c, user, room, parts[2])
+	default:
+		c.String(http.StatusNotFound, "资源不存在")
+	}
+}
+
+func caldavRoom(c *gin.Context, id string) (Room, bool) {
+	var room Room
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil || db.First(&room, id).Error != nil {
+		c.String(http.StatusNotFound, "会议室不存在")
+		return room, false
+	}
+	return room, true
+}
+
+// 处理只读集合（根、主体、日历主目录）的请求
+func caldavCollection(c *gin.Context, user User, href string, props []davProp, children func() []davResponse) {
+	if c.Request.Method != "PROPFIND" {
+		c.String(http.StatusMethodNotAllowed, "不支持的请求方法")
+		return
+	}
+	req, err := readDAVBody(c)
+	if err != nil {
+		writeDAVError(c, err)
+		return
+	}
+	found, missing := selectProps(props, req)
+	responses := []davResponse{{Href: href, Props: found, Missing: missing}}
+	if c.GetHeader("Depth") != "0" && children != nil {
+		for _, child := range children() {
+			child.Props, child.Missing = selectProps(child.Props, req)
+			responses = append(responses, child)
+		}
+	}
+	writeMultistatus(c, responses)
+}
+
+func currentUserPrincipal(user User) davProp {
+	return davProp{Name: davName(nsDAV, "current-user-principal"), Value: davHref(principalHref(user))}
+}
+
+func rootProps(user User) []davProp {
+	return []davProp{
+		{Name: davName(nsDAV, "resourcetype"), Value: davElement(davName(nsDAV, "collection"), "")},
+		{Name: davName(nsDAV, "displayname"), Value: "会议室预订"},
+		currentUserPrincipal(user),
+		{Name: davName(nsCalDAV, "calendar-home-set"), Value: davHref(calendarHomeHref())},
+	}
+}
+
+func principalProps(user User) []davProp {
+	name := user.Nickname
+	if name == "" {
+		name = user.Username
+	}
+	return []davProp{
+		{Name: davName(nsDAV, "resourcetype"), Value: davElement(davName(nsDAV, "collection"), "") + davElement(davName(nsDAV, "principal"), "")},
+		{Name: davName(nsDAV, "displayname"), Value: xmlText(name)},
+		currentUserPrincipal(user),
+		{Name: davName(nsDAV, "principal-URL"), Value: davHref(principalHref(user))},
+		{Name: davName(nsCalDAV, "calendar-home-set"), Value: davHref(calendarHomeHref())},
+	}
+}
+
+func homeProps(user User) []davProp {
+	return []davProp{
+		{Name: davName(nsDAV, "resourcetype"), Value: davElement(davName(nsDAV, "collection"), "")},
+		{Name: davName(nsDAV, "displayname"), Value: "会议室"},
+		currentUserPrincipal(user),
+	}
+}
+
+// 权限集合，所有登录用户都可以在会议室日历中创建预订
+func privilegeSet(write bool) string {
+	privileges := []xml.Name{davName(nsDAV, "read"), davName(nsCalDAV, "read-free-busy")}
+	if write {
+		privileges = append(privileges, davName(nsDAV, "write"), davName(nsDAV, "write-content"), davName(nsDAV, "bind"), davName(nsDAV, "unbind"))
+	}
+	var b strings.Builder
+	for _, p := range privileges {
+		b.WriteString(davElement(davName(nsDAV, "privilege"), davElement(p, "")))
+	}
+	return b.String()
+}
+
+func calendarProps(user User, room Room, ctag string) []davProp {
+	description := roomLocationText(room)
+	if room.Description != "" {
+		description += "\n" + room.Description
+	}
+	reports := ""
+	for _, r := range []string{"calendar-query", "calendar-multiget", "free-busy-query"} {
+		reports += davElement(davName(nsDAV, "supported-report"), davElement(davName(nsDAV, "report"), davElement(davName(nsCalDAV, r), "")))
+	}
+	return []davProp{
+		{Name: davName(nsDAV, "resourcetype"), Value: davElement(davName(nsDAV, "collection"), "") + davElement(davName(nsCalDAV, "calendar"), "")},
+		{Name: davName(nsDAV, "displayname"), Value: xmlText(room.Name)},
+		{Name: davName(nsCalDAV, "calendar-description"), Value: xmlText(description)},
+		{Name: davName(nsCalDAV, "supported-calendar-component-set"), Value: `<cal:comp name="VEVENT"/>`},
+		{Name: davName(nsDAV, "supported-report-set"), Value: reports},
+		{Name: davName(nsDAV, "current-user-privilege-set"), Value: privilegeSet(true)},
+		{Name: davName(nsCS, "getctag"), Value: xmlText(ctag)},
+		currentUserPrincipal(user),
+	}
+}
+
+// 对象属性，withData 时附带脱敏后的日历数据
+func objectProps(user User, obj davObject, withData bool) []davProp {
+	props := []davProp{
+		{Name: davName(nsDAV, "resourcetype")},
+		{Name: davName(nsDAV, "getetag"), Value: xmlText(obj.etag())},
+		{Name: davName(nsDAV, "getcontenttype"), Value: "text/calendar; charset=utf-8; component=vevent"},
+		{Name: davName(nsDAV, "current-user-privilege-set"), Value: privilegeSet(obj.ownerID() == user.ID)},
+	}
+	if withData {
+		props = append(props, davProp{Name: davName(nsCalDAV, "calendar-data"), Value: xmlText(calendarObjectData(db, user, obj))})
+	}
+	return props
+}
+
+// 计算各会议室日历的 ctag，预订新增或修改时变化
+func roomCTags(tx *gorm.DB) map[uint]string {
+	var rows []struct {
+		RoomID      uint
+		Total       int64
+		LastUpdated string
+	}
+	tx.Model(&Booking{}).Select("room_id, COUNT(*) AS total, MAX(updated_at) AS last_updated").Group("room_id").Scan(&rows)
+	ctags := make(map[uint]string, len(rows))
+	for _, r := range rows {
+		sum := sha1.Sum([]byte(fmt.Sprintf("%d|%s", r.Total, r.LastUpdated)))
+		ctags[r.RoomID] = hex.EncodeToString(sum[:])
+	}
+	return ctags
+}
+
+// 日历对象的 iCalendar 内容，私密预订按当前用户脱敏；CalDAV 对象不能包含 METHOD
+func calendarObjectData(tx *gorm.DB, user User, obj davObject) string {
+	bookings := append([]Booking(nil), obj.Bookings...)
+	redactBookings(tx, user.ID, user.Role, bookings)
+	w := &icsWriter{}
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", icsProdID)
+	w.line("CALSCALE", "GREGORIAN")
+	w.events(tx, bookings)
+	w.line("END", "VCALENDAR")
+	return w.String()
+}
+
+// 默认资源名中的预订或周期ID
+func defaultObjectID(name, prefix string) (uint, bool) {
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".ics") {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".ics"), 10, 64)
+	return uint(id), err == nil && id > 0
+}
+
+// 会议室日历中的所有对象：近期未取消的单次预订，以及仍有未取消日期的周期预订
+func roomCalendarObjects(tx *gorm.DB, roomID uint) []davObject {
+	cutoff := time.Now().UTC().Add(-calendarFeedHistory)
+	var singles []Booking
+	tx.Scopes(activeBookings).Where("room_id = ? AND series_id IS NULL AND end_time > ?", roomID, cutoff).Order("start_time").Find(&singles)
+	var seriesIDs []uint
+	tx.Model(&Booking{}).Scopes(activeBookings).Where("room_id = ? AND series_id IS NOT NULL AND end_time > ?", roomID, cutoff).
+		Distinct("series_id").Pluck("series_id", &seriesIDs)
+	var seriesBookings []Booking
+	if len(seriesIDs) > 0 {
+		tx.Where("series_id IN ?", seriesIDs).Order("start_time").Find(&seriesBookings)
+	}
+	var stored []CalendarObject
+	tx.Where("room_id = ?", roomID).Find(&stored)
+	bookingNames := make(map[uint]string)
+	seriesNames := make(map[uint]string)
+	for _, o := range stored {
+		if o.BookingID != nil {
+			bookingNames[*o.BookingID] = o.Name
+		}
+		if o.SeriesID != nil {
+			seriesNames[*o.SeriesID] = o.Name
+		}
+	}
+
+	objects := make([]davObject, 0, len(singles)+len(seriesIDs))
+	for _, b := range singles {
+		name, ok := bookingNames[b.ID]
+		if !ok {
+			name = fmt.Sprintf("booking-%d.ics", b.ID)
+		}
+		objects = append(objects, davObject{Name: name, Bookings: []Booking{b}})
+	}
+	grouped := make(map[uint][]Booking)
+	for _, b := range seriesBookings {
+		grouped[*b.SeriesID] = append(grouped[*b.SeriesID], b)
+	}
+	sort.Slice(seriesIDs, func(i, j int) bool { return seriesIDs[i] < seriesIDs[j] })
+	for _, id := range seriesIDs {
+		id := id
+		name, ok := seriesNames[id]
+		if !ok {
+			name = fmt.Sprintf("series-%d.ics", id)
+		}
+		objects = append(objects, davObject{Name: name, SeriesID: &id, Bookings: grouped[id]})
+	}
+	return objects
+}
+
+// 按资源名查找会议室日历中的对象，已全部取消的对象视为不存在
+func loadCalendarObject(tx *gorm.DB, roomID uint, name string) (davObject, bool) {
+	obj := davObject{Name: name}
+	var bookingID, seriesID uint
+	var stored CalendarObject
+	if err := tx.Where("room_id = ? AND name = ?", roomID, name).First(&stored).Error; err == nil {
+		if stored.BookingID != nil {
+			bookingID = *stored.BookingID
+		} else if stored.SeriesID != nil {
+			seriesID = *stored.SeriesID
+		}
+	} else if id, ok := defaultObjectID(name, "booking-"); ok {
+		bookingID = id
+	} else if id, ok := defaultObjectID(name, "series-"); ok {
+		seriesID = id
+	}
+
+	switch {
+	case bookingID != 0:
+		var b Booking
+		if err := tx.Where("room_id = ? AND series_id IS NULL", roomID).First(&b, bookingID).Error; err != nil {
+			return obj, false
+		}
+		obj.Bookings = []Booking{b}
+	case seriesID != 0:
+		tx.Where("room_id = ? AND series_id = ?", roomID, seriesID).Order("start_time").Find(&obj.Bookings)
+		obj.SeriesID = &seriesID
+	}
+	for _, b := range obj.Bookings {
+		if b.Status != BookingStatusCancelled {
+			return obj, true
+		}
+	}
+	return obj, false
+}
+
+// 会议室日历集合的请求
+func caldavCalendar(c *gin.Context, user User, room Room) {
+	switch c.Request.Method {
+	case "PROPFIND":
+		req, err := readDAVBody(c)
+		if err != nil {
+			writeDAVError(c, err)
+			return
+		}
+		found, missing := selectProps(calendarProps(user, room, roomCTags(db)[room.ID]), req)
+		responses := []davResponse{{Href: calendarHref(room.ID), Props: found, Missing: missing}}
+		if c.GetHeader("Depth") != "0" {
+			withData := wantsProp(req, nsCalDAV, "calendar-data")
+			for _, obj := range roomCalendarObjects(db, room.ID) {
+				found, missing := selectProps(objectProps(user, obj, withData), req)
+				responses = append(responses, davResponse{Href: objectHref(room.ID, obj.Name), Props: found, Missing: missing})
+			}
+		}
+		writeMultistatus(c, responses)
+	case "REPORT":
+		caldavReport(c, user, room)
+	case "GET", "HEAD":
+		// 整个会议室日历，便于直接导入
+		var bookings []Booking
+		db.Scopes(activeBookings).Where("room_id = ? AND end_time > ?", room.ID, time.Now().UTC().Add(-calendarFeedHistory)).
+			Order("start_time").Find(&bookings)
+		redactBookings(db, user.ID, user.Role, bookings)
+		writeCalendar(c, fmt.Sprintf("room-%d.ics", room.ID), buildCalendar(db, room.Name, icsMethodPublish, bookings))
+	default:
+		c.String(http.StatusMethodNotAllowed, "不支持的请求方法")
+	}
+}
+
+// 解析 CalDAV 的 time-range 元素，缺少的一端视为不限制
+func parseTimeRange(n *davNode) (time.Time, time.Time, error) {
+	start := time.Unix(0, 0).UTC()
+	end := time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	if v := n.attr("start"); v != "" {
+		t, err := time.Parse("20060102T150405Z", v)
+		if err != nil {
+			return start, end, &davError{Status: http.StatusBadRequest, Message: "time-range 格式错误"}
+		}
+		start = t
+	}
+	if v := n.attr("end"); v != "" {
+		t, err := time.Parse("20060102T150405Z", v)
+		if err != nil {
+			return start, end, &davError{Status: http.StatusBadRequest, Message: "time-range 格式错误"}
+		}
+		end = t
+	}
+	return start, end, nil
+}
+
+// REPORT：calendar-query、calendar-multiget 和 free-busy-query
+func caldavReport(c *gin.Context, user User, room Room) {
+	req, err := readDAVBody(c)
+	if err != nil {
+		writeDAVError(c, err)
+		return
+	}
+	if req == nil {
+		c.String(http.StatusBadRequest, "缺少 REPORT 请求体")
+		return
+	}
+	withData := wantsProp(req, nsCalDAV, "calendar-data")
+	switch req.XMLName {
+	case davName(nsCalDAV, "calendar-multiget"):
+		var responses []davResponse
+		prefix := calendarHref(room.ID)
+		for _, n := range req.Children {
+			if n.XMLName != davName(nsDAV, "href") {
+				continue
+			}
+			href := strings.TrimSpace(n.Text)
+			if u, err := url.Parse(href); err == nil {
+				href = u.Path
+			}
+			obj, ok := davObject{}, false
+			if strings.HasPrefix(href, prefix) {
+				obj, ok = loadCalendarObject(db, room.ID, strings.TrimPrefix(href, prefix))
+			}
+			if !ok {
+				responses = append(responses, davResponse{Href: href, Status: http.StatusNotFound})
+				continue
+			}
+			found, missing := selectProps(objectProps(user, obj, withData), req)
+			responses = append(responses, davResponse{Href: objectHref(room.ID, obj.Name), Props: found, Missing: missing})
+		}
+		writeMultistatus(c, responses)
+	case davName(nsCalDAV, "calendar-query"):
+		filter := req.child(nsCalDAV, "filter")
+		// 只有 VEVENT，查询其他组件类型时返回空结果
+		responses := []davResponse{}
+		if filter != nil {
+			if vcal := filter.child(nsCalDAV, "comp-filter"); vcal != nil {
+				if comp := vcal.child(nsCalDAV, "comp-filter"); comp != nil && comp.attr("name") != "VEVENT" {
+					writeMultistatus(c, responses)
+					return
+				}
+			}
+		}
+		start, end := time.Unix(0, 0).UTC(), time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+		if filter != nil {
+			if tr := filter.find(nsCalDAV, "time-range"); tr != nil {
+				if start, end, err = parseTimeRange(tr); err != nil {
+					writeDAVError(c, err)
+					return
+				}
+			}
+		}
+		for _, obj := range roomCalendarObjects(db, room.ID) {
+			if !obj.overlaps(start, end) {
+				continue
+			}
+			found, missing := selectProps(objectProps(user, obj, withData), req)
+			responses = append(responses, davResponse{Href: objectHref(room.ID, obj.Name), Props: found, Missing: missing})
+		}
+		writeMultistatus(c, responses)
+	case davName(nsCalDAV, "free-busy-query"):
+		tr := req.child(nsCalDAV, "time-range")
+		if tr == nil {
+			c.String(http.StatusBadRequest, "free-busy-query 缺少 time-range")
+			return
+		}
+		start, end, err := parseTimeRange(tr)
+		if err != nil {
+			writeDAVError(c, err)
+			return
+		}
+		c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(freeBusyData(db, room, start, end)))
+	default:
+		writeDAVError(c, &davError{Status: http.StatusForbidden, Condition: davName(nsDAV, "supported-report"), Message: "不支持的 REPORT 类型"})
+	}
+}
+
+// 会议室在 [start, end) 内的忙闲信息，临时占用标记为 BUSY-TENTATIVE
+func freeBusyData(tx *gorm.DB, room Room, start, end time.Time) string {
+	var bookings []Booking
+	tx.Scopes(activeBookings).Where("room_id = ? AND end_time > ? AND start_time < ?", room.ID, start, end).Order("start_time").Find(&bookings)
+	var holds []BookingHold
+	tx.Where("room_id = ? AND expires_at > ? AND end_time > ? AND start_time < ?", room.ID, time.Now().UTC(), start, end).Order("start_time").Find(&holds)
+
+	w := &icsWriter{}
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", icsProdID)
+	w.line("BEGIN", "VFREEBUSY")
+	w.line("DTSTAMP", icsUTC(time.Now()))
+	w.line("DTSTART", icsUTC(start))
+	w.line("DTEND", icsUTC(end))
+	for _, b := range bookings {
+		w.line("FREEBUSY;FBTYPE=BUSY", icsUTC(b.StartTime)+"/"+icsUTC(b.EndTime))
+	}
+	for _, h := range holds {
+		w.line("FREEBUSY;FBTYPE=BUSY-TENTATIVE", icsUTC(h.StartTime)+"/"+icsUTC(h.EndTime))
+	}
+	w.line("END", "VFREEBUSY")
+	w.line("END", "VCALENDAR")
+	return w.String()
+}
+
+// 日历对象资源的请求
+func caldavObject(c *gin.Context, user User, room Room, name string) {
+	obj, exists := loadCalendarObject(db, room.ID, name)
+	switch c.Request.Method {
+	case "GET", "HEAD":
+		if !exists {
+			c.String(http.StatusNotFound, "预订不存在")
+			return
+		}
+		c.Header("ETag", obj.etag())
+		c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(calendarObjectData(db, user, obj)))
+	case "PROPFIND":
+		if !exists {
+			c.String(http.StatusNotFound, "预订不存在")
+			return
+		}
+		req, err := readDAVBody(c)
+		if err != nil {
+			writeDAVError(c, err)
+			return
+		}
+		found, missing := selectProps(objectProps(user, obj, wantsProp(req, nsCalDAV, "calendar-data")), req)
+		writeMultistatus(c, []davResponse{{Href: objectHref(room.ID, obj.Name), Props: found, Missing: missing}})
+	case "PUT":
+		if !checkPreconditions(c, obj, exists) {
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxCalDAVBody+1))
+		if err != nil || len(data) > maxCalDAVBody {
+			c.String(http.StatusRequestEntityTooLarge, "请求体过大")
+			return
+		}
+		events, err := parseDAVEvents(string(data), room.location())
+		if err != nil {
+			writeDAVError(c, err)
+			return
+		}
+		if exists {
+			err = updateCalendarObject(c, room, user, obj, events)
+		} else {
+			err = createCalendarObject(c, room, user, name, events)
+		}
+		if err != nil {
+			writeDAVError(c, err)
+			return
+		}
+		// 服务器会调整事件内容，不返回 ETag，客户端需重新获取
+		if exists {
+			c.Status(http.StatusNoContent)
+		} else {
+			c.Status(http.StatusCreated)
+		}
+	case "DELETE":
+		if !exists {
+			c.String(http.StatusNotFound, "预订不存在")
+			return
+		}
+		if !checkPreconditions(c, obj, exists) {
+			return
+		}
+		if err := deleteCalendarObject(c, user, obj); err != nil {
+			writeDAVError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	default:
+		c.String(http.StatusMethodNotAllowed, "不支持的请求方法")
+	}
+}
+
+// 处理 If-Match / If-None-Match，防止覆盖其他客户端的修改
+func checkPreconditions(c *gin.Context, obj davObject, exists bool) bool {
+	if c.GetHeader("If-None-Match") == "*" && exists {
+		c.String(http.StatusPreconditionFailed, "资源已存在")
+		return false
+	}
+	if match := c.GetHeader("If-Match"); match != "" {
+		if !exists || (match != "*" && match != obj.etag()) {
+			c.String(http.StatusPreconditionFailed, "资源已被修改")
+			return false
+		}
+	}
+	return true
+}
+
+// davEvent 从 VEVENT 解析出的预订内容
+type davEvent struct {
+	UID          string
+	Summary      string
+	Start        time.Time
+	End          time.Time
+	Cancelled    bool
+	Visibility   string // 为空表示未指定
+	RecurrenceID *time.Time
+	Rule         *RecurrenceRule
+	ExDates      map[int64]bool
+}
+
+func invalidCalendarData(message string) error {
+	return &davError{Status: http.StatusForbidden, Condition: davName(nsCalDAV, "valid-calendar-data"), Message: message}
+}
+
+func unsupportedCalendarData(message string) error {
+	return &davError{Status: http.StatusForbidden, Condition: davName(nsCalDAV, "supported-calendar-data"), Message: message}
+}
+
+// 解析 PUT 请求中的日历数据，所有 VEVENT 须使用同一 UID 且恰好有一个主事件
+func parseDAVEvents(data string, loc *time.Location) ([]davEvent, error) {
+	cal, err := parseICS(data)
+	if err != nil {
+		return nil, invalidCalendarData(err.Error())
+	}
+	comps := cal.children("VEVENT")
+	if len(comps) == 0 {
+		return nil, unsupportedCalendarData("仅支持 VEVENT")
+	}
+	events := make([]davEvent, 0, len(comps))
+	masters := 0
+	for _, comp := range comps {
+		ev, err := parseDAVEvent(comp, loc)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) > 0 && ev.UID != events[0].UID {
+			return nil, invalidCalendarData("日历对象中的事件 UID 不一致")
+		}
+		if ev.RecurrenceID == nil {
+			masters++
+		}
+		events = append(events, ev)
+	}
+	if masters != 1 {
+		return nil, invalidCalendarData("日历对象必须包含一个主事件")
+	}
+	// 主事件放在第一位
+	sort.SliceStable(events, func(i, j int) bool { return events[i].RecurrenceID == nil && events[j].RecurrenceID != nil })
+	return events, nil
+}
+
+// 解析单个 VEVENT
+func parseDAVEvent(comp *icsComponent, loc *time.Location) (davEvent, error) {
+	var ev davEvent
+	if p := comp.prop("UID"); p != nil {
+		ev.UID = strings.TrimSpace(p.Value)
+	}
+	if ev.UID == "" {
+		return ev, invalidCalendarData("事件缺少 UID")
+	}
+	if p := comp.prop("SUMMARY"); p != nil {
+		ev.Summary = icsUnescape(p.Value)
+	}
+	dtstart := comp.prop("DTSTART")
+	if dtstart == nil {
+		return ev, invalidCalendarData("事件缺少 DTSTART")
+	}
+	var err error
+	if ev.Start, err = parseICSTime(*dtstart, loc); err != nil {
+		return ev, unsupportedCalendarData(err.Error())
+	}
+	if p := comp.prop("DTEND"); p != nil {
+		if ev.End, err = parseICSTime(*p, loc); err != nil {
+			return ev, unsupportedCalendarData(err.Error())
+		}
+	} else if p := comp.prop("DURATION"); p != nil {
+		d, err := parseICSDuration(p.Value)
+		if err != nil {
+			return ev, invalidCalendarData(err.Error())
+		}
+		ev.End = ev.Start.Add(d)
+	} else {
+		return ev, invalidCalendarData("事件缺少 DTEND")
+	}
+	if !ev.End.After(ev.Start) {
+		return ev, &davError{Status: http.StatusBadRequest, Message: "时间范围不合法"}
+	}
+	if p := comp.prop("STATUS"); p != nil && strings.EqualFold(p.Value, "CANCELLED") {
+		ev.Cancelled = true
+	}
+	if p := comp.prop("CLASS"); p != nil {
+		ev.Visibility = VisibilityPublic
+		if v := strings.ToUpper(p.Value); v == "PRIVATE" || v == "CONFIDENTIAL" {
+			ev.Visibility = VisibilityPrivate
+		}
+	}
+	if p := comp.prop("RECURRENCE-ID"); p != nil {
+		t, err := parseICSTime(*p, loc)
+		if err != nil {
+			return ev, unsupportedCalendarData(err.Error())
+		}
+		ev.RecurrenceID = &t
+	}
+	if p := comp.prop("RRULE"); p != nil {
+		rule, err := parseRRule(p.Value, ev.Start, loc)
+		if err != nil {
+			return ev, err
+		}
+		ev.Rule = &rule
+	}
+	ev.ExDates = make(map[int64]bool)
+	for _, p := range comp.Props {
+		if p.Name != "EXDATE" {
+			continue
+		}
+		times, err := parseICSTimes(p, loc)
+		if err != nil {
+			return ev, unsupportedCalendarData(err.Error())
+		}
+		for _, t := range times {
+			ev.ExDates[t.Unix()] = true
+		}
+	}
+	return ev, nil
+}
+
+// 解析 RRULE，支持 DAILY/WEEKLY 及 INTERVAL、COUNT、UNTIL；
+// BYDAY 只允许与开始日期相同的单个星期几
+func parseRRule(value string, start time.Time, loc *time.Location) (RecurrenceRule, error) {
+	var rule RecurrenceRule
+	weekdays := []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+	for _, part := range strings.Split(value, ";") {
+		key, val, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			switch strings.ToUpper(val) {
+			case "DAILY":
+				rule.Freq = RecurrenceDaily
+			case "WEEKLY":
+				rule.Freq = RecurrenceWeekly
+			default:
+				return rule, unsupportedCalendarData("仅支持按天或按周重复")
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return rule, invalidCalendarData("重复间隔无效")
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return rule, invalidCalendarData("重复次数无效")
+			}
+			rule.Count = n
+		case "UNTIL":
+			var until time.Time
+			var err error
+			if len(val) == 8 {
+				// 日期形式包含当天
+				until, err = time.ParseInLocation("20060102", val, loc)
+				until = until.AddDate(0, 0, 1).Add(-time.Second)
+			} else {
+				until, err = parseICSTime(icsProperty{Value: val}, loc)
+			}
+			if err != nil {
+				return rule, invalidCalendarData("重复截止时间无效")
+			}
+			until = until.UTC()
+			rule.Until = &until
+		case "BYDAY":
+			if val != weekdays[start.In(loc).Weekday()] {
+				return rule, unsupportedCalendarData("不支持按多个星期几重复")
+			}
+		case "WKST":
+		default:
+			return rule, unsupportedCalendarData("不支持的重复规则: " + key)
+		}
+	}
+	if rule.Freq == "" {
+		return rule, invalidCalendarData("重复规则缺少 FREQ")
+	}
+	return rule, nil
+}
+
+// 通过 PUT 创建日历对象，与 POST /api/bookings 使用相同的校验
+func createCalendarObject(c *gin.Context, room Room, user User, name string, events []davEvent) error {
+	if calendarUIDExists(db, room.ID, events[0].UID) {
+		return &davError{Status: http.StatusForbidden, Condition: davName(nsCalDAV, "no-uid-conflict"), Message: "该 UID 的事件已存在"}
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		bookings, err := createEventBookings(tx, room, user.ID, name, events)
+		if err != nil {
+			return err
+		}
+		for _, b := range bookings {
+			recordAudit(tx, c, "booking.create", "booking", b.ID, nil, b)
+		}
+		// 日历客户端的用户自己创建，只通知参与人
+		notifyBookings(tx, NotifyConfirmation, bookings, user.ID)
+		return nil
+	})
+}
+
+// 会议室日历中是否已有该 UID 的事件
+func calendarUIDExists(tx *gorm.DB, roomID uint, uid string) bool {
+	var count int64
+	tx.Model(&CalendarObject{}).Where("room_id = ? AND uid = ?", roomID, uid).Count(&count)
+	return count > 0
+}
+
+// 按事件（主事件及单独修改的日期）校验并创建预订，同时记录日历对象的资源名和 UID
+func createEventBookings(tx *gorm.DB, room Room, userID uint, name string, events []davEvent) ([]Booking, error) {
+	master := events[0]
+	visibility, err := resolveVisibility(tx, master.Visibility)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := []timeSlot{{Start: master.Start, End: master.End}}
+	reasons := []string{master.Summary}
+	nb := NewBooking{Room: room, UserID: userID, Reason: master.Summary, Visibility: visibility}
+	if master.Rule != nil {
+		all, err := expandRecurrence(*master.Rule, master.Start, master.End, room.location())
+		if err != nil {
+			return nil, unsupportedCalendarData(err.Error())
+		}
+		overrides := make(map[int64]davEvent)
+		for _, ev := range events[1:] {
+			overrides[ev.RecurrenceID.Unix()] = ev
+		}
+		// 被排除或取消的日期不创建预订，单独修改的日期使用修改后的时间
+		slots, reasons = slots[:0], reasons[:0]
+		for _, slot := range all {
+			key := slot.Start.Unix()
+			if master.ExDates[key] {
+				continue
+			}
+			if ov, ok := overrides[key]; ok {
+				if ov.Cancelled {
+					continue
+				}
+				slots = append(slots, timeSlot{Start: ov.Start, End: ov.End})
+				reasons = append(reasons, ov.Summary)
+			} else {
+				slots = append(slots, slot)
+				reasons = append(reasons, master.Summary)
+			}
+			nb.RecurrenceIDs = append(nb.RecurrenceIDs, slot.Start)
+		}
+		if len(slots) == 0 {
+			return nil, invalidCalendarData("周期规则未生成任何预订")
+		}
+		nb.Recurrence = master.Rule
+		nb.SeriesCount = len(all)
+		nb.SeriesFirst = &all[0]
+	}
+	nb.Slots = slots
+	nb.Reasons = reasons
+
+	if err := checkBookingSlots(tx, room, userID, 0, slots); err != nil {
+		return nil, err
+	}
+	bookings, series, err := createBookings(tx, nb)
+	if err != nil {
+		return nil, err
+	}
+	obj := CalendarObject{RoomID: room.ID, Name: name, UID: master.UID}
+	if series != nil {
+		obj.SeriesID = &series.ID
+	} else {
+		obj.BookingID = &bookings[0].ID
+	}
+	if err := tx.Create(&obj).Error; err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+// 通过 PUT 修改已有日历对象，只允许预订人修改
+func updateCalendarObject(c *gin.Context, room Room, user User, obj davObject, events []davEvent) error {
+	if obj.ownerID() != user.ID {
+		return &davError{Status: http.StatusForbidden, Condition: davName(nsDAV, "need-privileges"), Message: "只能修改自己的预订"}
+	}
+	master := events[0]
+	if obj.SeriesID == nil {
+		if master.Rule != nil {
+			return unsupportedCalendarData("不支持将单次预订改为周期预订，请新建事件")
+		}
+		return db.Transaction(func(tx *gorm.DB) error {
+			before := davSequences(obj.Bookings)
+			if err := applyDAVEvent(tx, c, room, user, &obj.Bookings[0], master); err != nil {
+				return err
+			}
+			notifyDAVChanges(tx, obj.Bookings, before, user.ID)
+			return nil
+		})
+	}
+
+	var series BookingSeries
+	if err := db.First(&series, *obj.SeriesID).Error; err != nil {
+		return err
+	}
+	if master.Rule == nil {
+		return unsupportedCalendarData("不支持取消周期规则，请删除后重新创建")
+	}
+	orig, err := series.occurrences()
+	if err != nil {
+		return err
+	}
+	// 只允许缩短周期（如“仅此次及以后”删除），其余规则变化需删除后重新创建
+	current, err := expandRecurrence(*master.Rule, master.Start, master.End, room.location())
+	if err != nil || len(current) > len(orig) {
+		return unsupportedCalendarData("不支持修改周期规则，请删除后重新创建")
+	}
+	for i := range current {
+		if !current[i].Start.Equal(orig[i].Start) || !current[i].End.Equal(orig[i].End) {
+			return unsupportedCalendarData("不支持修改周期规则，请删除后重新创建")
+		}
+	}
+	inRule := make(map[int64]timeSlot, len(current))
+	for _, slot := range current {
+		inRule[slot.Start.Unix()] = slot
+	}
+	overrides := make(map[int64]davEvent)
+	for _, ev := range events[1:] {
+		overrides[ev.RecurrenceID.Unix()] = ev
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		before := davSequences(obj.Bookings)
+		for i := range obj.Bookings {
+			b := &obj.Bookings[i]
+			if b.RecurrenceID == nil {
+				continue
+			}
+			key := b.RecurrenceID.Unix()
+			want := davEvent{Start: b.StartTime, End: b.EndTime, Summary: master.Summary, Visibility: master.Visibility}
+			slot, ok := inRule[key]
+			switch {
+			case !ok:
+				// 被截断的日期，已开始的保持不变
+				if !b.StartTime.After(time.Now()) {
+					continue
+				}
+				want.Cancelled = true
+			case master.ExDates[key]:
+				want.Cancelled = true
+			default:
+				if ov, ok := overrides[key]; ok {
+					want = ov
+				} else {
+					want.Start, want.End = slot.Start, slot.End
+				}
+			}
+			if err := applyDAVEvent(tx, c, room, user, b, want); err != nil {
+				return err
+			}
+		}
+		notifyDAVChanges(tx, obj.Bookings, before, user.ID)
+		if len(current) < len(orig) {
+			series.Count = len(current)
+			series.Until = nil
+			return tx.Save(&series).Error
+		}
+		return nil
+	})
+}
+
+// 将事件内容应用到预订：取消、改期或修改主题。改期与新建预订使用相同的校验
+func applyDAVEvent(tx *gorm.DB, c *gin.Context, room Room, user User, b *Booking, want davEvent) error {
+	if b.Status == BookingStatusCancelled {
+		// 已取消的预订不能通过日历客户端恢复
+		return nil
+	}
+	before := *b
+	now := time.Now()
+	started := !b.StartTime.After(now)
+	if want.Cancelled {
+		if started {
+			return &bookingError{Status: http.StatusBadRequest, Message: "已开始的预订无法取消"}
+		}
+		if err := cancelBooking(tx, b, user.ID, "", now); err != nil {
+			if errors.Is(err, errBookingCancelled) {
+				return nil
+			}
+			return err
+		}
+		publishEvent(tx, EventBookingCancelled, bookingEventData(*b, room))
+		recordAudit(tx, c, "booking.cancel", "booking", b.ID, before, *b)
+		syncReminders(tx, *b)
+		return nil
+	}
+
+	changed := false
+	if !want.Start.Equal(b.StartTime) || !want.End.Equal(b.EndTime) {
+		if started {
+			return &bookingError{Status: http.StatusBadRequest, Message: "已开始的预订无法修改时间"}
+		}
+		// 冲突和配额检查不计入原预订
+		if err := checkBookingSlots(tx, room, b.UserID, b.ID, []timeSlot{{Start: want.Start, End: want.End}}); err != nil {
+			return err
+		}
+		b.StartTime, b.EndTime = want.Start, want.End
+		changed = true
+	}
+	if want.Summary != b.Reason {
+		b.Reason = want.Summary
+		changed = true
+	}
+	if want.Visibility != "" && want.Visibility != b.Visibility {
+		b.Visibility = want.Visibility
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	b.Sequence++
+	if err := tx.Save(b).Error; err != nil {
+		return err
+	}
+	publishEvent(tx, EventBookingUpdated, bookingEventData(*b, room))
+	recordAudit(tx, c, "booking.update", "booking", b.ID, before, *b)
+	syncReminders(tx, *b)
+	return nil
+}
+
+// 通过 DELETE 取消日历对象中尚未开始的预订，只允许预订人操作；
+// 管理员取消他人预订需填写原因，请使用管理后台
+func deleteCalendarObject(c *gin.Context, user User, obj davObject) error {
+	if obj.ownerID() != user.ID {
+		return &davError{Status: http.StatusForbidden, Condition: davName(nsDAV, "need-privileges"), Message: "只能取消自己的预订"}
+	}
+	now := time.Now()
+	if obj.SeriesID == nil && !obj.Bookings[0].StartTime.After(now) {
+		return &bookingError{Status: http.StatusBadRequest, Message: "已开始的预订无法取消"}
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		before := davSequences(obj.Bookings)
+		for i := range obj.Bookings {
+			b := &obj.Bookings[i]
+			if b.Status == BookingStatusCancelled || !b.StartTime.After(now) {
+				continue
+			}
+			before := *b
+			if err := cancelBooking(tx, b, user.ID, "", now); err != nil {
+				if errors.Is(err, errBookingCancelled) {
+					continue
+				}
+				return err
+			}
+			publishBookingEvent(tx, EventBookingCancelled, *b)
+			recordAudit(tx, c, "booking.cancel", "booking", b.ID, before, *b)
+			syncReminders(tx, *b)
+		}
+		notifyDAVChanges(tx, obj.Bookings, before, user.ID)
+		return nil
+	})
+}
+
+// 修改前各预订的 SEQUENCE，用于找出被修改的预订
+func davSequences(bookings []Booking) map[uint]int {
+	seqs := make(map[uint]int, len(bookings))
+	for _, b := range bookings {
+		seqs[b.ID] = b.Sequence
+	}
+	return seqs
+}
+
+// 按取消和变更分别通知参与人，同一日历对象的多次会议合并为一封邮件
+func notifyDAVChanges(tx *gorm.DB, bookings []Booking, before map[uint]int, actorID uint) {
+	var cancelled, changed []Booking
+	for _, b := range bookings {
+		if b.Sequence == before[b.ID] {
+			continue
+		}
+		if b.Status == BookingStatusCancelled {
+			cancelled = append(cancelled, b)
+		} else {
+			changed = append(changed, b)
+		}
+	}
+	notifyBookings(tx, NotifyCancellation, cancelled, actorID)
+	notifyBookings(tx, NotifyChange, changed, actorID)
+}
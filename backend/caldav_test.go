@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 与 setupRouter 中相同的 CalDAV 路由
+func caldavRouter() *gin.Engine {
+	r := gin.New()
+	dav := r.Group(caldavPrefix)
+	dav.Use(CalDAVAuthMiddleware())
+	for _, method := range caldavMethods {
+		dav.Handle(method, "/*path", caldavHandler)
+	}
+	return r
+}
+
+func basicAuth(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+// 发送 CalDAV 请求，headers 为成对的名称和值
+func davRequest(r *gin.Engine, method, path, auth, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// 单个事件的日历数据
+func eventICS(uid string, start time.Time, d time.Duration) string {
+	return fmt.Sprintf("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:%s\r\nDTSTART:%s\r\nDTEND:%s\r\nSUMMARY:周会\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+		uid, icsUTC(start), icsUTC(start.Add(d)))
+}
+
+// 登录密码和应用专用密码可读写，日历订阅令牌只读，撤销的应用专用密码立即失效
+func TestCalDAVAuth(t *testing.T) {
+	tx := setupTestDB(t)
+	user, room := createBookingFixtures(t, tx)
+	tx.Model(&user).Update("password", "secret")
+	other := User{Username: "bob", Password: "bob-secret", Role: "user"}
+	mustCreate(t, &other)
+	r := caldavRouter()
+
+	code, resp := callHandler(t, createAppPasswordHandler, user, http.MethodPost, "/api/user/app-passwords", nil, CreateAppPasswordRequest{Name: "iPhone"})
+	appPassword, _ := resp["password"].(string)
+	if code != http.StatusOK || appPassword == "" {
+		t.Fatalf("创建应用专用密码返回 %d：%v", code, resp)
+	}
+	appID := uint(resp["app_password"].(map[string]interface{})["id"].(float64))
+	code, resp = callHandler(t, createAppPasswordHandler, other, http.MethodPost, "/api/user/app-passwords", nil, CreateAppPasswordRequest{Name: "Android"})
+	if code != http.StatusOK {
+		t.Fatalf("创建应用专用密码返回 %d：%v", code, resp)
+	}
+	otherPassword := resp["password"].(string)
+	feed, err := ensureCalendarToken(tx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calendar := calendarHref(room.ID)
+	cases := []struct {
+		name, method, auth string
+		want               int
+	}{
+		{"未登录", "PROPFIND", "", http.StatusUnauthorized},
+		{"密码错误", "PROPFIND", basicAuth("alice", "wrong"), http.StatusUnauthorized},
+		{"其他用户的应用专用密码", "PROPFIND", basicAuth("alice", otherPassword), http.StatusUnauthorized},
+		{"登录密码", "PROPFIND", basicAuth("alice", "secret"), http.StatusMultiStatus},
+		{"应用专用密码", "PROPFIND", basicAuth("alice", appPassword), http.StatusMultiStatus},
+		{"日历订阅令牌读取", "PROPFIND", basicAuth("alice", feed.Token), http.StatusMultiStatus},
+		{"日历订阅令牌写入", "PUT", basicAuth("alice", feed.Token), http.StatusForbidden},
+	}
+	start := time.Now().UTC().Truncate(time.Hour).Add(48 * time.Hour)
+	for i, tc := range cases {
+		w := davRequest(r, tc.method, calendar, tc.auth, "", "Depth", "0")
+		if tc.method == "PUT" {
+			w = davRequest(r, "PUT", calendar+fmt.Sprintf("event-%d.ics", i), tc.auth, eventICS(fmt.Sprintf("uid-%d", i), start, time.Hour))
+		}
+		if w.Code != tc.want {
+			t.Errorf("%s：返回 %d，应为 %d：%s", tc.name, w.Code, tc.want, w.Body.String())
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s：缺少 WWW-Authenticate", tc.name)
+		}
+	}
+	var count int64
+	tx.Model(&Booking{}).Count(&count)
+	if count != 0 {
+		t.Errorf("日历订阅令牌写入了 %d 个预订", count)
+	}
+
+	// 应用专用密码可以创建预订，并记录最后使用时间
+	w := davRequest(r, "PUT", calendar+"weekly.ics", basicAuth("alice", appPassword), eventICS("weekly", start, time.Hour))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("PUT 返回 %d：%s", w.Code, w.Body.String())
+	}
+	var app AppPassword
+	tx.First(&app, appID)
+	if app.LastUsedAt == nil {
+		t.Error("未记录应用专用密码的最后使用时间")
+	}
+
+	code, resp = callHandler(t, deleteAppPasswordHandler, user, http.MethodDelete, "/api/user/app-passwords/", idParam(appID), nil)
+	if code != http.StatusOK {
+		t.Fatalf("撤销返回 %d：%v", code, resp)
+	}
+	if w := davRequest(r, "PROPFIND", calendar, basicAuth("alice", appPassword), "", "Depth", "0"); w.Code != http.StatusUnauthorized {
+		t.Errorf("撤销后返回 %d", w.Code)
+	}
+}
+
+// PUT 与 POST /api/bookings 使用相同的冲突检查，并遵守 UID 唯一和 If-Match 前置条件
+func TestCalDAVPutConflicts(t *testing.T) {
+	tx := setupTestDB(t)
+	user, room := createBookingFixtures(t, tx)
+	tx.Model(&user).Update("password", "secret")
+	other := User{Username: "bob", Password: "bob-secret", Role: "user"}
+	mustCreate(t, &other)
+	r := caldavRouter()
+	alice, bob := basicAuth("alice", "secret"), basicAuth("bob", "bob-secret")
+	calendar := calendarHref(room.ID)
+	start := time.Now().UTC().Truncate(time.Hour).Add(48 * time.Hour)
+
+	if w := davRequest(r, "PUT", calendar+"a.ics", alice, eventICS("a", start, time.Hour), "If-None-Match", "*"); w.Code != http.StatusCreated {
+		t.Fatalf("PUT 返回 %d：%s", w.Code, w.Body.String())
+	}
+	get := davRequest(r, "GET", calendar+"a.ics", alice, "")
+	etag := get.Header().Get("ETag")
+	if get.Code != http.StatusOK || etag == "" {
+		t.Fatalf("GET 返回 %d，ETag %q", get.Code, etag)
+	}
+
+	cases := []struct {
+		name, auth, object, body string
+		headers                  []string
+		want                     int
+		contains                 string
+	}{
+		{"时间段冲突", bob, "b.ics", eventICS("b", start.Add(30*time.Minute), time.Hour), nil, http.StatusConflict, "该时间段已被预订"},
+		{"UID 已存在", alice, "c.ics", eventICS("a", start.Add(2*time.Hour), time.Hour), nil, http.StatusForbidden, "no-uid-conflict"},
+		{"资源已存在", alice, "a.ics", eventICS("a", start, 2*time.Hour), []string{"If-None-Match", "*"}, http.StatusPreconditionFailed, ""},
+		{"ETag 已过期", alice, "a.ics", eventICS("a", start, 2*time.Hour), []string{"If-Match", `"stale"`}, http.StatusPreconditionFailed, ""},
+		{"修改他人的预订", bob, "a.ics", eventICS("a", start, 2*time.Hour), []string{"If-Match", etag}, http.StatusForbidden, "need-privileges"},
+	}
+	for _, tc := range cases {
+		w := davRequest(r, "PUT", calendar+tc.object, tc.auth, tc.body, tc.headers...)
+		if w.Code != tc.want || !strings.Contains(w.Body.String(), tc.contains) {
+			t.Errorf("%s：返回 %d：%s", tc.name, w.Code, w.Body.String())
+		}
+	}
+	var bookings []Booking
+	tx.Find(&bookings)
+	if len(bookings) != 1 || !bookings[0].EndTime.Equal(start.Add(time.Hour)) {
+		t.Fatalf("预订 %+v", bookings)
+	}
+
+	// ETag 匹配时可以修改，修改后原 ETag 失效
+	if w := davRequest(r, "PUT", calendar+"a.ics", alice, eventICS("a", start, 2*time.Hour), "If-Match", etag); w.Code != http.StatusNoContent {
+		t.Fatalf("修改返回 %d：%s", w.Code, w.Body.String())
+	}
+	if w := davRequest(r, "DELETE", calendar+"a.ics", alice, "", "If-Match", etag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("使用旧 ETag 删除返回 %d", w.Code)
+	}
+	if w := davRequest(r, "DELETE", calendar+"a.ics", alice, ""); w.Code != http.StatusNoContent {
+		t.Errorf("删除返回 %d：%s", w.Code, w.Body.String())
+	}
+	tx.First(&bookings[0], bookings[0].ID)
+	if bookings[0].Status != BookingStatusCancelled || !bookings[0].EndTime.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("预订 %+v", bookings[0])
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 命令参数有误，用法已输出
+var errUsage = errors.New("usage")
+
+// cliCommand 服务程序的子命令，与 HTTP 接口共用配置、模型和业务逻辑，可在容器内执行
+type cliCommand struct {
+	Name    string
+	Summary string
+	Run     func(args []string) error
+}
+
+func cliCommands() []cliCommand {
+	return []cliCommand{
+		{"serve", "启动 HTTP 服务（默认）", runServe},
+		{"migrate", "数据库迁移: migrate [up|down [步数]|status]", runMigrate},
+		{"create-admin", "创建管理员账号", runCreateAdmin},
+		{"reset-password", "重置用户密码，用于管理员忘记密码时", runResetPassword},
+		{"backup", "在线备份 SQLite 数据库", runBackup},
+		{"restore", "从备份恢复 SQLite 数据库", runRestore},
+		{"import-bookings", "从 CSV 或 ICS 文件导入预订", runImportBookings},
+		{"export", "导出预订或用户为 CSV 或 XLSX", runExport},
+		{"purge", "清理指定日期之前的预订及历史记录", runPurge},
+	}
+}
+
+// 执行命令行，返回进程退出码。第一个参数不以 - 开头时为命令名，否则执行 serve
+func runCommand(args []string) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		printUsage(os.Stdout)
+		return 0
+	}
+	var cmd *cliCommand
+	for _, c := range cliCommands() {
+		if c.Name == name {
+			cmd = &c
+			break
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "未知命令: %s\n\n", name)
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	cfg, file, err := loadConfig()
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	applyConfig(cfg, file)
+	if err := cmd.Run(args); err != nil {
+		switch {
+		case errors.Is(err, flag.ErrHelp):
+			return 0
+		case errors.Is(err, errUsage):
+			return 2
+		}
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
+
+func printUsage(w io.Writer) {
+	program := filepath.Base(os.Args[0])
+	fmt.Fprintf(w, "用法: %s [命令] [参数]\n\n命令:\n", program)
+	for _, c := range cliCommands() {
+		fmt.Fprintf(w, "  %-16s %s\n", c.Name, c.Summary)
+	}
+	fmt.Fprintf(w, "\n使用 %s <命令> -h 查看命令的参数\n", program)
+}
+
+// 使配置生效
+func applyConfig(cfg Config, file string) {
+	appConfig, appConfigFile = cfg, file
+	dataDir = cfg.Server.DataDir
+	tokenTTL = time.Duration(cfg.Auth.TokenTTL)
+	mailSettings = cfg.Mail
+	dooTaskSettings = cfg.DooTask
+}
+
+// 连接数据库，migrate 为 true 时执行未执行的迁移（数据库版本比程序新时返回错误）
+func connectDatabase(migrate bool) error {
+	var err error
+	if db, err = openDatabase(appConfig.Database); err != nil {
+		return fmt.Errorf("failed to connect database: %v", err)
+	}
+	if migrate {
+		if _, err := migrateUp(db); err != nil {
+			return fmt.Errorf("数据库迁移失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// 创建子命令的参数解析，usage 为参数说明
+func newFlagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: %s %s %s\n", filepath.Base(os.Args[0]), name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// 解析参数，有误时返回 errUsage（flag 包已输出错误和用法）
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	return nil
+}
+
+// 输出用法并返回 errUsage
+func usageError(fs *flag.FlagSet, format string, args ...interface{}) error {
+	fmt.Fprintf(fs.Output(), format+"\n", args...)
+	fs.Usage()
+	return errUsage
+}
+
+func runMigrate(args []string) error {
+	if err := connectDatabase(false); err != nil {
+		return err
+	}
+	return runMigrateCommand(args)
+}
+
+func runCreateAdmin(args []string) error {
+	fs := newFlagSet("create-admin", "-username 用户名 -password 密码 [-nickname 昵称]")
+	username := fs.String("username", "", "用户名")
+	password := fs.String("password", "", "密码，至少6个字符")
+	nickname := fs.String("nickname", "", "昵称，默认与用户名相同")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	*username = strings.TrimSpace(*username)
+	if *username == "" || len(*password) < 6 {
+		return usageError(fs, "用户名不能为空，密码长度至少6个字符")
+	}
+	if err := connectDatabase(true); err != nil {
+		return err
+	}
+
+	var count int64
+	if err := db.Model(&User{}).Where("username = ?", *username).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("用户 %s 已存在，可使用 reset-password 重置密码", *username)
+	}
+	user := User{
+		Username: *username,
+		Password: *password,
+		Nickname: firstNonEmpty(*nickname, *username),
+		Role:     "admin",
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		publishEvent(tx, EventUserCreated, userEventData(user))
+		recordAudit(tx, nil, "user.create", "user", user.ID, nil, user)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("创建管理员失败: %v", err)
+	}
+	fmt.Printf("已创建管理员 %s（ID %d）\n", user.Username, user.ID)
+	return nil
+}
+
+func runResetPassword(args []string) error {
+	fs := newFlagSet("reset-password", "-username 用户名 [-password 新密码]")
+	username := fs.String("username", "", "用户名")
+	password := fs.String("password", "", "新密码，至少6个字符；不指定时随机生成并输出")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return usageError(fs, "请指定用户名")
+	}
+	generated := *password == ""
+	if generated {
+		random, err := randomHex(6)
+		if err != nil {
+			return err
+		}
+		*password = random
+	}
+	if len(*password) < 6 {
+		return usageError(fs, "新密码长度至少6个字符")
+	}
+	if err := connectDatabase(true); err != nil {
+		return err
+	}
+
+	var user User
+	if err := db.Where("username = ?", *username).Limit(1).Find(&user).Error; err != nil {
+		return err
+	}
+	if user.ID == 0 {
+		return fmt.Errorf("用户 %s 不存在", *username)
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password", *password).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, nil, "user.password_reset", "user", user.ID, nil, nil)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("密码更新失败: %v", err)
+	}
+	if generated {
+		fmt.Printf("用户 %s 的新密码: %s\n", user.Username, *password)
+	} else {
+		fmt.Printf("已重置用户 %s 的密码\n", user.Username)
+	}
+	return nil
+}
+
+func runBackup(args []string) error {
+	fs := newFlagSet("backup", "[-o 文件]")
+	output := fs.String("o", "", "备份文件路径，默认保存到备份目录并按保留数删除较早的备份")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := connectDatabase(false); err != nil {
+		return err
+	}
+	dest := *output
+	if dest == "" {
+		file, err := createBackup()
+		if err != nil {
+			return fmt.Errorf("备份失败: %v", err)
+		}
+		dest = filepath.Join(appConfig.Backup.Dir, file.Name)
+	} else if err := backupDatabase(dest); err != nil {
+		return fmt.Errorf("备份失败: %v", err)
+	}
+	fmt.Printf("已备份到 %s\n", dest)
+	return nil
+}
+
+func runRestore(args []string) error {
+	fs := newFlagSet("restore", "文件")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError(fs, "请指定备份文件")
+	}
+	if err := connectDatabase(false); err != nil {
+		return err
+	}
+	previous, err := restoreDatabase(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("恢复失败: %v", err)
+	}
+	recordAudit(db, nil, "backup.restore", "backup", 0, nil, gin.H{"name": fs.Arg(0), "previous": filepath.Base(previous)})
+	fmt.Printf("已从 %s 恢复，原数据库已备份到 %s\n", fs.Arg(0), previous)
+	return nil
+}
+
+func runImportBookings(args []string) error {
+	fs := newFlagSet("import-bookings", "[-format csv|ics] [-dry-run] [-skip-errors] [-default-user 用户] 文件")
+	format := fs.String("format", "", "文件格式 csv 或 ics，默认按扩展名判断")
+	dryRun := fs.Bool("dry-run", false, "只校验，不写入")
+	skipErrors := fs.Bool("skip-errors", false, "跳过无法导入的记录，导入其余记录")
+	defaultUser := fs.String("default-user", "", "记录未指定预订人时使用的用户名或邮箱")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError(fs, "请指定要导入的文件")
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := connectDatabase(true); err != nil {
+		return err
+	}
+
+	sources, err := parseImportFile(data, strings.ToLower(firstNonEmpty(*format, importFormat(fs.Arg(0)))), strings.TrimSpace(*defaultUser))
+	if err != nil {
+		return err
+	}
+	result, err := runImport(nil, sources, *dryRun, *skipErrors)
+	if err != nil {
+		return fmt.Errorf("导入失败: %v", err)
+	}
+	for _, row := range result.Rows {
+		if row.Status != ImportCreated {
+			fmt.Printf("第 %d 条 %s %s: %s %s\n", row.Row, row.Room, row.Reason, row.Status, row.Message)
+		}
+	}
+	statuses := make([]string, 0, len(result.Summary))
+	for status := range result.Summary {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Printf("%s: %d\n", status, result.Summary[status])
+	}
+	switch {
+	case result.DryRun:
+		fmt.Println("试运行，未写入任何预订")
+	case !result.Committed:
+		return errors.New("存在无法导入的记录，未导入任何预订，可使用 -skip-errors 导入其余记录")
+	default:
+		fmt.Printf("已导入 %d 条记录\n", result.Summary[ImportCreated])
+	}
+	return nil
+}
+
+func runExport(args []string) error {
+	fs := newFlagSet("export", "[-format csv|xlsx] [-lang zh|en] [-tz 时区] [-o 文件] bookings|users [筛选=值 ...]")
+	format := fs.String("format", "", "导出格式 csv 或 xlsx，默认按输出文件扩展名判断")
+	lang := fs.String("lang", "zh", "表头语言 zh 或 en")
+	tz := fs.String("tz", "", "时间列的时区，默认服务器时区")
+	output := fs.String("o", "-", "输出文件，- 为标准输出")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || (fs.Arg(0) != "bookings" && fs.Arg(0) != "users") {
+		return usageError(fs, "请指定导出 bookings 或 users，筛选条件与 /api/admin/bookings、/api/admin/users 的查询参数相同，如 room_id=1 date=2024-05-01")
+	}
+	kind := fs.Arg(0)
+	query := url.Values{}
+	for _, arg := range fs.Args()[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return usageError(fs, "筛选条件格式应为 名称=值: %s", arg)
+		}
+		query.Add(key, value)
+	}
+	query.Set("tz", *tz)
+	if *format == "" {
+		*format = ExportCSV
+		if strings.EqualFold(filepath.Ext(*output), ".xlsx") {
+			*format = ExportXLSX
+		}
+	}
+	// 筛选参数的解析与 HTTP 接口共用
+	c := &gin.Context{Request: &http.Request{URL: &url.URL{RawQuery: query.Encode()}}}
+	if err := connectDatabase(true); err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	err := exportTable(c, out, kind, *format, *lang)
+	if err != nil && *output != "-" {
+		os.Remove(*output)
+	}
+	return err
+}
+
+// 按筛选条件导出预订或用户到 out
+func exportTable(c *gin.Context, out io.Writer, kind, format, lang string) error {
+	if kind == "users" {
+		rows, err := db.Model(&User{}).Scopes(userListFilter(c)).Order("id").Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		tw, err := newTableWriter(out, format, kind, exportHeader(userExportColumns, lang, nil, nil))
+		if err != nil {
+			return err
+		}
+		return writeUserExport(context.Background(), out, tw, rows, lang)
+	}
+
+	loc, ok := requestLocation(c)
+	if !ok {
+		return errors.New("时区无效")
+	}
+	filter, msg := parseBookingFilter(c, loc)
+	if msg != "" {
+		return errors.New(msg)
+	}
+	rows, err := queryBookingExport(filter)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	tw, err := newTableWriter(out, format, kind, exportHeader(bookingExportColumns, lang, bookingExportTimeColumns, loc))
+	if err != nil {
+		return err
+	}
+	return writeBookingExport(context.Background(), out, tw, rows, lang, loc)
+}
+
+// 清理时各表删除的记录数
+type purgeCount struct {
+	Table string
+	Count int64
+}
+
+// 试运行时用于回滚清理事务
+var errPurgeDryRun = errors.New("purge dry run")
+
+func runPurge(args []string) error {
+	fs := newFlagSet("purge", "-before 日期 [-tz 时区] [-dry-run]")
+	before := fs.String("before", "", "删除此日期 0 点之前结束的预订，以及之前产生的通知、Webhook 投递和实时事件，格式 YYYY-MM-DD")
+	tz := fs.String("tz", "", "日期所用的时区，默认服务器时区")
+	dryRun := fs.Bool("dry-run", false, "只统计，不删除")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if !validTimeZone(*tz) {
+		return usageError(fs, "时区无效: %s", *tz)
+	}
+	cutoff, _, ok := localDayRange(*before, loadLocation(*tz))
+	if !ok {
+		return usageError(fs, "日期格式应为 YYYY-MM-DD")
+	}
+	if cutoff.After(time.Now()) {
+		return errors.New("只能清理今天及以前的数据")
+	}
+	if err := connectDatabase(true); err != nil {
+		return err
+	}
+
+	var counts []purgeCount
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		counts, err = purgeBefore(tx, cutoff.UTC())
+		if err != nil {
+			return err
+		}
+		if *dryRun {
+			return errPurgeDryRun
+		}
+		after := make(map[string]int64, len(counts))
+		for _, c := range counts {
+			after[c.Table] = c.Count
+		}
+		recordAudit(tx, nil, "data.purge", "", 0, nil, gin.H{"before": *before, "deleted": after})
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPurgeDryRun) {
+		return fmt.Errorf("清理失败: %v", err)
+	}
+	for _, c := range counts {
+		fmt.Printf("%-24s %d\n", c.Table, c.Count)
+	}
+	if *dryRun {
+		fmt.Println("试运行，未删除任何数据")
+	}
+	return nil
+}
+
+// 删除 cutoff 之前结束的预订及其参与人、提醒、日历对象和通知，不再有预订的周期预订，
+// 以及 cutoff 之前产生的 Webhook 投递和实时事件。尚待发送的通知和投递不删除，
+// 审计日志只按系统设置的保留天数清理，不在此删除
+func purgeBefore(tx *gorm.DB, cutoff time.Time) ([]purgeCount, error) {
+	expired := tx.Model(&Booking{}).Select("id").Where("end_time < ?", cutoff)
+	steps := []struct {
+		model interface{}
+		query string
+		args  []interface{}
+	}{
+		{&BookingParticipant{}, "booking_id IN (?)", []interface{}{expired}},
+		{&ReminderJob{}, "booking_id IN (?)", []interface{}{expired}},
+		{&CalendarObject{}, "booking_id IN (?)", []interface{}{expired}},
+		{&NotificationMessage{}, "(booking_id IN (?) OR created_at < ?) AND status <> ?", []interface{}{expired, cutoff, DeliveryPending}},
+		{&Booking{}, "end_time < ?", []interface{}{cutoff}},
+		{&BookingSeries{}, "id NOT IN (?)", []interface{}{tx.Model(&Booking{}).Select("series_id").Where("series_id IS NOT NULL")}},
+		{&CalendarObject{}, "series_id IS NOT NULL AND series_id NOT IN (?)", []interface{}{tx.Model(&BookingSeries{}).Select("id")}},
+		{&WebhookDelivery{}, "created_at < ? AND status <> ?", []interface{}{cutoff, DeliveryPending}},
+		{&ChangeEvent{}, "created_at < ?", []interface{}{cutoff}},
+	}
+	var counts []purgeCount
+	index := make(map[string]int)
+	for _, step := range steps {
+		stmt := &gorm.Statement{DB: tx}
+		if err := stmt.Parse(step.model); err != nil {
+			return nil, err
+		}
+		table := stmt.Schema.Table
+		result := tx.Where(step.query, step.args...).Delete(step.model)
+		if result.Error != nil {
+			return nil, fmt.Errorf("清理 %s 失败: %w", table, result.Error)
+		}
+		if i, ok := index[table]; ok {
+			counts[i].Count += result.RowsAffected
+			continue
+		}
+		index[table] = len(counts)
+		counts = append(counts, purgeCount{table, result.RowsAffected})
+	}
+	return counts, nil
+}
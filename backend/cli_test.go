@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 清理时保留尚待发送的通知和 Webhook 投递
+func TestPurgeBeforeKeepsPending(t *testing.T) {
+	tx := setupTestDB(t)
+	cutoff := time.Now().UTC()
+	old := cutoff.Add(-48 * time.Hour)
+	booking := Booking{RoomID: 1, UserID: 1, StartTime: old, EndTime: old.Add(time.Hour), Status: BookingStatusActive}
+	mustCreate(t, &booking)
+	messages := []NotificationMessage{
+		{Channel: ChannelEmail, BookingID: booking.ID, Status: DeliverySucceeded},
+		{Channel: ChannelEmail, BookingID: booking.ID, Status: DeliveryPending},
+		{Channel: ChannelEmail, Status: DeliveryFailed, CreatedAt: old},
+	}
+	deliveries := []WebhookDelivery{
+		{WebhookID: 1, Status: DeliverySucceeded, CreatedAt: old},
+		{WebhookID: 1, Status: DeliveryPending, CreatedAt: old},
+		{WebhookID: 1, Status: DeliverySucceeded},
+	}
+	mustCreate(t, &messages, &deliveries)
+
+	if _, err := purgeBefore(tx, cutoff); err != nil {
+		t.Fatal(err)
+	}
+	var bookings, keptMessages, keptDeliveries int64
+	tx.Model(&Booking{}).Count(&bookings)
+	tx.Model(&NotificationMessage{}).Where("id = ?", messages[1].ID).Count(&keptMessages)
+	tx.Model(&WebhookDelivery{}).Where("id IN ?", []uint{deliveries[1].ID, deliveries[2].ID}).Count(&keptDeliveries)
+	var totalMessages, totalDeliveries int64
+	tx.Model(&NotificationMessage{}).Count(&totalMessages)
+	tx.Model(&WebhookDelivery{}).Count(&totalDeliveries)
+	if bookings != 0 || keptMessages != 1 || totalMessages != 1 || keptDeliveries != 2 || totalDeliveries != 2 {
+		t.Errorf("剩余预订 %d，通知 %d/%d，投递 %d/%d", bookings, keptMessages, totalMessages, keptDeliveries, totalDeliveries)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// 默认配置文件，可通过环境变量 CONFIG_FILE 指定其他路径
+const defaultConfigFile = "config.yaml"
+
+// 管理接口中代替敏感配置的内容
+const redacted = "******"
+
+// Config 服务配置。先读取 YAML 配置文件（不存在时全部使用默认值），再由 env 标签对应的环境变量覆盖
+type Config struct {
+	Server   ServerConfig  `yaml:"server" json:"server"`
+	Database dbConfig      `yaml:"database" json:"database"`
+	Auth     AuthConfig    `yaml:"auth" json:"auth"`
+	Admin    AdminConfig   `yaml:"admin" json:"admin"`
+	Mail     mailConfig    `yaml:"mail" json:"mail"`
+	DooTask  dooTaskConfig `yaml:"dootask" json:"dootask"`
+	Backup   BackupConfig  `yaml:"backup" json:"backup"`
+}
+
+// ServerConfig HTTP 服务配置
+type ServerConfig struct {
+	Addr        string   `yaml:"addr" json:"addr" env:"LISTEN_ADDR"`                  // 监听地址，默认 :80
+	DataDir     string   `yaml:"data_dir" json:"data_dir" env:"DATA_DIR"`             // 数据目录，存放 SQLite 数据库和上传的照片；默认容器中为 /app/data，本地为 data
+	CORSOrigins []string `yaml:"cors_origins" json:"cors_origins" env:"CORS_ORIGINS"` // 允许跨域访问的来源，环境变量以逗号分隔；为空时不启用 CORS，默认非 release 模式下为 *
+}
+
+// AuthConfig 登录令牌配置
+type AuthConfig struct {
+	JWTSecret string   `yaml:"jwt_secret" json:"jwt_secret" env:"JWT_SECRET"` // 签名密钥，修改后已签发的令牌失效；为空时使用数据目录下自动生成的密钥
+	TokenTTL  duration `yaml:"token_ttl" json:"token_ttl" env:"TOKEN_TTL"`    // 令牌有效期，如 24h
+}
+
+// AdminConfig 默认管理员，启动时该用户名不存在则创建
+type AdminConfig struct {
+	Username string `yaml:"username" json:"username" env:"ADMIN_USERNAME"`
+	Password string `yaml:"password" json:"password" env:"ADMIN_PASSWORD"`
+}
+
+// BackupConfig SQLite 数据库备份
+type BackupConfig struct {
+	Dir      string   `yaml:"dir" json:"dir" env:"BACKUP_DIR"`                // 备份目录，默认为数据目录下的 backups
+	Interval duration `yaml:"interval" json:"interval" env:"BACKUP_INTERVAL"` // 定时备份间隔，如 24h；为 0 时不定时备份
+	Keep     int      `yaml:"keep" json:"keep" env:"BACKUP_KEEP"`             // 保留最近的备份数，0 表示全部保留
+}
+
+// duration 配置中的时长，格式同 time.ParseDuration，如 30m、24h
+type duration time.Duration
+
+func (d *duration) UnmarshalYAML(node *yaml.Node) error {
+	return d.parse(node.Value)
+}
+
+func (d *duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("时长格式无效: %q", s)
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// 旧版默认及示例配置中的 JWT 密钥，生产环境（GIN_MODE=release）下改用自动生成的密钥
+var defaultJWTSecrets = map[string]bool{"secret_key": true, "change-me": true}
+
+// 自动生成的 JWT 密钥保存在数据目录下的文件
+const jwtSecretFile = "jwt_secret"
+
+// 默认配置，与引入配置文件前的行为一致
+func defaultConfig() Config {
+	cfg := Config{
+		Server:   ServerConfig{Addr: ":80", DataDir: "data"},
+		Database: dbConfig{Driver: DBDriverSQLite},
+		Auth:     AuthConfig{TokenTTL: duration(24 * time.Hour)},
+		Admin:    AdminConfig{Username: "admin", Password: "admin"},
+		Mail:     mailConfig{Port: 25},
+		Backup:   BackupConfig{Keep: 7},
+	}
+	if _, err := os.Stat("/app/data"); err == nil {
+		cfg.Server.DataDir = "/app/data" // 容器环境
+	}
+	if os.Getenv("GIN_MODE") != "release" {
+		cfg.Server.CORSOrigins = []string{"*"} // 开发环境
+	}
+	return cfg
+}
+
+// 加载配置：默认值、配置文件、环境变量依次覆盖，最后校验
+func loadConfig() (Config, string, error) {
+	cfg := defaultConfig()
+	path := os.Getenv("CONFIG_FILE")
+	data, err := os.ReadFile(firstNonEmpty(path, defaultConfigFile))
+	switch {
+	case err == nil:
+		path = firstNonEmpty(path, defaultConfigFile)
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return cfg, path, fmt.Errorf("读取配置文件 %s 失败: %w", path, err)
+		}
+	case path != "" || !errors.Is(err, os.ErrNotExist):
+		// 指定的配置文件必须存在
+		return cfg, path, fmt.Errorf("读取配置文件 %s 失败: %w", firstNonEmpty(path, defaultConfigFile), err)
+	}
+	if err := applyEnv(reflect.ValueOf(&cfg).Elem()); err != nil {
+		return cfg, path, err
+	}
+	cfg.normalize()
+	if err := cfg.validate(); err != nil {
+		return cfg, path, err
+	}
+	return cfg, path, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// 按 env 标签用环境变量覆盖配置，未设置的环境变量不覆盖
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+		name := field.Tag.Get("env")
+		if name == "" {
+			if value.Kind() == reflect.Struct {
+				if err := applyEnv(value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		switch ptr := value.Addr().Interface().(type) {
+		case *string:
+			*ptr = s
+		case *int:
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("环境变量 %s 应为整数: %q", name, s)
+			}
+			*ptr = n
+		case *duration:
+			if err := ptr.parse(s); err != nil {
+				return fmt.Errorf("环境变量 %s: %w", name, err)
+			}
+		case *[]string:
+			*ptr = nil
+			for _, item := range strings.Split(s, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*ptr = append(*ptr, item)
+				}
+			}
+		default:
+			return fmt.Errorf("环境变量 %s 对应的配置类型不支持", name)
+		}
+	}
+	return nil
+}
+
+// 补全可由其他配置推导的值
+func (cfg *Config) normalize() {
+	cfg.Database.Driver = strings.ToLower(cfg.Database.Driver)
+	if cfg.Database.Driver == DBDriverSQLite && cfg.Database.DSN == "" {
+		cfg.Database.DSN = filepath.Join(cfg.Server.DataDir, "meeting_room.db")
+	}
+	if cfg.Backup.Dir == "" {
+		cfg.Backup.Dir = filepath.Join(cfg.Server.DataDir, "backups")
+	}
+	cfg.Mail.TLS = strings.ToLower(cfg.Mail.TLS)
+	if cfg.Mail.From == "" && cfg.Mail.Username != "" {
+		cfg.Mail.From = cfg.Mail.Username
+	}
+	cfg.DooTask.BaseURL = strings.TrimRight(cfg.DooTask.BaseURL, "/")
+}
+
+// 校验配置，返回所有问题
+func (cfg Config) validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	check(cfg.Server.Addr != "", "server.addr 不能为空")
+	check(cfg.Server.DataDir != "", "server.data_dir 不能为空")
+	for _, origin := range cfg.Server.CORSOrigins {
+		u, err := url.Parse(origin)
+		check(origin == "*" || err == nil && u.Scheme != "" && u.Host != "", "server.cors_origins 中的 %q 应为 * 或 http(s)://域名", origin)
+	}
+	switch cfg.Database.Driver {
+	case DBDriverSQLite, DBDriverPostgres, DBDriverMySQL:
+		check(cfg.Database.DSN != "", "database.dsn 不能为空")
+	default:
+		check(false, "database.driver 只能是 sqlite、postgres 或 mysql")
+	}
+	check(cfg.Auth.TokenTTL > 0, "auth.token_ttl 必须大于 0")
+	check(cfg.Admin.Username != "", "admin.username 不能为空")
+	check(cfg.Admin.Password != "", "admin.password 不能为空")
+	check(cfg.Mail.Port > 0 && cfg.Mail.Port < 65536, "mail.port 无效")
+	switch cfg.Mail.TLS {
+	case "", "starttls", "ssl", "none":
+	default:
+		check(false, "mail.tls 只能是 starttls、ssl 或 none")
+	}
+	if cfg.DooTask.BaseURL != "" {
+		u, err := url.Parse(cfg.DooTask.BaseURL)
+		check(err == nil && u.Scheme != "" && u.Host != "", "dootask.url 应为 http(s)://地址")
+	}
+	check(cfg.Backup.Interval >= 0, "backup.interval 不能为负数")
+	check(cfg.Backup.Interval == 0 || cfg.Database.Driver == DBDriverSQLite, "backup.interval 仅支持 SQLite 数据库")
+	check(cfg.Backup.Keep >= 0, "backup.keep 不能为负数")
+	if len(problems) > 0 {
+		return fmt.Errorf("配置无效:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// 签名登录令牌的密钥：使用配置的密钥，未配置时（或生产环境中仍为默认值时）读取数据目录下的密钥文件，
+// 文件不存在则生成随机密钥并保存，重启后已签发的令牌仍然有效
+func loadJWTSecret(cfg Config) ([]byte, error) {
+	secret := cfg.Auth.JWTSecret
+	if defaultJWTSecrets[secret] && os.Getenv("GIN_MODE") == "release" {
+		log.Printf("警告: auth.jwt_secret 为默认值 %q，已改用自动生成的密钥，之前签发的令牌失效", secret)
+		secret = ""
+	}
+	if secret != "" {
+		return []byte(secret), nil
+	}
+	path := filepath.Join(cfg.Server.DataDir, jwtSecretFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if s := strings.TrimSpace(string(data)); s != "" {
+			return []byte(s), nil
+		}
+		return nil, fmt.Errorf("JWT 密钥文件 %s 为空，请删除后重新启动", path)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("读取 JWT 密钥文件失败: %w", err)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	secret = hex.EncodeToString(key)
+	if err := os.MkdirAll(cfg.Server.DataDir, 0o755); err != nil {
+		return nil, fmt.Errorf("保存 JWT 密钥失败: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		// 其他进程刚生成了密钥
+		return loadJWTSecret(cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("保存 JWT 密钥失败: %w", err)
+	}
+	_, err = f.WriteString(secret + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("保存 JWT 密钥失败: %w", err)
+	}
+	log.Printf("已生成 JWT 密钥并保存到 %s", path)
+	return []byte(secret), nil
+}
+
+// 隐藏密钥和密码后的配置，用于管理接口
+func (cfg Config) redacted() Config {
+	hide := func(s *string) {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+	if cfg.Database.Driver != DBDriverSQLite {
+		hide(&cfg.Database.DSN) // 连接串中含密码
+	}
+	hide(&cfg.Auth.JWTSecret)
+	hide(&cfg.Admin.Password)
+	hide(&cfg.Mail.Password)
+	hide(&cfg.DooTask.Token)
+	cfg.Server.CORSOrigins = append([]string{}, cfg.Server.CORSOrigins...)
+	return cfg
+}
+
+// 当前生效的配置及配置文件路径
+var (
+	appConfig     Config
+	appConfigFile string
+)
+
+// @Summary (Admin) 查看服务配置
+// @Description 返回当前生效的配置（配置文件与环境变量合并后），密钥、密码及数据库连接串以 ****** 代替
+// @Tags 管理员
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/config [get]
+func getConfigHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"config": appConfig.redacted(), "config_file": appConfigFile})
+}
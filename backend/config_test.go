@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 写入临时配置文件并通过 CONFIG_FILE 指定
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	return path
+}
+
+func TestLoadConfigFileAndEnv(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  addr: ":8080"
+  data_dir: /srv/meeting
+auth:
+  jwt_secret: from-file
+  token_ttl: 2h
+mail:
+  host: smtp.example.com
+  username: noreply@example.com
+  tls: STARTTLS
+`)
+	t.Setenv("LISTEN_ADDR", ":9090")
+	t.Setenv("JWT_SECRET", "from-env")
+	t.Setenv("CORS_ORIGINS", "https://a.example.com, https://b.example.com,")
+	t.Setenv("BACKUP_INTERVAL", "24h")
+	t.Setenv("BACKUP_KEEP", "3")
+
+	cfg, file, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file != path {
+		t.Errorf("配置文件 %q，应为 %q", file, path)
+	}
+	// 环境变量覆盖配置文件，未设置的环境变量不覆盖
+	if cfg.Server.Addr != ":9090" || cfg.Auth.JWTSecret != "from-env" || time.Duration(cfg.Auth.TokenTTL) != 2*time.Hour {
+		t.Errorf("Server.Addr = %q，JWTSecret = %q，TokenTTL = %v", cfg.Server.Addr, cfg.Auth.JWTSecret, time.Duration(cfg.Auth.TokenTTL))
+	}
+	if strings.Join(cfg.Server.CORSOrigins, "|") != "https://a.example.com|https://b.example.com" {
+		t.Errorf("CORSOrigins = %q", cfg.Server.CORSOrigins)
+	}
+	if time.Duration(cfg.Backup.Interval) != 24*time.Hour || cfg.Backup.Keep != 3 {
+		t.Errorf("Backup = %+v", cfg.Backup)
+	}
+	// 由其他配置推导的值
+	if cfg.Database.DSN != filepath.Join("/srv/meeting", "meeting_room.db") || cfg.Backup.Dir != filepath.Join("/srv/meeting", "backups") {
+		t.Errorf("Database.DSN = %q，Backup.Dir = %q", cfg.Database.DSN, cfg.Backup.Dir)
+	}
+	if cfg.Mail.From != "noreply@example.com" || cfg.Mail.TLS != "starttls" {
+		t.Errorf("Mail.From = %q，Mail.TLS = %q", cfg.Mail.From, cfg.Mail.TLS)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	cases := []struct {
+		name, file string
+		env        map[string]string
+		want       []string
+	}{
+		{"未知配置项", "server:\n  port: 80\n", nil, []string{"field port not found"}},
+		{"环境变量不是整数", "", map[string]string{"SMTP_PORT": "abc"}, []string{"环境变量 SMTP_PORT 应为整数"}},
+		{"时长格式无效", "", map[string]string{"TOKEN_TTL": "1d"}, []string{"环境变量 TOKEN_TTL", "时长格式无效"}},
+		{"列出所有问题", "database:\n  driver: oracle\nmail:\n  tls: tls\nbackup:\n  keep: -1\n", map[string]string{"CORS_ORIGINS": "example.com"}, []string{
+			"server.cors_origins 中的 \"example.com\"",
+			"database.driver 只能是",
+			"mail.tls 只能是",
+			"backup.keep 不能为负数",
+		}},
+		{"定时备份仅支持 SQLite", "", map[string]string{"DB_DRIVER": "postgres", "DB_DSN": "host=db", "BACKUP_INTERVAL": "1h"}, []string{"backup.interval 仅支持 SQLite"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			writeConfigFile(t, tc.file)
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			_, _, err := loadConfig()
+			for _, want := range tc.want {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("loadConfig() = %v，应包含 %q", err, want)
+				}
+			}
+		})
+	}
+
+	t.Run("指定的配置文件不存在", func(t *testing.T) {
+		t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+		if _, _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "读取配置文件") {
+			t.Errorf("loadConfig() = %v", err)
+		}
+	})
+}
+
+// 生产环境中未配置密钥或仍为默认密钥时不拒绝启动，改用数据目录下自动生成并保存的密钥
+func TestLoadJWTSecret(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	cfg := defaultConfig()
+	cfg.Server.DataDir = dir
+	cfg.normalize()
+
+	t.Setenv("GIN_MODE", "release")
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("默认配置在 release 模式下校验失败: %v", err)
+	}
+	generated, err := loadJWTSecret(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(generated) != 64 {
+		t.Errorf("生成的密钥 %q 长度应为 64", generated)
+	}
+	info, err := os.Stat(filepath.Join(dir, jwtSecretFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("密钥文件权限 %v，应为 0600", info.Mode().Perm())
+	}
+
+	// 重启后读取同一密钥
+	for _, secret := range []string{"", "secret_key", "change-me"} {
+		cfg.Auth.JWTSecret = secret
+		if key, err := loadJWTSecret(cfg); err != nil || string(key) != string(generated) {
+			t.Errorf("jwt_secret 为 %q 时使用 %q，错误 %v，应为生成的密钥", secret, key, err)
+		}
+	}
+
+	cfg.Auth.JWTSecret = "configured"
+	if key, err := loadJWTSecret(cfg); err != nil || string(key) != "configured" {
+		t.Errorf("使用 %q，错误 %v，应为配置的密钥", key, err)
+	}
+
+	// 开发环境允许使用默认密钥
+	t.Setenv("GIN_MODE", "debug")
+	cfg.Auth.JWTSecret = "secret_key"
+	if key, err := loadJWTSecret(cfg); err != nil || string(key) != "secret_key" {
+		t.Errorf("开发环境使用 %q，错误 %v", key, err)
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Auth.JWTSecret = "jwt"
+	cfg.Admin.Password = "pw"
+	cfg.Mail.Password = "smtp"
+	cfg.DooTask.Token = "bot"
+	cfg.Database = dbConfig{Driver: DBDriverPostgres, DSN: "host=db password=secret"}
+	r := cfg.redacted()
+	for name, v := range map[string]string{"jwt_secret": r.Auth.JWTSecret, "admin.password": r.Admin.Password, "mail.password": r.Mail.Password, "dootask.token": r.DooTask.Token, "database.dsn": r.Database.DSN} {
+		if v != redacted {
+			t.Errorf("%s = %q，应隐藏", name, v)
+		}
+	}
+	if cfg.Auth.JWTSecret != "jwt" {
+		t.Error("redacted 修改了原配置")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 支持的数据库
+const (
+	DBDriverSQLite   = "sqlite"
+	DBDriverPostgres = "postgres"
+	DBDriverMySQL    = "mysql"
+)
+
+// dbConfig 数据库配置，默认使用数据目录下的 SQLite 文件
+type dbConfig struct {
+	Driver string `yaml:"driver" json:"driver" env:"DB_DRIVER"` // sqlite、postgres 或 mysql
+	DSN    string `yaml:"dsn" json:"dsn" env:"DB_DSN"`          // SQLite 为文件路径；PostgreSQL 如 host=db user=meeting password=secret dbname=meeting；MySQL 如 user:secret@tcp(db:3306)/meeting
+}
+
+// 按配置连接数据库
+func openDatabase(cfg dbConfig) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch cfg.Driver {
+	case DBDriverSQLite:
+		dialector = sqlite.Open(cfg.DSN)
+	case DBDriverPostgres:
+		dialector = postgres.Open(cfg.DSN)
+	case DBDriverMySQL:
+		// 时间统一按 UTC 读写，与其他数据库一致
+		dsn, err := mysqldriver.ParseDSN(cfg.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("MySQL 连接串无效: %w", err)
+		}
+		dsn.ParseTime = true
+		dsn.Loc = time.UTC
+		dialector = mysql.New(mysql.Config{DSNConfig: dsn})
+	default:
+		return nil, fmt.Errorf("不支持的数据库类型 %q，可选 sqlite、postgres、mysql", cfg.Driver)
+	}
+	if cfg.DSN == "" {
+		return nil, fmt.Errorf("未设置 DB_DSN")
+	}
+	return gorm.Open(dialector, &gorm.Config{})
+}
+
+// 锁定会议室直到事务结束，使同一会议室的冲突检查和写入依次执行，避免并发预订同一时间段。
+// SQLite 的写事务本身是串行的，不支持行锁，锁定语句会被忽略
+func lockRoom(tx *gorm.DB, roomID uint) error {
+	var room Room
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&room, roomID).Error
+}
+
+// sqlDialect 各数据库语法不同的 SQL 片段，值为 gorm 方言名称
+type sqlDialect string
+
+func dialectOf(tx *gorm.DB) sqlDialect {
+	return sqlDialect(tx.Dialector.Name())
+}
+
+// 从 origin 到 t 两个时间之间的分钟数（浮点数）
+func (d sqlDialect) minutesBetween(origin, t string) string {
+	switch d {
+	case DBDriverPostgres:
+		return fmt.Sprintf("CAST(EXTRACT(EPOCH FROM (CAST(%s AS TIMESTAMPTZ) - CAST(%s AS TIMESTAMPTZ))) AS DOUBLE PRECISION) / 60", t, origin)
+	case DBDriverMySQL:
+		return fmt.Sprintf("TIMESTAMPDIFF(MICROSECOND, %s, %s) / 6e7", origin, t)
+	default:
+		return fmt.Sprintf("(julianday(%s) - julianday(%s)) * 1440", t, origin)
+	}
+}
+
+// 两个值中较小的一个
+func (d sqlDialect) least(a, b string) string {
+	if d == DBDriverSQLite {
+		return fmt.Sprintf("MIN(%s, %s)", a, b)
+	}
+	return fmt.Sprintf("LEAST(%s, %s)", a, b)
+}
+
+// 两个值中较大的一个
+func (d sqlDialect) greatest(a, b string) string {
+	if d == DBDriverSQLite {
+		return fmt.Sprintf("MAX(%s, %s)", a, b)
+	}
+	return fmt.Sprintf("GREATEST(%s, %s)", a, b)
+}
+
+// 非负数向下取整为整数
+func (d sqlDialect) floor(expr string) string {
+	switch d {
+	case DBDriverPostgres:
+		return fmt.Sprintf("CAST(FLOOR(%s) AS INTEGER)", expr)
+	case DBDriverMySQL:
+		return fmt.Sprintf("FLOOR(%s)", expr)
+	default:
+		return fmt.Sprintf("CAST(%s AS INTEGER)", expr)
+	}
+}
+
+// 转为双精度浮点数，递归查询中各部分的列类型需一致
+func (d sqlDialect) float(expr string) string {
+	switch d {
+	case DBDriverPostgres:
+		return fmt.Sprintf("CAST(%s AS DOUBLE PRECISION)", expr)
+	case DBDriverMySQL:
+		return fmt.Sprintf("CAST(%s AS DOUBLE)", expr)
+	default:
+		return fmt.Sprintf("CAST(%s AS REAL)", expr)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 测试所用的数据库：SQLite 使用临时文件；设置 TEST_POSTGRES_DSN、TEST_MYSQL_DSN 时同样的测试
+// 也在这些数据库上运行，未设置时跳过。注意测试开始时会回滚其中的所有迁移，清空数据
+var testDatabases = []struct {
+	driver string
+	env    string // 连接串所在的环境变量，SQLite 为空
+}{
+	{DBDriverSQLite, ""},
+	{DBDriverPostgres, "TEST_POSTGRES_DSN"},
+	{DBDriverMySQL, "TEST_MYSQL_DSN"},
+}
+
+// 在每种数据库上分别运行 fn，每次都是新迁移的空数据库
+func forEachDatabase(t *testing.T, fn func(t *testing.T, tx *gorm.DB)) {
+	for _, d := range testDatabases {
+		d := d
+		t.Run(d.driver, func(t *testing.T) {
+			fn(t, openTestDB(t, d.driver, d.env))
+		})
+	}
+}
+
+// 在 SQLite 临时文件上创建数据库
+func setupTestDB(t *testing.T) *gorm.DB {
+	return openTestDB(t, DBDriverSQLite, "")
+}
+
+// 连接测试数据库并迁移到最新版本，测试期间替换全局 db
+func openTestDB(t *testing.T, driver, env string) *gorm.DB {
+	t.Helper()
+	cfg := dbConfig{Driver: driver}
+	if env == "" {
+		cfg.DSN = filepath.Join(t.TempDir(), "test.db")
+	} else if cfg.DSN = os.Getenv(env); cfg.DSN == "" {
+		t.Skipf("未设置 %s，跳过 %s", env, driver)
+	}
+	conn, err := openDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env != "" {
+		// 共用的数据库先回滚到空库
+		if _, err := migrateDown(conn, math.MaxInt32); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := migrateUp(conn); err != nil {
+		t.Fatal(err)
+	}
+	old := db
+	db = conn
+	t.Cleanup(func() {
+		db = old
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return conn
+}
+
+func TestOpenDatabaseErrors(t *testing.T) {
+	cases := []struct {
+		cfg  dbConfig
+		want string
+	}{
+		{dbConfig{Driver: "oracle", DSN: "x"}, "不支持的数据库类型"},
+		{dbConfig{Driver: DBDriverPostgres}, "未设置 DB_DSN"},
+		{dbConfig{Driver: DBDriverMySQL, DSN: "meeting@127.0.0.1/meeting"}, "MySQL 连接串无效"},
+	}
+	for _, tc := range cases {
+		if _, err := openDatabase(tc.cfg); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("openDatabase(%+v) = %v，应包含 %q", tc.cfg, err, tc.want)
+		}
+	}
+}
+
+func TestLockRoom(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, tx *gorm.DB) {
+		room := Room{Name: "A101"}
+		if err := tx.Create(&room).Error; err != nil {
+			t.Fatal(err)
+		}
+		err := tx.Transaction(func(tx *gorm.DB) error {
+			return lockRoom(tx, room.ID)
+		})
+		if err != nil {
+			t.Errorf("锁定会议室失败: %v", err)
+		}
+		err = tx.Transaction(func(tx *gorm.DB) error {
+			return lockRoom(tx, room.ID+1)
+		})
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("锁定不存在的会议室返回 %v，应为 ErrRecordNotFound", err)
+		}
+	})
+}
+
+func TestSQLDialect(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, tx *gorm.DB) {
+		d := dialectOf(tx)
+		origin := time.Date(2030, 5, 6, 0, 0, 0, 0, time.UTC)
+		var row struct {
+			Minutes  float64
+			Least    float64
+			Greatest float64
+			Floor    int
+		}
+		query := "SELECT " + d.minutesBetween("@origin", "@t") + " AS minutes, " +
+			d.least(d.float("@a"), d.float("@b")) + " AS least, " +
+			d.greatest(d.float("@a"), d.float("@b")) + " AS greatest, " +
+			d.floor(d.float("@c")) + " AS floor"
+		err := tx.Raw(query,
+			sql.Named("origin", origin),
+			sql.Named("t", origin.Add(90*time.Minute+30*time.Second)),
+			sql.Named("a", 1.5),
+			sql.Named("b", -2),
+			sql.Named("c", 7.9),
+		).Scan(&row).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(row.Minutes-90.5) > 1e-6 || row.Least != -2 || row.Greatest != 1.5 || row.Floor != 7 {
+			t.Errorf("%s: %+v，应为 {Minutes:90.5 Least:-2 Greatest:1.5 Floor:7}", d, row)
+		}
+	})
+}
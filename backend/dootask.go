@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DooTask 接口超时
+const dooTaskTimeout = 10 * time.Second
+
+// dooTaskConfig DooTask 机器人配置，url 和 token 均设置后启用
+type dooTaskConfig struct {
+	BaseURL string `yaml:"url" json:"url" env:"DOOTASK_URL"`       // DooTask 地址，如 http://nginx 或 https://dootask.example.com
+	Token   string `yaml:"token" json:"token" env:"DOOTASK_TOKEN"` // 机器人的 token
+}
+
+func (cfg dooTaskConfig) enabled() bool {
+	return cfg.BaseURL != "" && cfg.Token != ""
+}
+
+// 启动时由配置设置
+var dooTaskSettings dooTaskConfig
+
+// 消息标题
+var dooTaskTitles = map[string]map[string]string{
+	"zh": {
+		NotifyConfirmation: "会议室预订成功",
+		NotifyChange:       "会议室预订已变更",
+		NotifyCancellation: "会议室预订已取消",
+		NotifyReminder:     "会议即将开始",
+		NotifyTest:         "测试消息",
+	},
+	"en": {
+		NotifyConfirmation: "Meeting room booked",
+		NotifyChange:       "Booking changed",
+		NotifyCancellation: "Booking cancelled",
+		NotifyReminder:     "Meeting starting soon",
+		NotifyTest:         "Test message",
+	},
+}
+
+// Markdown 消息正文
+var dooTaskTemplates = map[string]*template.Template{
+	"zh": template.Must(template.New("dootask/zh").Parse(`**{{.Title}}**
+{{- with .Data}}{{if .Summary}}
+会议：{{.Summary}}
+会议室：{{.Room}}
+时间：{{.Start}} - {{.End}}（{{.TimeZone}}）
+{{- if gt .Count 1}}
+次数：{{.Count}}{{end}}
+{{- if not .IsOrganizer}}
+预订人：{{.Organizer}}{{end}}
+{{- if .CancelledBy}}
+操作人：{{.CancelledBy}}{{end}}
+{{- if .CancelReason}}
+取消原因：{{.CancelReason}}{{end}}
+{{- else}}
+会议室预订系统的 DooTask 通知配置正确。{{end}}{{end}}`)),
+	"en": template.Must(template.New("dootask/en").Parse(`**{{.Title}}**
+{{- with .Data}}{{if .Summary}}
+Meeting: {{.Summary}}
+Room: {{.Room}}
+Time: {{.Start}} - {{.End}} ({{.TimeZone}})
+{{- if gt .Count 1}}
+Occurrences: {{.Count}}{{end}}
+{{- if not .IsOrganizer}}
+Organizer: {{.Organizer}}{{end}}
+{{- if .CancelledBy}}
+Cancelled by: {{.CancelledBy}}{{end}}
+{{- if .CancelReason}}
+Reason: {{.CancelReason}}{{end}}
+{{- else}}
+DooTask notifications for the meeting room system are configured correctly.{{end}}{{end}}`)),
+}
+
+// 解析 SSO 传入的 DooTask 用户ID，兼容数字和字符串
+func dooTaskUserID(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		if v > 0 {
+			return strconv.FormatUint(uint64(v), 10)
+		}
+	case string:
+		if id, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64); err == nil && id > 0 {
+			return strconv.FormatUint(id, 10)
+		}
+	}
+	return ""
+}
+
+// dooTaskNotifier 通过 DooTask 机器人向预订人发送私聊消息
+type dooTaskNotifier struct{}
+
+func (dooTaskNotifier) channel() string { return ChannelDooTask }
+
+func (dooTaskNotifier) enabled() bool { return dooTaskSettings.enabled() }
+
+func (dooTaskNotifier) compose(n notice, user User, lang string, data noticeData) (NotificationMessage, bool) {
+	if user.DooTaskUserID == "" {
+		return NotificationMessage{}, false
+	}
+	title := dooTaskTitles[lang][n.Kind]
+	var body bytes.Buffer
+	if err := dooTaskTemplates[lang].Execute(&body, struct {
+		Title string
+		Data  noticeData
+	}{title, data}); err != nil {
+		return NotificationMessage{}, false
+	}
+	return NotificationMessage{To: user.DooTaskUserID, Subject: title, Body: body.String()}, true
+}
+
+func (dooTaskNotifier) validRecipient(to string) bool {
+	id, err := strconv.ParseUint(to, 10, 64)
+	return err == nil && id > 0
+}
+
+func (dooTaskNotifier) send(msg NotificationMessage) error {
+	client := dooTaskClient{cfg: dooTaskSettings, http: &http.Client{Timeout: dooTaskTimeout}}
+	dialogID, err := client.userDialog(msg.To)
+	if err != nil {
+		return err
+	}
+	return client.sendText(dialogID, msg.Body)
+}
+
+// dooTaskClient 调用 DooTask 接口，以机器人身份发送消息
+type dooTaskClient struct {
+	cfg  dooTaskConfig
+	http *http.Client
+}
+
+// dooTaskResponse DooTask 接口的统一返回格式，ret 为 1 表示成功
+type dooTaskResponse struct {
+	Ret  int             `json:"ret"`
+	Msg  string          `json:"msg"`
+	Data json.RawMessage `json:"data"`
+}
+
+func (c dooTaskClient) call(method, path string, params url.Values) (json.RawMessage, error) {
+	endpoint := c.cfg.BaseURL + path
+	var body io.Reader
+	if method == http.MethodGet {
+		endpoint += "?" + params.Encode()
+	} else {
+		body = strings.NewReader(params.Encode())
+	}
+	req, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("token", c.cfg.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DooTask %s 返回 HTTP %d", path, resp.StatusCode)
+	}
+	var result dooTaskResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("DooTask %s 返回格式错误: %v", path, err)
+	}
+	if result.Ret != 1 {
+		return nil, fmt.Errorf("DooTask %s 失败: %s", path, result.Msg)
+	}
+	return result.Data, nil
+}
+
+// 打开机器人与用户的私聊会话，返回会话ID
+func (c dooTaskClient) userDialog(userID string) (string, error) {
+	data, err := c.call(http.MethodGet, "/api/dialog/open/user", url.Values{"userid": {userID}})
+	if err != nil {
+		return "", err
+	}
+	var dialog struct {
+		ID json.Number `json:"id"`
+	}
+	if err := json.Unmarshal(data, &dialog); err != nil || dialog.ID == "" {
+		return "", fmt.Errorf("DooTask 未返回会话ID")
+	}
+	return dialog.ID.String(), nil
+}
+
+// 在会话中发送 Markdown 消息
+func (c dooTaskClient) sendText(dialogID, text string) error {
+	_, err := c.call(http.MethodPost, "/api/dialog/msg/sendtext", url.Values{
+		"dialog_id": {dialogID},
+		"text":      {text},
+		"text_type": {"md"},
+	})
+	return err
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 模拟 DooTask 机器人接口，记录收到的请求，前 failures 次发送返回 HTTP 500
+type fakeDooTask struct {
+	mu       sync.Mutex
+	failures int
+	requests []fakeDooTaskRequest
+}
+
+type fakeDooTaskRequest struct {
+	method, path, token, contentType string
+	form                             map[string]string
+}
+
+func (f *fakeDooTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	req := fakeDooTaskRequest{
+		method:      r.Method,
+		path:        r.URL.Path,
+		token:       r.Header.Get("token"),
+		contentType: r.Header.Get("Content-Type"),
+		form:        make(map[string]string),
+	}
+	for k := range r.Form {
+		req.form[k] = r.Form.Get(k)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, req)
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/api/dialog/open/user":
+		w.Write([]byte(`{"ret":1,"msg":"success","data":{"id":1001,"type":"user"}}`))
+	case "/api/dialog/msg/sendtext":
+		if f.failures > 0 {
+			f.failures--
+			http.Error(w, "unavailable", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"ret":1,"msg":"发送成功","data":{"id":1}}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeDooTask) sent() []fakeDooTaskRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []fakeDooTaskRequest
+	for _, req := range f.requests {
+		if req.path == "/api/dialog/msg/sendtext" {
+			result = append(result, req)
+		}
+	}
+	return result
+}
+
+func TestDooTaskNotificationDelivery(t *testing.T) {
+	tx := setupTestDB(t)
+	fake := &fakeDooTask{failures: 1}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	oldDooTask, oldMail := dooTaskSettings, mailSettings
+	dooTaskSettings = dooTaskConfig{BaseURL: srv.URL, Token: "bot-token"}
+	mailSettings = mailConfig{}
+	t.Cleanup(func() { dooTaskSettings, mailSettings = oldDooTask, oldMail })
+
+	organizer := User{Username: "alice", Nickname: "张三", Role: "user", DooTaskUserID: "42"}
+	room := Room{Name: "A101", TimeZone: "Asia/Shanghai"}
+	for _, v := range []interface{}{&organizer, &room} {
+		if err := tx.Create(v).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Date(2030, 5, 6, 2, 0, 0, 0, time.UTC)
+	booking := Booking{RoomID: room.ID, UserID: organizer.ID, StartTime: start, EndTime: start.Add(time.Hour), Reason: "周会", Status: BookingStatusActive}
+	if err := tx.Create(&booking).Error; err != nil {
+		t.Fatal(err)
+	}
+	notifyBookings(tx, NotifyConfirmation, []Booking{booking}, 0)
+
+	var msg NotificationMessage
+	if err := tx.Where("channel = ?", ChannelDooTask).First(&msg).Error; err != nil {
+		t.Fatalf("未生成 DooTask 通知: %v", err)
+	}
+	if msg.To != "42" || msg.Status != DeliveryPending {
+		t.Fatalf("通知 To = %q，Status = %q", msg.To, msg.Status)
+	}
+
+	// 第一次发送返回 5xx，保持待发送并按退避时间重试
+	before := time.Now().UTC()
+	deliverNotification(&msg)
+	var saved NotificationMessage
+	tx.First(&saved, msg.ID)
+	if saved.Status != DeliveryPending || saved.Attempts != 1 || !strings.Contains(saved.Error, "HTTP 502") {
+		t.Fatalf("第一次发送后 Status = %q，Attempts = %d，Error = %q", saved.Status, saved.Attempts, saved.Error)
+	}
+	if saved.NextAttemptAt.Before(before.Add(notifyBaseBackoff - time.Second)) {
+		t.Errorf("NextAttemptAt = %v，应在 %v 之后", saved.NextAttemptAt, before.Add(notifyBaseBackoff))
+	}
+
+	// 重试成功
+	deliverNotification(&saved)
+	var final NotificationMessage
+	tx.First(&final, msg.ID)
+	if final.Status != DeliverySucceeded || final.Attempts != 2 || final.Error != "" {
+		t.Fatalf("重试后 Status = %q，Attempts = %d，Error = %q", final.Status, final.Attempts, final.Error)
+	}
+
+	fake.mu.Lock()
+	requests := append([]fakeDooTaskRequest(nil), fake.requests...)
+	fake.mu.Unlock()
+	for _, req := range requests {
+		if req.token != "bot-token" {
+			t.Errorf("%s 的 token 请求头为 %q", req.path, req.token)
+		}
+	}
+	open := requests[0]
+	if open.method != http.MethodGet || open.path != "/api/dialog/open/user" || open.form["userid"] != "42" {
+		t.Errorf("打开会话请求 %s %s %v", open.method, open.path, open.form)
+	}
+	sent := fake.sent()
+	if len(sent) != 2 {
+		t.Fatalf("发送消息请求 %d 次，应为 2 次", len(sent))
+	}
+	last := sent[1]
+	if last.method != http.MethodPost || last.contentType != "application/x-www-form-urlencoded" {
+		t.Errorf("发送消息请求 %s，Content-Type %q", last.method, last.contentType)
+	}
+	if last.form["dialog_id"] != "1001" || last.form["text_type"] != "md" {
+		t.Errorf("发送消息参数 %v", last.form)
+	}
+	for _, want := range []string{"**会议室预订成功**", "会议：周会", "会议室：A101", "时间：2030-05-06 10:00 - 2030-05-06 11:00（Asia/Shanghai）"} {
+		if !strings.Contains(last.form["text"], want) {
+			t.Errorf("消息正文缺少 %q：\n%s", want, last.form["text"])
+		}
+	}
+}
+
+func TestDooTaskNotificationGivesUp(t *testing.T) {
+	tx := setupTestDB(t)
+	fake := &fakeDooTask{failures: notifyMaxAttempts}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	oldDooTask := dooTaskSettings
+	dooTaskSettings = dooTaskConfig{BaseURL: srv.URL, Token: "bot-token"}
+	t.Cleanup(func() { dooTaskSettings = oldDooTask })
+
+	msg := enqueueNotification(tx, NotificationMessage{Channel: ChannelDooTask, Kind: NotifyTest, To: "42", Subject: "测试消息", Body: "**测试消息**"})
+	for i := 0; i < notifyMaxAttempts; i++ {
+		deliverNotification(&msg)
+	}
+	var final NotificationMessage
+	tx.First(&final, msg.ID)
+	if final.Status != DeliveryFailed || final.Attempts != notifyMaxAttempts || !strings.Contains(final.Error, "HTTP 502") {
+		t.Fatalf("Status = %q，Attempts = %d，Error = %q，应在 %d 次后失败", final.Status, final.Attempts, final.Error, notifyMaxAttempts)
+	}
+	if n := len(fake.sent()); n != notifyMaxAttempts {
+		t.Errorf("发送消息请求 %d 次，应为 %d 次", n, notifyMaxAttempts)
+	}
+}
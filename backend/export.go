@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 导出格式
+const (
+	ExportCSV  = "csv"
+	ExportXLSX = "xlsx"
+)
+
+// 每写出多少行刷新一次响应，使大量数据边查询边下载
+const exportFlushRows = 500
+
+// 导出表格中的时间格式
+const exportTimeLayout = "2006-01-02 15:04"
+
+// tableWriter 导出的表格，CSV 和 XLSX 共用
+type tableWriter interface {
+	WriteRow(cells []interface{}) error
+	Flush() error
+	Close() error
+}
+
+// csvTableWriter 以 CSV 导出，带 UTF-8 BOM 以便 Excel 正确识别中文
+type csvTableWriter struct {
+	w *csv.Writer
+}
+
+func (t *csvTableWriter) WriteRow(cells []interface{}) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		switch v := cell.(type) {
+		case nil:
+		case int, int64, uint, uint64, float64:
+			record[i] = fmt.Sprint(v)
+		default:
+			record[i] = escapeFormula(fmt.Sprint(v))
+		}
+	}
+	return t.w.Write(record)
+}
+
+// 以 = + - @ 等开头的文本在 Excel 中会被当作公式执行，前面加 ' 按文本显示
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (t *csvTableWriter) Flush() error {
+	t.w.Flush()
+	return t.w.Error()
+}
+
+func (t *csvTableWriter) Close() error {
+	return t.Flush()
+}
+
+// 导出表格的列名
+type exportColumn struct {
+	zh, en string
+}
+
+// 按语言生成表头，时间列附带时区
+func exportHeader(columns []exportColumn, lang string, timeColumns map[int]bool, loc *time.Location) []interface{} {
+	zone := ""
+	if loc != nil {
+		zone = loc.String()
+		if zone == "Local" {
+			zone, _ = time.Now().In(loc).Zone()
+		}
+	}
+	header := make([]interface{}, len(columns))
+	for i, col := range columns {
+		name := col.zh
+		if lang == "en" {
+			name = col.en
+		}
+		if timeColumns[i] {
+			name = fmt.Sprintf("%s (%s)", name, zone)
+		}
+		header[i] = name
+	}
+	return header
+}
+
+// 开始导出：校验格式、设置下载响应头并写入表头，format 无效时返回 false 并写入错误响应
+func startExport(c *gin.Context, name string, header []interface{}) (tableWriter, bool) {
+	format := c.DefaultQuery("format", ExportCSV)
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().Format("20060102-150405"), format)
+	switch format {
+	case ExportCSV:
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	case ExportXLSX:
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "导出格式只能是 csv 或 xlsx"})
+		return nil, false
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+	tw, err := newTableWriter(c.Writer, format, name, header)
+	if err != nil {
+		log.Printf("导出失败: %v", err)
+		return nil, false
+	}
+	return tw, true
+}
+
+// 创建指定格式的表格并写入表头，name 为 XLSX 的工作表名
+func newTableWriter(w io.Writer, format, name string, header []interface{}) (tableWriter, error) {
+	var tw tableWriter
+	switch format {
+	case ExportCSV:
+		if _, err := io.WriteString(w, "\xEF\xBB\xBF"); err != nil {
+			return nil, err
+		}
+		tw = &csvTableWriter{w: csv.NewWriter(w)}
+	case ExportXLSX:
+		x, err := newXLSXWriter(w, name)
+		if err != nil {
+			return nil, err
+		}
+		tw = x
+	default:
+		return nil, fmt.Errorf("导出格式只能是 csv 或 xlsx")
+	}
+	if err := tw.WriteRow(header); err != nil {
+		return nil, err
+	}
+	return tw, nil
+}
+
+// 写入一行，定期刷新到 w（HTTP 响应时发送给客户端）。ctx 取消（如客户端断开）时返回错误
+func writeExportRow(ctx context.Context, w io.Writer, tw tableWriter, n int, cells []interface{}) error {
+	if err := tw.WriteRow(cells); err != nil {
+		return err
+	}
+	if n%exportFlushRows == 0 {
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	return ctx.Err()
+}
+
+// 按时区格式化导出的时间，空值为空单元格
+func exportTime(t *time.Time, loc *time.Location) interface{} {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return t.In(loc).Format(exportTimeLayout)
+}
+
+// 预订导出的列
+var bookingExportColumns = []exportColumn{
+	{"预订ID", "Booking ID"},
+	{"会议室", "Room"},
+	{"预订人", "Organizer"},
+	{"用户名", "Username"},
+	{"开始时间", "Start"},
+	{"结束时间", "End"},
+	{"时长（分钟）", "Duration (min)"},
+	{"事由", "Reason"},
+	{"状态", "Status"},
+	{"可见性", "Visibility"},
+	{"参与人数", "Participants"},
+	{"签到时间", "Checked in"},
+	{"取消时间", "Cancelled at"},
+	{"取消人", "Cancelled by"},
+	{"取消原因", "Cancel reason"},
+	{"创建时间", "Created at"},
+}
+
+// 预订导出中的时间列
+var bookingExportTimeColumns = map[int]bool{4: true, 5: true, 11: true, 12: true, 15: true}
+
+// 状态和可见性的显示名称
+var exportLabels = map[string]map[string]string{
+	"zh": {
+		BookingStatusActive:    "有效",
+		BookingStatusCancelled: "已取消",
+		VisibilityPublic:       "公开",
+		VisibilityPrivate:      "私密",
+		"admin":                "管理员",
+		"user":                 "普通用户",
+	},
+	"en": {
+		BookingStatusActive:    "Active",
+		BookingStatusCancelled: "Cancelled",
+		VisibilityPublic:       "Public",
+		VisibilityPrivate:      "Private",
+		"admin":                "Admin",
+		"user":                 "User",
+	},
+}
+
+func exportLabel(lang, value string) string {
+	if label, ok := exportLabels[lang][value]; ok {
+		return label
+	}
+	return value
+}
+
+// 预订导出的一行，由联表查询得到
+type bookingExportRow struct {
+	ID                  uint
+	RoomName            string
+	Username            string
+	Nickname            string
+	StartTime           time.Time
+	EndTime             time.Time
+	Reason              string
+	Status              string
+	Visibility          string
+	Participants        int
+	CheckedInAt         *time.Time
+	CancelledAt         *time.Time
+	CancelledByUsername string
+	CancelledByNickname string
+	CancelReason        string
+	CreatedAt           time.Time
+}
+
+// @Summary (Admin) 导出预订
+// @Description 管理员以 CSV 或 XLSX 导出预订，筛选条件与预订列表相同，时间列按 tz 参数的时区显示。数据边查询边写出，适合大量数据
+// @Tags 管理员
+// @Produce octet-stream
+// @Param format query string false "csv（默认）或 xlsx"
+// @Param lang query string false "表头语言，zh（默认）或 en"
+// @Param room_id query int false "会议室ID"
+// @Param user_id query int false "预订人ID"
+// @Param status query string false "active 或 cancelled，默认只导出有效预订"
+// @Param start_time query string false "开始时间(ISO8601)，导出与时间段重叠的预订"
+// @Param end_time query string false "结束时间(ISO8601)"
+// @Param date query string false "当地日期(YYYY-MM-DD)"
+// @Param q query string false "事由关键字"
+// @Param tz query string false "时间列及不带时区的时间参数所用的时区，默认用户时区"
+// @Param include_cancelled query bool false "未指定 status 时是否包含已取消的预订"
+// @Success 200 {file} file "导出文件"
+// @Security Bearer
+// @Router /api/admin/bookings/export [get]
+func exportBookingsHandler(c *gin.Context) {
+	loc, ok := requestLocation(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "时区无效"})
+		return
+	}
+	filter, msg := parseBookingFilter(c, loc)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	lang := c.DefaultQuery("lang", "zh")
+	rows, err := queryBookingExport(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导出失败"})
+		return
+	}
+	defer rows.Close()
+	tw, ok := startExport(c, "bookings", exportHeader(bookingExportColumns, lang, bookingExportTimeColumns, loc))
+	if !ok {
+		return
+	}
+	// 客户端断开时停止导出，不记录日志
+	if err := writeBookingExport(c.Request.Context(), c.Writer, tw, rows, lang, loc); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("导出预订失败: %v", err)
+	}
+}
+
+// 查询要导出的预订，按开始时间倒序
+func queryBookingExport(filter bookingFilter) (*sql.Rows, error) {
+	return db.Table("bookings").
+		Select(`bookings.id, bookings.start_time, bookings.end_time, bookings.reason, bookings.status, bookings.visibility,
+			bookings.checked_in_at, bookings.cancelled_at, bookings.cancel_reason, bookings.created_at,
+			COALESCE(rooms.name, '') AS room_name,
+			COALESCE(users.username, '') AS username, COALESCE(users.nickname, '') AS nickname,
+			COALESCE(canceller.username, '') AS cancelled_by_username, COALESCE(canceller.nickname, '') AS cancelled_by_nickname,
+			(SELECT COUNT(*) FROM booking_participants p WHERE p.booking_id = bookings.id) AS participants`).
+		Joins("LEFT JOIN rooms ON rooms.id = bookings.room_id").
+		Joins("LEFT JOIN users ON users.id = bookings.user_id").
+		Joins("LEFT JOIN users canceller ON canceller.id = bookings.cancelled_by").
+		Scopes(filter.apply).
+		Order("bookings.start_time DESC").
+		Rows()
+}
+
+// 逐行写出查询到的预订并关闭表格
+func writeBookingExport(ctx context.Context, w io.Writer, tw tableWriter, rows *sql.Rows, lang string, loc *time.Location) error {
+	for n := 1; rows.Next(); n++ {
+		var r bookingExportRow
+		if err := db.ScanRows(rows, &r); err != nil {
+			return err
+		}
+		err := writeExportRow(ctx, w, tw, n, []interface{}{
+			r.ID,
+			r.RoomName,
+			displayName(User{Username: r.Username, Nickname: r.Nickname}),
+			r.Username,
+			exportTime(&r.StartTime, loc),
+			exportTime(&r.EndTime, loc),
+			int(r.EndTime.Sub(r.StartTime) / time.Minute),
+			r.Reason,
+			exportLabel(lang, r.Status),
+			exportLabel(lang, r.Visibility),
+			r.Participants,
+			exportTime(r.CheckedInAt, loc),
+			exportTime(r.CancelledAt, loc),
+			displayName(User{Username: r.CancelledByUsername, Nickname: r.CancelledByNickname}),
+			r.CancelReason,
+			exportTime(&r.CreatedAt, loc),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// 用户导出的列
+var userExportColumns = []exportColumn{
+	{"用户ID", "User ID"},
+	{"用户名", "Username"},
+	{"昵称", "Nickname"},
+	{"邮箱", "Email"},
+	{"角色", "Role"},
+	{"用户组", "Group"},
+	{"时区", "Time zone"},
+	{"DooTask 用户ID", "DooTask user ID"},
+}
+
+// @Summary (Admin) 导出用户
+// @Description 管理员以 CSV 或 XLSX 导出用户列表（不含密码），筛选条件与用户列表相同
+// @Tags 管理员
+// @Produce octet-stream
+// @Param format query string false "csv（默认）或 xlsx"
+// @Param lang query string false "表头语言，zh（默认）或 en"
+// @Param q query string false "关键字，匹配用户名、昵称和邮箱"
+// @Param role query string false "角色，admin 或 user"
+// @Param group query string false "用户组"
+// @Success 200 {file} file "导出文件"
+// @Security Bearer
+// @Router /api/admin/users/export [get]
+func exportUsersHandler(c *gin.Context) {
+	lang := c.DefaultQuery("lang", "zh")
+	rows, err := db.Model(&User{}).Scopes(userListFilter(c)).Order("id").Rows()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导出失败"})
+		return
+	}
+	defer rows.Close()
+	tw, ok := startExport(c, "users", exportHeader(userExportColumns, lang, nil, nil))
+	if !ok {
+		return
+	}
+	if err := writeUserExport(c.Request.Context(), c.Writer, tw, rows, lang); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("导出用户失败: %v", err)
+	}
+}
+
+// 逐行写出查询到的用户并关闭表格
+func writeUserExport(ctx context.Context, w io.Writer, tw tableWriter, rows *sql.Rows, lang string) error {
+	for n := 1; rows.Next(); n++ {
+		var u User
+		if err := db.ScanRows(rows, &u); err != nil {
+			return err
+		}
+		err := writeExportRow(ctx, w, tw, n, []interface{}{
+			u.ID,
+			u.Username,
+			u.Nickname,
+			u.Email,
+			exportLabel(lang, u.Role),
+			u.Group,
+			u.TimeZone,
+			u.DooTaskUserID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
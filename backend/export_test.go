@@ -0,0 +1,56 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCSVWriterEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	tw := &csvTableWriter{w: csv.NewWriter(&buf)}
+	if err := tw.WriteRow([]interface{}{"=HYPERLINK(\"http://x\")", "+1", "-2", "@SUM(A1)", "周会", -3, nil}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := `"'=HYPERLINK(""http://x"")",'+1,'-2,'@SUM(A1),周会,-3,` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("CSV = %q，应为 %q", got, want)
+	}
+}
+
+func TestXLSXWriterEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	x, err := newXLSXWriter(&buf, "预订")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := x.WriteRow([]interface{}{"=1+1", "周会", -3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sheet string
+	for _, f := range zr.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			r, _ := f.Open()
+			data, _ := io.ReadAll(r)
+			sheet = string(data)
+		}
+	}
+	for _, want := range []string{`<t xml:space="preserve">&#39;=1+1</t>`, `<t xml:space="preserve">周会</t>`, `<v>-3</v>`} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("工作表缺少 %s:\n%s", want, sheet)
+		}
+	}
+}
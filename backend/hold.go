@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 临时占用默认和最长保留时间，及每个用户同时保留的最大数量
+const (
+	defaultHoldTTL = 5 * time.Minute
+	maxHoldTTL     = 15 * time.Minute
+	maxActiveHolds = 3
+)
+
+// BookingHold 临时占用，用户填写会议信息期间锁定时间段，过期自动失效
+type BookingHold struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	RoomID    uint      `gorm:"index" json:"room_id"`
+	UserID    uint      `json:"user_id"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+	Status    string    `gorm:"-" json:"status"` // 固定为 held，仅用于返回
+}
+
+// AfterFind 查询后填充状态字段
+func (h *BookingHold) AfterFind(tx *gorm.DB) error {
+	h.Status = "held"
+	return nil
+}
+
+// 临时占用请求体
+type CreateHoldRequest struct {
+	RoomID     uint      `json:"room_id" binding:"required"`
+	StartTime  time.Time `json:"start_time" binding:"required"`
+	EndTime    time.Time `json:"end_time" binding:"required"`
+	TTLSeconds int       `json:"ttl_seconds"` // 可选，默认300秒，最长900秒
+}
+
+// 确认临时占用请求体
+type ConfirmHoldRequest struct {
+	Reason         string `json:"reason"`
+	Visibility     string `json:"visibility"`
+	ParticipantIDs []uint `json:"participant_ids"`
+}
+
+// 检查会议室在指定时间段内是否被其他用户临时占用
+func hasHoldConflict(tx *gorm.DB, roomID uint, start, end time.Time, userID uint) bool {
+	var count int64
+	tx.Model(&BookingHold{}).
+		Where("room_id = ? AND user_id <> ? AND expires_at > ? AND end_time > ? AND start_time < ?", roomID, userID, time.Now().UTC(), start, end).
+		Count(&count)
+	return count > 0
+}
+
+// 临时占用已过期或已被清理
+var errHoldExpired = errors.New("hold expired")
+
+// 定期删除已过期的临时占用
+func sweepExpiredHolds(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := db.Where("expires_at <= ?", time.Now().UTC()).Delete(&BookingHold{}).Error; err != nil {
+			log.Printf("清理过期临时占用失败: %v", err)
+		}
+	}
+}
+
+// @Summary 临时占用会议室
+// @Description 在填写会议信息期间临时锁定时间段，过期后自动释放。与预订相同地检查开放时间、冲突和配额，每个用户最多同时保留 3 个
+// @Tags 预订
+// @Accept json
+// @Produce json
+// @Param data body CreateHoldRequest true "占用参数"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/holds [post]
+func createHoldHandler(c *gin.Context) {
+	var req CreateHoldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	if !req.EndTime.After(req.StartTime) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "时间范围不合法"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var room Room
+	if err := db.First(&room, req.RoomID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "会议室不存在"})
+		return
+	}
+	req.StartTime, req.EndTime = req.StartTime.UTC(), req.EndTime.UTC()
+
+	ttl := defaultHoldTTL
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+		if ttl > maxHoldTTL {
+			ttl = maxHoldTTL
+		}
+	}
+
+	now := time.Now().UTC()
+	hold := BookingHold{
+		RoomID:    req.RoomID,
+		UserID:    userID,
+		StartTime: req.StartTime,
+		EndTime:   req.EndTime,
+		ExpiresAt: now.Add(ttl),
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 与预订相同的开放时间、冲突、临时占用和配额检查，超出配额的时间段确认时也无法预订
+		if err := checkBookingSlots(tx, room, userID, 0, []timeSlot{{Start: req.StartTime, End: req.EndTime}}); err != nil {
+			return err
+		}
+		var active int64
+		if err := tx.Model(&BookingHold{}).Where("user_id = ? AND expires_at > ?", userID, now).Count(&active).Error; err != nil {
+			return err
+		}
+		if active >= maxActiveHolds {
+			return &bookingError{Status: http.StatusForbidden, Message: fmt.Sprintf("最多同时临时占用 %d 个时间段，请先确认或释放已有的占用", maxActiveHolds)}
+		}
+		if err := tx.Create(&hold).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "hold.create", "hold", hold.ID, nil, hold)
+		return nil
+	})
+	if err != nil {
+		writeBookingError(c, err, "占用失败")
+		return
+	}
+	hold.Status = "held"
+	c.JSON(http.StatusOK, gin.H{"message": "占用成功", "hold": hold})
+}
+
+// @Summary 确认临时占用
+// @Description 将未过期的临时占用转为正式预订
+// @Tags 预订
+// @Accept json
+// @Produce json
+// @Param id path int true "占用ID"
+// @Param data body ConfirmHoldRequest false "确认参数"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/holds/{id}/confirm [post]
+func confirmHoldHandler(c *gin.Context) {
+	var req ConfirmHoldRequest
+	// 请求体可选
+	_ = c.ShouldBindJSON(&req)
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var hold BookingHold
+	if err := db.First(&hold, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "占用不存在或已过期"})
+		return
+	}
+	if hold.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限确认该占用"})
+		return
+	}
+	var room Room
+	if err := db.First(&room, hold.RoomID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会议室不存在"})
+		return
+	}
+
+	visibility, err := resolveVisibility(db, req.Visibility)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "可见性参数无效"})
+		return
+	}
+	participants, err := normalizeParticipants(db, userID, req.ParticipantIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参与人不存在"})
+		return
+	}
+
+	var bookings []Booking
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// 锁定会议室后删除未过期的占用：占用可能已过期并被清理，时间段随后可能被他人占用或预订
+		if err := lockRoom(tx, room.ID); err != nil {
+			return err
+		}
+		result := tx.Where("expires_at > ?", time.Now().UTC()).Delete(&BookingHold{}, hold.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errHoldExpired
+		}
+		// 与直接预订相同的开放时间、冲突、临时占用和配额检查
+		slots := []timeSlot{{Start: hold.StartTime, End: hold.EndTime}}
+		if err := checkBookingSlots(tx, room, userID, 0, slots); err != nil {
+			return err
+		}
+		var err error
+		bookings, _, err = createBookings(tx, NewBooking{
+			Room:           room,
+			UserID:         userID,
+			Slots:          slots,
+			Reason:         req.Reason,
+			Visibility:     visibility,
+			ParticipantIDs: participants,
+		})
+		if err != nil {
+			return err
+		}
+		recordAudit(tx, c, "booking.create", "booking", bookings[0].ID, nil, bookings[0])
+		notifyBookings(tx, NotifyConfirmation, bookings, 0)
+		return nil
+	})
+	if errors.Is(err, errHoldExpired) {
+		c.JSON(http.StatusGone, gin.H{"error": "占用已过期，请重新选择时间"})
+		return
+	}
+	if err != nil {
+		writeBookingError(c, err, "预订失败")
+		return
+	}
+	booking := bookings[0]
+	booking.localize(room.location())
+	c.JSON(http.StatusOK, gin.H{"message": "预订成功", "booking": booking})
+}
+
+// @Summary 释放临时占用
+// @Description 放弃临时占用的时间段
+// @Tags 预订
+// @Param id path int true "占用ID"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/holds/{id} [delete]
+func releaseHoldHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var hold BookingHold
+	if err := db.First(&hold, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "占用不存在或已过期"})
+		return
+	}
+	if hold.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限释放该占用"})
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&hold).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "hold.release", "hold", hold.ID, hold, nil)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "释放失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "释放成功"})
+}
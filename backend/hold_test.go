@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// 两个用户和一个 08:00-20:00 开放的会议室，start 为一周后 09:00（UTC）
+func createHoldFixtures(t *testing.T) (User, User, Room, time.Time) {
+	t.Helper()
+	alice := User{Username: "alice", Role: "user"}
+	bob := User{Username: "bob", Role: "user"}
+	room := Room{Name: "A101", Capacity: 6, TimeZone: "UTC", OpenTime: "08:00", CloseTime: "20:00"}
+	mustCreate(t, &alice, &bob, &room)
+	start := time.Now().UTC().Truncate(24 * time.Hour).Add(7*24*time.Hour + 9*time.Hour)
+	return alice, bob, room, start
+}
+
+func holdRequest(room Room, start time.Time, d time.Duration) CreateHoldRequest {
+	return CreateHoldRequest{RoomID: room.ID, StartTime: start, EndTime: start.Add(d)}
+}
+
+func createHold(t *testing.T, user User, req CreateHoldRequest, want int) uint {
+	t.Helper()
+	code, resp := callHandler(t, createHoldHandler, user, http.MethodPost, "/api/holds", nil, req)
+	if code != want {
+		t.Fatalf("%s 临时占用返回 %d，应为 %d：%v", user.Username, code, want, resp)
+	}
+	if hold, ok := resp["hold"].(map[string]interface{}); ok {
+		return uint(hold["id"].(float64))
+	}
+	return 0
+}
+
+func confirmHold(t *testing.T, user User, holdID uint, req ConfirmHoldRequest) (int, map[string]interface{}) {
+	t.Helper()
+	return callHandler(t, confirmHoldHandler, user, http.MethodPost, "/api/holds/confirm", idParam(holdID), req)
+}
+
+func TestHoldBlocksOthersUntilConfirmed(t *testing.T) {
+	tx := setupTestDB(t)
+	alice, bob, room, start := createHoldFixtures(t)
+
+	holdID := createHold(t, alice, holdRequest(room, start, time.Hour), http.StatusOK)
+	// 他人不能占用或预订重叠的时间段
+	createHold(t, bob, holdRequest(room, start.Add(30*time.Minute), time.Hour), http.StatusConflict)
+	code, resp := callHandler(t, bookRoomHandler, bob, http.MethodPost, "/api/bookings", nil,
+		BookRoomRequest{RoomID: room.ID, StartTime: start, EndTime: start.Add(time.Hour)})
+	if code != http.StatusConflict || resp["error"] != "该时间段已被他人临时占用" {
+		t.Fatalf("他人预订返回 %d：%v", code, resp)
+	}
+
+	code, resp = confirmHold(t, bob, holdID, ConfirmHoldRequest{})
+	if code != http.StatusForbidden {
+		t.Fatalf("他人确认返回 %d：%v", code, resp)
+	}
+	code, resp = confirmHold(t, alice, holdID, ConfirmHoldRequest{Reason: "周会", ParticipantIDs: []uint{bob.ID}})
+	if code != http.StatusOK {
+		t.Fatalf("确认返回 %d：%v", code, resp)
+	}
+
+	var bookings []Booking
+	tx.Find(&bookings)
+	if len(bookings) != 1 || bookings[0].UserID != alice.ID || bookings[0].Reason != "周会" || !bookings[0].StartTime.Equal(start) {
+		t.Fatalf("预订 %+v", bookings)
+	}
+	// 与直接预订相同：保存参与人、发布事件、记录审计日志，并删除占用
+	var participants, events, audits, holds int64
+	tx.Model(&BookingParticipant{}).Where("booking_id = ? AND user_id = ?", bookings[0].ID, bob.ID).Count(&participants)
+	tx.Model(&ChangeEvent{}).Where("event = ?", EventBookingCreated).Count(&events)
+	tx.Model(&AuditLog{}).Where("action = ? AND target_id = ?", "booking.create", bookings[0].ID).Count(&audits)
+	tx.Model(&BookingHold{}).Count(&holds)
+	if participants != 1 || events != 1 || audits != 1 || holds != 0 {
+		t.Errorf("参与人 %d，事件 %d，审计日志 %d，剩余占用 %d", participants, events, audits, holds)
+	}
+
+	code, _ = confirmHold(t, alice, holdID, ConfirmHoldRequest{})
+	if code != http.StatusNotFound {
+		t.Errorf("重复确认返回 %d，应为 404", code)
+	}
+}
+
+// 占用过期后时间段被他人占用，确认不应覆盖他人的占用
+func TestConfirmExpiredHold(t *testing.T) {
+	tx := setupTestDB(t)
+	alice, bob, room, start := createHoldFixtures(t)
+
+	holdID := createHold(t, alice, holdRequest(room, start, time.Hour), http.StatusOK)
+	tx.Model(&BookingHold{}).Where("id = ?", holdID).Update("expires_at", time.Now().UTC().Add(-time.Second))
+	bobHold := createHold(t, bob, holdRequest(room, start, time.Hour), http.StatusOK)
+
+	code, resp := confirmHold(t, alice, holdID, ConfirmHoldRequest{})
+	if code != http.StatusGone {
+		t.Fatalf("确认过期的占用返回 %d：%v", code, resp)
+	}
+	var bookings int64
+	tx.Model(&Booking{}).Count(&bookings)
+	if bookings != 0 {
+		t.Errorf("确认过期的占用后有 %d 个预订", bookings)
+	}
+	if err := tx.First(&BookingHold{}, bobHold).Error; err != nil {
+		t.Errorf("他人的占用被删除: %v", err)
+	}
+}
+
+// 确认时重新检查开放时间和冲突，失败时保留占用
+func TestConfirmHoldRechecksSlot(t *testing.T) {
+	tx := setupTestDB(t)
+	alice, bob, room, start := createHoldFixtures(t)
+	holdID := createHold(t, alice, holdRequest(room, start, time.Hour), http.StatusOK)
+
+	tx.Model(&room).Update("open_time", "09:30")
+	code, resp := confirmHold(t, alice, holdID, ConfirmHoldRequest{})
+	if code != http.StatusBadRequest {
+		t.Fatalf("开放时间变更后确认返回 %d：%v", code, resp)
+	}
+	tx.Model(&room).Update("open_time", "08:00")
+
+	mustCreate(t, &Booking{RoomID: room.ID, UserID: bob.ID, StartTime: start.Add(30 * time.Minute), EndTime: start.Add(2 * time.Hour), Status: BookingStatusActive})
+	code, resp = confirmHold(t, alice, holdID, ConfirmHoldRequest{})
+	if code != http.StatusConflict {
+		t.Fatalf("时间段已被预订时确认返回 %d：%v", code, resp)
+	}
+	if err := tx.First(&BookingHold{}, holdID).Error; err != nil {
+		t.Errorf("确认失败后占用被删除: %v", err)
+	}
+}
+
+func TestHoldLimits(t *testing.T) {
+	tx := setupTestDB(t)
+	alice, bob, room, start := createHoldFixtures(t)
+
+	// 开放时间之外
+	createHold(t, alice, holdRequest(room, start.Add(-2*time.Hour), time.Hour), http.StatusBadRequest)
+
+	// 每个用户同时保留的数量有限，过期的不计
+	var ids []uint
+	for i := 0; i < maxActiveHolds; i++ {
+		ids = append(ids, createHold(t, alice, holdRequest(room, start.Add(time.Duration(i)*time.Hour), time.Hour), http.StatusOK))
+	}
+	next := holdRequest(room, start.Add(time.Duration(maxActiveHolds)*time.Hour), time.Hour)
+	createHold(t, alice, next, http.StatusForbidden)
+	tx.Model(&BookingHold{}).Where("id = ?", ids[0]).Update("expires_at", time.Now().UTC().Add(-time.Second))
+	createHold(t, alice, next, http.StatusOK)
+
+	// 占用时检查配额
+	mustCreate(t,
+		&BookingQuota{Scope: QuotaScopeUser, UserID: bob.ID, MaxActiveBookings: 1},
+		&Booking{RoomID: room.ID, UserID: bob.ID, StartTime: start.Add(24 * time.Hour), EndTime: start.Add(25 * time.Hour), Status: BookingStatusActive},
+	)
+	code, resp := callHandler(t, createHoldHandler, bob, http.MethodPost, "/api/holds", nil, holdRequest(room, start.Add(48*time.Hour), time.Hour))
+	if code != http.StatusForbidden {
+		t.Errorf("超出配额时占用返回 %d：%v", code, resp)
+	}
+}
+
+func TestReleaseHold(t *testing.T) {
+	tx := setupTestDB(t)
+	alice, bob, room, start := createHoldFixtures(t)
+	holdID := createHold(t, alice, holdRequest(room, start, time.Hour), http.StatusOK)
+
+	release := func(user User) int {
+		code, _ := callHandler(t, releaseHoldHandler, user, http.MethodDelete, "/api/holds", idParam(holdID), nil)
+		return code
+	}
+	if code := release(bob); code != http.StatusForbidden {
+		t.Errorf("他人释放返回 %d", code)
+	}
+	if code := release(alice); code != http.StatusOK {
+		t.Errorf("释放返回 %d", code)
+	}
+	var audits int64
+	tx.Model(&AuditLog{}).Where("action = ? AND target_id = ?", "hold.release", holdID).Count(&audits)
+	if audits != 1 {
+		t.Errorf("审计日志 %d 条，应为 1 条", audits)
+	}
+	// 释放后他人可以占用
+	createHold(t, bob, holdRequest(room, start, time.Hour), http.StatusOK)
+}
@@ -0,0 +1,825 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 日历订阅包含的历史预订范围
+const calendarFeedHistory = 90 * 24 * time.Hour
+
+// iCalendar 产品标识及 UID 域名
+const (
+	icsProdID    = "-//MeetingRoom//Meeting Room Booking//ZH"
+	icsUIDDomain = "meeting-room"
+)
+
+// VCALENDAR 的 METHOD（RFC 5546）：订阅地址发布全部预订，邮件附件邀请参会或取消会议
+const (
+	icsMethodPublish = "PUBLISH"
+	icsMethodRequest = "REQUEST"
+	icsMethodCancel  = "CANCEL"
+)
+
+// CalendarToken 日历订阅令牌，订阅地址通过令牌鉴权，日历客户端无需登录
+type CalendarToken struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	UserID    uint      `gorm:"uniqueIndex" json:"-"`
+	Token     string    `gorm:"uniqueIndex;size:191" json:"token"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// icsWriter 生成 RFC 5545 内容，负责 CRLF 换行和 75 字节折行
+type icsWriter struct {
+	b      strings.Builder
+	method string // REQUEST、CANCEL 时事件带 ORGANIZER，供邮件客户端识别为会议邀请
+}
+
+func (w *icsWriter) line(name, value string) {
+	l := name + ":" + value
+	// 按 UTF-8 字符边界折行，续行以空格开头
+	for len(l) > 75 {
+		cut := 75
+		for cut > 0 && !utf8Start(l[cut]) {
+			cut--
+		}
+		w.b.WriteString(l[:cut] + "\r\n")
+		l = " " + l[cut:]
+	}
+	w.b.WriteString(l + "\r\n")
+}
+
+func (w *icsWriter) String() string {
+	return w.b.String()
+}
+
+func utf8Start(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// 转义 TEXT 类型的值
+func icsEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// 参数值加引号，可包含 , ; :，去掉不允许出现的引号和换行
+func icsParam(s string) string {
+	return `"` + strings.NewReplacer(`"`, "", "\r", "", "\n", " ").Replace(s) + `"`
+}
+
+func icsUTC(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+func icsLocal(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format("20060102T150405")
+}
+
+func icsOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+}
+
+// 计算时区在 [from, to) 内的偏移变化时刻
+func zoneTransitions(loc *time.Location, from, to time.Time) []time.Time {
+	var result []time.Time
+	_, offset := from.In(loc).Zone()
+	for t := from; t.Before(to); t = t.Add(24 * time.Hour) {
+		next := t.Add(24 * time.Hour)
+		_, o := next.In(loc).Zone()
+		if o == offset {
+			continue
+		}
+		lo, hi := t, next
+		for hi.Sub(lo) > time.Minute {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if _, mo := mid.In(loc).Zone(); mo == offset {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		result = append(result, hi.Truncate(time.Minute))
+		offset = o
+	}
+	return result
+}
+
+// 写入 VTIMEZONE，列出 [from, to) 内的所有偏移变化
+func (w *icsWriter) vtimezone(loc *time.Location, from, to time.Time) {
+	w.line("BEGIN", "VTIMEZONE")
+	w.line("TZID", loc.String())
+	name, offset := from.In(loc).Zone()
+	kind := "STANDARD"
+	if from.In(loc).IsDST() {
+		kind = "DAYLIGHT"
+	}
+	w.line("BEGIN", kind)
+	w.line("DTSTART", from.In(loc).Format("20060102T150405"))
+	w.line("TZOFFSETFROM", icsOffset(offset))
+	w.line("TZOFFSETTO", icsOffset(offset))
+	w.line("TZNAME", name)
+	w.line("END", kind)
+	for _, tr := range zoneTransitions(loc, from, to) {
+		newName, newOffset := tr.In(loc).Zone()
+		kind := "STANDARD"
+		if tr.In(loc).IsDST() {
+			kind = "DAYLIGHT"
+		}
+		w.line("BEGIN", kind)
+		// 变化时刻以变化前的当地时间表示
+		w.line("DTSTART", tr.Add(time.Duration(offset)*time.Second).UTC().Format("20060102T150405"))
+		w.line("TZOFFSETFROM", icsOffset(offset))
+		w.line("TZOFFSETTO", icsOffset(newOffset))
+		w.line("TZNAME", newName)
+		w.line("END", kind)
+		offset = newOffset
+	}
+	w.line("END", "VTIMEZONE")
+}
+
+// icsProperty 解析后的 iCalendar 属性
+type icsProperty struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// icsComponent 解析后的 iCalendar 组件，如 VCALENDAR、VEVENT
+type icsComponent struct {
+	Name       string
+	Props      []icsProperty
+	Components []*icsComponent
+}
+
+// 返回第一个指定名称的属性
+func (c *icsComponent) prop(name string) *icsProperty {
+	for i := range c.Props {
+		if c.Props[i].Name == name {
+			return &c.Props[i]
+		}
+	}
+	return nil
+}
+
+// 返回所有指定名称的子组件
+func (c *icsComponent) children(name string) []*icsComponent {
+	var result []*icsComponent
+	for _, child := range c.Components {
+		if child.Name == name {
+			result = append(result, child)
+		}
+	}
+	return result
+}
+
+// 解析 iCalendar 文本，返回最外层的 VCALENDAR
+func parseICS(data string) (*icsComponent, error) {
+	// 展开折行，兼容只使用 LF 的客户端
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\n ", "")
+	data = strings.ReplaceAll(data, "\n\t", "")
+
+	var root *icsComponent
+	var stack []*icsComponent
+	for _, raw := range strings.Split(data, "\n") {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		prop, err := parseICSLine(raw)
+		if err != nil {
+			return nil, err
+		}
+		switch prop.Name {
+		case "BEGIN":
+			comp := &icsComponent{Name: strings.ToUpper(prop.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, comp)
+			} else if root != nil {
+				return nil, fmt.Errorf("日历数据包含多个顶层组件")
+			} else {
+				root = comp
+			}
+			stack = append(stack, comp)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(prop.Value) {
+				return nil, fmt.Errorf("日历数据组件不匹配: %s", prop.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("属性不在组件内: %s", prop.Name)
+			}
+			comp := stack[len(stack)-1]
+			comp.Props = append(comp.Props, prop)
+		}
+	}
+	if root == nil || len(stack) > 0 || root.Name != "VCALENDAR" {
+		return nil, fmt.Errorf("日历数据不完整")
+	}
+	return root, nil
+}
+
+// 解析一行内容：NAME;PARAM=VALUE:VALUE，参数值可用双引号包裹
+func parseICSLine(line string) (icsProperty, error) {
+	prop := icsProperty{Params: make(map[string]string)}
+	inQuote := false
+	colon := -1
+	for i, ch := range line {
+		if ch == '"' {
+			inQuote = !inQuote
+		} else if ch == ':' && !inQuote {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return prop, fmt.Errorf("无法解析的日历数据行: %s", line)
+	}
+	prop.Value = line[colon+1:]
+	var parts []string
+	start := 0
+	inQuote = false
+	head := line[:colon]
+	for i, ch := range head {
+		if ch == '"' {
+			inQuote = !inQuote
+		} else if ch == ';' && !inQuote {
+			parts = append(parts, head[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, head[start:])
+	prop.Name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		prop.Params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+	return prop, nil
+}
+
+// 还原 TEXT 类型值中的转义字符
+func icsUnescape(s string) string {
+	r := strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	return r.Replace(s)
+}
+
+// 全天事件（VALUE=DATE）无法对应到会议室预订
+var errICSAllDay = fmt.Errorf("不支持全天事件")
+
+// 解析 DATE-TIME 值：以 Z 结尾为 UTC，带 TZID 时按该时区，否则按 loc 解释为浮动时间。
+// TZID 不是 IANA 时区名称（如 Windows 时区名）时同样按 loc 解释
+func parseICSTimes(p icsProperty, loc *time.Location) ([]time.Time, error) {
+	if p.Params["VALUE"] == "DATE" {
+		return nil, errICSAllDay
+	}
+	if tzid := p.Params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+			loc = l
+		}
+	}
+	var result []time.Time
+	for _, v := range strings.Split(p.Value, ",") {
+		v = strings.TrimSpace(v)
+		if len(v) == 8 {
+			return nil, errICSAllDay
+		}
+		var t time.Time
+		var err error
+		if strings.HasSuffix(v, "Z") {
+			t, err = time.Parse("20060102T150405Z", v)
+		} else {
+			t, err = time.ParseInLocation("20060102T150405", v, loc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("时间格式错误: %s", v)
+		}
+		result = append(result, t.UTC())
+	}
+	return result, nil
+}
+
+// 解析单个 DATE-TIME 值
+func parseICSTime(p icsProperty, loc *time.Location) (time.Time, error) {
+	times, err := parseICSTimes(p, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(times) != 1 {
+		return time.Time{}, fmt.Errorf("时间格式错误: %s", p.Value)
+	}
+	return times[0], nil
+}
+
+// 解析 DURATION 值，如 PT1H30M、P1D、P1W
+func parseICSDuration(v string) (time.Duration, error) {
+	orig := v
+	sign := time.Duration(1)
+	if strings.HasPrefix(v, "-") {
+		sign = -1
+		v = v[1:]
+	}
+	v = strings.TrimPrefix(v, "+")
+	if !strings.HasPrefix(v, "P") {
+		return 0, fmt.Errorf("时长格式错误: %s", orig)
+	}
+	v = v[1:]
+	var d time.Duration
+	inTime := false
+	num := ""
+	for _, ch := range v {
+		switch {
+		case ch >= '0' && ch <= '9':
+			num += string(ch)
+		case ch == 'T':
+			inTime = true
+		default:
+			n, err := strconv.Atoi(num)
+			if err != nil {
+				return 0, fmt.Errorf("时长格式错误: %s", orig)
+			}
+			num = ""
+			switch {
+			case ch == 'W' && !inTime:
+				d += time.Duration(n) * 7 * 24 * time.Hour
+			case ch == 'D' && !inTime:
+				d += time.Duration(n) * 24 * time.Hour
+			case ch == 'H' && inTime:
+				d += time.Duration(n) * time.Hour
+			case ch == 'M' && inTime:
+				d += time.Duration(n) * time.Minute
+			case ch == 'S' && inTime:
+				d += time.Duration(n) * time.Second
+			default:
+				return 0, fmt.Errorf("时长格式错误: %s", orig)
+			}
+		}
+	}
+	if num != "" {
+		return 0, fmt.Errorf("时长格式错误: %s", orig)
+	}
+	return sign * d, nil
+}
+
+// 日历生成所需的关联数据
+type icsContext struct {
+	rooms       map[uint]Room
+	users       map[uint]User
+	series      map[uint]BookingSeries
+	bookingUIDs map[uint]string         // 日历客户端创建的预订使用客户端提供的 UID
+	seriesUIDs  map[uint]string         // 同上，周期预订
+	recurrences map[uint]map[int64]bool // 周期中存在预订记录的原始开始时间
+}
+
+// 加载预订关联的会议室、用户和周期
+func loadICSContext(tx *gorm.DB, bookings []Booking) icsContext {
+	ctx := icsContext{
+		rooms:       make(map[uint]Room),
+		users:       make(map[uint]User),
+		series:      make(map[uint]BookingSeries),
+		bookingUIDs: make(map[uint]string),
+		seriesUIDs:  make(map[uint]string),
+		recurrences: make(map[uint]map[int64]bool),
+	}
+	var roomIDs, userIDs, bookingIDs, seriesIDs []uint
+	for _, b := range bookings {
+		roomIDs = append(roomIDs, b.RoomID)
+		userIDs = append(userIDs, b.UserID)
+		bookingIDs = append(bookingIDs, b.ID)
+		if b.SeriesID != nil {
+			seriesIDs = append(seriesIDs, *b.SeriesID)
+		}
+	}
+	var rooms []Room
+	var users []User
+	var series []BookingSeries
+	var objects []CalendarObject
+	if len(roomIDs) > 0 {
+		tx.Where("id IN ?", roomIDs).Find(&rooms)
+		tx.Where("id IN ?", userIDs).Find(&users)
+		tx.Where("booking_id IN ?", bookingIDs).Find(&objects)
+	}
+	if len(seriesIDs) > 0 {
+		tx.Where("id IN ?", seriesIDs).Find(&series)
+		var seriesObjects []CalendarObject
+		tx.Where("series_id IN ?", seriesIDs).Find(&seriesObjects)
+		objects = append(objects, seriesObjects...)
+		// 周期中所有预订记录的原始开始时间，不限于本次输出的预订
+		var occurrences []Booking
+		tx.Select("series_id", "recurrence_id").Where("series_id IN ?", seriesIDs).Find(&occurrences)
+		for _, o := range occurrences {
+			if o.RecurrenceID == nil {
+				continue
+			}
+			if ctx.recurrences[*o.SeriesID] == nil {
+				ctx.recurrences[*o.SeriesID] = make(map[int64]bool)
+			}
+			ctx.recurrences[*o.SeriesID][o.RecurrenceID.Unix()] = true
+		}
+	}
+	for _, o := range objects {
+		if o.BookingID != nil {
+			ctx.bookingUIDs[*o.BookingID] = o.UID
+		}
+		if o.SeriesID != nil {
+			ctx.seriesUIDs[*o.SeriesID] = o.UID
+		}
+	}
+	for _, r := range rooms {
+		ctx.rooms[r.ID] = r
+	}
+	for _, u := range users {
+		ctx.users[u.ID] = u
+	}
+	for _, s := range series {
+		ctx.series[s.ID] = s
+	}
+	return ctx
+}
+
+// 会议室位置描述
+func roomLocationText(room Room) string {
+	parts := []string{}
+	for _, p := range []string{room.Site, room.Building, room.Floor, room.Name} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// 写入一个 VEVENT 的公共属性
+func (w *icsWriter) eventBody(b Booking, ctx icsContext, now time.Time) {
+	room := ctx.rooms[b.RoomID]
+	summary := b.Reason
+	if summary == "" {
+		summary = room.Name
+	}
+	w.line("DTSTAMP", icsUTC(now))
+	if !b.CreatedAt.IsZero() {
+		w.line("CREATED", icsUTC(b.CreatedAt))
+	}
+	if !b.UpdatedAt.IsZero() {
+		w.line("LAST-MODIFIED", icsUTC(b.UpdatedAt))
+	}
+	w.line("SEQUENCE", strconv.Itoa(b.Sequence))
+	w.line("SUMMARY", icsEscape(summary))
+	w.line("LOCATION", icsEscape(roomLocationText(room)))
+	if user, ok := ctx.users[b.UserID]; ok && b.UserID != 0 {
+		organizer := user.Nickname
+		if organizer == "" {
+			organizer = user.Username
+		}
+		w.line("DESCRIPTION", icsEscape("预订人: "+organizer))
+		if (w.method == icsMethodRequest || w.method == icsMethodCancel) && user.Email != "" {
+			w.line("ORGANIZER;CN="+icsParam(organizer), "mailto:"+user.Email)
+		}
+	}
+	if b.Visibility == VisibilityPrivate {
+		w.line("CLASS", "PRIVATE")
+	}
+	if b.Status == BookingStatusCancelled {
+		w.line("STATUS", "CANCELLED")
+	} else {
+		w.line("STATUS", "CONFIRMED")
+	}
+	w.line("TRANSP", "OPAQUE")
+}
+
+// 单次预订的 VEVENT
+func (w *icsWriter) singleEvent(b Booking, ctx icsContext, now time.Time) {
+	w.line("BEGIN", "VEVENT")
+	w.line("UID", ctx.bookingUID(b.ID))
+	w.line("DTSTART", icsUTC(b.StartTime))
+	w.line("DTEND", icsUTC(b.EndTime))
+	w.eventBody(b, ctx, now)
+	w.line("END", "VEVENT")
+}
+
+// 周期预订：主事件携带 RRULE，已取消或被修改的单次会议以 RECURRENCE-ID 覆盖
+func (w *icsWriter) seriesEvents(series BookingSeries, occurrences []Booking, ctx icsContext, now time.Time) {
+	loc := loadLocation(series.TimeZone)
+	uid := ctx.seriesUID(series.ID)
+
+	master := occurrences[0]
+	for _, b := range occurrences {
+		if b.Sequence > master.Sequence {
+			master.Sequence = b.Sequence
+		}
+	}
+	master.Status = BookingStatusActive
+	master.CreatedAt, master.UpdatedAt = time.Time{}, time.Time{}
+
+	freq := "DAILY"
+	if series.Freq == RecurrenceWeekly {
+		freq = "WEEKLY"
+	}
+	w.line("BEGIN", "VEVENT")
+	w.line("UID", uid)
+	w.line("DTSTART;TZID="+loc.String(), icsLocal(series.StartTime, loc))
+	w.line("DTEND;TZID="+loc.String(), icsLocal(series.EndTime, loc))
+	w.line("RRULE", fmt.Sprintf("FREQ=%s;INTERVAL=%d;COUNT=%d", freq, series.Interval, series.Count))
+	// 没有预订记录的日期（创建时被排除）以 EXDATE 表示
+	slots, _ := series.occurrences()
+	expected := make(map[int64]bool, len(slots))
+	for _, slot := range slots {
+		expected[slot.Start.Unix()] = true
+		if !ctx.recurrences[series.ID][slot.Start.Unix()] {
+			w.line("EXDATE;TZID="+loc.String(), icsLocal(slot.Start, loc))
+		}
+	}
+	w.eventBody(master, ctx, now)
+	w.line("END", "VEVENT")
+
+	duration := series.EndTime.Sub(series.StartTime)
+	for _, b := range occurrences {
+		if b.RecurrenceID == nil || !expected[b.RecurrenceID.Unix()] {
+			continue
+		}
+		modified := !b.StartTime.Equal(*b.RecurrenceID) || b.EndTime.Sub(b.StartTime) != duration
+		if b.Status != BookingStatusCancelled && !modified {
+			continue
+		}
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", uid)
+		w.line("RECURRENCE-ID;TZID="+loc.String(), icsLocal(*b.RecurrenceID, loc))
+		w.line("DTSTART;TZID="+loc.String(), icsLocal(b.StartTime, loc))
+		w.line("DTEND;TZID="+loc.String(), icsLocal(b.EndTime, loc))
+		w.eventBody(b, ctx, now)
+		w.line("END", "VEVENT")
+	}
+}
+
+// 预订的 UID，日历客户端创建的使用客户端提供的 UID
+func (ctx icsContext) bookingUID(id uint) string {
+	if uid, ok := ctx.bookingUIDs[id]; ok {
+		return uid
+	}
+	return fmt.Sprintf("booking-%d@%s", id, icsUIDDomain)
+}
+
+func (ctx icsContext) seriesUID(id uint) string {
+	if uid, ok := ctx.seriesUIDs[id]; ok {
+		return uid
+	}
+	return fmt.Sprintf("series-%d@%s", id, icsUIDDomain)
+}
+
+// 生成 VCALENDAR，method 为 icsMethodPublish 等
+func buildCalendar(tx *gorm.DB, name, method string, bookings []Booking) string {
+	w := &icsWriter{method: method}
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", icsProdID)
+	w.line("CALSCALE", "GREGORIAN")
+	w.line("METHOD", method)
+	w.line("X-WR-CALNAME", icsEscape(name))
+	w.events(tx, bookings)
+	w.line("END", "VCALENDAR")
+	return w.String()
+}
+
+// 写入预订对应的 VTIMEZONE 和 VEVENT，周期预订合并为一个带 RRULE 的事件
+func (w *icsWriter) events(tx *gorm.DB, bookings []Booking) {
+	ctx := loadICSContext(tx, bookings)
+	now := time.Now()
+
+	// 按周期分组，周期信息缺失时按单次预订处理
+	var singles []Booking
+	grouped := make(map[uint][]Booking)
+	for _, b := range bookings {
+		if b.SeriesID != nil {
+			if _, ok := ctx.series[*b.SeriesID]; ok {
+				grouped[*b.SeriesID] = append(grouped[*b.SeriesID], b)
+				continue
+			}
+		}
+		singles = append(singles, b)
+	}
+	seriesIDs := make([]uint, 0, len(grouped))
+	for id := range grouped {
+		seriesIDs = append(seriesIDs, id)
+	}
+	sort.Slice(seriesIDs, func(i, j int) bool { return seriesIDs[i] < seriesIDs[j] })
+
+	// 周期预订使用会议室时区，需要附带 VTIMEZONE
+	zones := make(map[string][2]time.Time)
+	for _, id := range seriesIDs {
+		s := ctx.series[id]
+		loc := loadLocation(s.TimeZone)
+		last := grouped[id][len(grouped[id])-1].EndTime
+		from, to := s.StartTime.AddDate(-1, 0, 0), last.AddDate(1, 0, 0)
+		if r, ok := zones[loc.String()]; ok {
+			if r[0].Before(from) {
+				from = r[0]
+			}
+			if r[1].After(to) {
+				to = r[1]
+			}
+		}
+		zones[loc.String()] = [2]time.Time{from, to}
+	}
+	zoneNames := make([]string, 0, len(zones))
+	for z := range zones {
+		zoneNames = append(zoneNames, z)
+	}
+	sort.Strings(zoneNames)
+	for _, z := range zoneNames {
+		w.vtimezone(loadLocation(z), zones[z][0], zones[z][1])
+	}
+
+	for _, id := range seriesIDs {
+		w.seriesEvents(ctx.series[id], grouped[id], ctx, now)
+	}
+	for _, b := range singles {
+		w.singleEvent(b, ctx, now)
+	}
+}
+
+// 返回 text/calendar 响应
+func writeCalendar(c *gin.Context, filename, content string) {
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, filename))
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(content))
+}
+
+// 获取或创建用户的日历订阅令牌
+func ensureCalendarToken(tx *gorm.DB, userID uint) (CalendarToken, error) {
+	var token CalendarToken
+	err := tx.Where("user_id = ?", userID).First(&token).Error
+	if err == gorm.ErrRecordNotFound {
+		value, genErr := randomHex(20)
+		if genErr != nil {
+			return token, genErr
+		}
+		token = CalendarToken{UserID: userID, Token: value}
+		err = tx.Create(&token).Error
+	}
+	return token, err
+}
+
+// 根据订阅令牌获取用户
+func calendarTokenUser(c *gin.Context) (User, bool) {
+	var user User
+	value := c.Query("token")
+	if value == "" {
+		c.String(http.StatusUnauthorized, "missing token")
+		return user, false
+	}
+	var token CalendarToken
+	if err := db.Where("token = ?", value).First(&token).Error; err != nil {
+		c.String(http.StatusUnauthorized, "invalid token")
+		return user, false
+	}
+	if err := db.First(&user, token.UserID).Error; err != nil {
+		c.String(http.StatusUnauthorized, "invalid token")
+		return user, false
+	}
+	return user, true
+}
+
+func calendarFeedURLs(token string) gin.H {
+	return gin.H{
+		"token":       token,
+		"user_feed":   "/api/calendar/my.ics?token=" + token,
+		"room_feed":   "/api/calendar/rooms/{room_id}.ics?token=" + token,
+		"webcal_hint": "将地址中的 http(s):// 替换为 webcal:// 可直接在日历客户端中订阅",
+	}
+}
+
+// @Summary 获取日历订阅地址
+// @Description 返回当前用户的日历订阅令牌及个人、会议室订阅地址，不存在时自动创建
+// @Tags 日历
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/user/calendar [get]
+func calendarSubscriptionHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	token, err := ensureCalendarToken(db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成订阅令牌失败"})
+		return
+	}
+	c.JSON(http.StatusOK, calendarFeedURLs(token.Token))
+}
+
+// @Summary 重置日历订阅令牌
+// @Description 重新生成订阅令牌，原订阅地址立即失效
+// @Tags 日历
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/user/calendar/reset [post]
+func resetCalendarTokenHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var token CalendarToken
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&CalendarToken{}).Error; err != nil {
+			return err
+		}
+		var err error
+		if token, err = ensureCalendarToken(tx, userID); err != nil {
+			return err
+		}
+		recordAudit(tx, c, "calendar_token.reset", "user", userID, nil, nil)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成订阅令牌失败"})
+		return
+	}
+	c.JSON(http.StatusOK, calendarFeedURLs(token.Token))
+}
+
+// @Summary 个人日历订阅
+// @Description 当前用户的预订（含已取消）的 iCalendar 订阅，通过 token 参数鉴权
+// @Tags 日历
+// @Produce text/calendar
+// @Param token query string true "订阅令牌"
+// @Success 200 {string} string
+// @Router /api/calendar/my.ics [get]
+func userCalendarFeedHandler(c *gin.Context) {
+	user, ok := calendarTokenUser(c)
+	if !ok {
+		return
+	}
+	var bookings []Booking
+	db.Where("user_id = ? AND end_time > ?", user.ID, time.Now().UTC().Add(-calendarFeedHistory)).
+		Order("start_time").Find(&bookings)
+	name := user.Nickname
+	if name == "" {
+		name = user.Username
+	}
+	writeCalendar(c, "my-bookings.ics", buildCalendar(db, name+" 的会议室预订", icsMethodPublish, bookings))
+}
+
+// @Summary 会议室日历订阅
+// @Description 指定会议室预订（含已取消）的 iCalendar 订阅，通过 token 参数鉴权，私密预订按订阅用户脱敏
+// @Tags 日历
+// @Produce text/calendar
+// @Param id path string true "会议室ID，可带 .ics 后缀"
+// @Param token query string true "订阅令牌"
+// @Success 200 {string} string
+// @Router /api/calendar/rooms/{id}.ics [get]
+func roomCalendarFeedHandler(c *gin.Context) {
+	user, ok := calendarTokenUser(c)
+	if !ok {
+		return
+	}
+	var room Room
+	if err := db.First(&room, strings.TrimSuffix(c.Param("id"), ".ics")).Error; err != nil {
+		c.String(http.StatusNotFound, "room not found")
+		return
+	}
+	var bookings []Booking
+	db.Where("room_id = ? AND end_time > ?", room.ID, time.Now().UTC().Add(-calendarFeedHistory)).
+		Order("start_time").Find(&bookings)
+	redactBookings(db, user.ID, user.Role, bookings)
+	writeCalendar(c, fmt.Sprintf("room-%d.ics", room.ID), buildCalendar(db, room.Name, icsMethodPublish, bookings))
+}
+
+// @Summary 下载单个预订的 .ics 文件
+// @Description 下载指定预订的 iCalendar 文件，可导入日历客户端
+// @Tags 日历
+// @Produce text/calendar
+// @Param id path int true "预订ID"
+// @Success 200 {string} string
+// @Security Bearer
+// @Router /api/bookings/{id}/ics [get]
+func bookingICSHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var booking Booking
+	if err := db.First(&booking, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "预订不存在"})
+		return
+	}
+	role, _ := c.Get("role")
+	bookings := []Booking{booking}
+	redactBookings(db, userID, role, bookings)
+	// 单独下载时作为独立事件，不附带周期规则
+	bookings[0].SeriesID = nil
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="booking-%d.ics"`, booking.ID))
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(buildCalendar(db, "会议室预订", icsMethodPublish, bookings)))
+}
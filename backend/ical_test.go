@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 按 75 字节折行且不拆开 UTF-8 字符，解析时还原
+func TestICSLineFolding(t *testing.T) {
+	summary := strings.Repeat("季度经营分析会", 8) + "; 议程: 预算,人员\n备注\\"
+	w := &icsWriter{}
+	w.line("BEGIN", "VCALENDAR")
+	w.line("SUMMARY", icsEscape(summary))
+	w.line("END", "VCALENDAR")
+	out := w.String()
+	if !strings.HasSuffix(out, "\r\n") {
+		t.Fatal("行应以 CRLF 结尾")
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+	if len(lines) < 4 {
+		t.Fatalf("未折行：%q", out)
+	}
+	for i, l := range lines {
+		if len(l) > 75 || !utf8.ValidString(l) {
+			t.Errorf("第 %d 行 %d 字节：%q", i+1, len(l), l)
+		}
+		if i > 1 && i < len(lines)-1 && !strings.HasPrefix(l, " ") {
+			t.Errorf("续行应以空格开头：%q", l)
+		}
+	}
+	cal, err := parseICS(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := icsUnescape(cal.prop("SUMMARY").Value); got != summary {
+		t.Errorf("解析得到 %q，应为 %q", got, summary)
+	}
+}
+
+// 周期预订输出为带 RRULE 的主事件，被排除的日期为 EXDATE，取消的单次会议以 RECURRENCE-ID 覆盖
+func TestBuildCalendar(t *testing.T) {
+	tx := setupTestDB(t)
+	user := User{Username: "alice", Nickname: "Alice", Email: "alice@example.com", Role: "user"}
+	room := Room{Name: "A101", Building: "总部", TimeZone: "Europe/Berlin"}
+	mustCreate(t, &user, &room)
+
+	// 每周一 9:00（当地时间），2026-10-25 结束夏令时，第 3 次被排除
+	first := time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC)
+	slots, err := expandRecurrence(RecurrenceRule{Freq: RecurrenceWeekly, Count: 4}, first, first.Add(time.Hour), room.location())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := []timeSlot{slots[0], slots[1], slots[3]}
+	series, _, err := createBookings(tx, NewBooking{Room: room, UserID: user.ID, Slots: kept, Reason: "周会",
+		Recurrence: &RecurrenceRule{Freq: RecurrenceWeekly, Count: 4}, SeriesCount: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cancelBooking(tx, &series[1], user.ID, "", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	single := Booking{RoomID: room.ID, UserID: user.ID, StartTime: first.Add(3 * time.Hour), EndTime: first.Add(4 * time.Hour),
+		Reason: "面试, 二面", Visibility: VisibilityPrivate, Status: BookingStatusActive}
+	mustCreate(t, &single)
+	var bookings []Booking
+	tx.Order("start_time").Find(&bookings)
+
+	cal, err := parseICS(buildCalendar(tx, "Alice 的会议室预订", icsMethodPublish, bookings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cal.prop("METHOD").Value != icsMethodPublish {
+		t.Errorf("METHOD = %s", cal.prop("METHOD").Value)
+	}
+	zones := cal.children("VTIMEZONE")
+	if len(zones) != 1 || zones[0].prop("TZID").Value != "Europe/Berlin" || len(zones[0].children("STANDARD")) == 0 || len(zones[0].children("DAYLIGHT")) == 0 {
+		t.Fatalf("VTIMEZONE %+v", zones)
+	}
+
+	var master, override, singleEvent *icsComponent
+	for _, ev := range cal.children("VEVENT") {
+		switch {
+		case ev.prop("RRULE") != nil:
+			master = ev
+		case ev.prop("RECURRENCE-ID") != nil:
+			override = ev
+		default:
+			singleEvent = ev
+		}
+	}
+	if master == nil || override == nil || singleEvent == nil || len(cal.children("VEVENT")) != 3 {
+		t.Fatalf("VEVENT %+v", cal.children("VEVENT"))
+	}
+	props := func(ev *icsComponent, names ...string) string {
+		var values []string
+		for _, name := range names {
+			p := ev.prop(name)
+			if p == nil {
+				values = append(values, "")
+				continue
+			}
+			values = append(values, p.Params["TZID"]+"|"+p.Value)
+		}
+		return strings.Join(values, " ")
+	}
+	if got := props(master, "DTSTART", "RRULE", "EXDATE", "SUMMARY", "LOCATION", "STATUS"); got !=
+		"Europe/Berlin|20261019T090000 |FREQ=WEEKLY;INTERVAL=1;COUNT=4 Europe/Berlin|20261102T090000 |周会 |总部 A101 |CONFIRMED" {
+		t.Errorf("主事件 %s", got)
+	}
+	if override.prop("UID").Value != master.prop("UID").Value {
+		t.Errorf("覆盖事件的 UID %s 与主事件 %s 不同", override.prop("UID").Value, master.prop("UID").Value)
+	}
+	// 夏令时结束后仍为当地 9:00
+	if got := props(override, "RECURRENCE-ID", "DTSTART", "STATUS"); got != "Europe/Berlin|20261026T090000 Europe/Berlin|20261026T090000 |CANCELLED" {
+		t.Errorf("取消的单次会议 %s", got)
+	}
+	if got := props(singleEvent, "DTSTART", "DTEND", "SUMMARY", "CLASS"); got != `|20261019T100000Z |20261019T110000Z |面试\, 二面 |PRIVATE` {
+		t.Errorf("单次预订 %s", got)
+	}
+	// 发布的日历不带 ORGANIZER，邮件邀请才带
+	if singleEvent.prop("ORGANIZER") != nil {
+		t.Error("PUBLISH 的事件不应带 ORGANIZER")
+	}
+	invite, err := parseICS(buildCalendar(tx, "会议室预订", icsMethodRequest, []Booking{single}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := invite.children("VEVENT")[0].prop("ORGANIZER"); p == nil || p.Value != "mailto:alice@example.com" || p.Params["CN"] != "Alice" {
+		t.Errorf("ORGANIZER %+v", p)
+	}
+}
+
+// 订阅地址通过令牌鉴权，重置后原令牌失效
+func TestUserCalendarFeed(t *testing.T) {
+	tx := setupTestDB(t)
+	user, room := createBookingFixtures(t, tx)
+	start := time.Now().UTC().Truncate(time.Hour).Add(24 * time.Hour)
+	booking := Booking{RoomID: room.ID, UserID: user.ID, StartTime: start, EndTime: start.Add(time.Hour), Reason: "周会", Status: BookingStatusActive}
+	mustCreate(t, &booking)
+
+	feed := func(token string) (int, string) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/calendar/my.ics?token="+token, nil)
+		userCalendarFeedHandler(c)
+		if w.Code == http.StatusOK && !strings.HasPrefix(w.Header().Get("Content-Type"), "text/calendar") {
+			t.Errorf("Content-Type = %s", w.Header().Get("Content-Type"))
+		}
+		return w.Code, w.Body.String()
+	}
+	if code, _ := feed(""); code != http.StatusUnauthorized {
+		t.Errorf("缺少令牌返回 %d", code)
+	}
+	if code, _ := feed("invalid"); code != http.StatusUnauthorized {
+		t.Errorf("无效令牌返回 %d", code)
+	}
+
+	code, resp := callHandler(t, calendarSubscriptionHandler, user, http.MethodGet, "/api/user/calendar", nil, nil)
+	token, _ := resp["token"].(string)
+	if code != http.StatusOK || token == "" {
+		t.Fatalf("获取订阅令牌返回 %d：%v", code, resp)
+	}
+	code, body := feed(token)
+	if code != http.StatusOK || !strings.Contains(body, "UID:booking-") || !strings.Contains(body, "SUMMARY:周会") {
+		t.Errorf("订阅返回 %d：%s", code, body)
+	}
+
+	code, resp = callHandler(t, resetCalendarTokenHandler, user, http.MethodPost, "/api/user/calendar/reset", nil, nil)
+	if code != http.StatusOK || resp["token"] == token {
+		t.Fatalf("重置返回 %d：%v", code, resp)
+	}
+	if code, _ := feed(token); code != http.StatusUnauthorized {
+		t.Errorf("重置后原令牌返回 %d", code)
+	}
+	if code, _ := feed(resp["token"].(string)); code != http.StatusOK {
+		t.Errorf("新令牌返回 %d", code)
+	}
+}
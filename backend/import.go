@@ -0,0 +1,492 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 导入文件大小及记录数上限
+const (
+	maxImportFileSize = 5 << 20
+	maxImportRows     = 5000
+)
+
+// 导入结果
+const (
+	ImportCreated       = "created"
+	ImportConflict      = "conflict"
+	ImportUnknownRoom   = "unknown_room"
+	ImportUnknownUser   = "unknown_user"
+	ImportInvalidTime   = "invalid_time"
+	ImportQuotaExceeded = "quota_exceeded"
+	ImportDuplicate     = "duplicate"
+	ImportInvalid       = "invalid"
+)
+
+// 试运行时用于回滚事务
+var errImportDryRun = errors.New("import dry run")
+
+// CSV 表头别名，不区分大小写
+var importColumns = map[string][]string{
+	"room":       {"room", "room_name", "会议室"},
+	"user":       {"user", "username", "email", "organizer", "预订人"},
+	"start_time": {"start_time", "start", "开始时间"},
+	"end_time":   {"end_time", "end", "结束时间"},
+	"reason":     {"reason", "subject", "summary", "事由"},
+	"visibility": {"visibility", "可见性"},
+}
+
+// ImportRow 导入报告中的一条记录
+type ImportRow struct {
+	Row        int        `json:"row"` // CSV 行号（含表头）或 ICS 中的事件序号
+	Room       string     `json:"room"`
+	User       string     `json:"user"`
+	StartTime  *time.Time `json:"start_time,omitempty"`
+	EndTime    *time.Time `json:"end_time,omitempty"`
+	Reason     string     `json:"reason"`
+	Status     string     `json:"status"`
+	Message    string     `json:"message,omitempty"`
+	BookingIDs []uint     `json:"booking_ids,omitempty"` // 试运行时为空
+}
+
+// 导入时的会议室和用户查找表
+type importLookup struct {
+	rooms       map[string]Room
+	roomsByText map[string]Room
+	users       map[string]User
+}
+
+func loadImportLookup(tx *gorm.DB) importLookup {
+	l := importLookup{
+		rooms:       make(map[string]Room),
+		roomsByText: make(map[string]Room),
+		users:       make(map[string]User),
+	}
+	var rooms []Room
+	tx.Find(&rooms)
+	for _, r := range rooms {
+		l.rooms[strings.ToLower(r.Name)] = r
+		l.roomsByText[strings.ToLower(roomLocationText(r))] = r
+	}
+	var users []User
+	tx.Find(&users)
+	for _, u := range users {
+		if u.Email != "" {
+			l.users[strings.ToLower(u.Email)] = u
+		}
+	}
+	// 用户名优先于邮箱
+	for _, u := range users {
+		l.users[strings.ToLower(u.Username)] = u
+	}
+	return l
+}
+
+// 按名称查找会议室，也接受 iCalendar 中“园区 楼宇 楼层 名称”形式的位置
+func (l importLookup) room(name string) (Room, bool) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if r, ok := l.rooms[key]; ok {
+		return r, true
+	}
+	r, ok := l.roomsByText[key]
+	return r, ok
+}
+
+// 按用户名或邮箱查找用户
+func (l importLookup) user(name string) (User, bool) {
+	key := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(name, "mailto:"), "MAILTO:")))
+	u, ok := l.users[key]
+	return u, ok
+}
+
+// 将校验错误转换为导入结果
+func importStatus(err error) string {
+	var be *bookingError
+	if errors.As(err, &be) {
+		switch be.Status {
+		case http.StatusConflict:
+			return ImportConflict
+		case http.StatusForbidden:
+			return ImportQuotaExceeded
+		default:
+			return ImportInvalidTime
+		}
+	}
+	var de *davError
+	if errors.As(err, &de) && de.Status == http.StatusBadRequest {
+		return ImportInvalidTime
+	}
+	return ImportInvalid
+}
+
+// importSource 待导入的一条记录，create 在事务中执行校验并创建预订
+type importSource struct {
+	row    ImportRow
+	create func(tx *gorm.DB) ([]Booking, error)
+}
+
+// 解析 CSV，首行为表头
+func parseImportCSV(data []byte, lookup importLookup, defaultUser string) ([]importSource, error) {
+	// 兼容 Excel 导出的 UTF-8 BOM
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("CSV 格式错误: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("CSV 文件为空")
+	}
+	index := make(map[string]int)
+	for i, h := range records[0] {
+		h = strings.ToLower(strings.TrimSpace(h))
+		for col, aliases := range importColumns {
+			for _, alias := range aliases {
+				if h == alias {
+					index[col] = i
+				}
+			}
+		}
+	}
+	for _, col := range []string{"room", "start_time", "end_time"} {
+		if _, ok := index[col]; !ok {
+			return nil, fmt.Errorf("CSV 缺少 %s 列", col)
+		}
+	}
+	if len(records)-1 > maxImportRows {
+		return nil, fmt.Errorf("单次最多导入 %d 条记录", maxImportRows)
+	}
+
+	sources := make([]importSource, 0, len(records)-1)
+	for i, record := range records[1:] {
+		field := func(col string) string {
+			if idx, ok := index[col]; ok && idx < len(record) {
+				return strings.TrimSpace(record[idx])
+			}
+			return ""
+		}
+		row := ImportRow{Row: i + 2, Room: field("room"), User: field("user"), Reason: field("reason")}
+		if row.User == "" {
+			row.User = defaultUser
+		}
+		src := importSource{row: row}
+		room, ok := lookup.room(row.Room)
+		if !ok {
+			src.row.Status, src.row.Message = ImportUnknownRoom, "会议室不存在"
+			sources = append(sources, src)
+			continue
+		}
+		user, ok := lookup.user(row.User)
+		if !ok {
+			src.row.Status, src.row.Message = ImportUnknownUser, "用户不存在"
+			sources = append(sources, src)
+			continue
+		}
+		// 不带时区的时间按会议室时区解释
+		start, ok1 := parseTimeParam(field("start_time"), room.location())
+		end, ok2 := parseTimeParam(field("end_time"), room.location())
+		if !ok1 || !ok2 || !end.After(start) {
+			src.row.Status, src.row.Message = ImportInvalidTime, "时间范围不合法"
+			sources = append(sources, src)
+			continue
+		}
+		src.row.StartTime, src.row.EndTime = &start, &end
+		visibility, err := resolveVisibility(db, strings.ToLower(field("visibility")))
+		if err != nil {
+			src.row.Status, src.row.Message = ImportInvalid, "可见性参数无效"
+			sources = append(sources, src)
+			continue
+		}
+		reason := row.Reason
+		src.create = func(tx *gorm.DB) ([]Booking, error) {
+			slots := []timeSlot{{Start: start, End: end}}
+			if err := checkBookingSlots(tx, room, user.ID, 0, slots); err != nil {
+				return nil, err
+			}
+			bookings, _, err := createBookings(tx, NewBooking{
+				Room:       room,
+				UserID:     user.ID,
+				Slots:      slots,
+				Reason:     reason,
+				Visibility: visibility,
+			})
+			return bookings, err
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+// 导入事件在日历对象中使用的资源名
+func importObjectName(uid string) string {
+	sum := sha1.Sum([]byte(uid))
+	return "import-" + hex.EncodeToString(sum[:8]) + ".ics"
+}
+
+// 解析 iCalendar，同一 UID 的主事件和单独修改的日期合并为一条记录；
+// 会议室取自 LOCATION，用户取自 ORGANIZER（邮箱或 CN）
+func parseImportICS(data []byte, lookup importLookup, defaultUser string) ([]importSource, error) {
+	cal, err := parseICS(string(data))
+	if err != nil {
+		return nil, err
+	}
+	comps := cal.children("VEVENT")
+	if len(comps) > maxImportRows {
+		return nil, fmt.Errorf("单次最多导入 %d 条记录", maxImportRows)
+	}
+	// 没有 UID 的事件按文件内容和序号生成，重复导入同一文件时仍能识别
+	fileSum := sha1.Sum(data)
+	var order []string
+	groups := make(map[string][]*icsComponent)
+	for i, comp := range comps {
+		uid := fmt.Sprintf("import-%s-%d", hex.EncodeToString(fileSum[:6]), i+1)
+		if p := comp.prop("UID"); p != nil && strings.TrimSpace(p.Value) != "" {
+			uid = strings.TrimSpace(p.Value)
+		} else {
+			comp.Props = append(comp.Props, icsProperty{Name: "UID", Params: map[string]string{}, Value: uid})
+		}
+		if _, ok := groups[uid]; !ok {
+			order = append(order, uid)
+		}
+		groups[uid] = append(groups[uid], comp)
+	}
+
+	sources := make([]importSource, 0, len(order))
+	for i, uid := range order {
+		group := groups[uid]
+		// 以不带 RECURRENCE-ID 的主事件为准
+		head := group[0]
+		for _, comp := range group {
+			if comp.prop("RECURRENCE-ID") == nil {
+				head = comp
+				break
+			}
+		}
+		row := ImportRow{Row: i + 1, User: defaultUser}
+		if p := head.prop("LOCATION"); p != nil {
+			row.Room = icsUnescape(p.Value)
+		}
+		if p := head.prop("ORGANIZER"); p != nil {
+			row.User = strings.TrimPrefix(strings.TrimPrefix(p.Value, "mailto:"), "MAILTO:")
+			if _, ok := lookup.user(row.User); !ok && p.Params["CN"] != "" {
+				row.User = p.Params["CN"]
+			}
+		}
+		if p := head.prop("SUMMARY"); p != nil {
+			row.Reason = icsUnescape(p.Value)
+		}
+		src := importSource{row: row}
+		room, ok := lookup.room(row.Room)
+		if !ok {
+			src.row.Status, src.row.Message = ImportUnknownRoom, "会议室不存在"
+			sources = append(sources, src)
+			continue
+		}
+		user, ok := lookup.user(row.User)
+		if !ok {
+			src.row.Status, src.row.Message = ImportUnknownUser, "用户不存在"
+			sources = append(sources, src)
+			continue
+		}
+		events := make([]davEvent, 0, len(group))
+		masters := 0
+		for _, comp := range group {
+			ev, err := parseDAVEvent(comp, room.location())
+			if err != nil {
+				src.row.Status, src.row.Message = importStatus(err), err.Error()
+				break
+			}
+			if ev.RecurrenceID == nil {
+				masters++
+				events = append([]davEvent{ev}, events...)
+			} else {
+				events = append(events, ev)
+			}
+		}
+		if src.row.Status == "" && masters != 1 {
+			src.row.Status, src.row.Message = ImportInvalid, "事件缺少主事件"
+		}
+		if src.row.Status != "" {
+			sources = append(sources, src)
+			continue
+		}
+		src.row.StartTime, src.row.EndTime = &events[0].Start, &events[0].End
+		src.create = func(tx *gorm.DB) ([]Booking, error) {
+			// 重复导入同一文件时跳过已导入的事件
+			if calendarUIDExists(tx, room.ID, uid) {
+				return nil, errImportDuplicate
+			}
+			return createEventBookings(tx, room, user.ID, importObjectName(uid), events)
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+// 事件已导入过
+var errImportDuplicate = errors.New("该事件已导入")
+
+// @Summary 导入预订
+// @Description 管理员通过 CSV 或 iCalendar 文件批量导入预订。会议室按名称匹配，用户按用户名或邮箱匹配，每条记录与 POST /api/bookings 使用相同的校验。
+// @Description CSV 表头：room,user,start_time,end_time,reason,visibility，时间不带时区时按会议室时区解释。
+// @Description dry_run=true 时只返回逐条结果不写入；正式导入在一个事务中执行，存在失败记录时全部不导入，除非 skip_errors=true
+// @Tags 预订
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "CSV 或 .ics 文件"
+// @Param format formData string false "文件格式 csv 或 ics，默认按扩展名判断"
+// @Param dry_run formData bool false "是否试运行"
+// @Param skip_errors formData bool false "是否跳过失败记录，只导入成功的记录"
+// @Param default_user formData string false "记录未指定预订人时使用的用户名或邮箱"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/bookings/import [post]
+func importBookingsHandler(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请选择要导入的文件"})
+		return
+	}
+	if fileHeader.Size > maxImportFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件不能超过5MB"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取文件失败"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxImportFileSize+1))
+	if err != nil || len(data) > maxImportFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取文件失败"})
+		return
+	}
+
+	format := strings.ToLower(c.PostForm("format"))
+	if format == "" {
+		format = importFormat(fileHeader.Filename)
+	}
+	dryRun := c.PostForm("dry_run") == "true" || c.Query("dry_run") == "true"
+	skipErrors := c.PostForm("skip_errors") == "true" || c.Query("skip_errors") == "true"
+	sources, err := parseImportFile(data, format, strings.TrimSpace(c.PostForm("default_user")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := runImport(c, sources, dryRun, skipErrors)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导入失败"})
+		return
+	}
+	resp := gin.H{
+		"dry_run":   result.DryRun,
+		"committed": result.Committed,
+		"total":     len(result.Rows),
+		"summary":   result.Summary,
+		"rows":      result.Rows,
+	}
+	if !dryRun && !result.Committed {
+		resp["error"] = "存在无法导入的记录，未导入任何预订"
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// 按扩展名判断导入文件的格式
+func importFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".ics", ".ical", ".ifb":
+		return "ics"
+	default:
+		return "csv"
+	}
+}
+
+// 解析导入文件，defaultUser 为记录未指定预订人时使用的用户名或邮箱
+func parseImportFile(data []byte, format, defaultUser string) ([]importSource, error) {
+	lookup := loadImportLookup(db)
+	switch format {
+	case "csv":
+		return parseImportCSV(data, lookup, defaultUser)
+	case "ics":
+		return parseImportICS(data, lookup, defaultUser)
+	default:
+		return nil, errors.New("仅支持 csv 和 ics 格式")
+	}
+}
+
+// importResult 导入结果
+type importResult struct {
+	DryRun    bool
+	Committed bool           // 是否已写入
+	Summary   map[string]int // 各状态的记录数
+	Rows      []ImportRow
+}
+
+// 导入解析后的记录，c 为 nil 时（命令行导入）审计日志的操作人为 system。
+// 所有记录在同一事务中依次校验和创建，文件内相互冲突的记录也能被发现；
+// 试运行或存在失败记录时回滚。返回的错误只表示数据库失败
+func runImport(c *gin.Context, sources []importSource, dryRun, skipErrors bool) (importResult, error) {
+	rows := make([]ImportRow, len(sources))
+	failed := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i, src := range sources {
+			rows[i] = src.row
+			if src.create == nil {
+				failed++
+				continue
+			}
+			bookings, err := src.create(tx)
+			if err != nil {
+				var be *bookingError
+				var de *davError
+				switch {
+				case errors.Is(err, errImportDuplicate):
+					rows[i].Status = ImportDuplicate
+				case errors.As(err, &be) || errors.As(err, &de):
+					rows[i].Status = importStatus(err)
+				default:
+					return err
+				}
+				rows[i].Message = err.Error()
+				failed++
+				continue
+			}
+			rows[i].Status = ImportCreated
+			for _, b := range bookings {
+				rows[i].BookingIDs = append(rows[i].BookingIDs, b.ID)
+				recordAudit(tx, c, "booking.import", "booking", b.ID, nil, b)
+			}
+		}
+		if dryRun || (failed > 0 && !skipErrors) {
+			return errImportDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errImportDryRun) {
+		return importResult{}, err
+	}
+	result := importResult{DryRun: dryRun, Committed: err == nil, Summary: make(map[string]int), Rows: rows}
+	for i := range rows {
+		result.Summary[rows[i].Status]++
+		if !result.Committed {
+			rows[i].BookingIDs = nil
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 以管理员身份上传导入文件，fields 为其他表单字段
+func postImport(t *testing.T, admin User, filename, content string, fields map[string]string) (int, map[string]interface{}) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(content))
+	mw.Close()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/admin/bookings/import", &body)
+	c.Request.Header.Set("Content-Type", mw.FormDataContentType())
+	c.Set("user_id", admin.ID)
+	c.Set("username", admin.Username)
+	c.Set("role", admin.Role)
+	importBookingsHandler(c)
+	resp := make(map[string]interface{})
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+// 各行的导入结果
+func importStatuses(resp map[string]interface{}) []string {
+	var statuses []string
+	rows, _ := resp["rows"].([]interface{})
+	for _, r := range rows {
+		statuses = append(statuses, r.(map[string]interface{})["status"].(string))
+	}
+	return statuses
+}
+
+// 试运行逐条报告结果且不写入；存在失败记录时正式导入全部回滚，skip_errors 时只导入成功的记录
+func TestImportDryRunReport(t *testing.T) {
+	tx := setupTestDB(t)
+	user, room := createBookingFixtures(t, tx)
+	admin := User{Username: "admin", Role: "admin"}
+	carol := User{Username: "carol", Email: "carol@example.com", Role: "user"}
+	mustCreate(t, &admin, &carol)
+	mustCreate(t, &BookingQuota{Scope: QuotaScopeUser, UserID: carol.ID, HoursPerWeek: 1})
+	existing := Booking{RoomID: room.ID, UserID: user.ID, StartTime: time.Date(2030, 5, 6, 14, 0, 0, 0, time.UTC),
+		EndTime: time.Date(2030, 5, 6, 15, 0, 0, 0, time.UTC), Status: BookingStatusActive}
+	mustCreate(t, &existing)
+
+	csv := "\ufeff会议室,预订人,开始时间,结束时间,事由\n" +
+		"a101,alice,2030-05-06 09:00,2030-05-06 10:00,周会\n" +
+		"B999,alice,2030-05-06 09:00,2030-05-06 10:00,\n" +
+		"A101,nobody,2030-05-06 11:00,2030-05-06 12:00,\n" +
+		"A101,alice,2030-05-06 12:00,2030-05-06 11:00,\n" +
+		"A101,carol@example.com,2030-05-06 09:30,2030-05-06 10:30,文件内冲突\n" +
+		"A101,alice,2030-05-06 14:30,2030-05-06 15:30,与已有预订冲突\n" +
+		"A101,carol,2030-05-06 16:00,2030-05-06 18:00,超出配额\n"
+	want := []string{ImportCreated, ImportUnknownRoom, ImportUnknownUser, ImportInvalidTime, ImportConflict, ImportConflict, ImportQuotaExceeded}
+	count := func() int64 {
+		var n int64
+		tx.Model(&Booking{}).Count(&n)
+		return n
+	}
+
+	code, resp := postImport(t, admin, "bookings.csv", csv, map[string]string{"dry_run": "true"})
+	if code != http.StatusOK || resp["dry_run"] != true || resp["committed"] != false {
+		t.Fatalf("试运行返回 %d：%v", code, resp)
+	}
+	if got := importStatuses(resp); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("试运行结果 %v，应为 %v", got, want)
+	}
+	summary := resp["summary"].(map[string]interface{})
+	if summary[ImportCreated] != 1.0 || summary[ImportConflict] != 2.0 || resp["total"] != 7.0 {
+		t.Errorf("汇总 %v", resp)
+	}
+	first := resp["rows"].([]interface{})[0].(map[string]interface{})
+	if first["row"] != 2.0 || first["booking_ids"] != nil || first["start_time"] != "2030-05-06T09:00:00Z" {
+		t.Errorf("第一条记录 %v", first)
+	}
+	var audits int64
+	tx.Model(&AuditLog{}).Count(&audits)
+	if n := count(); n != 1 || audits != 0 {
+		t.Fatalf("试运行写入了 %d 个预订、%d 条审计日志", n-1, audits)
+	}
+
+	code, resp = postImport(t, admin, "bookings.csv", csv, nil)
+	if code != http.StatusBadRequest || resp["committed"] != false || count() != 1 {
+		t.Fatalf("存在失败记录时返回 %d：%v", code, resp)
+	}
+
+	code, resp = postImport(t, admin, "bookings.csv", csv, map[string]string{"skip_errors": "true"})
+	if code != http.StatusOK || resp["committed"] != true {
+		t.Fatalf("跳过失败记录返回 %d：%v", code, resp)
+	}
+	rows := resp["rows"].([]interface{})
+	if ids, _ := rows[0].(map[string]interface{})["booking_ids"].([]interface{}); len(ids) != 1 || count() != 2 {
+		t.Errorf("导入结果 %v", rows[0])
+	}
+	tx.Model(&AuditLog{}).Where("action = ?", "booking.import").Count(&audits)
+	if audits != 1 {
+		t.Errorf("审计日志 %d 条", audits)
+	}
+}
+
+// 重复导入同一 iCalendar 文件时，已导入的事件报告为重复
+func TestImportICSDuplicate(t *testing.T) {
+	tx := setupTestDB(t)
+	_, room := createBookingFixtures(t, tx)
+	admin := User{Username: "admin", Role: "admin"}
+	mustCreate(t, &admin)
+	ics := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\nUID:weekly@example.com\r\nDTSTART:20300506T090000Z\r\nDTEND:20300506T100000Z\r\nRRULE:FREQ=WEEKLY;COUNT=3\r\n" +
+		"SUMMARY:周会\r\nLOCATION:A101\r\nORGANIZER;CN=alice:mailto:alice@example.com\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:weekly@example.com\r\nRECURRENCE-ID:20300513T090000Z\r\nDTSTART:20300513T090000Z\r\nDTEND:20300513T100000Z\r\n" +
+		"STATUS:CANCELLED\r\nEND:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	code, resp := postImport(t, admin, "export.ics", ics, nil)
+	if code != http.StatusOK || strings.Join(importStatuses(resp), ",") != ImportCreated {
+		t.Fatalf("导入返回 %d：%v", code, resp)
+	}
+	var bookings []Booking
+	tx.Where("room_id = ?", room.ID).Order("start_time").Find(&bookings)
+	if len(bookings) != 2 || bookings[0].SeriesID == nil || bookings[1].StartTime.Day() != 20 {
+		t.Errorf("导入的预订 %+v", bookings)
+	}
+
+	code, resp = postImport(t, admin, "export.ics", ics, map[string]string{"dry_run": "true"})
+	if code != http.StatusOK || strings.Join(importStatuses(resp), ",") != ImportDuplicate {
+		t.Errorf("重复导入返回 %d：%v", code, resp)
+	}
+}
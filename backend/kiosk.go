@@ -0,0 +1,488 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 会议开始前多久可以签到
+const kioskCheckInEarly = 10 * time.Minute
+
+// 门口平板即时预订可选的时长（分钟）
+var kioskBookMinutes = []int{15, 30, 60}
+
+// 门口平板即时预订的默认事由，预订人为空
+const kioskBookReason = "即时会议"
+
+// KioskDevice 会议室门口的显示平板，凭设备令牌访问所绑定会议室的 /api/kiosk 接口，无需用户登录
+type KioskDevice struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Name       string     `json:"name"`
+	RoomID     uint       `gorm:"index" json:"room_id"`
+	Token      string     `gorm:"uniqueIndex;size:191" json:"-"` // 仅在注册和重置时返回
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// 注册设备请求体
+type SaveKioskDeviceRequest struct {
+	Name   string `json:"name" binding:"required"`
+	RoomID uint   `json:"room_id" binding:"required"`
+}
+
+// 即时预订请求体
+type KioskBookRequest struct {
+	Minutes int    `json:"minutes" binding:"required"` // 15、30 或 60
+	Reason  string `json:"reason"`
+}
+
+// 签到和提前结束请求体，booking_id 可选，指定时须为平板当前显示的会议
+type KioskMeetingRequest struct {
+	BookingID uint `json:"booking_id"`
+}
+
+// kioskMeeting 平板上显示的会议，私密预订只显示占用
+type kioskMeeting struct {
+	ID             uint      `json:"id"`
+	Subject        string    `json:"subject"`
+	Organizer      string    `json:"organizer,omitempty"`
+	StartTime      time.Time `json:"start_time"`
+	EndTime        time.Time `json:"end_time"`
+	StartTimeLocal string    `json:"start_time_local"`
+	EndTimeLocal   string    `json:"end_time_local"`
+	CheckedIn      bool      `json:"checked_in"`
+}
+
+func newKioskMeeting(tx *gorm.DB, b Booking, loc *time.Location) *kioskMeeting {
+	b.localize(loc)
+	m := &kioskMeeting{
+		ID:             b.ID,
+		Subject:        b.Reason,
+		StartTime:      b.StartTime,
+		EndTime:        b.EndTime,
+		StartTimeLocal: b.StartTimeLocal,
+		EndTimeLocal:   b.EndTimeLocal,
+		CheckedIn:      b.CheckedInAt != nil,
+	}
+	if b.Visibility == VisibilityPrivate {
+		m.Subject = redactedReason
+		return m
+	}
+	var user User
+	if b.UserID != 0 && tx.First(&user, b.UserID).Error == nil {
+		m.Organizer = user.Nickname
+		if m.Organizer == "" {
+			m.Organizer = user.Username
+		}
+	}
+	return m
+}
+
+// 生成设备令牌
+func newKioskToken() (string, error) {
+	return randomHex(24)
+}
+
+// 设备令牌鉴权，可通过 X-Device-Token 请求头或 token 参数传入，便于直接在平板浏览器中打开；
+// token 参数在访问日志中由 accessLogFormatter 隐藏
+func KioskAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader("X-Device-Token")
+		if token == "" {
+			token = c.Query("token")
+		}
+		var device KioskDevice
+		if token == "" || db.Where("token = ?", token).First(&device).Error != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "设备令牌无效"})
+			c.Abort()
+			return
+		}
+		var room Room
+		if err := db.First(&room, device.RoomID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "会议室不存在"})
+			c.Abort()
+			return
+		}
+		now := time.Now().UTC()
+		db.Model(&device).UpdateColumn("last_seen_at", now)
+		c.Set("kiosk_device", device)
+		c.Set("kiosk_room", room)
+		c.Next()
+	}
+}
+
+func kioskRoom(c *gin.Context) Room {
+	room, _ := c.Get("kiosk_room")
+	return room.(Room)
+}
+
+// 会议室当前进行中的会议
+func currentBooking(tx *gorm.DB, roomID uint, now time.Time) (Booking, bool) {
+	var b Booking
+	err := tx.Scopes(activeBookings).
+		Where("room_id = ? AND start_time <= ? AND end_time > ?", roomID, now, now).
+		Order("start_time").First(&b).Error
+	return b, err == nil
+}
+
+// 会议室下一场会议
+func nextBooking(tx *gorm.DB, roomID uint, now time.Time) (Booking, bool) {
+	var b Booking
+	err := tx.Scopes(activeBookings).
+		Where("room_id = ? AND start_time > ?", roomID, now).
+		Order("start_time").First(&b).Error
+	return b, err == nil
+}
+
+// 会议室今天的关门时间，不限开放时间或已过关门时间时返回 false
+func closingTime(room Room, now time.Time) (time.Time, bool) {
+	if room.OpenTime == "" || room.CloseTime == "" {
+		return time.Time{}, false
+	}
+	closing, ok := parseClock(room.CloseTime)
+	if !ok {
+		return time.Time{}, false
+	}
+	local := now.In(room.location())
+	t := time.Date(local.Year(), local.Month(), local.Day(), closing/60, closing%60, 0, 0, local.Location()).UTC()
+	return t, t.After(now)
+}
+
+// 平板显示的会议室状态：当前会议、下一场会议及空闲时长。
+// 空闲时 free_until 为下一场会议开始或今天关门的时间，今天之内都空闲时为空
+func kioskStatus(tx *gorm.DB, room Room, now time.Time) gin.H {
+	loc := room.location()
+	status := gin.H{
+		"room":         gin.H{"id": room.ID, "name": room.Name, "capacity": room.Capacity, "time_zone": loc.String(), "open_time": room.OpenTime, "close_time": room.CloseTime},
+		"now":          now,
+		"status":       "free",
+		"current":      nil,
+		"next":         nil,
+		"free_until":   nil,
+		"free_minutes": nil,
+		"busy_until":   nil,
+		"can_check_in": false,
+		"book_minutes": kioskBookMinutes,
+	}
+	current, busy := currentBooking(tx, room.ID, now)
+	next, hasNext := nextBooking(tx, room.ID, now)
+	if busy {
+		status["status"] = "busy"
+		status["current"] = newKioskMeeting(tx, current, loc)
+		status["busy_until"] = current.EndTime.UTC()
+		status["can_check_in"] = current.CheckedInAt == nil
+	} else if hasNext && next.CheckedInAt == nil && !next.StartTime.After(now.Add(kioskCheckInEarly)) {
+		status["can_check_in"] = true
+	}
+	if hasNext {
+		status["next"] = newKioskMeeting(tx, next, loc)
+	}
+	if !busy {
+		// 下一场会议在今天之内才显示“空闲至”
+		local := now.In(loc)
+		endOfDay := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc).UTC()
+		var until *time.Time
+		if hasNext && next.StartTime.Before(endOfDay) {
+			t := next.StartTime.UTC()
+			until = &t
+		}
+		if closing, ok := closingTime(room, now); ok && (until == nil || closing.Before(*until)) {
+			until = &closing
+		}
+		if until != nil {
+			status["free_until"] = *until
+			status["free_minutes"] = int(until.Sub(now) / time.Minute)
+		}
+	}
+	return status
+}
+
+// 找到平板当前要操作的会议：进行中的会议，允许签到时也包括即将开始的会议
+func kioskTargetBooking(tx *gorm.DB, roomID uint, now time.Time, allowUpcoming bool) (Booking, bool) {
+	if b, ok := currentBooking(tx, roomID, now); ok {
+		return b, true
+	}
+	if allowUpcoming {
+		if b, ok := nextBooking(tx, roomID, now); ok && !b.StartTime.After(now.Add(kioskCheckInEarly)) {
+			return b, true
+		}
+	}
+	return Booking{}, false
+}
+
+// @Summary 门口平板：会议室状态
+// @Description 返回当前会议、下一场会议、空闲至何时及剩余空闲分钟数，私密预订只显示占用
+// @Tags 门口平板
+// @Produce json
+// @Param X-Device-Token header string false "设备令牌，也可通过 token 参数传入"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/kiosk/status [get]
+func kioskStatusHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, kioskStatus(db, kioskRoom(c), time.Now().UTC()))
+}
+
+// @Summary 门口平板：立即预订
+// @Description 从现在开始预订 15、30 或 60 分钟，预订人为空，不计入个人配额
+// @Tags 门口平板
+// @Accept json
+// @Produce json
+// @Param X-Device-Token header string false "设备令牌，也可通过 token 参数传入"
+// @Param data body KioskBookRequest true "预订时长"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/kiosk/book [post]
+func kioskBookHandler(c *gin.Context) {
+	var req KioskBookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	allowed := false
+	for _, m := range kioskBookMinutes {
+		allowed = allowed || m == req.Minutes
+	}
+	if !allowed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "预订时长只能是 15、30 或 60 分钟"})
+		return
+	}
+	room := kioskRoom(c)
+	now := time.Now().UTC()
+	start := now.Truncate(time.Minute)
+	slot := timeSlot{Start: start, End: start.Add(time.Duration(req.Minutes) * time.Minute)}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		reason = kioskBookReason
+	}
+	visibility, err := resolveVisibility(db, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "预订失败"})
+		return
+	}
+	var booking Booking
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := checkSlotAvailability(tx, room, 0, 0, []timeSlot{slot}); err != nil {
+			return err
+		}
+		bookings, _, err := createBookings(tx, NewBooking{Room: room, Slots: []timeSlot{slot}, Reason: reason, Visibility: visibility})
+		if err != nil {
+			return err
+		}
+		// 在门口预订视为已签到
+		booking = bookings[0]
+		booking.CheckedInAt = &now
+		if err := tx.Model(&booking).Update("checked_in_at", now).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "booking.create", "booking", booking.ID, nil, booking)
+		return nil
+	})
+	if err != nil {
+		writeBookingError(c, err, "预订失败")
+		return
+	}
+	booking.localize(room.location())
+	c.JSON(http.StatusOK, gin.H{"message": "预订成功", "booking": booking, "status": kioskStatus(db, room, now)})
+}
+
+var errNoKioskMeeting = errors.New("no meeting")
+
+// @Summary 门口平板：签到
+// @Description 为进行中或 10 分钟内开始的会议签到
+// @Tags 门口平板
+// @Accept json
+// @Produce json
+// @Param X-Device-Token header string false "设备令牌，也可通过 token 参数传入"
+// @Param data body KioskMeetingRequest false "会议"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/kiosk/checkin [post]
+func kioskCheckInHandler(c *gin.Context) {
+	var req KioskMeetingRequest
+	c.ShouldBindJSON(&req)
+	room := kioskRoom(c)
+	now := time.Now().UTC()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		b, ok := kioskTargetBooking(tx, room.ID, now, true)
+		if !ok || (req.BookingID != 0 && req.BookingID != b.ID) {
+			return errNoKioskMeeting
+		}
+		if b.CheckedInAt != nil {
+			return nil
+		}
+		before := b
+		b.CheckedInAt = &now
+		if err := tx.Model(&b).Update("checked_in_at", now).Error; err != nil {
+			return err
+		}
+		publishBookingEvent(tx, EventBookingUpdated, b)
+		recordAudit(tx, c, "booking.checkin", "booking", b.ID, before, b)
+		return nil
+	})
+	if errors.Is(err, errNoKioskMeeting) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "当前没有可签到的会议"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "签到失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "签到成功", "status": kioskStatus(db, room, now)})
+}
+
+// @Summary 门口平板：提前结束会议
+// @Description 将进行中会议的结束时间改为当前时间，会议室随即释放
+// @Tags 门口平板
+// @Accept json
+// @Produce json
+// @Param X-Device-Token header string false "设备令牌，也可通过 token 参数传入"
+// @Param data body KioskMeetingRequest false "会议"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/kiosk/end [post]
+func kioskEndHandler(c *gin.Context) {
+	var req KioskMeetingRequest
+	c.ShouldBindJSON(&req)
+	room := kioskRoom(c)
+	now := time.Now().UTC()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		b, ok := kioskTargetBooking(tx, room.ID, now, false)
+		if !ok || (req.BookingID != 0 && req.BookingID != b.ID) {
+			return errNoKioskMeeting
+		}
+		before := b
+		if err := endBookingNow(tx, &b, now); err != nil {
+			return err
+		}
+		recordAudit(tx, c, "booking.end", "booking", b.ID, before, b)
+		return nil
+	})
+	if errors.Is(err, errNoKioskMeeting) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "当前没有进行中的会议"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "结束会议失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "会议已结束", "status": kioskStatus(db, room, now)})
+}
+
+// @Summary 门口平板设备列表
+// @Description 管理员查看已注册的门口平板，可按会议室筛选
+// @Tags 门口平板
+// @Produce json
+// @Param room_id query int false "会议室ID"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/kiosk-devices [get]
+func listKioskDevicesHandler(c *gin.Context) {
+	query := db.Order("id")
+	if roomID := c.Query("room_id"); roomID != "" {
+		query = query.Where("room_id = ?", roomID)
+	}
+	devices := []KioskDevice{}
+	query.Find(&devices)
+	c.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+// @Summary 注册门口平板
+// @Description 管理员为会议室注册门口平板，返回的设备令牌只显示一次
+// @Tags 门口平板
+// @Accept json
+// @Produce json
+// @Param data body SaveKioskDeviceRequest true "设备信息"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/kiosk-devices [post]
+func createKioskDeviceHandler(c *gin.Context) {
+	var req SaveKioskDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	if err := db.First(&Room{}, req.RoomID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "会议室不存在"})
+		return
+	}
+	token, err := newKioskToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成设备令牌失败"})
+		return
+	}
+	device := KioskDevice{Name: strings.TrimSpace(req.Name), RoomID: req.RoomID, Token: token}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&device).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "kiosk_device.create", "kiosk_device", device.ID, nil, device)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "注册设备失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "注册成功", "device": device, "token": token})
+}
+
+// @Summary 重置门口平板令牌
+// @Description 管理员重置设备令牌，原令牌立即失效
+// @Tags 门口平板
+// @Produce json
+// @Param id path int true "设备ID"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/kiosk-devices/{id}/token [post]
+func resetKioskTokenHandler(c *gin.Context) {
+	var device KioskDevice
+	if err := db.First(&device, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "设备不存在"})
+		return
+	}
+	token, err := newKioskToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成设备令牌失败"})
+		return
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&device).Update("token", token).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "kiosk_device.reset_token", "kiosk_device", device.ID, nil, nil)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重置失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "重置成功", "device": device, "token": token})
+}
+
+// @Summary 删除门口平板
+// @Description 管理员删除设备，设备令牌立即失效
+// @Tags 门口平板
+// @Produce json
+// @Param id path int true "设备ID"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/kiosk-devices/{id} [delete]
+func deleteKioskDeviceHandler(c *gin.Context) {
+	var device KioskDevice
+	if err := db.First(&device, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "设备不存在"})
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&device).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "kiosk_device.delete", "kiosk_device", device.ID, device, nil)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
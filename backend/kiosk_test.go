@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 与 setupRouter 中相同的门口平板路由
+func kioskRouter() *gin.Engine {
+	r := gin.New()
+	kiosk := r.Group("/api/kiosk")
+	kiosk.Use(KioskAuthMiddleware())
+	kiosk.GET("/status", kioskStatusHandler)
+	kiosk.POST("/book", kioskBookHandler)
+	return r
+}
+
+// 发送门口平板请求，token 非空时放在 X-Device-Token 请求头中
+func kioskRequest(t *testing.T, r *gin.Engine, method, target, token string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, target, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Device-Token", token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	resp := make(map[string]interface{})
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+// 设备令牌只能访问所绑定的会议室，重置或删除设备后原令牌立即失效
+func TestKioskAuth(t *testing.T) {
+	tx := setupTestDB(t)
+	user, roomA := createBookingFixtures(t, tx)
+	admin := User{Username: "admin", Role: "admin"}
+	roomB := Room{Name: "B202", TimeZone: "UTC"}
+	mustCreate(t, &admin, &roomB)
+	now := time.Now().UTC()
+	private := Booking{RoomID: roomA.ID, UserID: user.ID, StartTime: now.Add(-10 * time.Minute), EndTime: now.Add(50 * time.Minute),
+		Reason: "并购谈判", Visibility: VisibilityPrivate, Status: BookingStatusActive}
+	mustCreate(t, &private)
+
+	register := func(room Room) (uint, string) {
+		t.Helper()
+		code, resp := callHandler(t, createKioskDeviceHandler, admin, http.MethodPost, "/api/admin/kiosk-devices", nil,
+			SaveKioskDeviceRequest{Name: room.Name + " 门口", RoomID: room.ID})
+		token, _ := resp["token"].(string)
+		if code != http.StatusOK || token == "" {
+			t.Fatalf("注册设备返回 %d：%v", code, resp)
+		}
+		return uint(resp["device"].(map[string]interface{})["id"].(float64)), token
+	}
+	deviceA, tokenA := register(roomA)
+	_, tokenB := register(roomB)
+	r := kioskRouter()
+
+	for name, target := range map[string]string{"缺少令牌": "/api/kiosk/status", "令牌无效": "/api/kiosk/status?token=invalid"} {
+		if code, _ := kioskRequest(t, r, http.MethodGet, target, "", nil); code != http.StatusUnauthorized {
+			t.Errorf("%s：返回 %d", name, code)
+		}
+	}
+	code, resp := kioskRequest(t, r, http.MethodGet, "/api/kiosk/status", tokenA, nil)
+	if code != http.StatusOK || resp["room"].(map[string]interface{})["id"] != float64(roomA.ID) || resp["status"] != "busy" {
+		t.Fatalf("状态返回 %d：%v", code, resp)
+	}
+	// 平板无需登录，私密预订只显示占用
+	if current := resp["current"].(map[string]interface{}); current["subject"] != redactedReason || current["organizer"] != nil {
+		t.Errorf("当前会议 %v", current)
+	}
+	if code, resp := kioskRequest(t, r, http.MethodGet, "/api/kiosk/status?token="+tokenB, "", nil); code != http.StatusOK ||
+		resp["room"].(map[string]interface{})["id"] != float64(roomB.ID) {
+		t.Errorf("通过 token 参数访问返回 %d：%v", code, resp)
+	}
+	if masked := maskQueryToken("/api/kiosk/status?token=" + tokenB); strings.Contains(masked, tokenB) {
+		t.Errorf("访问日志中未隐藏令牌：%s", masked)
+	}
+	var device KioskDevice
+	tx.First(&device, deviceA)
+	if device.LastSeenAt == nil {
+		t.Error("未记录设备最后访问时间")
+	}
+
+	// 即时预订只能预订绑定的会议室
+	if code, resp := kioskRequest(t, r, http.MethodPost, "/api/kiosk/book", tokenA, KioskBookRequest{Minutes: 30}); code != http.StatusConflict {
+		t.Errorf("会议室占用时预订返回 %d：%v", code, resp)
+	}
+	code, resp = kioskRequest(t, r, http.MethodPost, "/api/kiosk/book", tokenB, KioskBookRequest{Minutes: 30})
+	if code != http.StatusOK {
+		t.Fatalf("预订返回 %d：%v", code, resp)
+	}
+	if b := resp["booking"].(map[string]interface{}); b["room_id"] != float64(roomB.ID) || b["user_id"] != 0.0 || b["reason"] != kioskBookReason {
+		t.Errorf("平板预订 %v", b)
+	}
+
+	code, resp = callHandler(t, resetKioskTokenHandler, admin, http.MethodPost, "/api/admin/kiosk-devices/token", idParam(deviceA), nil)
+	newToken, _ := resp["token"].(string)
+	if code != http.StatusOK || newToken == "" || newToken == tokenA {
+		t.Fatalf("重置令牌返回 %d：%v", code, resp)
+	}
+	if code, _ := kioskRequest(t, r, http.MethodGet, "/api/kiosk/status", tokenA, nil); code != http.StatusUnauthorized {
+		t.Errorf("重置后原令牌返回 %d", code)
+	}
+	if code, _ := kioskRequest(t, r, http.MethodGet, "/api/kiosk/status", newToken, nil); code != http.StatusOK {
+		t.Errorf("新令牌返回 %d", code)
+	}
+	if code, resp := callHandler(t, deleteKioskDeviceHandler, admin, http.MethodDelete, "/api/admin/kiosk-devices", idParam(deviceA), nil); code != http.StatusOK {
+		t.Fatalf("删除设备返回 %d：%v", code, resp)
+	}
+	if code, _ := kioskRequest(t, r, http.MethodGet, "/api/kiosk/status", newToken, nil); code != http.StatusUnauthorized {
+		t.Errorf("删除设备后返回 %d", code)
+	}
+}
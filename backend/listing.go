@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 列表接口的分页参数
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// 解析分页参数 page、page_size，未传时返回第 1 页
+func parsePage(c *gin.Context) (page, pageSize int) {
+	page, _ = strconv.Atoi(c.Query("page"))
+	pageSize, _ = strconv.Atoi(c.Query("page_size"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > maxPageSize {
+		pageSize = defaultPageSize
+	}
+	return page, pageSize
+}
+
+// 分页查询
+func paginate(page, pageSize int) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Offset((page - 1) * pageSize).Limit(pageSize)
+	}
+}
+
+// 解析排序参数 sort：字段名，加 - 前缀表示降序，如 -start_time。
+// columns 为允许排序的字段及对应的列，未传时使用 def。列名由数据库方言加引号，可使用 group 等保留字
+func parseSort(c *gin.Context, columns map[string]string, def string) (clause.OrderByColumn, bool) {
+	value := c.DefaultQuery("sort", def)
+	field := strings.TrimPrefix(value, "-")
+	column, ok := columns[field]
+	if !ok {
+		return clause.OrderByColumn{}, false
+	}
+	return clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: strings.HasPrefix(value, "-")}, true
+}
+
+// 关键字匹配条件，不区分大小写，需配合 likePattern 使用。
+// 使用 ! 作为转义字符，因为 MySQL 字符串中的反斜杠本身需要转义
+func likeCondition(column string) string {
+	return "LOWER(" + column + ") LIKE ? ESCAPE '!'"
+}
+
+// 生成 LIKE 模式，转小写并转义通配符
+func likePattern(keyword string) string {
+	r := strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`)
+	return "%" + r.Replace(strings.ToLower(keyword)) + "%"
+}
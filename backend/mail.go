@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SMTP 连接超时
+const mailTimeout = 30 * time.Second
+
+// mailConfig SMTP 配置，未设置 host 时不发送邮件
+type mailConfig struct {
+	Host     string `yaml:"host" json:"host" env:"SMTP_HOST"`
+	Port     int    `yaml:"port" json:"port" env:"SMTP_PORT"`
+	Username string `yaml:"username" json:"username" env:"SMTP_USERNAME"`
+	Password string `yaml:"password" json:"password" env:"SMTP_PASSWORD"`
+	From     string `yaml:"from" json:"from" env:"SMTP_FROM"` // 发件人，可带显示名，如 "会议室预订 <noreply@example.com>"，默认同 Username
+	TLS      string `yaml:"tls" json:"tls" env:"SMTP_TLS"`    // 为空时服务器支持则使用 STARTTLS；starttls 必须使用；ssl 直接 TLS 连接（465 端口）；none 不加密
+}
+
+func (cfg mailConfig) enabled() bool {
+	return cfg.Host != "" && cfg.From != ""
+}
+
+// 启动时由配置设置
+var mailSettings mailConfig
+
+type mailTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// 中英文邮件模板
+var mailTemplateText = map[string]map[string][2]string{
+	"zh": {
+		NotifyConfirmation: {
+			"[会议室预订] 预订成功：{{.Summary}}",
+			`{{.Name}}，您好：
+
+{{if .IsOrganizer}}您的会议室预订已确认。{{else}}{{.Organizer}} 邀请您参加会议。{{end}}
+
+会议：{{.Summary}}
+会议室：{{.Room}}
+时间：{{.Start}} - {{.End}}（{{.TimeZone}}）
+{{- if gt .Count 1}}
+重复：共 {{.Count}} 次{{end}}
+预订人：{{.Organizer}}
+
+附件中的日历文件可导入日历客户端。
+`,
+		},
+		NotifyChange: {
+			"[会议室预订] 预订已变更：{{.Summary}}",
+			`{{.Name}}，您好：
+
+以下会议已变更，请以最新信息为准。
+
+会议：{{.Summary}}
+会议室：{{.Room}}
+时间：{{.Start}} - {{.End}}（{{.TimeZone}}）
+{{- if gt .Count 1}}
+涉及：{{.Count}} 次会议{{end}}
+预订人：{{.Organizer}}
+
+附件中的日历文件可更新日历客户端中的会议。
+`,
+		},
+		NotifyCancellation: {
+			"[会议室预订] 预订已取消：{{.Summary}}",
+			`{{.Name}}，您好：
+
+以下会议已取消{{if .CancelledBy}}（操作人：{{.CancelledBy}}）{{end}}。
+
+会议：{{.Summary}}
+会议室：{{.Room}}
+时间：{{.Start}} - {{.End}}（{{.TimeZone}}）
+{{- if gt .Count 1}}
+涉及：{{.Count}} 次会议{{end}}
+{{- if .CancelReason}}
+取消原因：{{.CancelReason}}{{end}}
+
+附件中的日历文件可从日历客户端中移除该会议。
+`,
+		},
+		NotifyReminder: {
+			"[会议室预订] 会议提醒：{{.Summary}} {{.Start}}",
+			`{{.Name}}，您好：
+
+您的会议即将开始。
+
+会议：{{.Summary}}
+会议室：{{.Room}}
+时间：{{.Start}} - {{.End}}（{{.TimeZone}}）
+预订人：{{.Organizer}}
+`,
+		},
+		NotifyTest: {
+			"[会议室预订] 测试邮件",
+			"这是一封测试邮件，说明邮件通知配置正确。\n",
+		},
+	},
+	"en": {
+		NotifyConfirmation: {
+			"[Meeting Room] Booking confirmed: {{.Summary}}",
+			`Hello {{.Name}},
+
+{{if .IsOrganizer}}Your meeting room booking has been confirmed.{{else}}{{.Organizer}} has invited you to a meeting.{{end}}
+
+Meeting: {{.Summary}}
+Room: {{.Room}}
+Time: {{.Start}} - {{.End}} ({{.TimeZone}})
+{{- if gt .Count 1}}
+Repeats: {{.Count}} times{{end}}
+Organizer: {{.Organizer}}
+
+The attached calendar file can be imported into your calendar.
+`,
+		},
+		NotifyChange: {
+			"[Meeting Room] Booking changed: {{.Summary}}",
+			`Hello {{.Name}},
+
+The following meeting has been changed.
+
+Meeting: {{.Summary}}
+Room: {{.Room}}
+Time: {{.Start}} - {{.End}} ({{.TimeZone}})
+{{- if gt .Count 1}}
+Affects: {{.Count}} meetings{{end}}
+Organizer: {{.Organizer}}
+
+The attached calendar file updates the meeting in your calendar.
+`,
+		},
+		NotifyCancellation: {
+			"[Meeting Room] Booking cancelled: {{.Summary}}",
+			`Hello {{.Name}},
+
+The following meeting has been cancelled{{if .CancelledBy}} by {{.CancelledBy}}{{end}}.
+
+Meeting: {{.Summary}}
+Room: {{.Room}}
+Time: {{.Start}} - {{.End}} ({{.TimeZone}})
+{{- if gt .Count 1}}
+Affects: {{.Count}} meetings{{end}}
+{{- if .CancelReason}}
+Reason: {{.CancelReason}}{{end}}
+
+The attached calendar file removes the meeting from your calendar.
+`,
+		},
+		NotifyReminder: {
+			"[Meeting Room] Reminder: {{.Summary}} at {{.Start}}",
+			`Hello {{.Name}},
+
+Your meeting is starting soon.
+
+Meeting: {{.Summary}}
+Room: {{.Room}}
+Time: {{.Start}} - {{.End}} ({{.TimeZone}})
+Organizer: {{.Organizer}}
+`,
+		},
+		NotifyTest: {
+			"[Meeting Room] Test email",
+			"This is a test email. Email notifications are configured correctly.\n",
+		},
+	},
+}
+
+var mailTemplates = parseMailTemplates()
+
+func parseMailTemplates() map[string]map[string]mailTemplate {
+	result := make(map[string]map[string]mailTemplate)
+	for lang, kinds := range mailTemplateText {
+		result[lang] = make(map[string]mailTemplate)
+		for kind, text := range kinds {
+			name := lang + "/" + kind
+			result[lang][kind] = mailTemplate{
+				subject: template.Must(template.New(name + "/subject").Parse(text[0])),
+				body:    template.Must(template.New(name + "/body").Parse(text[1])),
+			}
+		}
+	}
+	return result
+}
+
+// 按语言渲染邮件主题和正文
+func renderMail(lang, kind string, data noticeData) (string, string, error) {
+	tpl, ok := mailTemplates[lang][kind]
+	if !ok {
+		return "", "", fmt.Errorf("unknown mail template %s/%s", lang, kind)
+	}
+	var subject, body bytes.Buffer
+	if err := tpl.subject.Execute(&subject, data); err != nil {
+		return "", "", err
+	}
+	if err := tpl.body.Execute(&body, data); err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(subject.String()), body.String(), nil
+}
+
+// 用户的通知偏好，没有记录时返回默认值
+// 生成 MIME 邮件：纯文本正文，可选 iCalendar 附件
+func buildMailMessage(from, to string, msg NotificationMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	host := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		// 编码非 ASCII 的显示名
+		from = addr.String()
+		if at := strings.LastIndex(addr.Address, "@"); at >= 0 {
+			host = addr.Address[at+1:]
+		}
+	}
+	header := []string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: " + mime.BEncoding.Encode("UTF-8", msg.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		fmt.Sprintf("Message-ID: <mail-%d-%d@%s>", msg.ID, time.Now().UnixNano(), host),
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/mixed; boundary="` + mw.Boundary() + `"`,
+	}
+	buf.WriteString(strings.Join(header, "\r\n") + "\r\n\r\n")
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	writeBase64Lines(part, []byte(msg.Body))
+	if msg.Attachment != "" {
+		part, err = mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {`text/calendar; charset=UTF-8; method=` + inviteMethod(msg.Kind) + `; name="invite.ics"`},
+			"Content-Disposition":       {`attachment; filename="invite.ics"`},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64Lines(part, []byte(msg.Attachment))
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 邮件附件的 iTIP 方法：取消通知为 CANCEL，其余为 REQUEST
+func inviteMethod(kind string) string {
+	if kind == NotifyCancellation {
+		return icsMethodCancel
+	}
+	return icsMethodRequest
+}
+
+// base64 编码并按 76 个字符换行
+func writeBase64Lines(w interface{ Write([]byte) (int, error) }, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
+
+// 通过 SMTP 发送一封邮件
+func sendSMTP(cfg mailConfig, to string, message []byte) error {
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return fmt.Errorf("发件人地址无效: %v", err)
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	dialer := &net.Dialer{Timeout: mailTimeout}
+	tlsConfig := &tls.Config{ServerName: cfg.Host}
+	var conn net.Conn
+	if cfg.TLS == "ssl" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(2 * mailTimeout))
+	client, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if cfg.TLS != "ssl" && cfg.TLS != "none" {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		} else if cfg.TLS == "starttls" {
+			return errors.New("SMTP 服务器不支持 STARTTLS")
+		}
+	}
+	if cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// emailNotifier 邮件通知渠道
+type emailNotifier struct{}
+
+func (emailNotifier) channel() string { return ChannelEmail }
+
+func (emailNotifier) enabled() bool { return mailSettings.enabled() }
+
+func (emailNotifier) compose(n notice, user User, lang string, data noticeData) (NotificationMessage, bool) {
+	if user.Email == "" {
+		return NotificationMessage{}, false
+	}
+	subject, body, err := renderMail(lang, n.Kind, data)
+	if err != nil {
+		return NotificationMessage{}, false
+	}
+	return NotificationMessage{To: user.Email, Subject: subject, Body: body, Attachment: n.Attachment}, true
+}
+
+func (emailNotifier) validRecipient(to string) bool {
+	_, err := mail.ParseAddress(to)
+	return err == nil
+}
+
+func (emailNotifier) send(msg NotificationMessage) error {
+	message, err := buildMailMessage(mailSettings.From, msg.To, msg)
+	if err != nil {
+		return err
+	}
+	return sendSMTP(mailSettings, msg.To, message)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpStub 进程内的 SMTP 服务，收到的邮件写入 mails
+type smtpStub struct {
+	host  string
+	port  int
+	mails chan smtpMail
+}
+
+type smtpMail struct {
+	from string
+	to   []string
+	data []byte
+}
+
+func startSMTPStub(t *testing.T) *smtpStub {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	addr := ln.Addr().(*net.TCPAddr)
+	s := &smtpStub{host: addr.IP.String(), port: addr.Port, mails: make(chan smtpMail, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	tc := textproto.NewConn(conn)
+	tc.PrintfLine("220 stub ESMTP")
+	var m smtpMail
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			tc.PrintfLine("250-stub")
+			tc.PrintfLine("250 8BITMIME")
+		case "MAIL":
+			m = smtpMail{from: smtpPath(line)}
+			tc.PrintfLine("250 OK")
+		case "RCPT":
+			m.to = append(m.to, smtpPath(line))
+			tc.PrintfLine("250 OK")
+		case "DATA":
+			tc.PrintfLine("354 end with .")
+			if m.data, err = tc.ReadDotBytes(); err != nil {
+				return
+			}
+			s.mails <- m
+			tc.PrintfLine("250 OK")
+		case "QUIT":
+			tc.PrintfLine("221 bye")
+			return
+		default:
+			tc.PrintfLine("250 OK")
+		}
+	}
+}
+
+// MAIL FROM:<a@b> 中的地址
+func smtpPath(line string) string {
+	start, end := strings.Index(line, "<"), strings.LastIndex(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+func (s *smtpStub) receive(t *testing.T) smtpMail {
+	t.Helper()
+	select {
+	case m := <-s.mails:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("未收到邮件")
+		return smtpMail{}
+	}
+}
+
+// 解析后的邮件：解码后的头部、正文和日历附件
+type parsedMail struct {
+	header         mail.Header
+	subject        string
+	body           string
+	calendar       string
+	calendarHeader textproto.MIMEHeader
+}
+
+func parseMail(t *testing.T, data []byte) parsedMail {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := parsedMail{header: msg.Header}
+	if p.subject, err = new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q", msg.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc := part.Header.Get("Content-Transfer-Encoding"); enc != "base64" {
+			t.Fatalf("Content-Transfer-Encoding = %q，应为 base64", enc)
+		}
+		content, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case strings.HasPrefix(part.Header.Get("Content-Type"), "text/plain"):
+			p.body = string(content)
+		case strings.HasPrefix(part.Header.Get("Content-Type"), "text/calendar"):
+			p.calendar = string(content)
+			p.calendarHeader = part.Header
+		}
+	}
+	return p
+}
+
+func TestEmailNotificationDelivery(t *testing.T) {
+	tx := setupTestDB(t)
+	stub := startSMTPStub(t)
+	oldSettings := mailSettings
+	mailSettings = mailConfig{Host: stub.host, Port: stub.port, From: "会议室预订 <noreply@example.com>", TLS: "none"}
+	t.Cleanup(func() { mailSettings = oldSettings })
+
+	organizer := User{Username: "alice", Nickname: "张三", Email: "alice@example.com", Role: "user"}
+	guest := User{Username: "bob", Nickname: "Bob", Email: "bob@example.com", Role: "user", TimeZone: "Europe/London"}
+	room := Room{Name: "A101", Capacity: 8, TimeZone: "Asia/Shanghai"}
+	for _, v := range []interface{}{&organizer, &guest, &room} {
+		if err := tx.Create(v).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Create(&NotificationPreference{UserID: guest.ID, Language: "en"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2030, 5, 6, 2, 0, 0, 0, time.UTC)
+	booking := Booking{RoomID: room.ID, UserID: organizer.ID, StartTime: start, EndTime: start.Add(time.Hour), Reason: "周会, 季度复盘", Status: BookingStatusActive}
+	if err := tx.Create(&booking).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Create(&BookingParticipant{BookingID: booking.ID, UserID: guest.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 发送队列中的邮件，按收件人返回解析结果
+	deliver := func(kind string) map[string]parsedMail {
+		t.Helper()
+		var msgs []NotificationMessage
+		tx.Where("channel = ? AND kind = ? AND status = ?", ChannelEmail, kind, DeliveryPending).Order("id").Find(&msgs)
+		if len(msgs) != 2 {
+			t.Fatalf("%s 通知 %d 封，应为 2 封", kind, len(msgs))
+		}
+		result := make(map[string]parsedMail)
+		for i := range msgs {
+			deliverNotification(&msgs[i])
+			if msgs[i].Status != DeliverySucceeded {
+				t.Fatalf("发送给 %s 的状态为 %s：%s", msgs[i].To, msgs[i].Status, msgs[i].Error)
+			}
+			m := stub.receive(t)
+			if m.from != "noreply@example.com" || len(m.to) != 1 || m.to[0] != msgs[i].To {
+				t.Fatalf("信封 MAIL FROM %q RCPT TO %v，应为 noreply@example.com -> %s", m.from, m.to, msgs[i].To)
+			}
+			result[msgs[i].To] = parseMail(t, m.data)
+		}
+		return result
+	}
+
+	notifyBookings(tx, NotifyConfirmation, []Booking{booking}, 0)
+	mails := deliver(NotifyConfirmation)
+
+	zh := mails["alice@example.com"]
+	from, err := mail.ParseAddress(zh.header.Get("From"))
+	if err != nil || from.Name != "会议室预订" || from.Address != "noreply@example.com" {
+		t.Errorf("From = %q", zh.header.Get("From"))
+	}
+	if to := zh.header.Get("To"); to != "alice@example.com" {
+		t.Errorf("To = %q", to)
+	}
+	if zh.header.Get("Message-ID") == "" || zh.header.Get("Date") == "" || zh.header.Get("MIME-Version") != "1.0" {
+		t.Errorf("缺少 Message-ID、Date 或 MIME-Version：%v", zh.header)
+	}
+	if zh.subject != "[会议室预订] 预订成功：周会, 季度复盘" {
+		t.Errorf("中文主题 = %q", zh.subject)
+	}
+	for _, want := range []string{"张三，您好", "您的会议室预订已确认", "会议室：A101", "时间：2030-05-06 10:00 - 2030-05-06 11:00（Asia/Shanghai）"} {
+		if !strings.Contains(zh.body, want) {
+			t.Errorf("中文正文缺少 %q：\n%s", want, zh.body)
+		}
+	}
+
+	en := mails["bob@example.com"]
+	if en.subject != "[Meeting Room] Booking confirmed: 周会, 季度复盘" {
+		t.Errorf("英文主题 = %q", en.subject)
+	}
+	for _, want := range []string{"Hello Bob,", "张三 has invited you to a meeting.", "Room: A101", "Time: 2030-05-06 03:00 - 2030-05-06 04:00 (Europe/London)"} {
+		if !strings.Contains(en.body, want) {
+			t.Errorf("英文正文缺少 %q：\n%s", want, en.body)
+		}
+	}
+
+	for to, m := range mails {
+		if ct := m.calendarHeader.Get("Content-Type"); ct != `text/calendar; charset=UTF-8; method=REQUEST; name="invite.ics"` {
+			t.Errorf("%s 附件 Content-Type = %q", to, ct)
+		}
+		if cd := m.calendarHeader.Get("Content-Disposition"); cd != `attachment; filename="invite.ics"` {
+			t.Errorf("%s 附件 Content-Disposition = %q", to, cd)
+		}
+		for _, want := range []string{
+			"BEGIN:VCALENDAR\r\n",
+			"METHOD:REQUEST\r\n",
+			"UID:booking-" + strconv.Itoa(int(booking.ID)) + "@meeting-room\r\n",
+			"DTSTART:20300506T020000Z\r\n",
+			"DTEND:20300506T030000Z\r\n",
+			`SUMMARY:周会\, 季度复盘` + "\r\n",
+			`ORGANIZER;CN="张三":mailto:alice@example.com` + "\r\n",
+			"STATUS:CONFIRMED\r\n",
+			"END:VCALENDAR\r\n",
+		} {
+			if !strings.Contains(m.calendar, want) {
+				t.Errorf("%s 附件缺少 %q：\n%s", to, want, m.calendar)
+			}
+		}
+	}
+
+	// 取消通知的附件使用 METHOD:CANCEL
+	now := time.Now().UTC()
+	booking.Status = BookingStatusCancelled
+	booking.CancelledAt = &now
+	booking.Sequence++
+	if err := tx.Save(&booking).Error; err != nil {
+		t.Fatal(err)
+	}
+	notifyBookings(tx, NotifyCancellation, []Booking{booking}, 0)
+	for to, m := range deliver(NotifyCancellation) {
+		if ct := m.calendarHeader.Get("Content-Type"); !strings.Contains(ct, "method=CANCEL;") {
+			t.Errorf("%s 取消通知附件 Content-Type = %q", to, ct)
+		}
+		for _, want := range []string{"METHOD:CANCEL\r\n", "SEQUENCE:1\r\n", "STATUS:CANCELLED\r\n"} {
+			if !strings.Contains(m.calendar, want) {
+				t.Errorf("%s 取消通知附件缺少 %q：\n%s", to, want, m.calendar)
+			}
+		}
+	}
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/mail"
 	"os"
+	"strconv"
 	"time"
 	"encoding/json"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"github.com/dgrijalva/jwt-go"
 	_ "metting-room-backend/docs"
@@ -24,6 +29,11 @@ type User struct {
 	Password string `json:"-"`
 	Role     string `json:"role"` // admin or user
 	Nickname string `json:"nickname"`
+	Group    string `gorm:"index" json:"group"` // 用户组，用于配额
+	TimeZone string `json:"time_zone"`          // 偏好时区（IANA），为空使用服务器时区
+	Email    string `gorm:"index" json:"email"` // 联系邮箱，SSO 用户自动填充
+	// DooTask 用户ID，通过 DooTask 插件登录时自动填充，用于发送机器人消息
+	DooTaskUserID string `gorm:"index" json:"dootask_userid,omitempty"`
 }
 
 // SystemSettings 系统设置
@@ -35,26 +45,98 @@ type SystemSettings struct {
 	AllowUserChangePassword bool `gorm:"column:allow_user_change_password" json:"allow_user_change_password"`
 	AutoLogin               bool `gorm:"column:auto_login" json:"autoLogin"`
 	AllowRegister           bool `gorm:"column:allow_register" json:"allowRegister"`
+	// 全局预订配额，0 表示不限制
+	QuotaHoursPerWeek      float64 `json:"quota_hours_per_week"`
+	QuotaMaxActiveBookings int     `json:"quota_max_active_bookings"`
+	QuotaMaxSeriesLength   int     `json:"quota_max_series_length"`
+	// 新建预订的默认可见性，public 或 private
+	DefaultBookingVisibility string `gorm:"default:public" json:"default_booking_visibility"`
+	// 会议提醒的提前量（分钟），逗号分隔，为空表示不提醒
+	ReminderLeadMinutes string `gorm:"default:'15,1440'" json:"reminder_lead_minutes"`
+	// 审计日志保留天数，0 表示永久保留
+	AuditRetentionDays int `gorm:"default:180" json:"audit_retention_days"`
+}
+
+// 获取系统设置，不存在时创建默认设置
+func loadSystemSettings() (SystemSettings, error) {
+	return loadSystemSettingsTx(db)
+}
+
+// 在事务 tx 中获取系统设置，事务内须使用此函数，避免 SQLite 上另开连接等待事务释放锁
+func loadSystemSettingsTx(tx *gorm.DB) (SystemSettings, error) {
+	var settings SystemSettings
+	err := tx.First(&settings).Error
+	if err == gorm.ErrRecordNotFound {
+		settings = SystemSettings{AllowUserChangePassword: true, AllowRegister: true, AuditRetentionDays: defaultAuditRetentionDays}
+		err = tx.Create(&settings).Error
+	}
+	return settings, err
 }
 
 type Room struct {
 	ID       uint   `gorm:"primaryKey" json:"id"`
 	Name     string `gorm:"unique" json:"name"`
 	Capacity int    `json:"capacity"`
-	Status   string `json:"status"` // 新增
+	Status   string `json:"status"`    // 新增
+	TimeZone string `json:"time_zone"` // IANA 时区，如 Asia/Shanghai，为空使用服务器时区
+	// 开放时间（会议室当地时间 HH:MM），为空表示不限制
+	OpenTime  string `json:"open_time"`
+	CloseTime string `json:"close_time"`
+	// 位置层级：园区 -> 楼宇 -> 楼层 -> 会议室
+	Site        string `gorm:"index" json:"site"`
+	Building    string `gorm:"index" json:"building"`
+	Floor       string `gorm:"index" json:"floor"`
+	Description string `json:"description"`
+	// 设施标签和照片分别存储在 RoomAmenity、RoomPhoto 中
+	Amenities []string    `gorm:"-" json:"amenities"`
+	Photos    []RoomPhoto `gorm:"-" json:"photos"`
 }
 
+// 预订状态
+const (
+	BookingStatusActive    = "active"
+	BookingStatusCancelled = "cancelled"
+)
+
 type Booking struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	RoomID    uint      `json:"room_id"`
-	UserID    uint      `json:"user_id"`
-	StartTime time.Time `json:"start_time"`
-	EndTime   time.Time `json:"end_time"`
-	Reason    string    `json:"reason"`
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	RoomID       uint       `json:"room_id"`
+	UserID       uint       `json:"user_id"`
+	StartTime    time.Time  `json:"start_time"`
+	EndTime      time.Time  `json:"end_time"`
+	Reason       string     `json:"reason"`
+	Status       string     `gorm:"default:active;index" json:"status"` // active 或 cancelled
+	CancelledAt  *time.Time `json:"cancelled_at,omitempty"`
+	CancelledBy  *uint      `json:"cancelled_by,omitempty"`
+	CancelReason string     `json:"cancel_reason,omitempty"`
+	Visibility   string     `gorm:"default:public" json:"visibility"` // public 或 private
+	// 参与人ID，存储在 BookingParticipant 中，仅创建时返回
+	ParticipantIDs []uint `gorm:"-" json:"participant_ids,omitempty"`
+	SeriesID       *uint  `gorm:"index" json:"series_id,omitempty"` // 所属周期预订
+	// 周期中本次会议按规则计算的开始时间，对应 iCalendar 的 RECURRENCE-ID
+	RecurrenceID *time.Time `json:"recurrence_id,omitempty"`
+	// 每次修改时递增，用于日历订阅的 SEQUENCE
+	Sequence  int       `json:"sequence"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// 在会议室门口平板上签到的时间
+	CheckedInAt *time.Time `json:"checked_in_at,omitempty"`
+	// 会议室时区及当地时间，仅用于返回
+	TimeZone       string `gorm:"-" json:"time_zone,omitempty"`
+	StartTimeLocal string `gorm:"-" json:"start_time_local,omitempty"`
+	EndTimeLocal   string `gorm:"-" json:"end_time_local,omitempty"`
 }
 
 var db *gorm.DB
-var jwtKey = []byte("secret_key")
+
+// 数据目录，存放数据库和上传文件
+var dataDir string
+
+// 登录令牌的签名密钥和有效期，启动时由配置设置
+var (
+	jwtKey   []byte
+	tokenTTL time.Duration
+)
 
 type Claims struct {
 	UserID   uint   `json:"user_id"`
@@ -82,13 +164,23 @@ type SSORequest struct {
 	Identity interface{} `json:"identity"`
 	Role     string      `json:"role"`     // 添加直接支持role字段
 	Nickname string      `json:"nickname"`
+	UserID   interface{} `json:"userid"` // DooTask 用户ID
 }
 
 // 添加会议室请求体
 type AddRoomRequest struct {
-	Name     string `json:"name" binding:"required"`
-	Capacity int    `json:"capacity" binding:"required"`
-	Status   string `json:"status"` // 新增
+	Name      string `json:"name" binding:"required"`
+	Capacity  int    `json:"capacity" binding:"required"`
+	Status    string `json:"status"` // 新增
+	TimeZone  string `json:"time_zone"`
+	OpenTime  string `json:"open_time"`
+	CloseTime string `json:"close_time"`
+	// 位置、描述和设施标签
+	Site        string   `json:"site"`
+	Building    string   `json:"building"`
+	Floor       string   `json:"floor"`
+	Description string   `json:"description"`
+	Amenities   []string `json:"amenities"`
 }
 
 // 预订会议室请求体
@@ -97,6 +189,12 @@ type BookRoomRequest struct {
 	StartTime time.Time `json:"start_time" binding:"required"`
 	EndTime   time.Time `json:"end_time" binding:"required"`
 	Reason    string    `json:"reason"`
+	// 可见性，public 或 private，留空使用系统默认值
+	Visibility string `json:"visibility"`
+	// 参与人ID，参与人可以查看私密预订详情
+	ParticipantIDs []uint `json:"participant_ids"`
+	// 周期规则，为空表示单次预订
+	Recurrence *RecurrenceRule `json:"recurrence"`
 }
 
 // 编辑会议室请求体
@@ -104,23 +202,48 @@ type EditRoomRequest struct {
 	Name     string `json:"name"`
 	Capacity int    `json:"capacity"`
 	Status   string `json:"status"` // 新增
+	// 以下字段未传递时保持原值，传空字符串表示清除
+	TimeZone    *string `json:"time_zone"`
+	OpenTime    *string `json:"open_time"`
+	CloseTime   *string `json:"close_time"`
+	Site        *string `json:"site"`
+	Building    *string `json:"building"`
+	Floor       *string `json:"floor"`
+	Description *string `json:"description"`
+	// 传递时整体替换设施标签
+	Amenities *[]string `json:"amenities"`
 }
 
 // UpdateProfileRequest for updating user's profile
 type UpdateProfileRequest struct {
-	Nickname string `json:"nickname" binding:"required"`
+	Nickname string  `json:"nickname" binding:"required"`
+	TimeZone *string `json:"time_zone"` // 可选，未传递时保持原值
+	Email    *string `json:"email"`     // 可选，未传递时保持原值
+}
+
+// 取消预订请求体
+type CancelBookingRequest struct {
+	Reason string `json:"reason"`
 }
 
 // BookingDetail 包含预订、用户和会议室的详细信息
 type BookingDetail struct {
-	ID        uint      `json:"id"`
-	RoomID    uint      `json:"room_id"`
-	UserID    uint      `json:"user_id"`
-	StartTime time.Time `json:"start_time"`
-	EndTime   time.Time `json:"end_time"`
-	Username  string    `json:"username"`
-	RoomName  string    `json:"room_name"`
-	Reason    string    `json:"reason"`
+	ID           uint       `json:"id"`
+	RoomID       uint       `json:"room_id"`
+	UserID       uint       `json:"user_id"`
+	StartTime    time.Time  `json:"start_time"`
+	EndTime      time.Time  `json:"end_time"`
+	Username     string     `json:"username"`
+	RoomName     string     `json:"room_name"`
+	Reason       string     `json:"reason"`
+	Status       string     `json:"status"`
+	CancelledAt  *time.Time `json:"cancelled_at,omitempty"`
+	CancelledBy  *uint      `json:"cancelled_by,omitempty"`
+	CancelReason string     `json:"cancel_reason,omitempty"`
+	// 会议室时区及当地时间
+	TimeZone       string `json:"time_zone"`
+	StartTimeLocal string `json:"start_time_local"`
+	EndTimeLocal   string `json:"end_time_local"`
 }
 
 // 修改密码请求体
@@ -140,6 +263,13 @@ type UpdateSystemSettingsRequest struct {
 	AllowUserChangePassword bool `json:"allow_user_change_password"`
 	AutoLogin               bool `json:"autoLogin"`
 	AllowRegister           bool `json:"allowRegister"` // 新增
+	// 配额字段可选，未传递时保持原值
+	QuotaHoursPerWeek        *float64 `json:"quota_hours_per_week"`
+	QuotaMaxActiveBookings   *int     `json:"quota_max_active_bookings"`
+	QuotaMaxSeriesLength     *int     `json:"quota_max_series_length"`
+	DefaultBookingVisibility *string  `json:"default_booking_visibility"`
+	ReminderLeadMinutes      *string  `json:"reminder_lead_minutes"` // 如 "15,1440"
+	AuditRetentionDays       *int     `json:"audit_retention_days"`  // 0 表示永久保留
 }
 
 // 新增：管理员修改用户角色请求体
@@ -176,7 +306,7 @@ func loginHandler(c *gin.Context) {
 		return
 	}
 	// 生成JWT
-	expirationTime := time.Now().Add(24 * time.Hour)
+	expirationTime := time.Now().Add(tokenTTL)
 	claims := &Claims{
 		UserID:   user.ID,
 		Username: user.Username,
@@ -237,13 +367,22 @@ func registerHandler(c *gin.Context) {
 		Role:     "user",      // 新增：注册用户默认角色为user
 	}
 	
-	if err := db.Create(&user).Error; err != nil {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		publishEvent(tx, EventUserCreated, userEventData(user))
+		auditAs(c, user)
+		recordAudit(tx, c, "user.register", "user", user.ID, nil, user)
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "注册失败"})
 		return
 	}
 	
 	// 生成JWT
-	expirationTime := time.Now().Add(24 * time.Hour)
+	expirationTime := time.Now().Add(tokenTTL)
 	claims := &Claims{
 		UserID:   user.ID,
 		Username: user.Username,
@@ -342,12 +481,23 @@ func ssoHandler(c *gin.Context) {
 				nickname = req.Email
 			}
 			newUser := User{
-				Username: req.Email,
-				Password: req.Email, // 默认使用 email作为密码
-				Role:     role,
-				Nickname: nickname,
+				Username:      req.Email,
+				Password:      req.Email, // 默认使用 email作为密码
+				Role:          role,
+				Nickname:      nickname,
+				Email:         req.Email,
+				DooTaskUserID: dooTaskUserID(req.UserID),
 			}
-			if err := db.Create(&newUser).Error; err != nil {
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Create(&newUser).Error; err != nil {
+					return err
+				}
+				publishEvent(tx, EventUserCreated, userEventData(newUser))
+				auditAs(c, newUser)
+				recordAudit(tx, c, "user.sso_register", "user", newUser.ID, nil, newUser)
+				return nil
+			})
+			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "用户注册失败"})
 				return
 			}
@@ -362,11 +512,21 @@ func ssoHandler(c *gin.Context) {
 			user.Nickname = req.Nickname
 			db.Save(&user)
 		}
+		// 补全升级前创建的 SSO 用户的邮箱
+		if user.Email == "" {
+			user.Email = req.Email
+			db.Model(&user).Update("email", user.Email)
+		}
+		// 同步 DooTask 用户ID
+		if id := dooTaskUserID(req.UserID); id != "" && user.DooTaskUserID != id {
+			user.DooTaskUserID = id
+			db.Model(&user).Update("doo_task_user_id", id)
+		}
 		// 不再覆盖 user.Role
 	}
 
 	// 为用户生成JWT
-	expirationTime := time.Now().Add(24 * time.Hour)
+	expirationTime := time.Now().Add(tokenTTL)
 	claims := &Claims{
 		UserID:   user.ID,
 		Username: user.Username,
@@ -399,11 +559,8 @@ func AuthMiddleware() gin.HandlerFunc {
 		if strings.HasPrefix(tokenString, "Bearer ") {
 			tokenString = strings.TrimPrefix(tokenString, "Bearer ")
 		}
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-		if err != nil || !token.Valid {
+		claims, err := parseJWT(tokenString)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "token无效或已过期"})
 			c.Abort()
 			return
@@ -420,10 +577,26 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Set("username", user.Username)
 		c.Set("role", user.Role)
 		c.Set("nickname", user.Nickname)
+		c.Set("time_zone", user.TimeZone)
 		c.Next()
 	}
 }
 
+// 校验 JWT 并返回其中的声明
+func parseJWT(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
 // 管理员权限中间件
 func AdminMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -437,6 +610,57 @@ func AdminMiddleware() gin.HandlerFunc {
 	}
 }
 
+// 从上下文中获取当前用户ID，失败时直接写入错误响应
+func currentUserID(c *gin.Context) (uint, bool) {
+	val, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "无法获取用户信息"})
+		return 0, false
+	}
+	switch v := val.(type) {
+	case float64:
+		return uint(v), true
+	case uint:
+		return v, true
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "用户ID格式无效"})
+		return 0, false
+	}
+}
+
+// 生成 n 字节随机数的十六进制字符串，用于令牌和文件名
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 检查会议室在指定时间段内是否已有预订，excludeID 非 0 时不计入该预订（修改已有预订时为其自身）
+func hasBookingConflict(tx *gorm.DB, roomID uint, start, end time.Time, excludeID uint) bool {
+	var count int64
+	q := tx.Model(&Booking{}).Scopes(activeBookings).Where("room_id = ? AND end_time > ? AND start_time < ?", roomID, start, end)
+	if excludeID != 0 {
+		q = q.Where("id <> ?", excludeID)
+	}
+	q.Count(&count)
+	return count > 0
+}
+
+// 只保留未取消的预订
+func activeBookings(tx *gorm.DB) *gorm.DB {
+	return tx.Where("status <> ?", BookingStatusCancelled)
+}
+
+// 根据 include_cancelled 参数决定是否包含已取消的预订
+func bookingStatusScope(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	if c.Query("include_cancelled") == "true" {
+		return func(tx *gorm.DB) *gorm.DB { return tx }
+	}
+	return activeBookings
+}
+
 // @Summary 添加会议室
 // @Description 管理员添加会议室
 // @Tags 会议室
@@ -452,8 +676,41 @@ func addRoomHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
 		return
 	}
-	room := Room{Name: req.Name, Capacity: req.Capacity, Status: req.Status} // 新增 Status
-	if err := db.Create(&room).Error; err != nil {
+	if !validTimeZone(req.TimeZone) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "时区无效"})
+		return
+	}
+	if !validBusinessHours(req.OpenTime, req.CloseTime) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "开放时间无效"})
+		return
+	}
+	room := Room{
+		Name:        req.Name,
+		Capacity:    req.Capacity,
+		Status:      req.Status, // 新增 Status
+		TimeZone:    req.TimeZone,
+		OpenTime:    req.OpenTime,
+		CloseTime:   req.CloseTime,
+		Site:        strings.TrimSpace(req.Site),
+		Building:    strings.TrimSpace(req.Building),
+		Floor:       strings.TrimSpace(req.Floor),
+		Description: req.Description,
+	}
+	amenities := normalizeAmenities(req.Amenities)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&room).Error; err != nil {
+			return err
+		}
+		if err := setRoomAmenities(tx, room.ID, amenities); err != nil {
+			return err
+		}
+		room.Amenities = amenities
+		room.Photos = []RoomPhoto{}
+		publishEvent(tx, EventRoomCreated, roomEventData(tx, room))
+		recordAudit(tx, c, "room.create", "room", room.ID, nil, room)
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "会议室已存在或参数错误"})
 		return
 	}
@@ -461,20 +718,68 @@ func addRoomHandler(c *gin.Context) {
 }
 
 // @Summary 查询会议室列表
-// @Description 查询所有会议室
+// @Description 查询会议室，支持按位置、容量和设施筛选及分页；不传 page 时返回全部
 // @Tags 会议室
 // @Produce json
+// @Param site query string false "园区"
+// @Param building query string false "楼宇"
+// @Param floor query string false "楼层"
+// @Param min_capacity query int false "最小容量"
+// @Param max_capacity query int false "最大容量"
+// @Param amenities query string false "必须具备的设施，逗号分隔"
+// @Param page query int false "页码，从1开始"
+// @Param page_size query int false "每页数量，默认20，最大100"
 // @Success 200 {object} map[string]interface{}
 // @Security Bearer
 // @Router /api/rooms [get]
 func listRoomsHandler(c *gin.Context) {
+	query := db.Model(&Room{})
+	for _, field := range []string{"site", "building", "floor"} {
+		if v := c.Query(field); v != "" {
+			query = query.Where(field+" = ?", v)
+		}
+	}
+	if v, err := strconv.Atoi(c.Query("min_capacity")); err == nil {
+		query = query.Where("capacity >= ?", v)
+	}
+	if v, err := strconv.Atoi(c.Query("max_capacity")); err == nil {
+		query = query.Where("capacity <= ?", v)
+	}
+	if v := c.Query("amenities"); v != "" {
+		required := normalizeAmenities(strings.Split(v, ","))
+		if len(required) > 0 {
+			sub := db.Model(&RoomAmenity{}).Select("room_id").Where("name IN ?", required).
+				Group("room_id").Having("COUNT(DISTINCT name) = ?", len(required))
+			query = query.Where("id IN (?)", sub)
+		}
+	}
+
+	var total int64
+	query.Count(&total)
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if page > 0 {
+		if pageSize <= 0 {
+			pageSize = 20
+		}
+		if pageSize > 100 {
+			pageSize = 100
+		}
+		query = query.Offset((page - 1) * pageSize).Limit(pageSize)
+	}
+
 	var rooms []Room
-	db.Find(&rooms)
-	c.JSON(http.StatusOK, gin.H{"rooms": rooms})
+	query.Order("id").Find(&rooms)
+	if rooms == nil {
+		rooms = make([]Room, 0)
+	}
+	loadRoomDetails(db, rooms)
+	c.JSON(http.StatusOK, gin.H{"rooms": rooms, "total": total, "page": page, "page_size": pageSize})
 }
 
 // @Summary 预订会议室
-// @Description 用户预订会议室
+// @Description 用户预订会议室，指定 recurrence 时按会议室时区创建周期预订
 // @Tags 预订
 // @Accept json
 // @Produce json
@@ -499,80 +804,165 @@ func bookRoomHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "会议室不存在"})
 		return
 	}
-	// 检查时间冲突
-	var count int64
-	db.Model(&Booking{}).Where("room_id = ? AND end_time > ? AND start_time < ?", req.RoomID, req.StartTime, req.EndTime).Count(&count)
-	if count > 0 {
-		c.JSON(http.StatusConflict, gin.H{"error": "该时间段已被预订"})
+	userID, ok := currentUserID(c)
+	if !ok {
 		return
 	}
-	val, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "无法获取用户信息"})
+	// 展开预订时间段，周期预订按会议室时区计算
+	slots := []timeSlot{{Start: req.StartTime.UTC(), End: req.EndTime.UTC()}}
+	if req.Recurrence != nil {
+		var err error
+		slots, err = expandRecurrence(*req.Recurrence, req.StartTime, req.EndTime, room.location())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	visibility, err := resolveVisibility(db, req.Visibility)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "可见性参数无效"})
 		return
 	}
-	var userID uint
-	switch v := val.(type) {
-	case float64:
-		userID = uint(v)
-	case uint:
-		userID = v
-	default:
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "用户ID格式无效"})
+	participants, err := normalizeParticipants(db, userID, req.ParticipantIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参与人不存在"})
 		return
 	}
-	booking := Booking{
-		RoomID:    req.RoomID,
-		UserID:    userID,
-		StartTime: req.StartTime,
-		EndTime:   req.EndTime,
-		Reason:    req.Reason,
+
+	var bookings []Booking
+	var series *BookingSeries
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// 检查开放时间、时间冲突、临时占用和预订配额
+		if err := checkBookingSlots(tx, room, userID, 0, slots); err != nil {
+			return err
+		}
+		var err error
+		bookings, series, err = createBookings(tx, NewBooking{
+			Room:           room,
+			UserID:         userID,
+			Slots:          slots,
+			Reason:         req.Reason,
+			Visibility:     visibility,
+			ParticipantIDs: participants,
+			Recurrence:     req.Recurrence,
+		})
+		if err != nil {
+			return err
+		}
+		for _, b := range bookings {
+			recordAudit(tx, c, "booking.create", "booking", b.ID, nil, b)
+		}
+		notifyBookings(tx, NotifyConfirmation, bookings, 0)
+		return nil
+	})
+	if err != nil {
+		writeBookingError(c, err, "预订失败")
+		return
 	}
-	if err := db.Create(&booking).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "预订失败"})
+	localizeBookings(db, bookings)
+	if series != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "预订成功", "booking": bookings[0], "bookings": bookings, "series": series})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "预订成功", "booking": booking})
+	c.JSON(http.StatusOK, gin.H{"message": "预订成功", "booking": bookings[0]})
+}
+
+// 预订列表允许排序的字段
+var bookingSortColumns = map[string]string{
+	"id":         "bookings.id",
+	"start_time": "bookings.start_time",
+	"end_time":   "bookings.end_time",
+	"created_at": "bookings.created_at",
 }
 
 // @Summary 查询所有预订
-// @Description 查询所有会议室的预订记录
+// @Description 查询所有会议室的预订记录，支持筛选、排序及分页，默认返回第 1 页
 // @Tags 预订
 // @Produce json
 // @Param room_id query int false "会议室ID"
+// @Param user_id query int false "预订人ID"
 // @Param start_time query string false "开始时间(ISO8601)"
 // @Param end_time query string false "结束时间(ISO8601)"
+// @Param date query string false "当地日期(YYYY-MM-DD)，按会议室或用户时区计算当天范围"
+// @Param tz query string false "解释不带时区的时间参数所用的时区"
+// @Param q query string false "事由关键字"
+// @Param status query string false "active 或 cancelled"
+// @Param include_cancelled query bool false "未指定 status 时是否包含已取消的预订"
+// @Param sort query string false "排序字段：start_time（默认）、end_time、created_at、id，加 - 前缀表示降序"
+// @Param page query int false "页码，从1开始"
+// @Param page_size query int false "每页数量，默认20，最大100"
 // @Success 200 {object} map[string]interface{}
 // @Security Bearer
 // @Router /api/bookings [get]
 func listBookingsHandler(c *gin.Context) {
-	roomID := c.Query("room_id")
-	start := c.Query("start_time")
-	end := c.Query("end_time")
+	// 不带时区的时间参数按会议室时区解释，未指定会议室时使用用户偏好时区
+	tz, _ := c.Get("time_zone")
+	loc := loadLocation(fmt.Sprint(tz))
+	if roomID := c.Query("room_id"); roomID != "" {
+		var room Room
+		if err := db.First(&room, roomID).Error; err == nil {
+			loc = room.location()
+		}
+	}
+	if name := c.Query("tz"); name != "" {
+		if !validTimeZone(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时区无效"})
+			return
+		}
+		loc = loadLocation(name)
+	}
+	filter, msg := parseBookingFilter(c, loc)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	order, ok := parseSort(c, bookingSortColumns, "start_time")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "排序字段无效"})
+		return
+	}
+	page, pageSize := parsePage(c)
 
+	userID, _ := currentUserID(c)
+	role, _ := c.Get("role")
+	query := filter.restrictPrivate(filter.apply(db.Model(&Booking{})), userID, role).Session(&gorm.Session{})
+	var total int64
+	query.Count(&total)
 	var bookings []Booking
-	query := db
-	if roomID != "" {
-		query = query.Where("room_id = ?", roomID)
+	query.Order(order).Order("bookings.id").Scopes(paginate(page, pageSize)).Find(&bookings)
+	if bookings == nil {
+		bookings = make([]Booking, 0)
 	}
-	if start != "" {
-		if t, err := time.Parse(time.RFC3339, start); err == nil {
-			query = query.Where("end_time > ?", t)
-		}
+	// 私密预订对无关用户脱敏
+	redactBookings(db, userID, role, bookings)
+	localizeBookings(db, bookings)
+
+	// 未过期的临时占用以 held 状态一并返回
+	var holds []BookingHold
+	holdQuery := db.Where("expires_at > ?", time.Now().UTC())
+	if filter.roomID != 0 {
+		holdQuery = holdQuery.Where("room_id = ?", filter.roomID)
 	}
-	if end != "" {
-		if t, err := time.Parse(time.RFC3339, end); err == nil {
-			query = query.Where("start_time < ?", t)
-		}
+	if filter.startTime != nil {
+		holdQuery = holdQuery.Where("end_time > ?", *filter.startTime)
 	}
-	query.Find(&bookings)
-	c.JSON(http.StatusOK, gin.H{"bookings": bookings})
+	if filter.endTime != nil {
+		holdQuery = holdQuery.Where("start_time < ?", *filter.endTime)
+	}
+	holdQuery.Find(&holds)
+	if holds == nil {
+		holds = make([]BookingHold, 0)
+	}
+	c.JSON(http.StatusOK, gin.H{"bookings": bookings, "holds": holds, "total": total, "page": page, "page_size": pageSize})
 }
 
 // @Summary 取消预订
-// @Description 取消指定ID的预订
+// @Description 取消指定ID的预订，记录取消人、时间和原因；管理员取消他人预订时必须填写原因
 // @Tags 预订
+// @Accept json
 // @Param id path int true "预订ID"
+// @Param reason query string false "取消原因"
+// @Param data body CancelBookingRequest false "取消参数"
 // @Success 200 {object} map[string]interface{}
 // @Security Bearer
 // @Router /api/bookings/{id} [delete]
@@ -583,19 +973,19 @@ func cancelBookingHandler(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "预订不存在"})
 		return
 	}
-	val, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "无法获取用户信息"})
+	if booking.Status == BookingStatusCancelled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "该预订已取消"})
 		return
 	}
-	var userID uint
-	switch v := val.(type) {
-	case float64:
-		userID = uint(v)
-	case uint:
-		userID = v
-	default:
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "用户ID格式无效"})
+	// 取消原因可通过请求体或查询参数传递
+	var req CancelBookingRequest
+	_ = c.ShouldBindJSON(&req)
+	if req.Reason == "" {
+		req.Reason = c.Query("reason")
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	userID, ok := currentUserID(c)
+	if !ok {
 		return
 	}
 	role, _ := c.Get("role")
@@ -603,86 +993,157 @@ func cancelBookingHandler(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权限取消该预订"})
 		return
 	}
+	if booking.UserID != userID && req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "取消他人的预订必须填写原因"})
+		return
+	}
 	if booking.StartTime.Before(time.Now()) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "已开始的预订无法取消"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "已开始的预订无法取消，可提前结束会议"})
 		return
 	}
-	db.Delete(&booking)
-	c.JSON(http.StatusOK, gin.H{"message": "取消成功"})
+	var room Room
+	db.First(&room, booking.RoomID)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 在事务中重新读取，并发取消时只有一个请求成功
+		var before Booking
+		if err := tx.First(&before, booking.ID).Error; err != nil {
+			return err
+		}
+		booking = before
+		if err := cancelBooking(tx, &booking, userID, req.Reason, time.Now()); err != nil {
+			return err
+		}
+		publishEvent(tx, EventBookingCancelled, bookingEventData(booking, room))
+		recordAudit(tx, c, "booking.cancel", "booking", booking.ID, before, booking)
+		notifyBookings(tx, NotifyCancellation, []Booking{booking}, 0)
+		syncReminders(tx, booking)
+		return nil
+	})
+	if err != nil {
+		writeBookingError(c, err, "取消失败")
+		return
+	}
+	booking.localize(room.location())
+	c.JSON(http.StatusOK, gin.H{"message": "取消成功", "booking": booking})
 }
 
 // @Summary 查询个人预订
 // @Description 查询当前用户的预订记录
 // @Tags 预订
 // @Produce json
+// @Param include_cancelled query bool false "是否包含已取消的预订"
 // @Success 200 {object} map[string]interface{}
 // @Security Bearer
 // @Router /api/mybookings [get]
 func listMyBookingsHandler(c *gin.Context) {
-	val, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "无法获取用户信息"})
-		return
-	}
-	var userID uint
-	switch v := val.(type) {
-	case float64:
-		userID = uint(v)
-	case uint:
-		userID = v
-	default:
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "用户ID格式无效"})
+	userID, ok := currentUserID(c)
+	if !ok {
 		return
 	}
 	var bookings []Booking
-	db.Where("user_id = ?", userID).Find(&bookings)
+	db.Scopes(bookingStatusScope(c)).Where("user_id = ?", userID).Find(&bookings)
+	localizeBookings(db, bookings)
 	c.JSON(http.StatusOK, gin.H{"bookings": bookings})
 }
 
+// 管理员预订列表允许排序的字段，可按会议室名称和预订人排序
+var adminBookingSortColumns = map[string]string{
+	"id":         "bookings.id",
+	"start_time": "bookings.start_time",
+	"end_time":   "bookings.end_time",
+	"created_at": "bookings.created_at",
+	"room_name":  "rooms.name",
+	"username":   "users.username",
+}
+
+// 管理员预订列表的一行，预订与会议室、预订人联表查询得到
+type bookingListRow struct {
+	Booking
+	RoomName     string
+	RoomTimeZone string
+	Username     string
+	Nickname     string
+}
+
 // @Summary (Admin) 查询所有预订
-// @Description 管理员查询所有用户的预订记录
+// @Description 管理员分页查询所有用户的预订记录，支持筛选和排序
 // @Tags 管理员
 // @Produce json
+// @Param room_id query int false "会议室ID"
+// @Param user_id query int false "预订人ID"
+// @Param status query string false "active 或 cancelled，默认只返回有效预订"
+// @Param start_time query string false "开始时间(ISO8601)，返回与时间段重叠的预订"
+// @Param end_time query string false "结束时间(ISO8601)"
+// @Param date query string false "当地日期(YYYY-MM-DD)"
+// @Param tz query string false "解释不带时区的时间参数所用的时区"
+// @Param q query string false "事由关键字"
+// @Param include_cancelled query bool false "未指定 status 时是否包含已取消的预订"
+// @Param sort query string false "排序字段：start_time、end_time、created_at、id、room_name、username，加 - 前缀表示降序，默认 -start_time"
+// @Param page query int false "页码，默认1"
+// @Param page_size query int false "每页数量，默认20，最大100"
 // @Success 200 {object} map[string]interface{}
 // @Security Bearer
 // @Router /api/admin/bookings [get]
 func listAllBookingsHandler(c *gin.Context) {
-	var bookings []Booking
-	if err := db.Order("start_time DESC").Find(&bookings).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询预订列表失败"})
+	loc, ok := requestLocation(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "时区无效"})
 		return
 	}
+	filter, msg := parseBookingFilter(c, loc)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	order, ok := parseSort(c, adminBookingSortColumns, "-start_time")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "排序字段无效"})
+		return
+	}
+	page, pageSize := parsePage(c)
 
-	var details []BookingDetail
-	for _, b := range bookings {
-		var user User
-		db.First(&user, b.UserID) // 查找预订用户
-
-		var room Room
-		db.First(&room, b.RoomID) // 查找会议室
-
-		displayName := user.Nickname
-		if displayName == "" {
-			displayName = user.Username
-		}
+	query := filter.apply(db.Table("bookings")).Session(&gorm.Session{})
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询预订列表失败"})
+		return
+	}
+	var rows []bookingListRow
+	err := query.Select("bookings.*, rooms.name AS room_name, rooms.time_zone AS room_time_zone, users.username, users.nickname").
+		Joins("LEFT JOIN rooms ON rooms.id = bookings.room_id").
+		Joins("LEFT JOIN users ON users.id = bookings.user_id").
+		Order(order).Order("bookings.id DESC").
+		Scopes(paginate(page, pageSize)).
+		Find(&rows).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询预订列表失败"})
+		return
+	}
 
+	details := make([]BookingDetail, 0, len(rows))
+	for _, r := range rows {
+		b := r.Booking
+		b.localize(Room{TimeZone: r.RoomTimeZone}.location())
 		details = append(details, BookingDetail{
-			ID:        b.ID,
-			RoomID:    b.RoomID,
-			UserID:    b.UserID,
-			StartTime: b.StartTime,
-			EndTime:   b.EndTime,
-			Username:  displayName,
-			RoomName:  room.Name,
-			Reason:    b.Reason,
+			ID:             b.ID,
+			RoomID:         b.RoomID,
+			UserID:         b.UserID,
+			StartTime:      b.StartTime,
+			EndTime:        b.EndTime,
+			Username:       displayName(User{Username: r.Username, Nickname: r.Nickname}),
+			RoomName:       r.RoomName,
+			Reason:         b.Reason,
+			Status:         b.Status,
+			CancelledAt:    b.CancelledAt,
+			CancelledBy:    b.CancelledBy,
+			CancelReason:   b.CancelReason,
+			TimeZone:       b.TimeZone,
+			StartTimeLocal: b.StartTimeLocal,
+			EndTimeLocal:   b.EndTimeLocal,
 		})
 	}
 
-	if details == nil {
-		details = make([]BookingDetail, 0)
-	}
-
-	c.JSON(http.StatusOK, gin.H{"bookings": details})
+	c.JSON(http.StatusOK, gin.H{"bookings": details, "total": total, "page": page, "page_size": pageSize})
 }
 
 // @Summary 编辑会议室
@@ -707,6 +1168,8 @@ func editRoomHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
 		return
 	}
+	before := []Room{room}
+	loadRoomDetails(db, before)
 	if req.Name != "" {
 		room.Name = req.Name
 	}
@@ -716,8 +1179,55 @@ func editRoomHandler(c *gin.Context) {
 	if req.Status != "" {
 		room.Status = req.Status
 	}
-	db.Save(&room)
-	c.JSON(http.StatusOK, gin.H{"message": "编辑成功", "room": room})
+	if req.TimeZone != nil {
+		if !validTimeZone(*req.TimeZone) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时区无效"})
+			return
+		}
+		room.TimeZone = *req.TimeZone
+	}
+	if req.OpenTime != nil {
+		room.OpenTime = *req.OpenTime
+	}
+	if req.CloseTime != nil {
+		room.CloseTime = *req.CloseTime
+	}
+	if !validBusinessHours(room.OpenTime, room.CloseTime) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "开放时间无效"})
+		return
+	}
+	if req.Site != nil {
+		room.Site = strings.TrimSpace(*req.Site)
+	}
+	if req.Building != nil {
+		room.Building = strings.TrimSpace(*req.Building)
+	}
+	if req.Floor != nil {
+		room.Floor = strings.TrimSpace(*req.Floor)
+	}
+	if req.Description != nil {
+		room.Description = *req.Description
+	}
+	rooms := []Room{room}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&room).Error; err != nil {
+			return err
+		}
+		if req.Amenities != nil {
+			if err := setRoomAmenities(tx, room.ID, normalizeAmenities(*req.Amenities)); err != nil {
+				return err
+			}
+		}
+		publishEvent(tx, EventRoomUpdated, roomEventData(tx, room))
+		loadRoomDetails(tx, rooms)
+		recordAudit(tx, c, "room.update", "room", room.ID, before[0], rooms[0])
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "会议室已存在或参数错误"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "编辑成功", "room": rooms[0]})
 }
 
 // @Summary 删除会议室
@@ -734,7 +1244,22 @@ func deleteRoomHandler(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "会议室不存在"})
 		return
 	}
-	db.Delete(&room)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteRoomAssets(tx, room.ID); err != nil {
+			return err
+		}
+		if err := tx.Delete(&room).Error; err != nil {
+			return err
+		}
+		publishEvent(tx, EventRoomDeleted, room)
+		recordAudit(tx, c, "room.delete", "room", room.ID, room, nil)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	removeRoomPhotoDir(room.ID)
 	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
 }
 
@@ -754,19 +1279,8 @@ func updateProfileHandler(c *gin.Context) {
 		return
 	}
 
-	val, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "无法获取用户信息"})
-		return
-	}
-	var userID uint
-	switch v := val.(type) {
-	case float64:
-		userID = uint(v)
-	case uint:
-		userID = v
-	default:
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "用户ID格式无效"})
+	userID, ok := currentUserID(c)
+	if !ok {
 		return
 	}
 
@@ -780,14 +1294,39 @@ func updateProfileHandler(c *gin.Context) {
 		return
 	}
 
+	before := user
 	user.Nickname = req.Nickname
-	if err := db.Save(&user).Error; err != nil {
+	if req.TimeZone != nil {
+		if !validTimeZone(*req.TimeZone) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时区无效"})
+			return
+		}
+		user.TimeZone = *req.TimeZone
+	}
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if email != "" {
+			if _, err := mail.ParseAddress(email); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "邮箱格式错误"})
+				return
+			}
+		}
+		user.Email = email
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "user.profile", "user", user.ID, before, user)
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
 		return
 	}
 
 	// 更新后，签发新token，以确保前端信息同步
-	expirationTime := time.Now().Add(24 * time.Hour)
+	expirationTime := time.Now().Add(tokenTTL)
 	claims := &Claims{
 		UserID:   user.ID,
 		Username: user.Username,
@@ -807,10 +1346,12 @@ func updateProfileHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message": "更新成功",
 		"user": gin.H{
-			"user_id":  user.ID,
-			"username": user.Username,
-			"role":     user.Role,
-			"nickname": user.Nickname,
+			"user_id":   user.ID,
+			"username":  user.Username,
+			"role":      user.Role,
+			"nickname":  user.Nickname,
+			"time_zone": user.TimeZone,
+			"email":     user.Email,
 		},
 		"token": tokenString,
 	})
@@ -838,19 +1379,8 @@ func changePasswordHandler(c *gin.Context) {
 		return
 	}
 
-	val, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "无法获取用户信息"})
-		return
-	}
-	var userID uint
-	switch v := val.(type) {
-	case float64:
-		userID = uint(v)
-	case uint:
-		userID = v
-	default:
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "用户ID格式无效"})
+	userID, ok := currentUserID(c)
+	if !ok {
 		return
 	}
 
@@ -890,7 +1420,14 @@ func changePasswordHandler(c *gin.Context) {
 
 	// 更新密码
 	user.Password = req.NewPassword
-	if err := db.Save(&user).Error; err != nil {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "user.password", "user", user.ID, nil, nil)
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "密码更新失败"})
 		return
 	}
@@ -932,7 +1469,14 @@ func adminChangeUserPasswordHandler(c *gin.Context) {
 
 	// 更新密码
 	user.Password = req.NewPassword
-	if err := db.Save(&user).Error; err != nil {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "user.password_reset", "user", user.ID, nil, nil)
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "密码更新失败"})
 		return
 	}
@@ -952,7 +1496,7 @@ func getSystemSettingsHandler(c *gin.Context) {
 	if err := db.First(&settings).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			// 如果没有设置记录，创建默认设置
-			settings = SystemSettings{AllowUserChangePassword: true, AllowRegister: true}
+			settings = SystemSettings{AllowUserChangePassword: true, AllowRegister: true, AuditRetentionDays: defaultAuditRetentionDays}
 			db.Create(&settings)
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取系统设置失败"})
@@ -990,44 +1534,132 @@ func updateSystemSettingsHandler(c *gin.Context) {
 		}
 	}
 
+	before := settings
 	settings.AllowUserChangePassword = req.AllowUserChangePassword
 	settings.AutoLogin = req.AutoLogin
 	settings.AllowRegister = req.AllowRegister // 新增
+	if req.QuotaHoursPerWeek != nil {
+		settings.QuotaHoursPerWeek = *req.QuotaHoursPerWeek
+	}
+	if req.QuotaMaxActiveBookings != nil {
+		settings.QuotaMaxActiveBookings = *req.QuotaMaxActiveBookings
+	}
+	if req.QuotaMaxSeriesLength != nil {
+		settings.QuotaMaxSeriesLength = *req.QuotaMaxSeriesLength
+	}
+	if req.DefaultBookingVisibility != nil {
+		if *req.DefaultBookingVisibility != VisibilityPublic && *req.DefaultBookingVisibility != VisibilityPrivate {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "默认可见性无效"})
+			return
+		}
+		settings.DefaultBookingVisibility = *req.DefaultBookingVisibility
+	}
+	remindersChanged := false
+	if req.ReminderLeadMinutes != nil {
+		leads, ok := parseReminderLeads(*req.ReminderLeadMinutes)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "提醒时间无效，请填写逗号分隔的分钟数"})
+			return
+		}
+		value := formatReminderLeads(leads)
+		remindersChanged = value != settings.ReminderLeadMinutes
+		settings.ReminderLeadMinutes = value
+	}
+	if req.AuditRetentionDays != nil {
+		if *req.AuditRetentionDays < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "审计日志保留天数无效"})
+			return
+		}
+		settings.AuditRetentionDays = *req.AuditRetentionDays
+	}
 
-	if err := db.Save(&settings).Error; err != nil {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&settings).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "settings.update", "settings", settings.ID, before, settings)
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新系统设置失败"})
 		return
 	}
+	if remindersChanged {
+		go resyncReminders()
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "系统设置更新成功", "settings": settings})
 }
 
+// 用户列表允许排序的字段
+var userSortColumns = map[string]string{
+	"id":       "id",
+	"username": "username",
+	"nickname": "nickname",
+	"role":     "role",
+	"group":    "group",
+}
+
+// 用户列表和导出共用的筛选条件：关键字 q 匹配用户名、昵称和邮箱，role、group 精确匹配
+func userListFilter(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if q := strings.TrimSpace(c.Query("q")); q != "" {
+			pattern := likePattern(q)
+			tx = tx.Where("("+likeCondition("username")+" OR "+likeCondition("nickname")+" OR "+likeCondition("email")+")", pattern, pattern, pattern)
+		}
+		if role := c.Query("role"); role != "" {
+			tx = tx.Where("role = ?", role)
+		}
+		if group := c.Query("group"); group != "" {
+			tx = tx.Where(map[string]interface{}{"group": group})
+		}
+		return tx
+	}
+}
+
 // @Summary 获取所有用户列表
-// @Description 管理员获取所有用户列表
+// @Description 管理员分页查询用户列表，支持按关键字、角色和用户组筛选及排序
 // @Tags 管理员
 // @Produce json
+// @Param q query string false "关键字，匹配用户名、昵称和邮箱"
+// @Param role query string false "角色，admin 或 user"
+// @Param group query string false "用户组"
+// @Param sort query string false "排序字段：id（默认）、username、nickname、role、group，加 - 前缀表示降序"
+// @Param page query int false "页码，默认1"
+// @Param page_size query int false "每页数量，默认20，最大100"
 // @Success 200 {object} map[string]interface{}
 // @Security Bearer
 // @Router /api/admin/users [get]
 func listUsersHandler(c *gin.Context) {
+	order, ok := parseSort(c, userSortColumns, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "排序字段无效"})
+		return
+	}
+	page, pageSize := parsePage(c)
+	query := db.Model(&User{}).Scopes(userListFilter(c)).Session(&gorm.Session{})
+	var total int64
+	query.Count(&total)
 	var users []User
-	if err := db.Find(&users).Error; err != nil {
+	if err := query.Order(order).Order("id").Scopes(paginate(page, pageSize)).Find(&users).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取用户列表失败"})
 		return
 	}
 
 	// 返回用户信息（不包含密码）
-	var userList []gin.H
+	userList := make([]gin.H, 0, len(users))
 	for _, user := range users {
 		userList = append(userList, gin.H{
 			"id":       user.ID,
 			"username": user.Username,
 			"role":     user.Role,
 			"nickname": user.Nickname,
+			"group":    user.Group,
+			"email":    user.Email,
 		})
 	}
 
-	c.JSON(http.StatusOK, gin.H{"users": userList})
+	c.JSON(http.StatusOK, gin.H{"users": userList, "total": total, "page": page, "page_size": pageSize})
 }
 
 // @Summary 获取系统设置（公开）
@@ -1041,7 +1673,7 @@ func getPublicSettingsHandler(c *gin.Context) {
 	if err := db.First(&settings).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			// 如果没有设置记录，创建默认设置
-			settings = SystemSettings{AllowUserChangePassword: true, AllowRegister: true}
+			settings = SystemSettings{AllowUserChangePassword: true, AllowRegister: true, AuditRetentionDays: defaultAuditRetentionDays}
 			db.Create(&settings)
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取系统设置失败"})
@@ -1075,8 +1707,16 @@ func adminChangeUserRoleHandler(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
 		return
 	}
+	before := user
 	user.Role = req.Role
-	if err := db.Save(&user).Error; err != nil {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "user.role", "user", user.ID, before, user)
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
 		return
 	}
@@ -1084,31 +1724,55 @@ func adminChangeUserRoleHandler(c *gin.Context) {
 }
 
 func main() {
-	var err error
-	var dbPath string
-	if _, err := os.Stat("/app/data"); err == nil {
-		dbPath = "/app/data/meeting_room.db" // 容器环境
-	} else {
-		dbPath = "data/meeting_room.db" // 本地开发
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// 启动 HTTP 服务：执行未执行的迁移，创建默认管理员并启动后台任务
+func runServe(args []string) error {
+	if err := parseFlags(newFlagSet("serve", ""), args); err != nil {
+		return err
 	}
-	db, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
-	if err != nil {
-		log.Fatalf("failed to connect database: %v", err)
+	// 执行未执行的迁移，数据库版本比程序新时拒绝启动
+	if err := connectDatabase(true); err != nil {
+		return err
+	}
+	var err error
+	if jwtKey, err = loadJWTSecret(appConfig); err != nil {
+		return err
 	}
-
-	// 自动迁移表结构
-	db.AutoMigrate(&User{}, &Room{}, &Booking{}, &SystemSettings{})
 
 	// 创建默认管理员
+	if appConfig.Admin.Password == "admin" {
+		log.Printf("警告: admin.password 仍为默认密码 admin，请通过 ADMIN_PASSWORD 修改或登录后修改管理员密码")
+	}
 	var admin User
-	db.Where(User{Username: "admin"}).FirstOrCreate(&admin, User{Username: "admin", Password: "admin", Role: "admin"})
-
-	r := gin.Default()
-
-	// 只在开发环境启用 CORS
-	if os.Getenv("GIN_MODE") != "release" {
+	db.Where(User{Username: appConfig.Admin.Username}).FirstOrCreate(&admin, User{Username: appConfig.Admin.Username, Password: appConfig.Admin.Password, Role: "admin"})
+
+	// 定期清理过期的临时占用
+	go sweepExpiredHolds(time.Minute)
+	// 投递 Webhook 事件
+	go runWebhookWorker(webhookPollInterval)
+	// 发送邮件及 DooTask 通知
+	go runNotificationWorker(notifyPollInterval)
+	// 会议提醒
+	go runReminderScheduler(reminderPollInterval)
+	// 清理过期的实时事件
+	go runChangeEventPruner(time.Hour)
+	// 清理超过保留期的审计日志
+	go runAuditPruner(time.Hour)
+	// 定时备份 SQLite 数据库
+	if appConfig.Backup.Interval > 0 {
+		go runBackupScheduler(time.Duration(appConfig.Backup.Interval))
+	}
+
+	r := gin.New()
+	// 访问日志隐藏 token 参数
+	r.Use(gin.LoggerWithFormatter(accessLogFormatter), gin.Recovery())
+
+	// 配置了允许的来源时启用 CORS，默认只在开发环境启用
+	if len(appConfig.Server.CORSOrigins) > 0 {
 		r.Use(cors.New(cors.Config{
-			AllowOrigins:     []string{"*"},
+			AllowOrigins:     appConfig.Server.CORSOrigins,
 			AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 			AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 			ExposeHeaders:    []string{"Content-Length"},
@@ -1125,6 +1789,34 @@ func main() {
 	r.POST("/api/register", registerHandler)
 	r.POST("/api/auth/sso", ssoHandler)
 	r.GET("/api/settings", getPublicSettingsHandler)
+	// 会议室照片无需登录，便于直接在 img 标签中引用
+	r.GET("/api/room-photos/:id", serveRoomPhotoHandler)
+	// 日历订阅，通过订阅令牌鉴权
+	r.GET("/api/calendar/my.ics", userCalendarFeedHandler)
+	r.GET("/api/calendar/rooms/:id", roomCalendarFeedHandler)
+
+	// CalDAV，日历客户端使用 HTTP Basic（密码、应用专用密码或只读的日历订阅令牌）或 JWT 鉴权
+	r.GET("/.well-known/caldav", caldavWellKnownHandler)
+	r.Handle("PROPFIND", "/.well-known/caldav", caldavWellKnownHandler)
+	r.OPTIONS(caldavPrefix+"/*path", caldavOptionsHandler)
+	dav := r.Group(caldavPrefix)
+	dav.Use(CalDAVAuthMiddleware())
+	for _, method := range caldavMethods {
+		dav.Handle(method, "/*path", caldavHandler)
+	}
+
+	// 实时事件流，与其他接口使用相同的 JWT，也可通过 token 参数传入
+	r.GET("/api/events", StreamTokenMiddleware(), AuthMiddleware(), eventStreamHandler)
+
+	// 会议室门口平板，通过设备令牌鉴权
+	kiosk := r.Group("/api/kiosk")
+	kiosk.Use(KioskAuthMiddleware())
+	{
+		kiosk.GET("/status", kioskStatusHandler)
+		kiosk.POST("/book", kioskBookHandler)
+		kiosk.POST("/checkin", kioskCheckInHandler)
+		kiosk.POST("/end", kioskEndHandler)
+	}
 
 	auth := r.Group("/api")
 	auth.Use(AuthMiddleware())
@@ -1134,12 +1826,14 @@ func main() {
 			username, _ := c.Get("username")
 			role, _ := c.Get("role")
 			nickname, _ := c.Get("nickname")
+			timeZone, _ := c.Get("time_zone")
 			
 			response := gin.H{
-				"user_id":  userID,
-				"username": username,
-				"role":     role,
-				"nickname": nickname,
+				"user_id":   userID,
+				"username":  username,
+				"role":      role,
+				"nickname":  nickname,
+				"time_zone": timeZone,
 			}
 			
 			// 如果是管理员，返回系统设置信息
@@ -1148,7 +1842,7 @@ func main() {
 				if err := db.First(&settings).Error; err != nil {
 					if err == gorm.ErrRecordNotFound {
 						// 如果没有设置记录，创建默认设置
-						settings = SystemSettings{AllowUserChangePassword: true, AllowRegister: true}
+						settings = SystemSettings{AllowUserChangePassword: true, AllowRegister: true, AuditRetentionDays: defaultAuditRetentionDays}
 						db.Create(&settings)
 					}
 				}
@@ -1161,6 +1855,10 @@ func main() {
 		auth.PUT("/user/profile", updateProfileHandler)
 		// 查询会议室
 		auth.GET("/rooms", listRoomsHandler)
+		auth.GET("/rooms/filters", roomFiltersHandler)
+		// 会议室照片（仅管理员）
+		auth.POST("/rooms/:id/photos", AdminMiddleware(), uploadRoomPhotoHandler)
+		auth.DELETE("/rooms/:id/photos/:photo_id", AdminMiddleware(), deleteRoomPhotoHandler)
 		// 添加会议室（仅管理员）
 		auth.POST("/rooms", AdminMiddleware(), addRoomHandler)
 		// 预订会议室
@@ -1171,23 +1869,82 @@ func main() {
 		auth.GET("/mybookings", listMyBookingsHandler)
 		// 取消预订
 		auth.DELETE("/bookings/:id", cancelBookingHandler)
+		// 提前结束和延长进行中的预订
+		auth.POST("/bookings/:id/end", endBookingHandler)
+		auth.POST("/bookings/:id/extend", extendBookingHandler)
+		// 下载单个预订的 .ics 文件
+		auth.GET("/bookings/:id/ics", bookingICSHandler)
+		// 日历订阅地址
+		auth.GET("/user/calendar", calendarSubscriptionHandler)
+		auth.POST("/user/calendar/reset", resetCalendarTokenHandler)
+		// CalDAV 应用专用密码
+		auth.GET("/user/app-passwords", listAppPasswordsHandler)
+		auth.POST("/user/app-passwords", createAppPasswordHandler)
+		auth.DELETE("/user/app-passwords/:id", deleteAppPasswordHandler)
+		// 临时占用会议室
+		auth.POST("/holds", createHoldHandler)
+		// 确认临时占用为正式预订
+		auth.POST("/holds/:id/confirm", confirmHoldHandler)
+		// 释放临时占用
+		auth.DELETE("/holds/:id", releaseHoldHandler)
 		// 编辑会议室
 		auth.PUT("/rooms/:id", AdminMiddleware(), editRoomHandler)
 		// 删除会议室
 		auth.DELETE("/rooms/:id", AdminMiddleware(), deleteRoomHandler)
 		// (Admin) 查询所有预订记录
 		auth.GET("/admin/bookings", AdminMiddleware(), listAllBookingsHandler)
+		auth.POST("/admin/bookings/import", AdminMiddleware(), importBookingsHandler)
+		// (Admin) 导出预订和用户
+		auth.GET("/admin/bookings/export", AdminMiddleware(), exportBookingsHandler)
+		auth.GET("/admin/users/export", AdminMiddleware(), exportUsersHandler)
 		// 用户修改密码
 		auth.PUT("/user/password", changePasswordHandler)
 		// 管理员功能
 		auth.GET("/admin/users", AdminMiddleware(), listUsersHandler)
+		// 审计日志
+		auth.GET("/admin/audit-logs", AdminMiddleware(), listAuditLogsHandler)
+		// 服务配置
+		auth.GET("/admin/config", AdminMiddleware(), getConfigHandler)
+		// 数据库备份与恢复（仅 SQLite）
+		auth.GET("/admin/backups", AdminMiddleware(), listBackupsHandler)
+		auth.POST("/admin/backups", AdminMiddleware(), createBackupHandler)
+		auth.GET("/admin/backups/:name", AdminMiddleware(), downloadBackupHandler)
+		auth.POST("/admin/backups/:name/restore", AdminMiddleware(), restoreBackupHandler)
 		auth.PUT("/admin/user/password", AdminMiddleware(), adminChangeUserPasswordHandler)
 		auth.GET("/admin/settings", AdminMiddleware(), getSystemSettingsHandler)
 		auth.PUT("/admin/settings", AdminMiddleware(), updateSystemSettingsHandler)
 		auth.PUT("/admin/user/role", AdminMiddleware(), adminChangeUserRoleHandler)
+		auth.PUT("/admin/user/group", AdminMiddleware(), adminChangeUserGroupHandler)
+		// 预订配额
+		auth.GET("/user/quota", userQuotaHandler)
+		auth.GET("/admin/quotas", AdminMiddleware(), listQuotasHandler)
+		auth.POST("/admin/quotas", AdminMiddleware(), saveQuotaHandler)
+		auth.DELETE("/admin/quotas/:id", AdminMiddleware(), deleteQuotaHandler)
+		// 通知
+		auth.GET("/user/notifications", getNotificationPreferenceHandler)
+		auth.PUT("/user/notifications", updateNotificationPreferenceHandler)
+		auth.POST("/admin/notifications/test", AdminMiddleware(), testNotificationHandler)
+		auth.GET("/admin/notifications/messages", AdminMiddleware(), listNotificationMessagesHandler)
+		auth.GET("/admin/reminders", AdminMiddleware(), listRemindersHandler)
+		// 会议室使用统计
+		auth.GET("/admin/analytics/rooms", AdminMiddleware(), roomUtilizationHandler)
+		auth.GET("/admin/analytics/heatmap", AdminMiddleware(), roomHeatmapHandler)
+		// 门口平板设备
+		auth.GET("/admin/kiosk-devices", AdminMiddleware(), listKioskDevicesHandler)
+		auth.POST("/admin/kiosk-devices", AdminMiddleware(), createKioskDeviceHandler)
+		auth.POST("/admin/kiosk-devices/:id/token", AdminMiddleware(), resetKioskTokenHandler)
+		auth.DELETE("/admin/kiosk-devices/:id", AdminMiddleware(), deleteKioskDeviceHandler)
+		// Webhook 订阅及投递记录
+		auth.GET("/admin/webhooks", AdminMiddleware(), listWebhooksHandler)
+		auth.POST("/admin/webhooks", AdminMiddleware(), createWebhookHandler)
+		auth.PUT("/admin/webhooks/:id", AdminMiddleware(), updateWebhookHandler)
+		auth.DELETE("/admin/webhooks/:id", AdminMiddleware(), deleteWebhookHandler)
+		auth.POST("/admin/webhooks/:id/ping", AdminMiddleware(), pingWebhookHandler)
+		auth.GET("/admin/webhooks/:id/deliveries", AdminMiddleware(), listWebhookDeliveriesHandler)
+		auth.POST("/admin/webhooks/deliveries/:id/redeliver", AdminMiddleware(), redeliverWebhookHandler)
 	}
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	r.Run(":80")
+	return r.Run(appConfig.Server.Addr)
 } 
\ No newline at end of file
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// 以 user 的身份调用处理函数，上下文与 AuthMiddleware 设置的相同，user.ID 为 0 时不登录。
+// params 为路径参数，body 非 nil 时以 JSON 发送；返回状态码和解析后的 JSON 响应
+func callHandler(t *testing.T, handler gin.HandlerFunc, user User, method, target string, params gin.Params, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, target, reader)
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = params
+	if user.ID != 0 {
+		c.Set("user_id", user.ID)
+		c.Set("username", user.Username)
+		c.Set("role", user.Role)
+		c.Set("nickname", user.Nickname)
+		c.Set("time_zone", user.TimeZone)
+	}
+	handler(c)
+	resp := make(map[string]interface{})
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+// 路径参数 id
+func idParam(id uint) gin.Params {
+	return gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(id), 10)}}
+}
+
+// 创建测试数据，失败时终止测试
+func mustCreate(t *testing.T, values ...interface{}) {
+	t.Helper()
+	for _, v := range values {
+		if err := db.Create(v).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
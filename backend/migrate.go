@@ -0,0 +1,649 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Migration 一个数据库版本的升级和回滚。
+// 迁移一经发布不得修改，表结构和数据的变化都应新增迁移
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration 已执行的迁移
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// SQL 迁移：migrations 目录下的 版本_名称.up.sql 和 版本_名称.down.sql
+//
+//go:embed migrations/*.sql
+var sqlMigrationFS embed.FS
+
+// Go 迁移，按版本号排列
+var goMigrations = []Migration{
+	{
+		// 初始表结构，即引入版本化迁移前启动时 AutoMigrate 的模型，已有数据库执行时只补齐缺少的表和列。
+		// 使用下面冻结的表结构而不是当前模型，之后对模型的修改应新增迁移
+		Version: 1,
+		Name:    "initial_schema",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(initialSchemaModels()...)
+		},
+		Down: func(tx *gorm.DB) error {
+			models := initialSchemaModels()
+			for i := len(models) - 1; i >= 0; i-- {
+				if err := tx.Migrator().DropTable(models[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// CalDAV 应用专用密码
+		Version: 4,
+		Name:    "app_passwords",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v4AppPassword{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v4AppPassword{})
+		},
+	},
+}
+
+func initialSchemaModels() []interface{} {
+	return []interface{}{&v1User{}, &v1Room{}, &v1Booking{}, &v1SystemSettings{}, &v1BookingHold{}, &v1BookingQuota{}, &v1BookingParticipant{}, &v1BookingSeries{}, &v1RoomAmenity{}, &v1RoomPhoto{}, &v1CalendarToken{}, &v1CalendarObject{}, &v1Webhook{}, &v1WebhookDelivery{}, &v1NotificationPreference{}, &v1NotificationMessage{}, &v1ReminderJob{}, &v1ChangeEvent{}, &v1KioskDevice{}, &v1AuditLog{}}
+}
+
+// 迁移 1 的表结构，与引入版本化迁移时的模型一致，不得修改
+
+// v1UniqueString 带唯一索引的字符串列。MySQL 不能对 longtext 建唯一索引，
+// 使用 varchar(191)（从支持 MySQL 起即如此）；其他数据库与当时一致，PostgreSQL 由迁移 3 改为 varchar(191)
+type v1UniqueString string
+
+func (v1UniqueString) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	if db.Dialector.Name() == DBDriverMySQL {
+		return "varchar(191)"
+	}
+	return ""
+}
+
+type v1User struct {
+	ID            uint   `gorm:"primaryKey"`
+	Username      string `gorm:"unique"`
+	Password      string
+	Role          string
+	Nickname      string
+	Group         string `gorm:"index"`
+	TimeZone      string
+	Email         string `gorm:"index"`
+	DooTaskUserID string `gorm:"index"`
+}
+
+func (v1User) TableName() string { return "users" }
+
+type v1Room struct {
+	ID          uint   `gorm:"primaryKey"`
+	Name        string `gorm:"unique"`
+	Capacity    int
+	Status      string
+	TimeZone    string
+	OpenTime    string
+	CloseTime   string
+	Site        string `gorm:"index"`
+	Building    string `gorm:"index"`
+	Floor       string `gorm:"index"`
+	Description string
+}
+
+func (v1Room) TableName() string { return "rooms" }
+
+type v1Booking struct {
+	ID           uint `gorm:"primaryKey"`
+	RoomID       uint
+	UserID       uint
+	StartTime    time.Time
+	EndTime      time.Time
+	Reason       string
+	Status       string `gorm:"default:active;index"`
+	CancelledAt  *time.Time
+	CancelledBy  *uint
+	CancelReason string
+	Visibility   string `gorm:"default:public"`
+	SeriesID     *uint  `gorm:"index"`
+	RecurrenceID *time.Time
+	Sequence     int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	CheckedInAt  *time.Time
+}
+
+func (v1Booking) TableName() string { return "bookings" }
+
+type v1SystemSettings struct {
+	ID                       uint `gorm:"primaryKey"`
+	AllowUserChangePassword  bool `gorm:"column:allow_user_change_password"`
+	AutoLogin                bool `gorm:"column:auto_login"`
+	AllowRegister            bool `gorm:"column:allow_register"`
+	QuotaHoursPerWeek        float64
+	QuotaMaxActiveBookings   int
+	QuotaMaxSeriesLength     int
+	DefaultBookingVisibility string `gorm:"default:public"`
+	ReminderLeadMinutes      string `gorm:"default:'15,1440'"`
+	AuditRetentionDays       int    `gorm:"default:180"`
+}
+
+func (v1SystemSettings) TableName() string { return "system_settings" }
+
+type v1BookingHold struct {
+	ID        uint `gorm:"primaryKey"`
+	RoomID    uint `gorm:"index"`
+	UserID    uint
+	StartTime time.Time
+	EndTime   time.Time
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+func (v1BookingHold) TableName() string { return "booking_holds" }
+
+type v1BookingQuota struct {
+	ID                uint   `gorm:"primaryKey"`
+	Scope             string `gorm:"index"`
+	RoomID            uint
+	UserID            uint
+	GroupName         string
+	HoursPerWeek      float64
+	MaxActiveBookings int
+	MaxSeriesLength   int
+}
+
+func (v1BookingQuota) TableName() string { return "booking_quota" }
+
+type v1BookingParticipant struct {
+	ID        uint `gorm:"primaryKey"`
+	BookingID uint `gorm:"uniqueIndex:idx_booking_participant"`
+	UserID    uint `gorm:"uniqueIndex:idx_booking_participant;index"`
+}
+
+func (v1BookingParticipant) TableName() string { return "booking_participants" }
+
+type v1BookingSeries struct {
+	ID        uint `gorm:"primaryKey"`
+	RoomID    uint
+	UserID    uint
+	Freq      string
+	Interval  int
+	Count     int
+	Until     *time.Time
+	TimeZone  string
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+func (v1BookingSeries) TableName() string { return "booking_series" }
+
+type v1RoomAmenity struct {
+	ID     uint   `gorm:"primaryKey"`
+	RoomID uint   `gorm:"uniqueIndex:idx_room_amenity"`
+	Name   string `gorm:"uniqueIndex:idx_room_amenity;index"`
+}
+
+func (v1RoomAmenity) TableName() string { return "room_amenities" }
+
+type v1RoomPhoto struct {
+	ID          uint `gorm:"primaryKey"`
+	RoomID      uint `gorm:"index"`
+	FileName    string
+	ContentType string
+	Size        int64
+	CreatedAt   time.Time
+}
+
+func (v1RoomPhoto) TableName() string { return "room_photos" }
+
+type v1CalendarToken struct {
+	ID        uint           `gorm:"primaryKey"`
+	UserID    uint           `gorm:"uniqueIndex"`
+	Token     v1UniqueString `gorm:"uniqueIndex"`
+	CreatedAt time.Time
+}
+
+func (v1CalendarToken) TableName() string { return "calendar_tokens" }
+
+type v1CalendarObject struct {
+	ID        uint           `gorm:"primaryKey"`
+	RoomID    uint           `gorm:"uniqueIndex:idx_calendar_object"`
+	Name      v1UniqueString `gorm:"uniqueIndex:idx_calendar_object"`
+	UID       string         `gorm:"index"`
+	BookingID *uint          `gorm:"index"`
+	SeriesID  *uint          `gorm:"index"`
+}
+
+func (v1CalendarObject) TableName() string { return "calendar_objects" }
+
+type v1Webhook struct {
+	ID         uint `gorm:"primaryKey"`
+	Name       string
+	URL        string
+	Secret     string
+	EventTypes string
+	Active     bool `gorm:"default:true"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (v1Webhook) TableName() string { return "webhooks" }
+
+type v1WebhookDelivery struct {
+	ID             uint   `gorm:"primaryKey"`
+	WebhookID      uint   `gorm:"index"`
+	EventID        string `gorm:"index"`
+	Event          string
+	Payload        string
+	Status         string `gorm:"index"`
+	Attempts       int
+	NextAttemptAt  time.Time `gorm:"index"`
+	LastAttemptAt  *time.Time
+	ResponseStatus int
+	ResponseBody   string
+	Error          string
+	CreatedAt      time.Time
+}
+
+func (v1WebhookDelivery) TableName() string { return "webhook_deliveries" }
+
+type v1NotificationPreference struct {
+	ID                 uint `gorm:"primaryKey"`
+	UserID             uint `gorm:"uniqueIndex"`
+	Language           string
+	OptOutConfirmation bool
+	OptOutChange       bool
+	OptOutCancellation bool
+	OptOutReminder     bool
+	OptOutEmail        bool
+	OptOutDooTask      bool
+	UpdatedAt          time.Time
+}
+
+func (v1NotificationPreference) TableName() string { return "notification_preferences" }
+
+type v1NotificationMessage struct {
+	ID            uint   `gorm:"primaryKey"`
+	Channel       string `gorm:"index"`
+	UserID        uint   `gorm:"index"`
+	Kind          string
+	BookingID     uint `gorm:"index"`
+	To            string
+	Subject       string
+	Body          string
+	Attachment    string
+	Status        string `gorm:"index"`
+	Attempts      int
+	NextAttemptAt time.Time `gorm:"index"`
+	LastAttemptAt *time.Time
+	Error         string
+	CreatedAt     time.Time
+}
+
+func (v1NotificationMessage) TableName() string { return "notification_messages" }
+
+type v1ReminderJob struct {
+	ID          uint      `gorm:"primaryKey"`
+	BookingID   uint      `gorm:"uniqueIndex:idx_reminder_job"`
+	LeadMinutes int       `gorm:"uniqueIndex:idx_reminder_job"`
+	RemindAt    time.Time `gorm:"index"`
+	Status      string    `gorm:"index"`
+	SentAt      *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (v1ReminderJob) TableName() string { return "reminder_jobs" }
+
+type v1ChangeEvent struct {
+	ID        uint   `gorm:"primaryKey"`
+	EventID   string `gorm:"index"`
+	Event     string `gorm:"index"`
+	RoomID    uint   `gorm:"index"`
+	BookingID uint
+	StartTime *time.Time
+	EndTime   *time.Time
+	Private   bool
+	Payload   string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (v1ChangeEvent) TableName() string { return "change_events" }
+
+type v1KioskDevice struct {
+	ID         uint `gorm:"primaryKey"`
+	Name       string
+	RoomID     uint           `gorm:"index"`
+	Token      v1UniqueString `gorm:"uniqueIndex"`
+	LastSeenAt *time.Time
+	CreatedAt  time.Time
+}
+
+func (v1KioskDevice) TableName() string { return "kiosk_devices" }
+
+type v1AuditLog struct {
+	ID         uint `gorm:"primaryKey"`
+	ActorID    uint `gorm:"index"`
+	ActorName  string
+	Action     string `gorm:"index"`
+	TargetType string `gorm:"index:idx_audit_target"`
+	TargetID   uint   `gorm:"index:idx_audit_target"`
+	Changes    string `gorm:"type:text"`
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time `gorm:"index"`
+}
+
+func (v1AuditLog) TableName() string { return "audit_logs" }
+
+// 迁移 4 的表结构，不得修改
+
+type v4AppPassword struct {
+	ID         uint `gorm:"primaryKey"`
+	UserID     uint `gorm:"index"`
+	Name       string
+	Token      string `gorm:"uniqueIndex;size:191"`
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+func (v4AppPassword) TableName() string { return "app_passwords" }
+
+// 合并 Go 迁移和 SQL 迁移，按版本排序并检查版本号唯一。
+// SQL 迁移可以为某种数据库提供专用的文件，如 版本_名称.down.mysql.sql，存在时代替通用的文件
+func loadMigrations(dialect string) ([]Migration, error) {
+	migrations := append([]Migration(nil), goMigrations...)
+	files, err := fs.Glob(sqlMigrationFS, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	type sqlScript struct {
+		body     string
+		specific bool // 当前数据库专用
+	}
+	scripts := make(map[int]map[string]sqlScript)
+	names := make(map[int]string)
+	for _, file := range files {
+		base := path.Base(file)
+		parts := strings.Split(strings.TrimSuffix(base, ".sql"), ".")
+		if len(parts) < 2 || len(parts) > 3 || (parts[1] != "up" && parts[1] != "down") {
+			return nil, fmt.Errorf("迁移文件名无效: %s", base)
+		}
+		specific := len(parts) == 3
+		if specific && parts[2] != dialect {
+			continue
+		}
+		name, direction := parts[0], parts[1]
+		sep := strings.Index(name, "_")
+		if sep <= 0 {
+			return nil, fmt.Errorf("迁移文件名无效: %s", base)
+		}
+		version, err := strconv.Atoi(name[:sep])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("迁移文件名无效: %s", base)
+		}
+		if scripts[version] == nil {
+			scripts[version] = make(map[string]sqlScript)
+		}
+		if scripts[version][direction].specific {
+			continue
+		}
+		body, err := sqlMigrationFS.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		scripts[version][direction] = sqlScript{body: string(body), specific: specific}
+		names[version] = name[sep+1:]
+	}
+	for version, s := range scripts {
+		up, ok := s["up"]
+		if !ok {
+			return nil, fmt.Errorf("迁移 %d 缺少 up.sql", version)
+		}
+		m := Migration{Version: version, Name: names[version], Up: sqlMigration(up.body)}
+		if down, ok := s["down"]; ok {
+			m.Down = sqlMigration(down.body)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("迁移版本 %d 重复", migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+// 依次执行 SQL 文件中以分号结尾的语句
+func sqlMigration(body string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		for _, stmt := range splitSQLStatements(body) {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return fmt.Errorf("%w\n%s", err, stmt)
+			}
+		}
+		return nil
+	}
+}
+
+// 按行尾的分号拆分语句，忽略 -- 注释行
+func splitSQLStatements(body string) []string {
+	var stmts []string
+	var current strings.Builder
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if s := strings.TrimSpace(current.String()); s != "" {
+		stmts = append(stmts, s)
+	}
+	return stmts
+}
+
+// 已执行的迁移版本
+func appliedMigrations(tx *gorm.DB) (map[int]SchemaMigration, error) {
+	if err := tx.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	return readSchemaMigrations(tx)
+}
+
+// 读取已执行的迁移，不创建迁移记录表，用于检查只读打开的数据库
+func readSchemaMigrations(tx *gorm.DB) (map[int]SchemaMigration, error) {
+	var rows []SchemaMigration
+	if err := tx.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// 检查数据库版本：数据库中有当前程序不认识的迁移（由更新的版本执行）时返回错误
+func checkSchemaVersion(migrations []Migration, applied map[int]SchemaMigration) error {
+	known := make(map[int]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+	}
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("数据库已迁移到版本 %d，比当前程序新，请使用新版本程序或先用新版本回滚", version)
+		}
+	}
+	return nil
+}
+
+// 执行所有未执行的迁移，每个迁移在单独的事务中执行
+func migrateUp(tx *gorm.DB) ([]Migration, error) {
+	migrations, err := loadMigrations(tx.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSchemaVersion(migrations, applied); err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := tx.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("迁移 %d_%s 失败: %w", m.Version, m.Name, err)
+		}
+		log.Printf("已执行迁移 %d_%s", m.Version, m.Name)
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// 按执行的倒序回滚最近的 steps 个迁移
+func migrateDown(tx *gorm.DB, steps int) ([]Migration, error) {
+	migrations, err := loadMigrations(tx.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSchemaVersion(migrations, applied); err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == nil {
+			return done, fmt.Errorf("迁移 %d_%s 不支持回滚", m.Version, m.Name)
+		}
+		err := tx.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("回滚 %d_%s 失败: %w", m.Version, m.Name, err)
+		}
+		log.Printf("已回滚迁移 %d_%s", m.Version, m.Name)
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// 迁移状态，每行一个迁移
+func migrationStatus(tx *gorm.DB) ([]string, error) {
+	migrations, err := loadMigrations(tx.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(tx)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, m := range migrations {
+		status := "pending"
+		if row, ok := applied[m.Version]; ok {
+			status = "applied " + row.AppliedAt.Format(time.RFC3339)
+		}
+		lines = append(lines, fmt.Sprintf("%4d  %-40s %s", m.Version, m.Name, status))
+	}
+	if err := checkSchemaVersion(migrations, applied); err != nil {
+		lines = append(lines, err.Error())
+	}
+	return lines, nil
+}
+
+// migrate 子命令：migrate [up]、migrate down [步数]、migrate status
+func runMigrateCommand(args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "up":
+		done, err := migrateUp(db)
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("数据库已是最新版本")
+		}
+		return nil
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("回滚步数无效: %s", args[1])
+			}
+			steps = n
+		}
+		done, err := migrateDown(db, steps)
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("没有可回滚的迁移")
+		}
+		return nil
+	case "status":
+		lines, err := migrationStatus(db)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		return nil
+	default:
+		return fmt.Errorf("未知的迁移命令: %s，可用命令为 up、down [步数]、status", action)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// 当前的模型，迁移后的表结构应包含它们的所有列
+var currentModels = []interface{}{&User{}, &Room{}, &Booking{}, &SystemSettings{}, &BookingHold{}, &BookingQuota{}, &BookingParticipant{}, &BookingSeries{}, &RoomAmenity{}, &RoomPhoto{}, &CalendarToken{}, &CalendarObject{}, &Webhook{}, &WebhookDelivery{}, &NotificationPreference{}, &NotificationMessage{}, &ReminderJob{}, &ChangeEvent{}, &KioskDevice{}, &AuditLog{}, &AppPassword{}}
+
+func TestSplitSQLStatements(t *testing.T) {
+	body := "-- 注释\nCREATE INDEX a ON t (x);\n\nUPDATE t\nSET x = 1;\nDROP INDEX b"
+	got := splitSQLStatements(body)
+	want := []string{"CREATE INDEX a ON t (x);", "UPDATE t\nSET x = 1;", "DROP INDEX b"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("splitSQLStatements = %q，应为 %q", got, want)
+	}
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, tx *gorm.DB) {
+		migrations, err := loadMigrations(tx.Dialector.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		applied, err := appliedMigrations(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(applied) != len(migrations) {
+			t.Fatalf("已执行 %d 个迁移，应为 %d 个", len(applied), len(migrations))
+		}
+		if done, err := migrateUp(tx); err != nil || len(done) != 0 {
+			t.Fatalf("重复执行 migrateUp 执行了 %d 个迁移，错误 %v", len(done), err)
+		}
+
+		m := tx.Migrator()
+		for _, model := range currentModels {
+			stmt := &gorm.Statement{DB: tx}
+			if err := stmt.Parse(model); err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range stmt.Schema.Fields {
+				if field.DBName != "" && !m.HasColumn(model, field.DBName) {
+					t.Errorf("表 %s 缺少列 %s，修改模型后应新增迁移", stmt.Schema.Table, field.DBName)
+				}
+			}
+		}
+		if !m.HasIndex(&Booking{}, "idx_bookings_room_time") {
+			t.Error("缺少索引 idx_bookings_room_time")
+		}
+
+		// 回滚最近一个迁移后再升级
+		last := migrations[len(migrations)-1]
+		if done, err := migrateDown(tx, 1); err != nil || len(done) != 1 || done[0].Version != last.Version {
+			t.Fatalf("migrateDown(1) = %v，错误 %v", done, err)
+		}
+		if done, err := migrateUp(tx); err != nil || len(done) != 1 || done[0].Version != last.Version {
+			t.Fatalf("回滚后 migrateUp = %v，错误 %v", done, err)
+		}
+
+		// 回滚到最早的版本后表都被删除
+		if done, err := migrateDown(tx, math.MaxInt32); err != nil || len(done) != len(migrations) {
+			t.Fatalf("全部回滚了 %d 个迁移，错误 %v", len(done), err)
+		}
+		for _, model := range initialSchemaModels() {
+			if m.HasTable(model) {
+				t.Errorf("回滚后表 %T 仍存在", model)
+			}
+		}
+		if done, err := migrateUp(tx); err != nil || len(done) != len(migrations) {
+			t.Fatalf("重新迁移执行了 %d 个迁移，错误 %v", len(done), err)
+		}
+		if !m.HasIndex(&Booking{}, "idx_bookings_room_time") {
+			t.Error("重新迁移后缺少索引 idx_bookings_room_time")
+		}
+	})
+}
+
+// 数据库由更新的程序迁移过时拒绝升级和回滚
+func TestMigrateRejectsNewerSchema(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, tx *gorm.DB) {
+		if err := tx.Create(&SchemaMigration{Version: 9999, Name: "future"}).Error; err != nil {
+			t.Fatal(err)
+		}
+		// 共用的数据库在下次测试开始时需要回滚
+		t.Cleanup(func() { tx.Delete(&SchemaMigration{}, 9999) })
+
+		if _, err := migrateUp(tx); err == nil || !strings.Contains(err.Error(), "比当前程序新") {
+			t.Errorf("migrateUp 返回 %v，应拒绝更新的数据库", err)
+		}
+		if _, err := migrateDown(tx, 1); err == nil || !strings.Contains(err.Error(), "比当前程序新") {
+			t.Errorf("migrateDown 返回 %v，应拒绝更新的数据库", err)
+		}
+	})
+}
@@ -0,0 +1 @@
+DROP INDEX idx_bookings_room_time ON bookings;
@@ -0,0 +1,2 @@
+-- 冲突检查和预订列表按会议室和时间段查询
+CREATE INDEX idx_bookings_room_time ON bookings (room_id, start_time, end_time);
@@ -0,0 +1,3 @@
+ALTER TABLE calendar_objects ALTER COLUMN name TYPE text;
+ALTER TABLE calendar_tokens ALTER COLUMN token TYPE text;
+ALTER TABLE kiosk_devices ALTER COLUMN token TYPE text;
@@ -0,0 +1,2 @@
+-- 带唯一索引的字符串列改为 varchar(191)，与模型的 size:191 一致。
+-- SQLite 不区分字符串长度，MySQL 在迁移 1 中已是 varchar(191)，无需修改
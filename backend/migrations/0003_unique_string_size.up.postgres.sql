@@ -0,0 +1,4 @@
+-- 带唯一索引的字符串列改为 varchar(191)，与模型的 size:191 一致
+ALTER TABLE calendar_objects ALTER COLUMN name TYPE varchar(191);
+ALTER TABLE calendar_tokens ALTER COLUMN token TYPE varchar(191);
+ALTER TABLE kiosk_devices ALTER COLUMN token TYPE varchar(191);
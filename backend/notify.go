@@ -0,0 +1,499 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 通知类型
+const (
+	NotifyConfirmation = "confirmation" // 预订成功或被邀请
+	NotifyChange       = "change"       // 时间或主题变更
+	NotifyCancellation = "cancellation" // 预订取消
+	NotifyReminder     = "reminder"     // 会议开始前提醒
+	NotifyTest         = "test"         // 管理员测试消息
+)
+
+// 通知渠道
+const (
+	ChannelEmail   = "email"
+	ChannelDooTask = "dootask"
+)
+
+// 发送参数：失败后从 1 分钟开始指数退避，最多尝试 5 次
+const (
+	notifyMaxAttempts  = 5
+	notifyBaseBackoff  = time.Minute
+	notifyMaxBackoff   = time.Hour
+	notifyPollInterval = 5 * time.Second
+	notifyBatchSize    = 20
+)
+
+var (
+	errUnknownChannel  = errors.New("未知的通知渠道")
+	errChannelDisabled = errors.New("通知渠道未配置")
+)
+
+// notifier 通知渠道，新增渠道实现该接口并加入 notifiers
+type notifier interface {
+	channel() string
+	// 是否已配置，未配置的渠道不生成消息
+	enabled() bool
+	// 为收件人生成消息，收件人在该渠道没有地址时返回 false
+	compose(n notice, user User, lang string, data noticeData) (NotificationMessage, bool)
+	// 校验测试消息的收件地址
+	validRecipient(to string) bool
+	send(msg NotificationMessage) error
+}
+
+var notifiers = []notifier{emailNotifier{}, dooTaskNotifier{}}
+
+func findNotifier(channel string) notifier {
+	for _, n := range notifiers {
+		if n.channel() == channel {
+			return n
+		}
+	}
+	return nil
+}
+
+func anyNotifierEnabled() bool {
+	for _, n := range notifiers {
+		if n.enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// NotificationPreference 用户的通知偏好，没有记录时使用中文并接收全部通知
+type NotificationPreference struct {
+	ID                 uint      `gorm:"primaryKey" json:"-"`
+	UserID             uint      `gorm:"uniqueIndex" json:"-"`
+	Language           string    `json:"language"` // zh 或 en
+	OptOutConfirmation bool      `json:"opt_out_confirmation"`
+	OptOutChange       bool      `json:"opt_out_change"`
+	OptOutCancellation bool      `json:"opt_out_cancellation"`
+	OptOutReminder     bool      `json:"opt_out_reminder"`
+	OptOutEmail        bool      `json:"opt_out_email"`   // 不接收邮件
+	OptOutDooTask      bool      `json:"opt_out_dootask"` // 不接收 DooTask 消息
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// 是否接收该类型的通知
+func (p NotificationPreference) wants(kind string) bool {
+	switch kind {
+	case NotifyConfirmation:
+		return !p.OptOutConfirmation
+	case NotifyChange:
+		return !p.OptOutChange
+	case NotifyCancellation:
+		return !p.OptOutCancellation
+	case NotifyReminder:
+		return !p.OptOutReminder
+	}
+	return true
+}
+
+// 是否接收该渠道的通知
+func (p NotificationPreference) wantsChannel(channel string) bool {
+	switch channel {
+	case ChannelEmail:
+		return !p.OptOutEmail
+	case ChannelDooTask:
+		return !p.OptOutDooTask
+	}
+	return true
+}
+
+func (p NotificationPreference) language() string {
+	if p.Language == "en" {
+		return "en"
+	}
+	return "zh"
+}
+
+// 修改通知偏好请求体，未传递的字段保持原值
+type UpdateNotificationRequest struct {
+	Language           *string `json:"language"`
+	OptOutConfirmation *bool   `json:"opt_out_confirmation"`
+	OptOutChange       *bool   `json:"opt_out_change"`
+	OptOutCancellation *bool   `json:"opt_out_cancellation"`
+	OptOutReminder     *bool   `json:"opt_out_reminder"`
+	OptOutEmail        *bool   `json:"opt_out_email"`
+	OptOutDooTask      *bool   `json:"opt_out_dootask"`
+}
+
+// 发送测试消息请求体
+type TestNotificationRequest struct {
+	Channel string `json:"channel"` // email 或 dootask，默认 email
+	To      string `json:"to" binding:"required"`
+}
+
+// NotificationMessage 待发送的通知，渲染后写入数据库，由后台任务异步发送并重试
+type NotificationMessage struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Channel       string     `gorm:"index" json:"channel"` // email 或 dootask
+	UserID        uint       `gorm:"index" json:"user_id"`
+	Kind          string     `json:"kind"`
+	BookingID     uint       `gorm:"index" json:"booking_id"`
+	To            string     `json:"to"` // 邮箱或 DooTask 用户ID
+	Subject       string     `json:"subject"`
+	Body          string     `json:"body"`
+	Attachment    string     `json:"-"`                   // iCalendar 附件
+	Status        string     `gorm:"index" json:"status"` // pending、succeeded 或 failed
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"index" json:"next_attempt_at"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// notice 一次需要通知的预订操作
+type notice struct {
+	Kind       string
+	Bookings   []Booking
+	Room       Room
+	Attachment string // 预订的 iCalendar 内容
+}
+
+// noticeData 消息模板参数
+type noticeData struct {
+	Name         string // 收件人
+	Organizer    string
+	IsOrganizer  bool
+	Summary      string
+	Room         string
+	Start        string
+	End          string
+	TimeZone     string
+	Count        int // 周期预订的次数
+	CancelReason string
+	CancelledBy  string // 他人取消时的操作人
+}
+
+// 用户的通知偏好，没有记录时返回默认值
+func loadNotificationPreference(tx *gorm.DB, userID uint) NotificationPreference {
+	pref := NotificationPreference{UserID: userID}
+	tx.Where("user_id = ?", userID).First(&pref)
+	return pref
+}
+
+func displayName(u User) string {
+	if u.Nickname != "" {
+		return u.Nickname
+	}
+	return u.Username
+}
+
+// 为预订的预订人和参与人生成各渠道的通知并写入发送队列。
+// bookings 为同一次操作涉及的预订（单次预订或同一周期中的多次），skipUserID 为无需通知的操作人。
+// 应在修改预订的事务中调用，事务回滚时不会发送
+func notifyBookings(tx *gorm.DB, kind string, bookings []Booking, skipUserID uint) {
+	if !anyNotifierEnabled() || len(bookings) == 0 {
+		return
+	}
+	first := bookings[0]
+	var room Room
+	tx.First(&room, first.RoomID)
+	var organizer User
+	tx.First(&organizer, first.UserID)
+
+	recipients := []uint{first.UserID}
+	var participantIDs []uint
+	tx.Model(&BookingParticipant{}).Where("booking_id = ?", first.ID).Order("id").Pluck("user_id", &participantIDs)
+	recipients = append(recipients, participantIDs...)
+
+	n := notice{Kind: kind, Bookings: bookings, Room: room}
+	if kind != NotifyReminder {
+		n.Attachment = buildCalendar(tx, room.Name, inviteMethod(kind), bookings)
+	}
+	summary := first.Reason
+	if summary == "" {
+		summary = room.Name
+	}
+	data := noticeData{
+		Organizer:    displayName(organizer),
+		Summary:      summary,
+		Room:         roomLocationText(room),
+		Count:        len(bookings),
+		CancelReason: first.CancelReason,
+	}
+	if first.CancelledBy != nil && *first.CancelledBy != first.UserID {
+		var actor User
+		if tx.First(&actor, *first.CancelledBy).Error == nil {
+			data.CancelledBy = displayName(actor)
+		}
+	}
+
+	seen := make(map[uint]bool)
+	for _, id := range recipients {
+		if seen[id] || id == skipUserID {
+			continue
+		}
+		seen[id] = true
+		var user User
+		if err := tx.First(&user, id).Error; err != nil {
+			continue
+		}
+		pref := loadNotificationPreference(tx, id)
+		if !pref.wants(kind) {
+			continue
+		}
+		// 优先使用收件人的时区显示时间
+		loc := room.location()
+		if user.TimeZone != "" {
+			loc = loadLocation(user.TimeZone)
+		}
+		d := data
+		d.Name = displayName(user)
+		d.IsOrganizer = id == first.UserID
+		d.Start = first.StartTime.In(loc).Format("2006-01-02 15:04")
+		d.End = first.EndTime.In(loc).Format("2006-01-02 15:04")
+		d.TimeZone = loc.String()
+		if loc == time.Local {
+			d.TimeZone = first.StartTime.In(loc).Format("MST")
+		}
+		for _, nf := range notifiers {
+			if !nf.enabled() || !pref.wantsChannel(nf.channel()) {
+				continue
+			}
+			msg, ok := nf.compose(n, user, pref.language(), d)
+			if !ok {
+				continue
+			}
+			msg.Channel = nf.channel()
+			msg.UserID = id
+			msg.Kind = kind
+			msg.BookingID = first.ID
+			enqueueNotification(tx, msg)
+		}
+	}
+}
+
+func enqueueNotification(tx *gorm.DB, msg NotificationMessage) NotificationMessage {
+	msg.Status = DeliveryPending
+	msg.NextAttemptAt = time.Now().UTC()
+	if err := tx.Create(&msg).Error; err != nil {
+		log.Printf("写入通知队列失败: %v", err)
+	}
+	return msg
+}
+
+// 发送一条队列中的通知并更新记录，失败时记录日志并按退避时间重试
+func deliverNotification(msg *NotificationMessage) {
+	now := time.Now().UTC()
+	msg.Attempts++
+	msg.LastAttemptAt = &now
+	msg.Error = ""
+	var err error
+	nf := findNotifier(msg.Channel)
+	switch {
+	case nf == nil:
+		err = errUnknownChannel
+		msg.Attempts = notifyMaxAttempts
+	case !nf.enabled():
+		err = errChannelDisabled
+		msg.Attempts = notifyMaxAttempts
+	default:
+		err = nf.send(*msg)
+	}
+	switch {
+	case err == nil:
+		msg.Status = DeliverySucceeded
+	case msg.Attempts >= notifyMaxAttempts:
+		msg.Status = DeliveryFailed
+		msg.Error = err.Error()
+	default:
+		msg.Error = err.Error()
+		msg.NextAttemptAt = now.Add(retryBackoff(msg.Attempts, notifyBaseBackoff, notifyMaxBackoff))
+	}
+	if err != nil {
+		log.Printf("发送 %s 通知 %d 失败（第 %d 次）: %v", msg.Channel, msg.ID, msg.Attempts, err)
+	}
+	if err := db.Save(msg).Error; err != nil {
+		log.Printf("更新通知记录失败: %v", err)
+	}
+}
+
+// 后台发送队列中的通知，没有启用任何渠道时不启动
+func runNotificationWorker(interval time.Duration) {
+	if !anyNotifierEnabled() {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for {
+			var messages []NotificationMessage
+			db.Where("status = ? AND next_attempt_at <= ?", DeliveryPending, time.Now().UTC()).
+				Order("next_attempt_at").Limit(notifyBatchSize).Find(&messages)
+			for i := range messages {
+				deliverNotification(&messages[i])
+			}
+			if len(messages) < notifyBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// @Summary 获取通知偏好
+// @Description 获取当前用户的通知语言及退订设置
+// @Tags 通知
+// @Produce json
+// @Success 200 {object} NotificationPreference
+// @Security Bearer
+// @Router /api/user/notifications [get]
+func getNotificationPreferenceHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	pref := loadNotificationPreference(db, userID)
+	pref.Language = pref.language()
+	c.JSON(http.StatusOK, pref)
+}
+
+// @Summary 修改通知偏好
+// @Description 修改当前用户的通知语言（zh 或 en）、各类通知及各渠道的退订设置
+// @Tags 通知
+// @Accept json
+// @Produce json
+// @Param data body UpdateNotificationRequest true "通知偏好"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/user/notifications [put]
+func updateNotificationPreferenceHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req UpdateNotificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	pref := loadNotificationPreference(db, userID)
+	before := pref
+	if req.Language != nil {
+		if *req.Language != "zh" && *req.Language != "en" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "语言只能是 zh 或 en"})
+			return
+		}
+		pref.Language = *req.Language
+	}
+	for _, f := range []struct {
+		value  *bool
+		target *bool
+	}{
+		{req.OptOutConfirmation, &pref.OptOutConfirmation},
+		{req.OptOutChange, &pref.OptOutChange},
+		{req.OptOutCancellation, &pref.OptOutCancellation},
+		{req.OptOutReminder, &pref.OptOutReminder},
+		{req.OptOutEmail, &pref.OptOutEmail},
+		{req.OptOutDooTask, &pref.OptOutDooTask},
+	} {
+		if f.value != nil {
+			*f.target = *f.value
+		}
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&pref).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "notification_preference.update", "user", userID, before, pref)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	pref.Language = pref.language()
+	c.JSON(http.StatusOK, gin.H{"message": "保存成功", "preference": pref})
+}
+
+// @Summary 发送测试消息
+// @Description 管理员通过指定渠道发送测试消息，用于检查配置。email 渠道填写邮箱，dootask 渠道填写 DooTask 用户ID
+// @Tags 通知
+// @Accept json
+// @Produce json
+// @Param data body TestNotificationRequest true "渠道及收件地址"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/notifications/test [post]
+func testNotificationHandler(c *gin.Context) {
+	var req TestNotificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	if req.Channel == "" {
+		req.Channel = ChannelEmail
+	}
+	nf := findNotifier(req.Channel)
+	if nf == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "通知渠道无效"})
+		return
+	}
+	if !nf.enabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "该通知渠道未配置"})
+		return
+	}
+	if !nf.validRecipient(req.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "收件地址无效"})
+		return
+	}
+	userID, _ := currentUserID(c)
+	pref := loadNotificationPreference(db, userID)
+	msg, ok := nf.compose(notice{Kind: NotifyTest}, User{Email: req.To, DooTaskUserID: req.To}, pref.language(), noticeData{})
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成消息失败"})
+		return
+	}
+	msg.Channel = nf.channel()
+	msg.UserID = userID
+	msg.Kind = NotifyTest
+	msg = enqueueNotification(db, msg)
+	recordAudit(db, c, "notification.test", "notification", msg.ID, nil, gin.H{"channel": msg.Channel, "to": req.To})
+	c.JSON(http.StatusOK, gin.H{"message": "已加入发送队列", "notification": msg})
+}
+
+// @Summary 查询通知队列
+// @Description 管理员分页查询通知发送记录，可按渠道和状态筛选
+// @Tags 通知
+// @Produce json
+// @Param channel query string false "email 或 dootask"
+// @Param status query string false "pending、succeeded 或 failed"
+// @Param page query int false "页码，默认1"
+// @Param page_size query int false "每页数量，默认20，最大100"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/notifications/messages [get]
+func listNotificationMessagesHandler(c *gin.Context) {
+	page, pageSize := parsePage(c)
+	query := db.Model(&NotificationMessage{})
+	if channel := c.Query("channel"); channel != "" {
+		query = query.Where("channel = ?", channel)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var total int64
+	query.Count(&total)
+	var messages []NotificationMessage
+	query.Order("id DESC").Scopes(paginate(page, pageSize)).Find(&messages)
+	if messages == nil {
+		messages = []NotificationMessage{}
+	}
+	channels := gin.H{}
+	for _, nf := range notifiers {
+		channels[nf.channel()] = nf.enabled()
+	}
+	c.JSON(http.StatusOK, gin.H{"messages": messages, "total": total, "page": page, "page_size": pageSize, "channels": channels})
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 配额作用范围
+const (
+	QuotaScopeRoom  = "room"
+	QuotaScopeUser  = "user"
+	QuotaScopeGroup = "group"
+)
+
+// BookingQuota 会议室、用户或用户组的预订配额，0 表示不限制
+// 用户配额优先于用户组配额，二者都不存在时使用系统设置中的全局配额；
+// 会议室配额额外限制每个用户在该会议室内的预订
+type BookingQuota struct {
+	ID                uint    `gorm:"primaryKey" json:"id"`
+	Scope             string  `gorm:"index" json:"scope"` // room、user 或 group
+	RoomID            uint    `json:"room_id,omitempty"`
+	UserID            uint    `json:"user_id,omitempty"`
+	GroupName         string  `json:"group_name,omitempty"`
+	HoursPerWeek      float64 `json:"hours_per_week"`
+	MaxActiveBookings int     `json:"max_active_bookings"`
+	MaxSeriesLength   int     `json:"max_series_length"`
+}
+
+// QuotaLimits 生效的配额限制
+type QuotaLimits struct {
+	Source            string  `json:"source"` // global、group、user 或 room
+	HoursPerWeek      float64 `json:"hours_per_week"`
+	MaxActiveBookings int     `json:"max_active_bookings"`
+	MaxSeriesLength   int     `json:"max_series_length"`
+}
+
+// QuotaUsage 当前配额使用情况
+type QuotaUsage struct {
+	HoursThisWeek  float64 `json:"hours_this_week"`
+	ActiveBookings int64   `json:"active_bookings"`
+}
+
+// 保存配额请求体
+type SaveQuotaRequest struct {
+	Scope             string  `json:"scope" binding:"required"`
+	RoomID            uint    `json:"room_id"`
+	UserID            uint    `json:"user_id"`
+	GroupName         string  `json:"group_name"`
+	HoursPerWeek      float64 `json:"hours_per_week"`
+	MaxActiveBookings int     `json:"max_active_bookings"`
+	MaxSeriesLength   int     `json:"max_series_length"`
+}
+
+// 管理员修改用户组请求体
+type ChangeUserGroupRequest struct {
+	UserID uint   `json:"user_id" binding:"required"`
+	Group  string `json:"group"`
+}
+
+// quotaError 超出配额时返回，Error() 为面向用户的提示
+type quotaError struct {
+	msg string
+}
+
+func (e *quotaError) Error() string { return e.msg }
+
+func (q BookingQuota) limits() QuotaLimits {
+	return QuotaLimits{
+		Source:            q.Scope,
+		HoursPerWeek:      q.HoursPerWeek,
+		MaxActiveBookings: q.MaxActiveBookings,
+		MaxSeriesLength:   q.MaxSeriesLength,
+	}
+}
+
+// 计算用户生效的配额：用户配额 > 用户组配额 > 全局配额
+func effectiveQuota(tx *gorm.DB, user User) (QuotaLimits, error) {
+	var quota BookingQuota
+	if err := tx.Where("scope = ? AND user_id = ?", QuotaScopeUser, user.ID).First(&quota).Error; err == nil {
+		return quota.limits(), nil
+	}
+	if user.Group != "" {
+		if err := tx.Where("scope = ? AND group_name = ?", QuotaScopeGroup, user.Group).First(&quota).Error; err == nil {
+			return quota.limits(), nil
+		}
+	}
+	settings, err := loadSystemSettingsTx(tx)
+	if err != nil {
+		return QuotaLimits{}, err
+	}
+	return QuotaLimits{
+		Source:            "global",
+		HoursPerWeek:      settings.QuotaHoursPerWeek,
+		MaxActiveBookings: settings.QuotaMaxActiveBookings,
+		MaxSeriesLength:   settings.QuotaMaxSeriesLength,
+	}, nil
+}
+
+// 获取会议室配额，不存在时返回 nil
+func roomQuota(tx *gorm.DB, roomID uint) *QuotaLimits {
+	var quota BookingQuota
+	if err := tx.Where("scope = ? AND room_id = ?", QuotaScopeRoom, roomID).First(&quota).Error; err != nil {
+		return nil
+	}
+	limits := quota.limits()
+	return &limits
+}
+
+// 返回 t 所在自然周（按 t 的时区，周一 00:00 起）的起止时间
+func weekRange(t time.Time) (time.Time, time.Time) {
+	offset := (int(t.Weekday()) + 6) % 7
+	start := time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+	return start, time.Date(start.Year(), start.Month(), start.Day()+7, 0, 0, 0, 0, t.Location())
+}
+
+// 用户预订查询，roomID 为 0 时统计所有会议室，excludeID 非 0 时不计入该预订
+func userBookingsQuery(tx *gorm.DB, userID, roomID, excludeID uint) *gorm.DB {
+	q := tx.Model(&Booking{}).Scopes(activeBookings).Where("user_id = ?", userID)
+	if roomID != 0 {
+		q = q.Where("room_id = ?", roomID)
+	}
+	if excludeID != 0 {
+		q = q.Where("id <> ?", excludeID)
+	}
+	return q
+}
+
+// 统计用户在 weekOf 所在自然周内的预订时长
+func weeklyHours(tx *gorm.DB, userID, roomID, excludeID uint, weekOf time.Time) (float64, error) {
+	weekStart, weekEnd := weekRange(weekOf)
+	var bookings []Booking
+	if err := userBookingsQuery(tx, userID, roomID, excludeID).Where("start_time >= ? AND start_time < ?", weekStart.UTC(), weekEnd.UTC()).Find(&bookings).Error; err != nil {
+		return 0, err
+	}
+	var hours float64
+	for _, b := range bookings {
+		hours += b.EndTime.Sub(b.StartTime).Hours()
+	}
+	return hours, nil
+}
+
+// 统计用户的配额使用量，周时长按 weekOf 的时区计算
+func quotaUsage(tx *gorm.DB, userID, roomID uint, weekOf time.Time) (QuotaUsage, error) {
+	var usage QuotaUsage
+	hours, err := weeklyHours(tx, userID, roomID, 0, weekOf)
+	if err != nil {
+		return usage, err
+	}
+	usage.HoursThisWeek = hours
+	if err := userBookingsQuery(tx, userID, roomID, 0).Where("end_time > ?", time.Now().UTC()).Count(&usage.ActiveBookings).Error; err != nil {
+		return usage, err
+	}
+	return usage, nil
+}
+
+// 判断新增 slots 中的预订后是否超出配额，每周时长按 loc 时区的自然周分别计算；
+// 修改已有预订时 excludeID 为其ID，已用量不计入自身
+func exceedsQuota(tx *gorm.DB, limits QuotaLimits, userID, roomID, excludeID uint, slots []timeSlot, loc *time.Location) error {
+	if limits.MaxSeriesLength > 0 && len(slots) > 1 && len(slots) > limits.MaxSeriesLength {
+		return &quotaError{fmt.Sprintf("超出周期预订次数配额（本次 %d 次，上限 %d 次）", len(slots), limits.MaxSeriesLength)}
+	}
+	if limits.MaxActiveBookings > 0 {
+		var active int64
+		if err := userBookingsQuery(tx, userID, roomID, excludeID).Where("end_time > ?", time.Now().UTC()).Count(&active).Error; err != nil {
+			return err
+		}
+		if active+int64(len(slots)) > int64(limits.MaxActiveBookings) {
+			return &quotaError{fmt.Sprintf("超出未完成预订数量配额（已有 %d 个，上限 %d 个）", active, limits.MaxActiveBookings)}
+		}
+	}
+	if limits.HoursPerWeek > 0 {
+		// 按自然周汇总本次新增的时长
+		added := make(map[time.Time]float64)
+		for _, slot := range slots {
+			weekStart, _ := weekRange(slot.Start.In(loc))
+			added[weekStart] += slot.End.Sub(slot.Start).Hours()
+		}
+		for weekStart, hours := range added {
+			used, err := weeklyHours(tx, userID, roomID, excludeID, weekStart)
+			if err != nil {
+				return err
+			}
+			if used+hours > limits.HoursPerWeek {
+				return &quotaError{fmt.Sprintf("超出每周预订时长配额（%s 当周已用 %.1f 小时，上限 %.1f 小时）", weekStart.Format("2006-01-02"), used, limits.HoursPerWeek)}
+			}
+		}
+	}
+	return nil
+}
+
+// 检查用户新增预订是否超出配额，slots 为本次创建的所有预订时间段，excludeID 同 exceedsQuota
+func checkBookingQuota(tx *gorm.DB, userID, roomID, excludeID uint, slots []timeSlot) error {
+	var user User
+	if err := tx.First(&user, userID).Error; err != nil {
+		return err
+	}
+	var room Room
+	if err := tx.First(&room, roomID).Error; err != nil {
+		return err
+	}
+	loc := room.location()
+
+	limits, err := effectiveQuota(tx, user)
+	if err != nil {
+		return err
+	}
+	if err := exceedsQuota(tx, limits, userID, 0, excludeID, slots, loc); err != nil {
+		return err
+	}
+
+	if rq := roomQuota(tx, roomID); rq != nil {
+		if err := exceedsQuota(tx, *rq, userID, roomID, excludeID, slots, loc); err != nil {
+			var qe *quotaError
+			if errors.As(err, &qe) {
+				return &quotaError{"该会议室" + qe.Error()}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// @Summary 查询个人配额
+// @Description 查询当前用户的预订配额及本周使用情况
+// @Tags 用户
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/user/quota [get]
+func userQuotaHandler(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var user User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户信息已失效，请重新登录"})
+		return
+	}
+	limits, err := effectiveQuota(db, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取配额失败"})
+		return
+	}
+	// 全局周时长按用户偏好时区计算
+	now := time.Now().In(loadLocation(user.TimeZone))
+	usage, err := quotaUsage(db, userID, 0, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取配额使用情况失败"})
+		return
+	}
+
+	// 会议室配额
+	var quotas []BookingQuota
+	db.Where("scope = ?", QuotaScopeRoom).Find(&quotas)
+	roomQuotas := make([]gin.H, 0, len(quotas))
+	for _, q := range quotas {
+		// 会议室周时长按会议室时区计算
+		var room Room
+		db.Select("id", "time_zone").First(&room, q.RoomID)
+		roomUsage, err := quotaUsage(db, userID, q.RoomID, now.In(room.location()))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取配额使用情况失败"})
+			return
+		}
+		roomQuotas = append(roomQuotas, gin.H{
+			"room_id": q.RoomID,
+			"limits":  q.limits(),
+			"usage":   roomUsage,
+		})
+	}
+
+	weekStart, weekEnd := weekRange(now)
+	c.JSON(http.StatusOK, gin.H{
+		"limits":      limits,
+		"usage":       usage,
+		"room_quotas": roomQuotas,
+		"week_start":  weekStart,
+		"week_end":    weekEnd,
+	})
+}
+
+// @Summary 查询配额列表
+// @Description 管理员查询会议室、用户和用户组配额
+// @Tags 管理员
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/quotas [get]
+func listQuotasHandler(c *gin.Context) {
+	var quotas []BookingQuota
+	if err := db.Order("scope, id").Find(&quotas).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询配额失败"})
+		return
+	}
+	settings, err := loadSystemSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取系统设置失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"quotas": quotas,
+		"global": QuotaLimits{
+			Source:            "global",
+			HoursPerWeek:      settings.QuotaHoursPerWeek,
+			MaxActiveBookings: settings.QuotaMaxActiveBookings,
+			MaxSeriesLength:   settings.QuotaMaxSeriesLength,
+		},
+	})
+}
+
+// @Summary 设置配额
+// @Description 管理员新增或更新会议室、用户或用户组配额，全局配额通过系统设置修改
+// @Tags 管理员
+// @Accept json
+// @Produce json
+// @Param data body SaveQuotaRequest true "配额参数"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/quotas [post]
+func saveQuotaHandler(c *gin.Context) {
+	var req SaveQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	if req.HoursPerWeek < 0 || req.MaxActiveBookings < 0 || req.MaxSeriesLength < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "配额不能为负数"})
+		return
+	}
+
+	query := db.Where("scope = ?", req.Scope)
+	switch req.Scope {
+	case QuotaScopeRoom:
+		var room Room
+		if err := db.First(&room, req.RoomID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "会议室不存在"})
+			return
+		}
+		query = query.Where("room_id = ?", req.RoomID)
+		req.UserID, req.GroupName = 0, ""
+	case QuotaScopeUser:
+		var user User
+		if err := db.First(&user, req.UserID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "用户不存在"})
+			return
+		}
+		query = query.Where("user_id = ?", req.UserID)
+		req.RoomID, req.GroupName = 0, ""
+	case QuotaScopeGroup:
+		if req.GroupName == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "用户组不能为空"})
+			return
+		}
+		query = query.Where("group_name = ?", req.GroupName)
+		req.RoomID, req.UserID = 0, 0
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "配额范围无效"})
+		return
+	}
+
+	var quota BookingQuota
+	if err := query.First(&quota).Error; err != nil && err != gorm.ErrRecordNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询配额失败"})
+		return
+	}
+	var before interface{}
+	if quota.ID != 0 {
+		before = quota
+	}
+	quota.Scope = req.Scope
+	quota.RoomID = req.RoomID
+	quota.UserID = req.UserID
+	quota.GroupName = req.GroupName
+	quota.HoursPerWeek = req.HoursPerWeek
+	quota.MaxActiveBookings = req.MaxActiveBookings
+	quota.MaxSeriesLength = req.MaxSeriesLength
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&quota).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "quota.save", "quota", quota.ID, before, quota)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存配额失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "配额保存成功", "quota": quota})
+}
+
+// @Summary 删除配额
+// @Description 管理员删除指定配额
+// @Tags 管理员
+// @Param id path int true "配额ID"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/quotas/{id} [delete]
+func deleteQuotaHandler(c *gin.Context) {
+	var quota BookingQuota
+	if err := db.First(&quota, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "配额不存在"})
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&quota).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "quota.delete", "quota", quota.ID, quota, nil)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
+
+// @Summary 管理员修改用户组
+// @Description 管理员设置指定用户所属的用户组，留空表示不属于任何用户组
+// @Tags 管理员
+// @Accept json
+// @Produce json
+// @Param data body ChangeUserGroupRequest true "修改用户组参数"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/user/group [put]
+func adminChangeUserGroupHandler(c *gin.Context) {
+	var req ChangeUserGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	var user User
+	if err := db.First(&user, req.UserID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	before := user
+	user.Group = req.Group
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "user.group", "user", user.ID, before, user)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "用户组更新成功"})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 用户配额 > 用户组配额 > 全局配额
+func TestEffectiveQuota(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, tx *gorm.DB) {
+		user := User{Username: "alice", Role: "user", Group: "sales"}
+		settings := SystemSettings{AllowRegister: true, QuotaHoursPerWeek: 10, QuotaMaxActiveBookings: 5}
+		for _, v := range []interface{}{&user, &settings} {
+			if err := tx.Create(v).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		check := func(source string, hours float64) {
+			t.Helper()
+			limits, err := effectiveQuota(tx, user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if limits.Source != source || limits.HoursPerWeek != hours {
+				t.Errorf("生效配额 %+v，应来自 %s，每周 %.0f 小时", limits, source, hours)
+			}
+		}
+		check("global", 10)
+		if err := tx.Create(&BookingQuota{Scope: QuotaScopeGroup, GroupName: "sales", HoursPerWeek: 5}).Error; err != nil {
+			t.Fatal(err)
+		}
+		check(QuotaScopeGroup, 5)
+		if err := tx.Create(&BookingQuota{Scope: QuotaScopeUser, UserID: user.ID, HoursPerWeek: 2}).Error; err != nil {
+			t.Fatal(err)
+		}
+		check(QuotaScopeUser, 2)
+		// 其他用户组的配额不生效
+		user.Group = "support"
+		tx.Where("scope = ?", QuotaScopeUser).Delete(&BookingQuota{})
+		check("global", 10)
+	})
+}
+
+func TestCheckBookingQuota(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, tx *gorm.DB) {
+		user := User{Username: "alice", Role: "user"}
+		room := Room{Name: "A101", TimeZone: "Asia/Shanghai"}
+		for _, v := range []interface{}{&user, &room} {
+			if err := tx.Create(v).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		loc := room.location()
+		// 两周后的周一 00:00（会议室当地时间）
+		weekStart, _ := weekRange(time.Now().In(loc))
+		monday := weekStart.AddDate(0, 0, 14)
+		slot := func(offset, d time.Duration) timeSlot {
+			start := monday.Add(offset).UTC()
+			return timeSlot{Start: start, End: start.Add(d)}
+		}
+		book := func(s timeSlot, status string) {
+			t.Helper()
+			b := Booking{RoomID: room.ID, UserID: user.ID, StartTime: s.Start, EndTime: s.End, Status: status}
+			if err := tx.Create(&b).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		setQuota := func(q BookingQuota) {
+			t.Helper()
+			tx.Where("1 = 1").Delete(&BookingQuota{})
+			if err := tx.Create(&q).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		expect := func(name string, slots []timeSlot, want string) {
+			t.Helper()
+			err := checkBookingQuota(tx, user.ID, room.ID, 0, slots)
+			var qe *quotaError
+			switch {
+			case want == "" && err != nil:
+				t.Errorf("%s：应通过，返回 %v", name, err)
+			case want != "" && (!errors.As(err, &qe) || !strings.HasPrefix(qe.Error(), want)):
+				t.Errorf("%s：返回 %v，应以 %q 开头", name, err, want)
+			}
+		}
+
+		// 每周时长按会议室时区的自然周计算：周日 22:00 与周一 00:30 属于不同的周，
+		// 但在 UTC 中同属周日
+		setQuota(BookingQuota{Scope: QuotaScopeUser, UserID: user.ID, HoursPerWeek: 2})
+		book(slot(-2*time.Hour, 90*time.Minute), BookingStatusActive)
+		expect("下一周", []timeSlot{slot(30*time.Minute, 90*time.Minute)}, "")
+		expect("同一周", []timeSlot{slot(-26*time.Hour, time.Hour)}, "超出每周预订时长配额")
+
+		// 未完成预订数量，已取消的不计
+		setQuota(BookingQuota{Scope: QuotaScopeUser, UserID: user.ID, MaxActiveBookings: 2})
+		book(slot(24*time.Hour, time.Hour), BookingStatusCancelled)
+		expect("加 1 个", []timeSlot{slot(48*time.Hour, time.Hour)}, "")
+		expect("加 2 个", []timeSlot{slot(48*time.Hour, time.Hour), slot(72*time.Hour, time.Hour)}, "超出未完成预订数量配额")
+
+		// 周期预订次数，单次预订不受限制
+		setQuota(BookingQuota{Scope: QuotaScopeUser, UserID: user.ID, MaxSeriesLength: 2})
+		series := []timeSlot{slot(48*time.Hour, time.Hour), slot(7*24*time.Hour+48*time.Hour, time.Hour), slot(14*24*time.Hour+48*time.Hour, time.Hour)}
+		expect("3 次", series, "超出周期预订次数配额")
+		expect("2 次", series[:2], "")
+
+		// 会议室配额只统计该会议室的预订
+		setQuota(BookingQuota{Scope: QuotaScopeRoom, RoomID: room.ID, MaxActiveBookings: 1})
+		expect("会议室配额", []timeSlot{slot(48*time.Hour, time.Hour)}, "该会议室超出未完成预订数量配额")
+	})
+}
+
+// 超出配额时预订返回 403 及提示
+func TestBookRoomQuotaExceeded(t *testing.T) {
+	tx := setupTestDB(t)
+	user, room := createBookingFixtures(t, tx)
+	mustCreate(t, &BookingQuota{Scope: QuotaScopeUser, UserID: user.ID, HoursPerWeek: 1})
+	start := time.Now().UTC().Truncate(time.Hour).Add(48 * time.Hour)
+	code, resp := callHandler(t, bookRoomHandler, user, http.MethodPost, "/api/bookings", nil,
+		BookRoomRequest{RoomID: room.ID, StartTime: start, EndTime: start.Add(2 * time.Hour)})
+	if code != http.StatusForbidden || !strings.Contains(resp["error"].(string), "超出每周预订时长配额") {
+		t.Errorf("预订返回 %d：%v", code, resp)
+	}
+	var count int64
+	tx.Model(&Booking{}).Count(&count)
+	if count != 0 {
+		t.Errorf("超出配额后写入了 %d 个预订", count)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// 周期预订单次最多生成的次数
+const maxSeriesOccurrences = 200
+
+// 周期频率
+const (
+	RecurrenceDaily  = "daily"
+	RecurrenceWeekly = "weekly"
+)
+
+// RecurrenceRule 周期预订规则，count 与 until 至少指定一个
+type RecurrenceRule struct {
+	Freq     string     `json:"freq"`     // daily 或 weekly
+	Interval int        `json:"interval"` // 间隔，默认为1
+	Count    int        `json:"count"`    // 总次数（含首次）
+	Until    *time.Time `json:"until"`    // 最后一次开始时间不晚于该时间
+}
+
+// BookingSeries 周期预订，每次会议对应一条 Booking 记录
+type BookingSeries struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	RoomID    uint       `json:"room_id"`
+	UserID    uint       `json:"user_id"`
+	Freq      string     `json:"freq"`
+	Interval  int        `json:"interval"`
+	Count     int        `json:"count"`
+	Until     *time.Time `json:"until,omitempty"`
+	TimeZone  string     `json:"time_zone"` // 展开周期时使用的时区
+	StartTime time.Time  `json:"start_time"`
+	EndTime   time.Time  `json:"end_time"`
+}
+
+// 按会议室时区展开周期规则。每次会议保持相同的当地开始和结束时间，
+// 因此跨越夏令时切换时 UTC 时间会相应变化
+func expandRecurrence(rule RecurrenceRule, start, end time.Time, loc *time.Location) ([]timeSlot, error) {
+	interval := rule.Interval
+	if interval <= 0 {
+		interval = 1
+	}
+	var stepDays int
+	switch rule.Freq {
+	case RecurrenceDaily:
+		stepDays = interval
+	case RecurrenceWeekly:
+		stepDays = 7 * interval
+	default:
+		return nil, errors.New("周期频率无效")
+	}
+	if rule.Count <= 0 && rule.Until == nil {
+		return nil, errors.New("周期预订必须指定次数或截止时间")
+	}
+	if rule.Count > maxSeriesOccurrences {
+		return nil, errors.New("周期预订次数过多")
+	}
+
+	ls, le := start.In(loc), end.In(loc)
+	var slots []timeSlot
+	for i := 0; ; i++ {
+		if rule.Count > 0 && i >= rule.Count {
+			break
+		}
+		s := time.Date(ls.Year(), ls.Month(), ls.Day()+i*stepDays, ls.Hour(), ls.Minute(), ls.Second(), 0, loc)
+		if rule.Until != nil && s.After(*rule.Until) {
+			break
+		}
+		if i >= maxSeriesOccurrences {
+			return nil, errors.New("周期预订次数过多")
+		}
+		e := time.Date(le.Year(), le.Month(), le.Day()+i*stepDays, le.Hour(), le.Minute(), le.Second(), 0, loc)
+		if !e.After(s) {
+			return nil, errors.New("时间范围不合法")
+		}
+		if n := len(slots); n > 0 && s.Before(slots[n-1].End) {
+			return nil, errors.New("周期预订的时间段相互重叠")
+		}
+		slots = append(slots, timeSlot{Start: s.UTC(), End: e.UTC()})
+	}
+	if len(slots) == 0 {
+		return nil, errors.New("周期规则未生成任何预订")
+	}
+	return slots, nil
+}
+
+// 周期规则展开后的所有时间段（含已取消或被排除的日期）
+func (s BookingSeries) occurrences() ([]timeSlot, error) {
+	rule := RecurrenceRule{Freq: s.Freq, Interval: s.Interval, Count: s.Count}
+	return expandRecurrence(rule, s.StartTime, s.EndTime, loadLocation(s.TimeZone))
+}
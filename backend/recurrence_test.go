@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpandRecurrenceAcrossDST(t *testing.T) {
+	newYork := loadLocation("America/New_York")
+	berlin := loadLocation("Europe/Berlin")
+	shanghai := loadLocation("Asia/Shanghai")
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	until := utc("2026-05-04T02:00:00Z")
+
+	cases := []struct {
+		name       string
+		rule       RecurrenceRule
+		start, end string // 首次会议的 UTC 时间
+		loc        *time.Location
+		want       []string // 各次会议的 UTC 开始时间
+		duration   time.Duration
+	}{
+		{
+			// 2026-03-08 开始夏令时，当地 9:00 由 UTC 14:00 变为 13:00
+			name: "纽约每周跨夏令时开始", rule: RecurrenceRule{Freq: RecurrenceWeekly, Count: 3},
+			start: "2026-03-02T14:00:00Z", end: "2026-03-02T15:00:00Z", loc: newYork,
+			want:     []string{"2026-03-02T14:00:00Z", "2026-03-09T13:00:00Z", "2026-03-16T13:00:00Z"},
+			duration: time.Hour,
+		},
+		{
+			// 2026-10-25 结束夏令时，当地 9:00 由 UTC 7:00 变为 8:00
+			name: "柏林每两周跨夏令时结束", rule: RecurrenceRule{Freq: RecurrenceWeekly, Interval: 2, Count: 2},
+			start: "2026-10-19T07:00:00Z", end: "2026-10-19T07:30:00Z", loc: berlin,
+			want:     []string{"2026-10-19T07:00:00Z", "2026-11-02T08:00:00Z"},
+			duration: 30 * time.Minute,
+		},
+		{
+			name: "上海无夏令时，按截止时间", rule: RecurrenceRule{Freq: RecurrenceWeekly, Until: &until},
+			start: "2026-04-13T02:00:00Z", end: "2026-04-13T03:00:00Z", loc: shanghai,
+			want:     []string{"2026-04-13T02:00:00Z", "2026-04-20T02:00:00Z", "2026-04-27T02:00:00Z", "2026-05-04T02:00:00Z"},
+			duration: time.Hour,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			slots, err := expandRecurrence(tc.rule, utc(tc.start), utc(tc.end), tc.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(slots) != len(tc.want) {
+				t.Fatalf("生成 %d 次，应为 %d 次：%v", len(slots), len(tc.want), slots)
+			}
+			for i, want := range tc.want {
+				if !slots[i].Start.Equal(utc(want)) || slots[i].End.Sub(slots[i].Start) != tc.duration {
+					t.Errorf("第 %d 次 %v - %v，应从 %s 开始，时长 %v", i+1, slots[i].Start, slots[i].End, want, tc.duration)
+				}
+			}
+		})
+	}
+}
+
+func TestExpandRecurrenceErrors(t *testing.T) {
+	start := time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name string
+		rule RecurrenceRule
+		end  time.Time
+	}{
+		{"频率无效", RecurrenceRule{Freq: "monthly", Count: 2}, start.Add(time.Hour)},
+		{"未指定次数和截止时间", RecurrenceRule{Freq: RecurrenceDaily}, start.Add(time.Hour)},
+		{"次数过多", RecurrenceRule{Freq: RecurrenceDaily, Count: maxSeriesOccurrences + 1}, start.Add(time.Hour)},
+		{"时间段相互重叠", RecurrenceRule{Freq: RecurrenceDaily, Count: 2}, start.Add(25 * time.Hour)},
+	}
+	for _, tc := range cases {
+		if _, err := expandRecurrence(tc.rule, start, tc.end, time.UTC); err == nil {
+			t.Errorf("%s：应返回错误", tc.name)
+		}
+	}
+}
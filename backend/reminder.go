@@ -0,0 +1,225 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 提醒任务状态
+const (
+	ReminderPending   = "pending"
+	ReminderSent      = "sent"
+	ReminderCancelled = "cancelled"
+)
+
+// 提醒提前量上限（7 天）及调度间隔
+const (
+	maxReminderLeadMinutes = 7 * 24 * 60
+	reminderPollInterval   = 30 * time.Second
+)
+
+// ReminderJob 一次会议提醒，随预订创建、改期和取消同步更新。
+// 任务持久化在数据库中，服务重启后继续处理到期但未发送的提醒
+type ReminderJob struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	BookingID   uint       `gorm:"uniqueIndex:idx_reminder_job" json:"booking_id"`
+	LeadMinutes int        `gorm:"uniqueIndex:idx_reminder_job" json:"lead_minutes"` // 开始前多少分钟提醒
+	RemindAt    time.Time  `gorm:"index" json:"remind_at"`
+	Status      string     `gorm:"index" json:"status"` // pending、sent 或 cancelled
+	SentAt      *time.Time `json:"sent_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// 解析提醒提前量（逗号分隔的分钟数），返回去重排序后的结果
+func parseReminderLeads(value string) ([]int, bool) {
+	leads := []int{}
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n <= 0 || n > maxReminderLeadMinutes {
+			return nil, false
+		}
+		if !seen[n] {
+			seen[n] = true
+			leads = append(leads, n)
+		}
+	}
+	sort.Ints(leads)
+	return leads, true
+}
+
+func formatReminderLeads(leads []int) string {
+	parts := make([]string, len(leads))
+	for i, lead := range leads {
+		parts[i] = strconv.Itoa(lead)
+	}
+	return strings.Join(parts, ",")
+}
+
+// 当前配置的提醒提前量
+func reminderLeads(tx *gorm.DB) []int {
+	var settings SystemSettings
+	if err := tx.First(&settings).Error; err != nil {
+		return nil
+	}
+	leads, _ := parseReminderLeads(settings.ReminderLeadMinutes)
+	return leads
+}
+
+// 按预订的当前状态同步提醒任务：取消的预订取消未发送的提醒，改期后按新的开始时间重新安排。
+// 应在修改预订的事务中调用
+func syncReminders(tx *gorm.DB, bookings ...Booking) {
+	leads := reminderLeads(tx)
+	now := time.Now().UTC()
+	for _, b := range bookings {
+		var jobs []ReminderJob
+		tx.Where("booking_id = ?", b.ID).Find(&jobs)
+		existing := make(map[int]*ReminderJob, len(jobs))
+		for i := range jobs {
+			existing[jobs[i].LeadMinutes] = &jobs[i]
+		}
+		wanted := make(map[int]bool, len(leads))
+		if b.Status != BookingStatusCancelled {
+			for _, lead := range leads {
+				wanted[lead] = true
+			}
+		}
+		for _, lead := range leads {
+			if !wanted[lead] {
+				continue
+			}
+			remindAt := b.StartTime.UTC().Add(-time.Duration(lead) * time.Minute)
+			job, ok := existing[lead]
+			switch {
+			case !ok:
+				// 预订时已过提醒时间的不再提醒
+				if remindAt.After(now) {
+					job = &ReminderJob{BookingID: b.ID, LeadMinutes: lead, RemindAt: remindAt, Status: ReminderPending}
+					if err := tx.Create(job).Error; err != nil {
+						log.Printf("创建提醒任务失败: %v", err)
+					}
+				}
+			case !job.RemindAt.Equal(remindAt):
+				// 改期：按新时间重新提醒，已过提醒时间的取消
+				job.RemindAt = remindAt
+				job.SentAt = nil
+				job.Status = ReminderPending
+				if !remindAt.After(now) {
+					job.Status = ReminderCancelled
+				}
+				tx.Save(job)
+			case job.Status == ReminderCancelled && remindAt.After(now):
+				job.Status = ReminderPending
+				tx.Save(job)
+			}
+		}
+		for lead, job := range existing {
+			if !wanted[lead] && job.Status == ReminderPending {
+				job.Status = ReminderCancelled
+				tx.Save(job)
+			}
+		}
+	}
+}
+
+// 按当前配置为所有未开始的预订同步提醒任务，用于启动时补建任务及修改提醒设置后
+func resyncReminders() {
+	var bookings []Booking
+	activeBookings(db).Where("start_time > ?", time.Now().UTC()).Find(&bookings)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		syncReminders(tx, bookings...)
+		// 已取消预订遗留的待发送任务
+		return tx.Model(&ReminderJob{}).
+			Where("status = ? AND booking_id IN (?)", ReminderPending,
+				tx.Model(&Booking{}).Select("id").Where("status = ?", BookingStatusCancelled)).
+			Update("status", ReminderCancelled).Error
+	})
+	if err != nil {
+		log.Printf("同步提醒任务失败: %v", err)
+	}
+}
+
+// 发送一个到期的提醒。任务状态与通知在同一事务中写入，重启或重复调度时不会重复发送
+func runReminderJob(job ReminderJob) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var booking Booking
+		now := time.Now().UTC()
+		status := ReminderSent
+		if err := tx.First(&booking, job.BookingID).Error; err != nil ||
+			booking.Status == BookingStatusCancelled || !booking.StartTime.After(now) {
+			// 预订已删除、取消或已开始
+			status = ReminderCancelled
+		}
+		result := tx.Model(&ReminderJob{}).
+			Where("id = ? AND status = ? AND remind_at = ?", job.ID, ReminderPending, job.RemindAt).
+			Updates(map[string]interface{}{"status": status, "sent_at": now})
+		if result.Error != nil || result.RowsAffected == 0 {
+			// 已被处理或期间改期
+			return result.Error
+		}
+		if status == ReminderSent {
+			notifyBookings(tx, NotifyReminder, []Booking{booking}, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("处理提醒任务 %d 失败: %v", job.ID, err)
+	}
+}
+
+// 后台调度提醒：启动时补建任务，之后定期发送到期的提醒
+func runReminderScheduler(interval time.Duration) {
+	resyncReminders()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		var jobs []ReminderJob
+		db.Where("status = ? AND remind_at <= ?", ReminderPending, time.Now().UTC()).
+			Order("remind_at").Limit(100).Find(&jobs)
+		for _, job := range jobs {
+			runReminderJob(job)
+		}
+	}
+}
+
+// @Summary 查询提醒任务
+// @Description 管理员分页查询会议提醒任务，可按预订和状态筛选
+// @Tags 通知
+// @Produce json
+// @Param booking_id query int false "预订ID"
+// @Param status query string false "pending、sent 或 cancelled"
+// @Param page query int false "页码，默认1"
+// @Param page_size query int false "每页数量，默认20，最大100"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/reminders [get]
+func listRemindersHandler(c *gin.Context) {
+	page, pageSize := parsePage(c)
+	query := db.Model(&ReminderJob{})
+	if bookingID := c.Query("booking_id"); bookingID != "" {
+		query = query.Where("booking_id = ?", bookingID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var total int64
+	query.Count(&total)
+	var jobs []ReminderJob
+	query.Order("remind_at DESC").Scopes(paginate(page, pageSize)).Find(&jobs)
+	if jobs == nil {
+		jobs = []ReminderJob{}
+	}
+	c.JSON(http.StatusOK, gin.H{"reminders": jobs, "total": total, "page": page, "page_size": pageSize})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 提醒任务持久化后只发送一次：重启时补建任务或重复调度不会重复提醒，改期后按新时间重新提醒
+func TestReminderSentOnce(t *testing.T) {
+	tx := setupTestDB(t)
+	oldDooTask, oldMail := dooTaskSettings, mailSettings
+	dooTaskSettings = dooTaskConfig{BaseURL: "http://127.0.0.1:1", Token: "bot-token"}
+	mailSettings = mailConfig{}
+	t.Cleanup(func() { dooTaskSettings, mailSettings = oldDooTask, oldMail })
+
+	user := User{Username: "alice", Role: "user", DooTaskUserID: "42"}
+	room := Room{Name: "A101", TimeZone: "UTC"}
+	mustCreate(t, &user, &room, &SystemSettings{AllowRegister: true, ReminderLeadMinutes: "15,60"})
+	start := time.Now().UTC().Truncate(time.Second).Add(20 * time.Minute)
+	booking := Booking{RoomID: room.ID, UserID: user.ID, StartTime: start, EndTime: start.Add(time.Hour), Reason: "周会", Status: BookingStatusActive}
+	mustCreate(t, &booking)
+
+	pending := func() []ReminderJob {
+		var jobs []ReminderJob
+		tx.Where("status = ?", ReminderPending).Order("lead_minutes").Find(&jobs)
+		return jobs
+	}
+	reminders := func() int64 {
+		var n int64
+		tx.Model(&NotificationMessage{}).Where("kind = ?", NotifyReminder).Count(&n)
+		return n
+	}
+
+	// 预订时已过 60 分钟的提醒时间，只安排 15 分钟的提醒
+	syncReminders(tx, booking)
+	jobs := pending()
+	if len(jobs) != 1 || jobs[0].LeadMinutes != 15 || !jobs[0].RemindAt.Equal(start.Add(-15*time.Minute)) {
+		t.Fatalf("提醒任务 %+v", jobs)
+	}
+	stale := jobs[0]
+	runReminderJob(stale)
+	if n := reminders(); n != 1 {
+		t.Fatalf("发送了 %d 条提醒", n)
+	}
+
+	// 模拟重启：补建任务后再次调度同一任务
+	resyncReminders()
+	runReminderJob(stale)
+	if n, jobs := reminders(), pending(); n != 1 || len(jobs) != 0 {
+		t.Errorf("重启后发送了 %d 条提醒，待发送任务 %+v", n, jobs)
+	}
+	var sent ReminderJob
+	tx.First(&sent, stale.ID)
+	if sent.Status != ReminderSent || sent.SentAt == nil {
+		t.Errorf("提醒任务 %+v", sent)
+	}
+
+	// 改期后按新时间重新提醒，改期前加载的任务不再发送
+	booking.StartTime, booking.EndTime = start.Add(2*time.Hour), start.Add(3*time.Hour)
+	tx.Save(&booking)
+	syncReminders(tx, booking)
+	runReminderJob(stale)
+	jobs = pending()
+	if reminders() != 1 || len(jobs) != 2 || jobs[0].ID != stale.ID || !jobs[0].RemindAt.Equal(booking.StartTime.Add(-15*time.Minute)) {
+		t.Fatalf("改期后的提醒任务 %+v", jobs)
+	}
+
+	// 发送前预订已取消的，任务取消且不提醒
+	tx.Model(&booking).Update("status", BookingStatusCancelled)
+	for _, job := range jobs {
+		runReminderJob(job)
+	}
+	if n := reminders(); n != 1 {
+		t.Errorf("取消后发送了 %d 条提醒", n-1)
+	}
+	var cancelled int64
+	tx.Model(&ReminderJob{}).Where("status = ?", ReminderCancelled).Count(&cancelled)
+	if cancelled != 2 {
+		t.Errorf("取消了 %d 个提醒任务", cancelled)
+	}
+}
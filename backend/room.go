@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 会议室照片大小上限
+const maxRoomPhotoSize = 5 << 20
+
+// 允许上传的照片类型及扩展名
+var roomPhotoTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// 常用设施标签，也允许管理员使用自定义标签
+var knownAmenities = []string{"projector", "vc_system", "whiteboard", "wheelchair_access", "tv", "phone"}
+
+// RoomAmenity 会议室设施标签
+type RoomAmenity struct {
+	ID     uint   `gorm:"primaryKey" json:"-"`
+	RoomID uint   `gorm:"uniqueIndex:idx_room_amenity" json:"-"`
+	Name   string `gorm:"uniqueIndex:idx_room_amenity;index" json:"name"`
+}
+
+// RoomPhoto 会议室照片，文件保存在数据目录的 uploads/rooms 下
+type RoomPhoto struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	RoomID      uint      `gorm:"index" json:"room_id"`
+	FileName    string    `json:"-"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+	URL         string    `gorm:"-" json:"url"`
+}
+
+// 照片访问地址
+func (p *RoomPhoto) AfterFind(tx *gorm.DB) error {
+	p.URL = fmt.Sprintf("/api/room-photos/%d", p.ID)
+	return nil
+}
+
+// 会议室照片存放目录
+func roomPhotoDir(roomID uint) string {
+	return filepath.Join(dataDir, "uploads", "rooms", strconv.FormatUint(uint64(roomID), 10))
+}
+
+// 规范化设施标签：小写、去空格、去重
+func normalizeAmenities(names []string) []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		name = strings.ReplaceAll(name, " ", "_")
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// 替换会议室的设施标签
+func setRoomAmenities(tx *gorm.DB, roomID uint, names []string) error {
+	if err := tx.Where("room_id = ?", roomID).Delete(&RoomAmenity{}).Error; err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	amenities := make([]RoomAmenity, 0, len(names))
+	for _, name := range names {
+		amenities = append(amenities, RoomAmenity{RoomID: roomID, Name: name})
+	}
+	return tx.Create(&amenities).Error
+}
+
+// 为会议室填充设施标签和照片
+func loadRoomDetails(tx *gorm.DB, rooms []Room) {
+	if len(rooms) == 0 {
+		return
+	}
+	ids := make([]uint, 0, len(rooms))
+	for _, r := range rooms {
+		ids = append(ids, r.ID)
+	}
+	var amenities []RoomAmenity
+	tx.Where("room_id IN ?", ids).Order("name").Find(&amenities)
+	var photos []RoomPhoto
+	tx.Where("room_id IN ?", ids).Order("id").Find(&photos)
+
+	amenityMap := make(map[uint][]string)
+	for _, a := range amenities {
+		amenityMap[a.RoomID] = append(amenityMap[a.RoomID], a.Name)
+	}
+	photoMap := make(map[uint][]RoomPhoto)
+	for _, p := range photos {
+		photoMap[p.RoomID] = append(photoMap[p.RoomID], p)
+	}
+	for i := range rooms {
+		rooms[i].Amenities = amenityMap[rooms[i].ID]
+		if rooms[i].Amenities == nil {
+			rooms[i].Amenities = []string{}
+		}
+		rooms[i].Photos = photoMap[rooms[i].ID]
+		if rooms[i].Photos == nil {
+			rooms[i].Photos = []RoomPhoto{}
+		}
+	}
+}
+
+// 删除会议室的设施标签、照片和门口平板记录，照片文件在事务提交后由 removeRoomPhotoDir 删除
+func deleteRoomAssets(tx *gorm.DB, roomID uint) error {
+	if err := tx.Where("room_id = ?", roomID).Delete(&RoomAmenity{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("room_id = ?", roomID).Delete(&RoomPhoto{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("room_id = ?", roomID).Delete(&KioskDevice{}).Error
+}
+
+// 删除会议室的照片目录
+func removeRoomPhotoDir(roomID uint) {
+	if err := os.RemoveAll(roomPhotoDir(roomID)); err != nil {
+		log.Printf("删除会议室照片目录失败: %v", err)
+	}
+}
+
+// 生成随机文件名
+func randomFileName(ext string) (string, error) {
+	name, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	return name + ext, nil
+}
+
+// @Summary 查询会议室筛选项
+// @Description 返回园区、楼宇、楼层的层级结构以及可用的设施标签
+// @Tags 会议室
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/rooms/filters [get]
+func roomFiltersHandler(c *gin.Context) {
+	var rooms []Room
+	db.Select("site", "building", "floor").Find(&rooms)
+
+	// site -> building -> floors
+	tree := make(map[string]map[string]map[string]bool)
+	for _, r := range rooms {
+		if tree[r.Site] == nil {
+			tree[r.Site] = make(map[string]map[string]bool)
+		}
+		if tree[r.Site][r.Building] == nil {
+			tree[r.Site][r.Building] = make(map[string]bool)
+		}
+		if r.Floor != "" {
+			tree[r.Site][r.Building][r.Floor] = true
+		}
+	}
+	sites := make([]gin.H, 0, len(tree))
+	for site, buildings := range tree {
+		buildingList := make([]gin.H, 0, len(buildings))
+		for building, floors := range buildings {
+			floorList := make([]string, 0, len(floors))
+			for floor := range floors {
+				floorList = append(floorList, floor)
+			}
+			sort.Strings(floorList)
+			buildingList = append(buildingList, gin.H{"name": building, "floors": floorList})
+		}
+		sort.Slice(buildingList, func(i, j int) bool {
+			return buildingList[i]["name"].(string) < buildingList[j]["name"].(string)
+		})
+		sites = append(sites, gin.H{"name": site, "buildings": buildingList})
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		return sites[i]["name"].(string) < sites[j]["name"].(string)
+	})
+
+	var used []string
+	db.Model(&RoomAmenity{}).Distinct("name").Order("name").Pluck("name", &used)
+	amenities := normalizeAmenities(append(append([]string{}, knownAmenities...), used...))
+
+	c.JSON(http.StatusOK, gin.H{"sites": sites, "amenities": amenities})
+}
+
+// @Summary 上传会议室照片
+// @Description 管理员上传会议室照片（jpeg/png/gif/webp，最大5MB）
+// @Tags 会议室
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path int true "会议室ID"
+// @Param file formData file true "照片文件"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/rooms/{id}/photos [post]
+func uploadRoomPhotoHandler(c *gin.Context) {
+	var room Room
+	if err := db.First(&room, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会议室不存在"})
+		return
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请选择要上传的照片"})
+		return
+	}
+	if fileHeader.Size > maxRoomPhotoSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "照片不能超过5MB"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取照片失败"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxRoomPhotoSize+1))
+	if err != nil || len(data) > maxRoomPhotoSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取照片失败"})
+		return
+	}
+	// 按文件内容判断类型，不信任客户端提供的扩展名
+	contentType := http.DetectContentType(data)
+	ext, ok := roomPhotoTypes[contentType]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "仅支持 jpeg、png、gif、webp 格式"})
+		return
+	}
+
+	dir := roomPhotoDir(room.ID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存照片失败"})
+		return
+	}
+	name, err := randomFileName(ext)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存照片失败"})
+		return
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存照片失败"})
+		return
+	}
+	photo := RoomPhoto{
+		RoomID:      room.ID,
+		FileName:    name,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&photo).Error; err != nil {
+			return err
+		}
+		photo.AfterFind(tx)
+		recordAudit(tx, c, "room.photo_upload", "room", room.ID, nil, photo)
+		return nil
+	})
+	if err != nil {
+		os.Remove(filepath.Join(dir, name))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存照片失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "上传成功", "photo": photo})
+}
+
+// @Summary 删除会议室照片
+// @Description 管理员删除会议室照片
+// @Tags 会议室
+// @Param id path int true "会议室ID"
+// @Param photo_id path int true "照片ID"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/rooms/{id}/photos/{photo_id} [delete]
+func deleteRoomPhotoHandler(c *gin.Context) {
+	var photo RoomPhoto
+	if err := db.Where("room_id = ?", c.Param("id")).First(&photo, c.Param("photo_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "照片不存在"})
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&photo).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "room.photo_delete", "room", photo.RoomID, photo, nil)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	if err := os.Remove(filepath.Join(roomPhotoDir(photo.RoomID), photo.FileName)); err != nil && !os.IsNotExist(err) {
+		log.Printf("删除会议室照片文件失败: %v", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
+
+// @Summary 获取会议室照片
+// @Description 返回照片文件内容，无需登录以便直接用于 img 标签
+// @Tags 会议室
+// @Produce image/jpeg,image/png,image/gif,image/webp
+// @Param id path int true "照片ID"
+// @Success 200 {file} file
+// @Router /api/room-photos/{id} [get]
+func serveRoomPhotoHandler(c *gin.Context) {
+	var photo RoomPhoto
+	if err := db.First(&photo, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "照片不存在"})
+		return
+	}
+	c.Header("Content-Type", photo.ContentType)
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.File(filepath.Join(roomPhotoDir(photo.RoomID), photo.FileName))
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 实时事件流的保留时长、轮询间隔和心跳间隔
+const (
+	changeEventRetention    = 24 * time.Hour
+	streamPollInterval      = time.Second
+	streamHeartbeatInterval = 25 * time.Second
+	streamRetryMillis       = 3000
+	streamBatchSize         = 500              // 每次查询的事件数
+	streamRescanWindow      = 100              // 开始推送时回看的事件ID数
+	streamGapTimeout        = 30 * time.Second // 事件ID空缺超过此时长视为事务已回滚
+)
+
+// ChangeEvent 推送给实时订阅者的预订和会议室变更。
+// 自增ID作为断线重连的游标，客户端通过 Last-Event-ID 或 cursor 参数补发错过的事件
+type ChangeEvent struct {
+	ID        uint       `gorm:"primaryKey"`
+	EventID   string     `gorm:"index"`
+	Event     string     `gorm:"index"`
+	RoomID    uint       `gorm:"index"`
+	BookingID uint       // 会议室事件为 0
+	StartTime *time.Time // 预订时间，用于按日期筛选
+	EndTime   *time.Time
+	Private   bool      // 私密预订，推送前按订阅者脱敏
+	Payload   string    `gorm:"type:text"` // 与 Webhook 相同的事件内容
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// 推送给实时订阅者的事件：预订和会议室变更
+func streamedEvent(event string) bool {
+	return strings.HasPrefix(event, "booking.") || strings.HasPrefix(event, "room.")
+}
+
+// 写入实时事件，应在触发事件的事务中调用
+func recordChangeEvent(tx *gorm.DB, eventID, event string, data interface{}, payload string) {
+	if !streamedEvent(event) {
+		return
+	}
+	ev := ChangeEvent{EventID: eventID, Event: event, Payload: payload}
+	switch d := data.(type) {
+	case gin.H:
+		if b, ok := d["booking"].(Booking); ok {
+			start, end := b.StartTime.UTC(), b.EndTime.UTC()
+			ev.RoomID = b.RoomID
+			ev.BookingID = b.ID
+			ev.StartTime, ev.EndTime = &start, &end
+			ev.Private = b.Visibility == VisibilityPrivate
+		}
+	case Room:
+		ev.RoomID = d.ID
+	}
+	if err := tx.Create(&ev).Error; err != nil {
+		log.Printf("写入实时事件失败: %v", err)
+	}
+}
+
+// 定期清理过期的实时事件
+func runChangeEventPruner(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		cutoff := time.Now().UTC().Add(-changeEventRetention)
+		if err := db.Where("created_at < ?", cutoff).Delete(&ChangeEvent{}).Error; err != nil {
+			log.Printf("清理实时事件失败: %v", err)
+		}
+	}
+}
+
+// 事件流筛选条件
+type streamFilter struct {
+	roomIDs   []uint
+	startTime *time.Time
+	endTime   *time.Time
+}
+
+// 会议室事件只按会议室筛选，预订事件同时按时间段筛选
+func (f streamFilter) apply(query *gorm.DB) *gorm.DB {
+	if len(f.roomIDs) > 0 {
+		query = query.Where("room_id IN ?", f.roomIDs)
+	}
+	if f.startTime != nil {
+		query = query.Where("(booking_id = 0 OR end_time > ?)", *f.startTime)
+	}
+	if f.endTime != nil {
+		query = query.Where("(booking_id = 0 OR start_time < ?)", *f.endTime)
+	}
+	return query
+}
+
+// 解析逗号分隔的会议室ID
+func parseRoomIDs(value string) ([]uint, bool) {
+	var ids []uint
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || id == 0 {
+			return nil, false
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, true
+}
+
+// 浏览器的 EventSource 无法设置请求头，允许通过 token 参数传入 JWT
+func StreamTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			if token := c.Query("token"); token != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		c.Next()
+	}
+}
+
+// 访问日志中需要隐藏的 token 查询参数：实时事件流的 JWT 及门口平板的设备令牌
+var tokenQueryPattern = regexp.MustCompile(`([?&]token=)[^&]*`)
+
+// 访问日志格式与 gin 默认相同，只把 token 参数显示为 ******，避免 JWT 写入日志
+func accessLogFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		maskQueryToken(param.Path),
+		param.ErrorMessage,
+	)
+}
+
+// 隐藏请求路径中的 token 参数
+func maskQueryToken(path string) string {
+	return tokenQueryPattern.ReplaceAllString(path, "${1}******")
+}
+
+// 私密预订事件按订阅者脱敏
+func redactChangeEvent(viewerID uint, role interface{}, payload string) string {
+	var data struct {
+		Booking Booking         `json:"booking"`
+		Room    json.RawMessage `json:"room"`
+	}
+	ev := webhookPayload{Data: &data}
+	if err := json.Unmarshal([]byte(payload), &ev); err != nil {
+		return payload
+	}
+	bookings := []Booking{data.Booking}
+	redactBookings(db, viewerID, role, bookings)
+	data.Booking = bookings[0]
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return payload
+	}
+	return string(body)
+}
+
+// 实时事件的读取进度。PostgreSQL、MySQL 在事务提交前就分配自增ID，较小的ID可能晚于较大的ID提交，
+// 因此 pos 只越过连续已处理的ID，其后已处理的ID记在 seen 中去重；空缺超过 streamGapTimeout 后不再等待
+type streamCursor struct {
+	pos      uint          // 此ID及之前的事件均已处理
+	seen     map[uint]bool // pos 之后已处理的事件ID
+	gapSince time.Time     // pos 之后出现空缺的时间
+}
+
+// 从 start 开始读取：start 及之前已存在的事件视为已处理，回看 streamRescanWindow 个ID内尚未提交的事件
+func newStreamCursor(tx *gorm.DB, start uint) (*streamCursor, error) {
+	cur := &streamCursor{seen: make(map[uint]bool)}
+	if start > streamRescanWindow {
+		cur.pos = start - streamRescanWindow
+	}
+	var ids []uint
+	if err := tx.Model(&ChangeEvent{}).Where("id > ? AND id <= ?", cur.pos, start).Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	cur.mark(ids, time.Now())
+	return cur, nil
+}
+
+// 尚未处理的事件ID
+func (cur *streamCursor) pending(tx *gorm.DB) ([]uint, error) {
+	var ids []uint
+	if err := tx.Model(&ChangeEvent{}).Where("id > ?", cur.pos).Order("id").Limit(streamBatchSize).Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	result := ids[:0]
+	for _, id := range ids {
+		if !cur.seen[id] {
+			result = append(result, id)
+		}
+	}
+	return result, nil
+}
+
+// 记录已处理的事件ID并推进 pos
+func (cur *streamCursor) mark(ids []uint, now time.Time) {
+	for _, id := range ids {
+		if id > cur.pos {
+			cur.seen[id] = true
+		}
+	}
+	cur.advance(now)
+}
+
+// 越过连续已处理的ID；遇到空缺时等待 streamGapTimeout，超时后跳到下一个已处理的ID
+func (cur *streamCursor) advance(now time.Time) {
+	for len(cur.seen) > 0 {
+		if cur.seen[cur.pos+1] {
+			delete(cur.seen, cur.pos+1)
+			cur.pos++
+			cur.gapSince = time.Time{}
+			continue
+		}
+		if cur.gapSince.IsZero() {
+			cur.gapSince = now
+		}
+		if now.Sub(cur.gapSince) < streamGapTimeout {
+			return
+		}
+		next := cur.pos
+		for id := range cur.seen {
+			if next == cur.pos || id < next {
+				next = id
+			}
+		}
+		cur.pos = next - 1
+		cur.gapSince = time.Time{}
+	}
+	cur.gapSince = time.Time{}
+}
+
+// @Summary 订阅实时事件
+// @Description 以 Server-Sent Events 推送预订（booking.created、booking.updated、booking.cancelled）和会议室（room.created、room.updated、room.deleted）变更，事件内容与 Webhook 相同。
+// @Description 每个事件的 id 为游标，断线重连时浏览器会自动通过 Last-Event-ID 请求头补发错过的事件，也可通过 cursor 参数指定；游标已过期（事件保留 24 小时）时推送 reset 事件，客户端应重新加载数据。
+// @Description 事务提交较晚的事件可能在 id 更大的事件之后推送，重连时可能重复推送，客户端可按 id 去重。
+// @Description 未指定游标时只推送新事件。EventSource 无法设置请求头，可通过 token 参数传入 JWT
+// @Tags 预订
+// @Produce text/event-stream
+// @Param room_id query string false "会议室ID，多个用逗号分隔"
+// @Param start_time query string false "开始时间(ISO8601)，只推送与时间段重叠的预订"
+// @Param end_time query string false "结束时间(ISO8601)"
+// @Param date query string false "当地日期(YYYY-MM-DD)"
+// @Param tz query string false "解释不带时区的时间参数所用的时区"
+// @Param cursor query int false "从该游标之后开始推送"
+// @Param token query string false "JWT，未设置 Authorization 请求头时使用"
+// @Success 200 {string} string "事件流"
+// @Security Bearer
+// @Router /api/events [get]
+func eventStreamHandler(c *gin.Context) {
+	var filter streamFilter
+	if value := c.Query("room_id"); value != "" {
+		ids, ok := parseRoomIDs(value)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "会议室ID无效"})
+			return
+		}
+		filter.roomIDs = ids
+	}
+
+	// 不带时区的时间参数按会议室时区解释（仅筛选一个会议室时），否则使用用户偏好时区
+	tz, _ := c.Get("time_zone")
+	loc := loadLocation(fmt.Sprint(tz))
+	if len(filter.roomIDs) == 1 {
+		var room Room
+		if err := db.First(&room, filter.roomIDs[0]).Error; err == nil {
+			loc = room.location()
+		}
+	}
+	if name := c.Query("tz"); name != "" {
+		if !validTimeZone(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时区无效"})
+			return
+		}
+		loc = loadLocation(name)
+	}
+	if date := c.Query("date"); date != "" {
+		dayStart, dayEnd, ok := localDayRange(date, loc)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "日期格式错误"})
+			return
+		}
+		filter.startTime, filter.endTime = &dayStart, &dayEnd
+	}
+	if value := c.Query("start_time"); value != "" {
+		t, ok := parseTimeParam(value, loc)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时间格式错误"})
+			return
+		}
+		filter.startTime = &t
+	}
+	if value := c.Query("end_time"); value != "" {
+		t, ok := parseTimeParam(value, loc)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "时间格式错误"})
+			return
+		}
+		filter.endTime = &t
+	}
+
+	// 游标：优先使用浏览器重连时带上的 Last-Event-ID
+	cursorValue := c.GetHeader("Last-Event-ID")
+	if cursorValue == "" {
+		cursorValue = c.Query("cursor")
+	}
+	var latest uint
+	db.Model(&ChangeEvent{}).Select("COALESCE(MAX(id), 0)").Scan(&latest)
+	cursor := latest
+	reset := false
+	if cursorValue != "" {
+		n, err := strconv.ParseUint(cursorValue, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "游标无效"})
+			return
+		}
+		var oldest uint
+		db.Model(&ChangeEvent{}).Select("COALESCE(MIN(id), 0)").Scan(&oldest)
+		switch {
+		case uint(n) > latest:
+			// 数据已重置
+			reset = true
+		case oldest > 0 && uint(n)+1 < oldest:
+			// 之间的事件已清理，无法补发
+			reset = true
+		default:
+			cursor = uint(n)
+		}
+	}
+
+	userID, _ := currentUserID(c)
+	role, _ := c.Get("role")
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // 关闭 Nginx 缓冲
+	c.Status(http.StatusOK)
+	w := c.Writer
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetryMillis)
+	if reset {
+		fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", cursor)
+	}
+	w.Flush()
+
+	stream, err := newStreamCursor(db, cursor)
+	if err != nil {
+		log.Printf("查询实时事件失败: %v", err)
+		return
+	}
+	poll := time.NewTicker(streamPollInterval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	ctx := c.Request.Context()
+	for {
+		// 先取未处理的事件ID再按条件查询，筛选掉的事件也一并标记为已处理
+		ids, err := stream.pending(db)
+		if err == nil && len(ids) > 0 {
+			var events []ChangeEvent
+			if err = filter.apply(db.Where("id IN ?", ids)).Order("id").Find(&events).Error; err == nil {
+				for _, ev := range events {
+					payload := ev.Payload
+					if ev.Private {
+						payload = redactChangeEvent(userID, role, payload)
+					}
+					fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Event, payload)
+				}
+				w.Flush()
+			}
+		}
+		if err != nil {
+			log.Printf("查询实时事件失败: %v", err)
+		} else {
+			stream.mark(ids, time.Now())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			w.Flush()
+		case <-poll.C:
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaskQueryToken(t *testing.T) {
+	cases := []struct{ path, want string }{
+		{"/api/events", "/api/events"},
+		{"/api/events?token=eyJhbGciOi.x.y", "/api/events?token=******"},
+		{"/api/events?room_id=1&token=eyJ&cursor=5", "/api/events?room_id=1&token=******&cursor=5"},
+		{"/api/events?room_id=1&mytoken=abc", "/api/events?room_id=1&mytoken=abc"},
+		{"/api/kiosk/status?token=3f9a0c", "/api/kiosk/status?token=******"},
+		{"/api/kiosk/status?lang=en&token=3f9a0c", "/api/kiosk/status?lang=en&token=******"},
+	}
+	for _, tc := range cases {
+		if got := maskQueryToken(tc.path); got != tc.want {
+			t.Errorf("maskQueryToken(%q) = %q，应为 %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestStreamCursorWaitsForGap(t *testing.T) {
+	now := time.Now()
+	cur := &streamCursor{pos: 10, seen: make(map[uint]bool)}
+
+	// 12 先于 11 提交：游标停在 10，12 不再重复推送
+	cur.mark([]uint{12}, now)
+	if cur.pos != 10 || !cur.seen[12] {
+		t.Fatalf("pos = %d，seen = %v，应停在 10 并记录 12", cur.pos, cur.seen)
+	}
+	cur.mark([]uint{11}, now.Add(time.Second))
+	if cur.pos != 12 || len(cur.seen) != 0 {
+		t.Fatalf("11 提交后 pos = %d，seen = %v，应越过 12", cur.pos, cur.seen)
+	}
+
+	// 14 之前的 13 一直没有提交，超时后跳过
+	cur.mark([]uint{14}, now)
+	cur.advance(now.Add(streamGapTimeout - time.Second))
+	if cur.pos != 12 {
+		t.Fatalf("未超时 pos = %d，应为 12", cur.pos)
+	}
+	cur.advance(now.Add(streamGapTimeout))
+	if cur.pos != 14 || len(cur.seen) != 0 {
+		t.Fatalf("超时后 pos = %d，seen = %v，应为 14", cur.pos, cur.seen)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	// 内嵌时区数据，alpine 运行镜像中没有 tzdata
+	_ "time/tzdata"
+
+	"gorm.io/gorm"
+)
+
+// 不带时区的时间参数格式，按会议室或用户时区解释
+var localTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// timeSlot 一个预订时间段
+type timeSlot struct {
+	Start time.Time
+	End   time.Time
+}
+
+// 加载 IANA 时区，为空或无效时使用服务器本地时区
+func loadLocation(name string) *time.Location {
+	if name == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// 校验 IANA 时区名称，空字符串表示使用服务器本地时区
+func validTimeZone(name string) bool {
+	if name == "" {
+		return true
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// 会议室所在时区
+func (r Room) location() *time.Location {
+	return loadLocation(r.TimeZone)
+}
+
+// 解析时间参数：带时区的 RFC3339 直接使用，否则按 loc 解释为当地时间
+func parseTimeParam(value string, loc *time.Location) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), true
+	}
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// 当地日期的起止时间（UTC），正确处理夏令时导致的 23 或 25 小时
+func localDayRange(date string, loc *time.Location) (time.Time, time.Time, bool) {
+	day, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	next := time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, loc)
+	return day.UTC(), next.UTC(), true
+}
+
+// 解析 HH:MM 格式的时间，返回当天的分钟数
+func parseClock(value string) (int, bool) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// 校验开放时间配置，开始和结束需同时设置且开始早于结束
+func validBusinessHours(open, close string) bool {
+	if open == "" && close == "" {
+		return true
+	}
+	o, ok1 := parseClock(open)
+	c, ok2 := parseClock(close)
+	return ok1 && ok2 && o < c
+}
+
+// 检查预订是否在会议室开放时间内，按会议室所在时区计算
+func checkBusinessHours(room Room, start, end time.Time) error {
+	if room.OpenTime == "" || room.CloseTime == "" {
+		return nil
+	}
+	open, _ := parseClock(room.OpenTime)
+	closing, _ := parseClock(room.CloseTime)
+	loc := room.location()
+	ls, le := start.In(loc), end.In(loc)
+	startMin := ls.Hour()*60 + ls.Minute()
+	endMin := le.Hour()*60 + le.Minute()
+	sameDay := ls.Year() == le.Year() && ls.YearDay() == le.YearDay()
+	if !sameDay || startMin < open || endMin > closing {
+		return fmt.Errorf("预订时间须在会议室开放时间 %s-%s（%s）内", room.OpenTime, room.CloseTime, loc.String())
+	}
+	return nil
+}
+
+// 为预订填充会议室时区及当地时间
+func localizeBookings(tx *gorm.DB, bookings []Booking) {
+	if len(bookings) == 0 {
+		return
+	}
+	var roomIDs []uint
+	seen := make(map[uint]bool)
+	for _, b := range bookings {
+		if !seen[b.RoomID] {
+			seen[b.RoomID] = true
+			roomIDs = append(roomIDs, b.RoomID)
+		}
+	}
+	var rooms []Room
+	tx.Select("id", "time_zone").Where("id IN ?", roomIDs).Find(&rooms)
+	locs := make(map[uint]*time.Location, len(rooms))
+	for _, r := range rooms {
+		locs[r.ID] = r.location()
+	}
+	for i := range bookings {
+		loc, ok := locs[bookings[i].RoomID]
+		if !ok {
+			loc = time.Local
+		}
+		bookings[i].localize(loc)
+	}
+}
+
+// 填充当地时间字段，原始时间统一以 UTC 返回
+func (b *Booking) localize(loc *time.Location) {
+	b.StartTime = b.StartTime.UTC()
+	b.EndTime = b.EndTime.UTC()
+	b.TimeZone = loc.String()
+	b.StartTimeLocal = b.StartTime.In(loc).Format(time.RFC3339)
+	b.EndTimeLocal = b.EndTime.In(loc).Format(time.RFC3339)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 开放时间按会议室时区计算，与服务器时区无关
+func TestCheckBusinessHours(t *testing.T) {
+	newYork := Room{TimeZone: "America/New_York", OpenTime: "08:00", CloseTime: "18:00"}
+	shanghai := Room{TimeZone: "Asia/Shanghai", OpenTime: "08:00", CloseTime: "18:00"}
+	cases := []struct {
+		name  string
+		room  Room
+		start time.Time // UTC
+		d     time.Duration
+		ok    bool
+	}{
+		{"纽约夏令时 8:00 开始", newYork, time.Date(2026, 3, 9, 12, 0, 0, 0, time.UTC), time.Hour, true},
+		{"同一 UTC 时间在冬令时为 7:00", newYork, time.Date(2026, 3, 6, 12, 0, 0, 0, time.UTC), time.Hour, false},
+		{"纽约冬令时 17:00-18:00", newYork, time.Date(2026, 3, 6, 22, 0, 0, 0, time.UTC), time.Hour, true},
+		{"纽约夏令时 18:00 之后", newYork, time.Date(2026, 3, 9, 22, 0, 0, 0, time.UTC), time.Hour, false},
+		{"上海 9:00 即 UTC 1:00", shanghai, time.Date(2026, 3, 9, 1, 0, 0, 0, time.UTC), time.Hour, true},
+		{"上海跨越当地午夜", shanghai, time.Date(2026, 3, 9, 15, 30, 0, 0, time.UTC), time.Hour, false},
+		{"未设置开放时间", Room{TimeZone: "Asia/Shanghai"}, time.Date(2026, 3, 9, 15, 30, 0, 0, time.UTC), time.Hour, true},
+	}
+	for _, tc := range cases {
+		err := checkBusinessHours(tc.room, tc.start, tc.start.Add(tc.d))
+		if (err == nil) != tc.ok {
+			t.Errorf("%s：checkBusinessHours = %v", tc.name, err)
+		}
+	}
+}
+
+// 跨夏令时的周期预订保持当地时间，每次都在开放时间内
+func TestRecurrenceWithinBusinessHoursAcrossDST(t *testing.T) {
+	room := Room{TimeZone: "America/New_York", OpenTime: "08:00", CloseTime: "09:00"}
+	start := time.Date(2026, 10, 26, 12, 0, 0, 0, time.UTC) // 当地 8:00（EDT）
+	slots, err := expandRecurrence(RecurrenceRule{Freq: RecurrenceWeekly, Count: 3}, start, start.Add(time.Hour), room.location())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range slots {
+		if err := checkBusinessHours(room, s.Start, s.End); err != nil {
+			t.Errorf("%v - %v：%v", s.Start, s.End, err)
+		}
+	}
+	// 2026-11-01 结束夏令时，按固定 UTC 间隔重复则会早一小时
+	shifted := slots[0].Start.Add(14 * 24 * time.Hour)
+	if err := checkBusinessHours(room, shifted, shifted.Add(time.Hour)); err == nil {
+		t.Errorf("%v 在当地为 7:00，应在开放时间之外", shifted)
+	}
+}
@@ -0,0 +1,120 @@
+// fakedootask 模拟 DooTask 的机器人消息接口，用于在本地调试 DooTask 通知。
+//
+// 用法：
+//
+//	go run ./tools/fakedootask -addr :9098 -token test -fail 1
+//	DOOTASK_URL=http://127.0.0.1:9098 DOOTASK_TOKEN=test go run .
+//
+// 收到的消息打印到标准输出，也可通过 GET /messages 查看；-fail 指定前几次发送返回错误，用于检查重试。
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type message struct {
+	DialogID string    `json:"dialog_id"`
+	UserID   string    `json:"userid"`
+	Text     string    `json:"text"`
+	TextType string    `json:"text_type"`
+	Time     time.Time `json:"time"`
+}
+
+type server struct {
+	token    string
+	failures int
+
+	mu       sync.Mutex
+	dialogs  map[string]string // 会话ID -> 用户ID
+	messages []message
+}
+
+func (s *server) reply(w http.ResponseWriter, ret int, msg string, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"ret": ret, "msg": msg, "data": data})
+}
+
+func (s *server) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("token") != s.token {
+		s.reply(w, -1, "身份已失效，请重新登录", nil)
+		return false
+	}
+	return true
+}
+
+func (s *server) openUser(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(w, r) {
+		return
+	}
+	userID := r.URL.Query().Get("userid")
+	if _, err := strconv.Atoi(userID); err != nil {
+		s.reply(w, 0, "用户不存在", nil)
+		return
+	}
+	s.mu.Lock()
+	dialogID := strconv.Itoa(1000 + len(s.dialogs))
+	for id, uid := range s.dialogs {
+		if uid == userID {
+			dialogID = id
+		}
+	}
+	s.dialogs[dialogID] = userID
+	s.mu.Unlock()
+	s.reply(w, 1, "success", map[string]interface{}{"id": json.Number(dialogID), "type": "user"})
+}
+
+func (s *server) sendText(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(w, r) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		log.Printf("模拟发送失败，剩余 %d 次", s.failures)
+		http.Error(w, "simulated failure", http.StatusInternalServerError)
+		return
+	}
+	userID, ok := s.dialogs[r.FormValue("dialog_id")]
+	if !ok {
+		s.reply(w, 0, "会话不存在", nil)
+		return
+	}
+	m := message{
+		DialogID: r.FormValue("dialog_id"),
+		UserID:   userID,
+		Text:     r.FormValue("text"),
+		TextType: r.FormValue("text_type"),
+		Time:     time.Now(),
+	}
+	s.messages = append(s.messages, m)
+	log.Printf("发送给用户 %s:\n%s", m.UserID, m.Text)
+	s.reply(w, 1, "发送成功", map[string]interface{}{"id": len(s.messages)})
+}
+
+func (s *server) list(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.messages)
+}
+
+func main() {
+	addr := flag.String("addr", ":9098", "监听地址")
+	token := flag.String("token", "test", "机器人 token")
+	fail := flag.Int("fail", 0, "前几次发送消息返回 HTTP 500")
+	flag.Parse()
+
+	s := &server{token: *token, failures: *fail, dialogs: make(map[string]string)}
+	http.HandleFunc("/api/dialog/open/user", s.openUser)
+	http.HandleFunc("/api/dialog/msg/sendtext", s.sendText)
+	http.HandleFunc("/messages", s.list)
+	log.Printf("fake DooTask listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// 预订可见性
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+// 私密预订对无关用户展示的占位文本
+const redactedReason = "Busy"
+
+// BookingParticipant 预订参与人，参与人可以查看私密预订的详情
+type BookingParticipant struct {
+	ID        uint `gorm:"primaryKey" json:"id"`
+	BookingID uint `gorm:"uniqueIndex:idx_booking_participant" json:"booking_id"`
+	UserID    uint `gorm:"uniqueIndex:idx_booking_participant;index" json:"user_id"`
+}
+
+var errInvalidParticipants = errors.New("invalid participants")
+
+// 确定预订的可见性，未指定时使用系统默认值。在事务中调用时 tx 应为该事务
+func resolveVisibility(tx *gorm.DB, visibility string) (string, error) {
+	switch visibility {
+	case VisibilityPublic, VisibilityPrivate:
+		return visibility, nil
+	case "":
+		settings, err := loadSystemSettingsTx(tx)
+		if err != nil {
+			return "", err
+		}
+		if settings.DefaultBookingVisibility == VisibilityPrivate {
+			return VisibilityPrivate, nil
+		}
+		return VisibilityPublic, nil
+	default:
+		return "", errors.New("invalid visibility")
+	}
+}
+
+// 校验参与人并去重，组织者本人不重复记录
+func normalizeParticipants(tx *gorm.DB, organizerID uint, ids []uint) ([]uint, error) {
+	seen := make(map[uint]bool)
+	var result []uint
+	for _, id := range ids {
+		if id == 0 || id == organizerID || seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	var count int64
+	if err := tx.Model(&User{}).Where("id IN ?", result).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if int(count) != len(result) {
+		return nil, errInvalidParticipants
+	}
+	return result, nil
+}
+
+// 保存预订参与人
+func saveParticipants(tx *gorm.DB, bookingID uint, userIDs []uint) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	participants := make([]BookingParticipant, 0, len(userIDs))
+	for _, id := range userIDs {
+		participants = append(participants, BookingParticipant{BookingID: bookingID, UserID: id})
+	}
+	return tx.Create(&participants).Error
+}
+
+// 对查看者无权查看的私密预订进行脱敏，管理员、组织者和参与人可以看到完整信息
+func redactBookings(tx *gorm.DB, viewerID uint, role interface{}, bookings []Booking) {
+	if role == "admin" {
+		return
+	}
+	var privateIDs []uint
+	for _, b := range bookings {
+		if b.Visibility == VisibilityPrivate && b.UserID != viewerID {
+			privateIDs = append(privateIDs, b.ID)
+		}
+	}
+	if len(privateIDs) == 0 {
+		return
+	}
+	var attending []uint
+	tx.Model(&BookingParticipant{}).Where("user_id = ? AND booking_id IN ?", viewerID, privateIDs).Pluck("booking_id", &attending)
+	allowed := make(map[uint]bool, len(attending))
+	for _, id := range attending {
+		allowed[id] = true
+	}
+	for i := range bookings {
+		b := &bookings[i]
+		if b.Visibility != VisibilityPrivate || b.UserID == viewerID || allowed[b.ID] {
+			continue
+		}
+		b.UserID = 0
+		b.Reason = redactedReason
+		b.CancelledBy = nil
+		b.CancelReason = ""
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestRedactBookings(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, tx *gorm.DB) {
+		organizer := User{Username: "alice", Role: "user"}
+		participant := User{Username: "bob", Role: "user"}
+		other := User{Username: "carol", Role: "user"}
+		for _, u := range []*User{&organizer, &participant, &other} {
+			if err := tx.Create(u).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		start := time.Date(2030, 5, 6, 9, 0, 0, 0, time.UTC)
+		cancelledBy := organizer.ID
+		private := Booking{RoomID: 1, UserID: organizer.ID, StartTime: start, EndTime: start.Add(time.Hour), Reason: "并购谈判", Visibility: VisibilityPrivate,
+			Status: BookingStatusCancelled, CancelledBy: &cancelledBy, CancelReason: "改期"}
+		public := Booking{RoomID: 1, UserID: organizer.ID, StartTime: start.Add(2 * time.Hour), EndTime: start.Add(3 * time.Hour), Reason: "周会", Visibility: VisibilityPublic, Status: BookingStatusActive}
+		for _, b := range []*Booking{&private, &public} {
+			if err := tx.Create(b).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := saveParticipants(tx, private.ID, []uint{participant.ID}); err != nil {
+			t.Fatal(err)
+		}
+
+		cases := []struct {
+			name     string
+			viewer   uint
+			role     string
+			redacted bool
+		}{
+			{"组织者", organizer.ID, "user", false},
+			{"参与人", participant.ID, "user", false},
+			{"管理员", other.ID, "admin", false},
+			{"无关用户", other.ID, "user", true},
+		}
+		for _, tc := range cases {
+			bookings := []Booking{private, public}
+			redactBookings(tx, tc.viewer, tc.role, bookings)
+			p := bookings[0]
+			if tc.redacted {
+				if p.Reason != redactedReason || p.UserID != 0 || p.CancelledBy != nil || p.CancelReason != "" {
+					t.Errorf("%s：私密预订未脱敏 %+v", tc.name, p)
+				}
+			} else if p.Reason != "并购谈判" || p.UserID != organizer.ID || p.CancelReason != "改期" {
+				t.Errorf("%s：私密预订被脱敏 %+v", tc.name, p)
+			}
+			// 时间段始终可见，公开预订不脱敏
+			if !p.StartTime.Equal(start) || bookings[1].Reason != "周会" || bookings[1].UserID != organizer.ID {
+				t.Errorf("%s：%+v", tc.name, bookings)
+			}
+		}
+	})
+}
+
+// 无关用户按事由或预订人筛选时匹配不到私密预订，避免推断其内容
+func TestListBookingsPrivateFilter(t *testing.T) {
+	tx := setupTestDB(t)
+	organizer, room := createBookingFixtures(t, tx)
+	participant := User{Username: "bob", Role: "user"}
+	other := User{Username: "carol", Role: "user"}
+	mustCreate(t, &participant, &other)
+	start := time.Date(2030, 5, 6, 9, 0, 0, 0, time.UTC)
+	private := Booking{RoomID: room.ID, UserID: organizer.ID, StartTime: start, EndTime: start.Add(time.Hour), Reason: "merger talks", Visibility: VisibilityPrivate, Status: BookingStatusActive}
+	mustCreate(t, &private)
+	if err := saveParticipants(tx, private.ID, []uint{participant.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(viewer User, query string) (float64, []interface{}) {
+		t.Helper()
+		code, resp := callHandler(t, listBookingsHandler, viewer, http.MethodGet, "/api/bookings?"+query, nil, nil)
+		if code != http.StatusOK {
+			t.Fatalf("查询返回 %d：%v", code, resp)
+		}
+		return resp["total"].(float64), resp["bookings"].([]interface{})
+	}
+	total, bookings := list(other, "")
+	if total != 1 || bookings[0].(map[string]interface{})["reason"] != redactedReason {
+		t.Errorf("无关用户看到 %v", bookings)
+	}
+	for _, query := range []string{"q=merger", fmt.Sprintf("user_id=%d", organizer.ID)} {
+		if total, _ := list(other, query); total != 0 {
+			t.Errorf("无关用户按 %s 筛选匹配到 %.0f 个预订", query, total)
+		}
+		if total, _ := list(participant, query); total != 1 {
+			t.Errorf("参与人按 %s 筛选匹配到 %.0f 个预订", query, total)
+		}
+	}
+}
@@ -0,0 +1,637 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 事件类型
+const (
+	EventBookingCreated   = "booking.created"
+	EventBookingUpdated   = "booking.updated"
+	EventBookingCancelled = "booking.cancelled"
+	EventRoomCreated      = "room.created"
+	EventRoomUpdated      = "room.updated"
+	EventRoomDeleted      = "room.deleted"
+	EventUserCreated      = "user.created"
+	EventPing             = "ping"
+)
+
+// 可订阅的事件，* 表示全部
+var webhookEvents = []string{
+	EventBookingCreated, EventBookingUpdated, EventBookingCancelled,
+	EventRoomCreated, EventRoomUpdated, EventRoomDeleted,
+	EventUserCreated,
+}
+
+// 投递状态
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+	DeliverySkipped   = "skipped" // Webhook 已停用，不再投递
+)
+
+// 投递参数：最多尝试次数，重试间隔从 30 秒开始指数增长，最长 1 小时；
+// 每个请求最长等待 5 秒，最多同时向 4 个 Webhook 投递
+const (
+	webhookMaxAttempts  = 8
+	webhookBaseBackoff  = 30 * time.Second
+	webhookMaxBackoff   = time.Hour
+	webhookTimeout      = 5 * time.Second
+	webhookPollInterval = 3 * time.Second
+	webhookBatchSize    = 20
+	webhookMaxLogBody   = 2048
+	webhookConcurrency  = 4
+)
+
+// Webhook 管理员配置的事件订阅
+type Webhook struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Name       string    `json:"name"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"-"`               // 签名密钥，仅创建和修改时返回
+	EventTypes string    `json:"-"`               // 逗号分隔的事件类型
+	Events     []string  `gorm:"-" json:"events"` // 订阅的事件
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// 填充订阅的事件列表
+func (w *Webhook) AfterFind(tx *gorm.DB) error {
+	w.Events = splitEventTypes(w.EventTypes)
+	return nil
+}
+
+// 是否订阅了指定事件
+func (w Webhook) subscribes(event string) bool {
+	for _, e := range splitEventTypes(w.EventTypes) {
+		if e == "*" || e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery 一次事件投递，既是待发送的发件箱也是投递日志。
+// 记录与触发事件的数据变更在同一事务中写入，服务重启后继续投递
+type WebhookDelivery struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	WebhookID      uint       `gorm:"index" json:"webhook_id"`
+	EventID        string     `gorm:"index" json:"event_id"` // 同一事件投递到多个订阅时相同
+	Event          string     `json:"event"`
+	Payload        string     `json:"payload"`
+	Status         string     `gorm:"index" json:"status"` // pending、succeeded、failed 或 skipped
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"index" json:"next_attempt_at"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at,omitempty"`
+	ResponseStatus int        `json:"response_status"`
+	ResponseBody   string     `json:"response_body,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// 创建或修改 Webhook 请求体
+type SaveWebhookRequest struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url" binding:"required"`
+	Events []string `json:"events" binding:"required"`
+	Secret string   `json:"secret"` // 为空时自动生成
+	Active *bool    `json:"active"`
+}
+
+// webhookPayload 投递的请求体
+type webhookPayload struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+func splitEventTypes(value string) []string {
+	result := []string{}
+	for _, e := range strings.Split(value, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// 校验并规范化订阅的事件
+func normalizeEventTypes(events []string) ([]string, bool) {
+	known := map[string]bool{"*": true}
+	for _, e := range webhookEvents {
+		known[e] = true
+	}
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(events))
+	for _, e := range events {
+		e = strings.TrimSpace(e)
+		if !known[e] {
+			return nil, false
+		}
+		if !seen[e] {
+			seen[e] = true
+			result = append(result, e)
+		}
+	}
+	return result, len(result) > 0
+}
+
+// 校验回调地址，只允许 http 和 https
+func validWebhookURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// 记录事件：写入实时事件流，并为所有订阅的 Webhook 写入待投递记录。
+// 应在触发事件的数据变更所在事务中调用，事务回滚时不会推送或投递
+func publishEvent(tx *gorm.DB, event string, data interface{}) {
+	eventID, err := randomHex(16)
+	if err != nil {
+		log.Printf("生成事件ID失败: %v", err)
+		return
+	}
+	body, err := json.Marshal(webhookPayload{ID: eventID, Event: event, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		log.Printf("序列化事件失败: %v", err)
+		return
+	}
+	recordChangeEvent(tx, eventID, event, data, string(body))
+
+	var hooks []Webhook
+	if err := tx.Where("active = ?", true).Find(&hooks).Error; err != nil {
+		log.Printf("查询 Webhook 失败: %v", err)
+		return
+	}
+	for _, h := range hooks {
+		if h.subscribes(event) {
+			enqueueDelivery(tx, h.ID, eventID, event, string(body))
+		}
+	}
+}
+
+func enqueueDelivery(tx *gorm.DB, webhookID uint, eventID, event, payload string) WebhookDelivery {
+	delivery := WebhookDelivery{
+		WebhookID:     webhookID,
+		EventID:       eventID,
+		Event:         event,
+		Payload:       payload,
+		Status:        DeliveryPending,
+		NextAttemptAt: time.Now().UTC(),
+	}
+	if err := tx.Create(&delivery).Error; err != nil {
+		log.Printf("写入 Webhook 投递记录失败: %v", err)
+	}
+	return delivery
+}
+
+// 预订事件数据，附带会议室信息和当地时间
+func bookingEventData(b Booking, room Room) gin.H {
+	b.localize(room.location())
+	return gin.H{
+		"booking": b,
+		"room":    gin.H{"id": room.ID, "name": room.Name, "site": room.Site, "building": room.Building, "floor": room.Floor},
+	}
+}
+
+// 会议室事件数据，含设施和照片
+func roomEventData(tx *gorm.DB, room Room) Room {
+	rooms := []Room{room}
+	loadRoomDetails(tx, rooms)
+	return rooms[0]
+}
+
+// 用户事件数据，不含密码
+func userEventData(u User) gin.H {
+	return gin.H{"id": u.ID, "username": u.Username, "nickname": u.Nickname, "email": u.Email, "role": u.Role}
+}
+
+// 按预订所在会议室生成事件数据并发布
+func publishBookingEvent(tx *gorm.DB, event string, b Booking) {
+	var room Room
+	tx.First(&room, b.RoomID)
+	publishEvent(tx, event, bookingEventData(b, room))
+}
+
+// 签名：HMAC-SHA256(secret, timestamp + "." + body)，十六进制编码
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// 第 attempts 次失败后的重试间隔，从 base 开始指数增长，不超过 max
+func retryBackoff(attempts int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// 发送一次投递并更新记录
+func deliverWebhook(d *WebhookDelivery) {
+	var hook Webhook
+	if err := db.First(&hook, d.WebhookID).Error; err != nil {
+		// Webhook 已删除
+		d.Status = DeliveryFailed
+		d.Error = "webhook 不存在"
+		db.Save(d)
+		return
+	}
+	if !hook.Active {
+		// 停用后不再投递，包括停用前已排队的记录
+		d.Status = DeliverySkipped
+		d.Error = "webhook 已停用"
+		db.Save(d)
+		return
+	}
+	now := time.Now().UTC()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	body := []byte(d.Payload)
+
+	d.Attempts++
+	d.LastAttemptAt = &now
+	d.ResponseStatus = 0
+	d.ResponseBody = ""
+	d.Error = ""
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "MeetingRoom-Webhook/1.0")
+		req.Header.Set("X-Webhook-Event", d.Event)
+		req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(d.ID), 10))
+		req.Header.Set("X-Webhook-Event-ID", d.EventID)
+		req.Header.Set("X-Webhook-Timestamp", timestamp)
+		req.Header.Set("X-Webhook-Signature", webhookSignature(hook.Secret, timestamp, body))
+		var resp *http.Response
+		resp, err = webhookClient.Do(req)
+		if err == nil {
+			respBody, _ := io.ReadAll(io.LimitReader(resp.Body, webhookMaxLogBody))
+			resp.Body.Close()
+			d.ResponseStatus = resp.StatusCode
+			d.ResponseBody = string(respBody)
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				err = fmt.Errorf("HTTP %d", resp.StatusCode)
+			}
+		}
+	}
+	switch {
+	case err == nil:
+		d.Status = DeliverySucceeded
+	case d.Attempts >= webhookMaxAttempts:
+		d.Status = DeliveryFailed
+		d.Error = err.Error()
+	default:
+		d.Status = DeliveryPending
+		d.Error = err.Error()
+		d.NextAttemptAt = now.Add(retryBackoff(d.Attempts, webhookBaseBackoff, webhookMaxBackoff))
+	}
+	if err := db.Save(d).Error; err != nil {
+		log.Printf("更新 Webhook 投递记录失败: %v", err)
+	}
+}
+
+// 正在投递的 Webhook，同一 Webhook 同时只由一个 goroutine 投递，避免重复投递和乱序
+var (
+	webhookBusyMu sync.Mutex
+	webhookBusy   = make(map[uint]bool)
+)
+
+// 后台投递待发送的事件，每个 Webhook 由单独的 goroutine 顺序投递，响应慢的地址不影响其他 Webhook
+func runWebhookWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	slots := make(chan struct{}, webhookConcurrency)
+	for range ticker.C {
+		dispatchWebhooks(slots)
+	}
+}
+
+// 为有待投递记录且未在投递中的 Webhook 启动投递，并发数由 slots 的容量限制，已满时留到下一轮
+func dispatchWebhooks(slots chan struct{}) {
+	var hookIDs []uint
+	db.Model(&WebhookDelivery{}).Distinct("webhook_id").
+		Where("status = ? AND next_attempt_at <= ?", DeliveryPending, time.Now().UTC()).
+		Pluck("webhook_id", &hookIDs)
+	for _, id := range hookIDs {
+		webhookBusyMu.Lock()
+		busy := webhookBusy[id]
+		webhookBusy[id] = true
+		webhookBusyMu.Unlock()
+		if busy {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			webhookBusyMu.Lock()
+			delete(webhookBusy, id)
+			webhookBusyMu.Unlock()
+			return
+		}
+		go func(id uint) {
+			defer func() {
+				<-slots
+				webhookBusyMu.Lock()
+				delete(webhookBusy, id)
+				webhookBusyMu.Unlock()
+			}()
+			deliverPending(id)
+		}(id)
+	}
+}
+
+// 按时间顺序投递一个 Webhook 所有到期的记录
+func deliverPending(hookID uint) {
+	for {
+		var deliveries []WebhookDelivery
+		db.Where("webhook_id = ? AND status = ? AND next_attempt_at <= ?", hookID, DeliveryPending, time.Now().UTC()).
+			Order("next_attempt_at").Limit(webhookBatchSize).Find(&deliveries)
+		for i := range deliveries {
+			deliverWebhook(&deliveries[i])
+		}
+		if len(deliveries) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// 从请求构造 Webhook，返回错误提示
+func applyWebhookRequest(hook *Webhook, req SaveWebhookRequest) string {
+	if !validWebhookURL(req.URL) {
+		return "回调地址无效"
+	}
+	events, ok := normalizeEventTypes(req.Events)
+	if !ok {
+		return "事件类型无效"
+	}
+	hook.Name = strings.TrimSpace(req.Name)
+	hook.URL = req.URL
+	hook.EventTypes = strings.Join(events, ",")
+	hook.Events = events
+	if req.Active != nil {
+		hook.Active = *req.Active
+	}
+	if req.Secret != "" {
+		hook.Secret = req.Secret
+	}
+	return ""
+}
+
+// @Summary 查询 Webhook
+// @Description 管理员查询所有 Webhook 订阅及可订阅的事件类型
+// @Tags Webhook
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/webhooks [get]
+func listWebhooksHandler(c *gin.Context) {
+	var hooks []Webhook
+	db.Order("id").Find(&hooks)
+	if hooks == nil {
+		hooks = []Webhook{}
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks, "events": webhookEvents})
+}
+
+// @Summary 创建 Webhook
+// @Description 管理员创建 Webhook 订阅，未指定密钥时自动生成。请求头 X-Webhook-Signature 为 sha256=HMAC-SHA256(secret, X-Webhook-Timestamp + "." + 请求体)
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param data body SaveWebhookRequest true "Webhook 参数"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/webhooks [post]
+func createWebhookHandler(c *gin.Context) {
+	var req SaveWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	hook := Webhook{Active: true}
+	if msg := applyWebhookRequest(&hook, req); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if hook.Secret == "" {
+		secret, err := randomHex(24)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "生成密钥失败"})
+			return
+		}
+		hook.Secret = secret
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&hook).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "webhook.create", "webhook", hook.ID, nil, hook)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "创建成功", "webhook": hook, "secret": hook.Secret})
+}
+
+// @Summary 修改 Webhook
+// @Description 管理员修改 Webhook 订阅，secret 为空时保持原密钥
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Param data body SaveWebhookRequest true "Webhook 参数"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/webhooks/{id} [put]
+func updateWebhookHandler(c *gin.Context) {
+	var hook Webhook
+	if err := db.First(&hook, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook 不存在"})
+		return
+	}
+	var req SaveWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return
+	}
+	before := hook
+	if msg := applyWebhookRequest(&hook, req); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&hook).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "webhook.update", "webhook", hook.ID, before, hook)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "修改失败"})
+		return
+	}
+	result := gin.H{"message": "修改成功", "webhook": hook}
+	if req.Secret != "" {
+		result["secret"] = hook.Secret
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// @Summary 删除 Webhook
+// @Description 管理员删除 Webhook 订阅及其投递记录
+// @Tags Webhook
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/webhooks/{id} [delete]
+func deleteWebhookHandler(c *gin.Context) {
+	var hook Webhook
+	if err := db.First(&hook, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook 不存在"})
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", hook.ID).Delete(&WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&hook).Error; err != nil {
+			return err
+		}
+		recordAudit(tx, c, "webhook.delete", "webhook", hook.ID, hook, nil)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
+
+// @Summary 测试 Webhook
+// @Description 向 Webhook 发送一个 ping 事件
+// @Tags Webhook
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/webhooks/{id}/ping [post]
+func pingWebhookHandler(c *gin.Context) {
+	var hook Webhook
+	if err := db.First(&hook, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook 不存在"})
+		return
+	}
+	eventID, err := randomHex(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "发送失败"})
+		return
+	}
+	body, _ := json.Marshal(webhookPayload{ID: eventID, Event: EventPing, CreatedAt: time.Now().UTC(), Data: gin.H{"webhook_id": hook.ID}})
+	var delivery WebhookDelivery
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if delivery = enqueueDelivery(tx, hook.ID, eventID, EventPing, string(body)); delivery.ID == 0 {
+			return errors.New("enqueue webhook delivery failed")
+		}
+		recordAudit(tx, c, "webhook.ping", "webhook", hook.ID, nil, nil)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "发送失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已加入发送队列", "delivery": delivery})
+}
+
+// @Summary 查询投递记录
+// @Description 管理员分页查询 Webhook 的投递记录，可按状态和事件筛选
+// @Tags Webhook
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Param status query string false "pending、succeeded、failed 或 skipped"
+// @Param event query string false "事件类型"
+// @Param page query int false "页码，默认1"
+// @Param page_size query int false "每页数量，默认20，最大100"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/webhooks/{id}/deliveries [get]
+func listWebhookDeliveriesHandler(c *gin.Context) {
+	page, pageSize := parsePage(c)
+	query := db.Model(&WebhookDelivery{}).Where("webhook_id = ?", c.Param("id"))
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if event := c.Query("event"); event != "" {
+		query = query.Where("event = ?", event)
+	}
+	var total int64
+	query.Count(&total)
+	var deliveries []WebhookDelivery
+	query.Order("id DESC").Scopes(paginate(page, pageSize)).Find(&deliveries)
+	if deliveries == nil {
+		deliveries = []WebhookDelivery{}
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries, "total": total, "page": page, "page_size": pageSize})
+}
+
+// @Summary 重新投递
+// @Description 以相同的事件ID和内容重新投递一次，生成新的投递记录
+// @Tags Webhook
+// @Produce json
+// @Param id path int true "投递记录ID"
+// @Success 200 {object} map[string]interface{}
+// @Security Bearer
+// @Router /api/admin/webhooks/deliveries/{id}/redeliver [post]
+func redeliverWebhookHandler(c *gin.Context) {
+	var original WebhookDelivery
+	if err := db.First(&original, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "投递记录不存在"})
+		return
+	}
+	var hook Webhook
+	if err := db.First(&hook, original.WebhookID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook 不存在"})
+		return
+	}
+	var delivery WebhookDelivery
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if delivery = enqueueDelivery(tx, hook.ID, original.EventID, original.Event, original.Payload); delivery.ID == 0 {
+			return errors.New("enqueue webhook delivery failed")
+		}
+		recordAudit(tx, c, "webhook.redeliver", "webhook", hook.ID, nil, gin.H{"delivery_id": original.ID, "event_id": original.EventID})
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重新投递失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已加入发送队列", "delivery": delivery})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	got := webhookSignature("secret", "1700000000", []byte(`{"id":"1"}`))
+	want := "sha256=086f6aff7bd084c98679825129c5a64dbad88c760016d6d2c0fb123f27951d54"
+	if got != want {
+		t.Errorf("webhookSignature = %s，应为 %s", got, want)
+	}
+}
+
+// 接收端按 README 的说明校验签名
+func TestWebhookDeliverySigned(t *testing.T) {
+	tx := setupTestDB(t)
+	var headers http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	hook := Webhook{URL: server.URL, Secret: "s3cret", EventTypes: "*", Active: true}
+	mustCreate(t, &hook)
+	publishEvent(tx, EventRoomCreated, Room{ID: 1, Name: "A101"})
+	var delivery WebhookDelivery
+	if err := tx.Where("webhook_id = ?", hook.ID).First(&delivery).Error; err != nil {
+		t.Fatal(err)
+	}
+	deliverWebhook(&delivery)
+
+	if delivery.Status != DeliverySucceeded || delivery.Attempts != 1 || delivery.ResponseBody != "ok" {
+		t.Fatalf("投递记录 %+v", delivery)
+	}
+	if string(body) != delivery.Payload || headers.Get("X-Webhook-Event") != EventRoomCreated || headers.Get("X-Webhook-Event-ID") != delivery.EventID {
+		t.Errorf("请求头 %v，请求体 %s", headers, body)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(headers.Get("X-Webhook-Timestamp") + "." + string(body)))
+	if headers.Get("X-Webhook-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("签名 %s 校验失败", headers.Get("X-Webhook-Signature"))
+	}
+}
+
+// 停用的 Webhook 不再投递，失败后按退避时间重试
+func TestWebhookDeliveryStatus(t *testing.T) {
+	tx := setupTestDB(t)
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	hook := Webhook{URL: server.URL, Secret: "s", EventTypes: "*", Active: true}
+	mustCreate(t, &hook)
+	failing := enqueueDelivery(tx, hook.ID, "e1", EventPing, "{}")
+	deliverWebhook(&failing)
+	if failing.Status != DeliveryPending || failing.ResponseStatus != 503 || failing.Error != "HTTP 503" || !failing.NextAttemptAt.After(time.Now().Add(webhookBaseBackoff-time.Second)) {
+		t.Errorf("失败后的投递记录 %+v", failing)
+	}
+
+	tx.Model(&hook).Update("active", false)
+	skipped := enqueueDelivery(tx, hook.ID, "e2", EventPing, "{}")
+	deliverWebhook(&skipped)
+	if skipped.Status != DeliverySkipped || skipped.Attempts != 0 || calls != 1 {
+		t.Errorf("停用后的投递记录 %+v，请求 %d 次", skipped, calls)
+	}
+}
+
+// 响应慢的 Webhook 不影响其他 Webhook，同一 Webhook 不会同时投递
+func TestDispatchWebhooksConcurrently(t *testing.T) {
+	tx := setupTestDB(t)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	received := make(map[string]int)
+	handler := func(name string, wait bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if wait {
+				<-release
+			}
+			mu.Lock()
+			received[name]++
+			mu.Unlock()
+		}
+	}
+	slow := httptest.NewServer(handler("slow", true))
+	// 测试结束前等待慢的投递完成，避免写入之后测试的数据库
+	t.Cleanup(func() {
+		close(release)
+		for {
+			webhookBusyMu.Lock()
+			n := len(webhookBusy)
+			webhookBusyMu.Unlock()
+			if n == 0 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		slow.Close()
+	})
+	fast := httptest.NewServer(handler("fast", false))
+	defer fast.Close()
+
+	slowHook := Webhook{URL: slow.URL, Secret: "s", EventTypes: "*", Active: true}
+	fastHook := Webhook{URL: fast.URL, Secret: "s", EventTypes: "*", Active: true}
+	mustCreate(t, &slowHook, &fastHook)
+	publishEvent(tx, EventRoomCreated, Room{ID: 1})
+	publishEvent(tx, EventRoomUpdated, Room{ID: 1})
+
+	slots := make(chan struct{}, webhookConcurrency)
+	dispatchWebhooks(slots)
+	dispatchWebhooks(slots) // 慢的 Webhook 仍在投递，不会重复启动
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		var done int64
+		tx.Model(&WebhookDelivery{}).Where("webhook_id = ? AND status = ?", fastHook.ID, DeliverySucceeded).Count(&done)
+		if done == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("慢的 Webhook 阻塞了其他 Webhook，已投递 %d 个", done)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	webhookBusyMu.Lock()
+	busy := webhookBusy[slowHook.ID]
+	webhookBusyMu.Unlock()
+	mu.Lock()
+	defer mu.Unlock()
+	if !busy || received["slow"] != 0 || received["fast"] != 2 {
+		t.Errorf("慢的 Webhook 投递中 %v，收到 %v", busy, received)
+	}
+}
@@ -32,7 +32,6 @@ import styles from './App.module.css';
 // 导入 React 相关库
 import React, { useState, useEffect, useRef } from 'react';
 import { Routes, Route, useNavigate, useParams, useLocation } from 'react-router-dom';
-import * as XLSX from 'xlsx'; // 导出Excel
 import { saveAs } from 'file-saver';
 
 // 设置 moment 语言为中文
@@ -325,9 +324,9 @@ function RoomBookingPage({ rooms }) {
 
   // useEffect 必须始终调用
   const selectedDate = moment(selectedDateKey);
-  useEffect(() => {
-    if (!room) return;
-    api.get(`/api/bookings?room_id=${room.id}&start_time=${selectedDate.toISOString().slice(0,10)}T00:00:00&end_time=${selectedDate.toISOString().slice(0,10)}T23:59:59`, {
+  const dayRange = `start_time=${selectedDate.toISOString().slice(0,10)}T00:00:00&end_time=${selectedDate.toISOString().slice(0,10)}T23:59:59`;
+  const loadBookedSlots = () => {
+    api.get(`/api/bookings?room_id=${room.id}&${dayRange}&page_size=100`, {
       headers: { Authorization: localStorage.getItem('token') }
     }).then(res => {
       setBookedSlots(res.data.bookings.map(b => ({
@@ -335,9 +334,25 @@ function RoomBookingPage({ rooms }) {
         end: moment(b.end_time)
       })));
     });
+  };
+  useEffect(() => {
+    if (!room) return;
+    loadBookedSlots();
     setSelectedSlots([]);
   }, [room, selectedDateKey]);
 
+  // 订阅实时事件，其他人预订或取消后自动刷新；断线后浏览器自动重连并补发错过的事件
+  useEffect(() => {
+    if (!room || typeof EventSource === 'undefined') return;
+    const base = (api.defaults.baseURL || '/').replace(/\/$/, '');
+    const token = encodeURIComponent(localStorage.getItem('token') || '');
+    const source = new EventSource(`${base}/api/events?room_id=${room.id}&${dayRange}&token=${token}`);
+    ['booking.created', 'booking.updated', 'booking.cancelled', 'reset'].forEach(event => {
+      source.addEventListener(event, loadBookedSlots);
+    });
+    return () => source.close();
+  }, [room, selectedDateKey]);
+
   useEffect(() => {
     function syncFloatBar() {
       if (!cardRef.current || !floatBarRef.current) return;
@@ -438,14 +453,7 @@ function RoomBookingPage({ rooms }) {
       setSelectedSlots([]);
       setReason('');
       // 重新拉取已预约时间段
-      api.get(`/api/bookings?room_id=${room.id}&start_time=${selectedDate.toISOString().slice(0,10)}T00:00:00&end_time=${selectedDate.toISOString().slice(0,10)}T23:59:59`, {
-        headers: { Authorization: localStorage.getItem('token') }
-      }).then(res => {
-        setBookedSlots(res.data.bookings.map(b => ({
-          start: moment(b.start_time),
-          end: moment(b.end_time)
-        })));
-      });
+      loadBookedSlots();
     } catch (e) {
       message.error(e.response?.data?.error || '预订失败');
     } finally {
@@ -601,15 +609,18 @@ function MyBookings({ rooms, fetchRooms }) {
 
 // 预订管理（管理员视角），可查看所有用户的预订并导出
 function BookingManage() {
-  const [bookings, setBookings] = useState([]); // 所有预订记录
+  const [bookings, setBookings] = useState([]); // 当前页的预订记录
   const [loading, setLoading] = useState(false); // 加载状态
+  const [query, setQuery] = useState({ page: 1, page_size: 20, sort: '-start_time', q: '' }); // 分页、排序和搜索条件
+  const [total, setTotal] = useState(0); // 预订总数
 
-  // 拉取所有预订
+  // 按条件分页拉取预订
   const fetchAllBookings = async () => {
     setLoading(true);
     try {
-      const res = await api.get('/api/admin/bookings', { headers: { Authorization: localStorage.getItem('token') } });
+      const res = await api.get('/api/admin/bookings', { params: query, headers: { Authorization: localStorage.getItem('token') } });
       setBookings(res.data.bookings || []);
+      setTotal(res.data.total || 0);
     } catch (e) {
       message.error("获取预订列表失败");
     } finally {
@@ -619,32 +630,37 @@ function BookingManage() {
 
   useEffect(() => {
     fetchAllBookings();
-  }, []);
+  }, [query]);
+
+  // 翻页和排序由服务端处理
+  const onTableChange = (pagination, filters, sorter) => {
+    const field = sorter.order ? sorter.field : 'start_time';
+    const sort = sorter.order === 'ascend' ? field : `-${field}`;
+    setQuery({ ...query, page: pagination.current, page_size: pagination.pageSize, sort });
+  };
 
   // 表格列定义
   const columns = [
-    { title: '会议室', dataIndex: 'room_name', key: 'room_name', filters: [...new Set(bookings.map(b => b.room_name))].map(name => ({ text: name, value: name })), onFilter: (value, record) => record.room_name === value },
-    { title: '预订人', dataIndex: 'username', key: 'username' },
-    { title: '开始时间', dataIndex: 'start_time', render: t => t ? moment(t).format('YYYY/M/D HH:mm') : '', defaultSortOrder: 'descend', sorter: (a, b) => new Date(a.start_time) - new Date(b.start_time) },
-    { title: '结束时间', dataIndex: 'end_time', render: t => t ? moment(t).format('YYYY/M/D HH:mm') : '' },
+    { title: '会议室', dataIndex: 'room_name', key: 'room_name', sorter: true },
+    { title: '预订人', dataIndex: 'username', key: 'username', sorter: true },
+    { title: '开始时间', dataIndex: 'start_time', render: t => t ? moment(t).format('YYYY/M/D HH:mm') : '', defaultSortOrder: 'descend', sorter: true },
+    { title: '结束时间', dataIndex: 'end_time', render: t => t ? moment(t).format('YYYY/M/D HH:mm') : '', sorter: true },
     { title: '申请理由', dataIndex: 'reason', key: 'reason', ellipsis: true },
   ];
 
-  // 导出Excel功能
-  const handleExport = () => {
-    // 只导出表格可见列
-    const exportData = bookings.map(row => ({
-      '会议室': row.room_name,
-      '预订人': row.username,
-      '开始时间': moment(row.start_time).format('YYYY/M/D HH:mm'),
-      '结束时间': moment(row.end_time).format('YYYY/M/D HH:mm'),
-      '申请理由': row.reason,
-    }));
-    const ws = XLSX.utils.json_to_sheet(exportData);
-    const wb = XLSX.utils.book_new();
-    XLSX.utils.book_append_sheet(wb, ws, '预订记录');
-    const wbout = XLSX.write(wb, { bookType: 'xlsx', type: 'array' });
-    saveAs(new Blob([wbout], { type: 'application/octet-stream' }), `预订记录_${moment().format('YYYYMMDD_HHmmss')}.xlsx`);
+  // 导出Excel功能，由服务端生成文件，时间按浏览器时区显示
+  const handleExport = async () => {
+    try {
+      const tz = Intl.DateTimeFormat().resolvedOptions().timeZone;
+      const res = await api.get('/api/admin/bookings/export', {
+        params: { format: 'xlsx', tz, q: query.q },
+        headers: { Authorization: localStorage.getItem('token') },
+        responseType: 'blob',
+      });
+      saveAs(res.data, `预订记录_${moment().format('YYYYMMDD_HHmmss')}.xlsx`);
+    } catch (e) {
+      message.error("导出失败");
+    }
   };
 
   // 渲染所有预订表格和导出按钮
@@ -652,7 +668,10 @@ function BookingManage() {
     <div style={{ background: '#fff', padding: 24, borderRadius: 8 }}>
       <h2 style={{marginBottom: 16, display: 'flex', justifyContent: 'space-between', alignItems: 'center'}}>
         <span>所有预订记录</span>
-        <Button type="primary" onClick={handleExport}>导出数据</Button>
+        <Space>
+          <Input.Search placeholder="搜索申请理由" allowClear onSearch={q => setQuery({ ...query, q, page: 1 })} />
+          <Button type="primary" onClick={handleExport}>导出数据</Button>
+        </Space>
       </h2>
       <div style={{ overflowX: 'auto' }}>
         <Table
@@ -660,6 +679,8 @@ function BookingManage() {
           columns={columns}
           rowKey="id"
           loading={loading}
+          pagination={{ current: query.page, pageSize: query.page_size, total, showSizeChanger: true }}
+          onChange={onTableChange}
           scroll={{ x: 'max-content' }}
         />
       </div>
@@ -831,6 +852,8 @@ function SystemSettingsPage() {
   const [users, setUsers] = useState([]); // 用户列表
   const [loading, setLoading] = useState(false); // 设置加载状态
   const [usersLoading, setUsersLoading] = useState(false); // 用户列表加载状态
+  const [userQuery, setUserQuery] = useState({ page: 1, page_size: 20, q: '' }); // 用户列表分页和搜索条件
+  const [usersTotal, setUsersTotal] = useState(0); // 用户总数
   const [passwordModalVisible, setPasswordModalVisible] = useState(false); // 重置密码弹窗
   const [selectedUser, setSelectedUser] = useState(null); // 当前选中的用户
   const [passwordForm] = Form.useForm(); // 重置密码表单
@@ -838,9 +861,12 @@ function SystemSettingsPage() {
   // 拉取系统设置和用户列表
   useEffect(() => {
     fetchSettings();
-    fetchUsers();
   }, []);
 
+  useEffect(() => {
+    fetchUsers();
+  }, [userQuery]);
+
   // 获取系统设置
   const fetchSettings = async () => {
     setLoading(true);
@@ -858,8 +884,9 @@ function SystemSettingsPage() {
   const fetchUsers = async () => {
     setUsersLoading(true);
     try {
-      const res = await api.get('/api/admin/users', { headers: { Authorization: localStorage.getItem('token') } });
+      const res = await api.get('/api/admin/users', { params: userQuery, headers: { Authorization: localStorage.getItem('token') } });
       setUsers(res.data.users);
+      setUsersTotal(res.data.total || 0);
     } catch (e) {
       message.error('获取用户列表失败');
     } finally {
@@ -967,13 +994,20 @@ function SystemSettingsPage() {
 
       <div>
         <h3 style={{marginBottom: 16}}>用户管理</h3>
+        <Input.Search
+          placeholder="搜索用户名、昵称或邮箱"
+          allowClear
+          style={{ maxWidth: 320, marginBottom: 16 }}
+          onSearch={q => setUserQuery({ ...userQuery, q, page: 1 })}
+        />
         <div style={{ overflowX: 'auto' }}>
           <Table
             dataSource={users}
             columns={userColumns}
             rowKey="id"
             loading={usersLoading}
-            pagination={false}
+            pagination={{ current: userQuery.page, pageSize: userQuery.page_size, total: usersTotal }}
+            onChange={p => setUserQuery({ ...userQuery, page: p.current, page_size: p.pageSize })}
             scroll={{ x: 'max-content' }}
           />
         </div>
@@ -1099,10 +1133,11 @@ function App() {
     }
     console.log('开始 SSO 登录，使用邮箱:', userInfoData.email);
     try {
-      // 只传递 email、nickname，不再传递 identity
+      // 只传递 email、nickname 和 DooTask 用户ID（用于机器人消息），不再传递 identity
       const ssoData = {
         email: userInfoData.email,
-        nickname: userInfoData.nickname || userInfoData.email
+        nickname: userInfoData.nickname || userInfoData.email,
+        userid: userInfoData.userid
       };
       console.log('SSO 登录数据:', ssoData);
       const res = await api.post('/api/auth/sso', ssoData);